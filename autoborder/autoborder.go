@@ -0,0 +1,293 @@
+// Package autoborder places border items around grounds, the way Remere's
+// Map Editor does when painting with its ground brushes, so that borders
+// never need to be placed by hand.
+//
+// Brushes are read from the editor's borders.xml and grounds.xml. Each tile
+// gets the outer borders of the neighbouring grounds whose brushes have a
+// higher z-order than the tile's own ground; the items of each border are
+// chosen by where those neighbours are.
+package autoborder
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+// edge is a single piece of a border.
+type edge int
+
+const (
+	edgeN edge = iota
+	edgeE
+	edgeS
+	edgeW
+	cornerNW // The bordered ground is only to the northwest.
+	cornerNE
+	cornerSW
+	cornerSE
+	diagonalNW // The bordered ground is both to the north and to the west.
+	diagonalNE
+	diagonalSW
+	diagonalSE
+	edgeCount
+)
+
+var edgeNames = map[string]edge{
+	"n": edgeN, "e": edgeE, "s": edgeS, "w": edgeW,
+	"cnw": cornerNW, "cne": cornerNE, "csw": cornerSW, "cse": cornerSE,
+	"dnw": diagonalNW, "dne": diagonalNE, "dsw": diagonalSW, "dse": diagonalSE,
+}
+
+// Neighbours of a tile, as bits of a mask.
+const (
+	nw = 1 << iota
+	n
+	ne
+	w
+	e
+	sw
+	s
+	se
+)
+
+// neighbours lists the offsets of the neighbours, in the order of their bits.
+var neighbours = [8][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}}
+
+// Brushes knows the ground brushes and their borders.
+type Brushes struct {
+	grounds     map[uint16]*ground // By the server IDs of the items they paint.
+	borderItems map[uint16]bool
+}
+
+type ground struct {
+	name    string
+	zOrder  int
+	outer   []border
+	friends map[string]bool
+}
+
+// border is a set of border items placed around a ground, next to the
+// grounds named in to: a brush name, "none" for tiles without ground, or ""
+// for all grounds.
+type border struct {
+	to    string
+	items [edgeCount]uint16
+}
+
+// New returns the ground brushes read from grounds.xml, with their borders
+// read from borders.xml. Brushes other than ground brushes are ignored, as
+// are inner borders.
+func New(borders xmls.Borders, grounds xmls.Grounds) (*Brushes, error) {
+	sets := map[int][edgeCount]uint16{}
+	b := &Brushes{
+		grounds:     map[uint16]*ground{},
+		borderItems: map[uint16]bool{},
+	}
+	for _, bd := range borders.Border {
+		var items [edgeCount]uint16
+		for _, item := range bd.Item {
+			e, ok := edgeNames[item.Edge]
+			if !ok {
+				return nil, fmt.Errorf("border %d: unknown edge %q", bd.ID, item.Edge)
+			}
+			items[e] = uint16(item.Item)
+			b.borderItems[uint16(item.Item)] = true
+		}
+		sets[bd.ID] = items
+	}
+
+	for _, brush := range grounds.Brush {
+		if brush.Type != "ground" {
+			continue
+		}
+		g := &ground{
+			name:    brush.Name,
+			zOrder:  brush.ZOrder,
+			friends: map[string]bool{},
+		}
+		for _, bb := range brush.Border {
+			if bb.Align != "outer" {
+				continue
+			}
+			items, ok := sets[bb.ID]
+			if !ok {
+				return nil, fmt.Errorf("brush %q: unknown border %d", brush.Name, bb.ID)
+			}
+			to := bb.To
+			if to == "all" {
+				to = ""
+			}
+			g.outer = append(g.outer, border{to: to, items: items})
+		}
+		for _, f := range brush.Friend {
+			g.friends[f.Name] = true
+		}
+		for _, item := range brush.Item {
+			b.grounds[uint16(item.ID)] = g
+		}
+	}
+	return b, nil
+}
+
+// Load returns the ground brushes read from the grounds.xml and borders.xml
+// files at the passed paths.
+func Load(bordersPath, groundsPath string) (*Brushes, error) {
+	f, err := os.Open(bordersPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening borders: %w", err)
+	}
+	borders, err := xmls.ReadBorders(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading borders: %w", err)
+	}
+
+	f, err = os.Open(groundsPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening grounds: %w", err)
+	}
+	grounds, err := xmls.ReadGrounds(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading grounds: %w", err)
+	}
+	return New(borders, grounds)
+}
+
+// IsBorder returns whether the item with the passed server ID is part of any
+// border, and so may be replaced when borders are placed again.
+func (b *Brushes) IsBorder(serverID uint16) bool {
+	return b.borderItems[serverID]
+}
+
+// IsGround returns whether the item with the passed server ID is painted by a
+// ground brush.
+func (b *Brushes) IsGround(serverID uint16) bool {
+	return b.grounds[serverID] != nil
+}
+
+// Borders returns the border items to place on a tile, bottom-most first.
+// groundAt returns the server ID of the ground dx, dy tiles away from the
+// tile, or zero if there is none.
+func (b *Brushes) Borders(groundAt func(dx, dy int) uint16) []uint16 {
+	self := b.grounds[groundAt(0, 0)]
+	if self == nil && groundAt(0, 0) != 0 {
+		// Grounds not painted by a brush, such as floors of buildings, are
+		// left alone.
+		return nil
+	}
+
+	var around [8]*ground
+	var bordering []*ground
+	for i, off := range neighbours {
+		g := b.grounds[groundAt(off[0], off[1])]
+		if g == nil || g == self {
+			continue
+		}
+		if self != nil && (g.zOrder <= self.zOrder || g.friends[self.name] || self.friends[g.name]) {
+			continue
+		}
+		around[i] = g
+		seen := false
+		for _, other := range bordering {
+			seen = seen || other == g
+		}
+		if !seen {
+			bordering = append(bordering, g)
+		}
+	}
+	sort.Slice(bordering, func(i, j int) bool {
+		if bordering[i].zOrder != bordering[j].zOrder {
+			return bordering[i].zOrder < bordering[j].zOrder
+		}
+		return bordering[i].name < bordering[j].name
+	})
+
+	var items []uint16
+	for _, g := range bordering {
+		bd := g.outerBorder(self)
+		if bd == nil {
+			continue
+		}
+		mask := 0
+		for i := range around {
+			if around[i] == g {
+				mask |= 1 << i
+			}
+		}
+		for _, e := range edges(mask) {
+			if item := bd.items[e]; item != 0 {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// outerBorder returns the border placed around the ground next to the passed
+// ground, or nil if there is none. Borders naming the ground are preferred
+// over borders for all grounds.
+func (g *ground) outerBorder(next *ground) *border {
+	name := "none"
+	if next != nil {
+		name = next.name
+	}
+	var all *border
+	for i := range g.outer {
+		switch g.outer[i].to {
+		case name:
+			return &g.outer[i]
+		case "":
+			if all == nil {
+				all = &g.outer[i]
+			}
+		}
+	}
+	return all
+}
+
+// edges returns the pieces of a border placed on a tile with the bordered
+// ground on the neighbours in the passed mask.
+//
+// Where the ground is on two sides of a corner, a diagonal piece covers both
+// sides. Remaining sides get edge pieces, and corners with neither of their
+// sides bordered get corner pieces.
+func edges(mask int) []edge {
+	var pieces []edge
+	covered := 0
+	for _, d := range []struct {
+		sides int
+		piece edge
+	}{
+		{n | w, diagonalNW}, {n | e, diagonalNE}, {s | w, diagonalSW}, {s | e, diagonalSE},
+	} {
+		if mask&d.sides == d.sides {
+			pieces = append(pieces, d.piece)
+			covered |= d.sides
+		}
+	}
+	for _, side := range []struct {
+		side  int
+		piece edge
+	}{
+		{n, edgeN}, {e, edgeE}, {s, edgeS}, {w, edgeW},
+	} {
+		if mask&side.side != 0 && covered&side.side == 0 {
+			pieces = append(pieces, side.piece)
+		}
+	}
+	for _, c := range []struct {
+		corner, sides int
+		piece         edge
+	}{
+		{nw, n | w, cornerNW}, {ne, n | e, cornerNE}, {sw, s | w, cornerSW}, {se, s | e, cornerSE},
+	} {
+		if mask&c.corner != 0 && mask&c.sides == 0 {
+			pieces = append(pieces, c.piece)
+		}
+	}
+	return pieces
+}
@@ -0,0 +1,161 @@
+package autoborder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+const testBordersXML = `<?xml version="1.0" encoding="UTF-8"?>
+<materials>
+	<border id="1"> <!-- grass -->
+		<borderitem edge="n" item="101"/>
+		<borderitem edge="e" item="102"/>
+		<borderitem edge="s" item="103"/>
+		<borderitem edge="w" item="104"/>
+		<borderitem edge="cnw" item="105"/>
+		<borderitem edge="cne" item="106"/>
+		<borderitem edge="csw" item="107"/>
+		<borderitem edge="cse" item="108"/>
+		<borderitem edge="dnw" item="109"/>
+		<borderitem edge="dne" item="110"/>
+		<borderitem edge="dsw" item="111"/>
+		<borderitem edge="dse" item="112"/>
+	</border>
+	<border id="2"> <!-- grass next to nothing; only straight edges -->
+		<borderitem edge="n" item="201"/>
+		<borderitem edge="e" item="202"/>
+		<borderitem edge="s" item="203"/>
+		<borderitem edge="w" item="204"/>
+	</border>
+	<border id="3"> <!-- snow -->
+		<borderitem edge="n" item="301"/>
+		<borderitem edge="e" item="302"/>
+		<borderitem edge="s" item="303"/>
+		<borderitem edge="w" item="304"/>
+	</border>
+</materials>`
+
+const testGroundsXML = `<?xml version="1.0" encoding="UTF-8"?>
+<materials>
+	<brush name="grass" type="ground" server_lookid="10" z-order="3500">
+		<item id="10" chance="2500"/>
+		<item id="11" chance="10"/>
+		<border align="outer" id="1"/>
+		<border align="outer" to="none" id="2"/>
+		<border align="inner" id="3"/>
+	</brush>
+	<brush name="sand" type="ground" server_lookid="20" z-order="3400">
+		<item id="20" chance="1"/>
+	</brush>
+	<brush name="dirt" type="ground" server_lookid="30" z-order="3300">
+		<item id="30" chance="1"/>
+		<friend name="grass"/>
+	</brush>
+	<brush name="stone" type="ground" server_lookid="40" z-order="3600">
+		<item id="40" chance="1"/>
+	</brush>
+	<brush name="snow" type="ground" server_lookid="50" z-order="3700">
+		<item id="50" chance="1"/>
+		<border align="outer" id="3"/>
+	</brush>
+	<brush name="fence" type="wall" server_lookid="60">
+		<item id="60" chance="1"/>
+	</brush>
+</materials>`
+
+func testBrushes(t *testing.T) *Brushes {
+	t.Helper()
+	borders, err := xmls.ReadBorders(strings.NewReader(testBordersXML))
+	if err != nil {
+		t.Fatalf("reading borders: %v", err)
+	}
+	grounds, err := xmls.ReadGrounds(strings.NewReader(testGroundsXML))
+	if err != nil {
+		t.Fatalf("reading grounds: %v", err)
+	}
+	b, err := New(borders, grounds)
+	if err != nil {
+		t.Fatalf("creating brushes: %v", err)
+	}
+	return b
+}
+
+// testGrounds maps the letters of fixture grids to grounds; '.' is no ground.
+var testGrounds = map[byte]uint16{'g': 10, 'G': 11, 's': 20, 'd': 30, 'o': 40, 'w': 50, 'x': 99, '.': 0}
+
+// gridGround returns the ground at the passed position of the grid, given as
+// rows separated by spaces.
+func gridGround(grid string, x, y int) uint16 {
+	rows := strings.Fields(grid)
+	if y < 0 || y >= len(rows) || x < 0 || x >= len(rows[y]) {
+		return 0
+	}
+	return testGrounds[rows[y][x]]
+}
+
+func TestBorders(t *testing.T) {
+	b := testBrushes(t)
+	for _, tc := range []struct {
+		name string
+		grid string
+		x, y int
+		want []uint16
+	}{
+		{"corner", "sss sgs sss", 0, 0, []uint16{108}},
+		{"north edge", "sss sgs sss", 1, 0, []uint16{103}},
+		{"west edge", "sss sgs sss", 0, 1, []uint16{102}},
+		{"bordered ground itself", "sss sgs sss", 1, 1, nil},
+		{"other item of the same brush", "sss sGs sss", 2, 2, []uint16{105}},
+		{"diagonal", "gg gs", 1, 1, []uint16{109}},
+		{"diagonal with extra corner", "ggg gss gss", 1, 1, []uint16{109}},
+		{"opposite sides", "gsg", 1, 0, []uint16{102, 104}},
+		{"three sides", "ggg gsg", 1, 1, []uint16{109, 110}},
+		{"no ground", ".g", 0, 0, []uint16{202}},
+		{"no ground corner only", ".s sg", 0, 0, nil},
+		{"lower ground", "sg", 1, 0, nil},
+		{"friend", "gd", 1, 0, nil},
+		{"ground without brush", "gx", 1, 0, nil},
+		{"higher ground without borders", "os", 1, 0, nil},
+		{"two borders, by z-order", "wsg", 1, 0, []uint16{102, 304}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := b.Borders(func(dx, dy int) uint16 { return gridGround(tc.grid, tc.x+dx, tc.y+dy) })
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("borders at %d,%d of %q are %v, want %v", tc.x, tc.y, tc.grid, got, tc.want)
+			}
+		})
+	}
+
+	if !b.IsBorder(109) || !b.IsBorder(202) || b.IsBorder(10) {
+		t.Errorf("border items not told apart from other items")
+	}
+	if !b.IsGround(11) || b.IsGround(60) || b.IsGround(109) {
+		t.Errorf("ground items not told apart from other items")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		borders xmls.Borders
+		grounds xmls.Grounds
+	}{
+		{
+			"unknown edge",
+			xmls.Borders{Border: []xmls.Border{{ID: 1, Item: []xmls.BorderItem{{Edge: "north", Item: 100}}}}},
+			xmls.Grounds{},
+		},
+		{
+			"unknown border",
+			xmls.Borders{},
+			xmls.Grounds{Brush: []xmls.GroundBrush{{Name: "grass", Type: "ground", Border: []xmls.BrushBorder{{Align: "outer", ID: 7}}}}},
+		},
+	} {
+		if _, err := New(tc.borders, tc.grounds); err == nil {
+			t.Errorf("%s: creating brushes succeeded", tc.name)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package automap writes the map in the format the 8.x client keeps its
+// automap in, so that players can start with an already explored map.
+//
+// The client splits each floor into areas of 256x256 tiles, and keeps each
+// area in its own file in the Automap directory, named XXXYYYZZ.map after the
+// area's position (x/256 and y/256) and the floor. Each file holds the map
+// color of every tile of the area, then the walk speed of every tile, both
+// one byte per tile, column by column, followed by the marks placed on the
+// area.
+package automap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"badc0de.net/pkg/go-tibia/compositor"
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/things"
+
+	"github.com/golang/glog"
+)
+
+const (
+	AreaSize = 256 // Width and height of an area, in tiles.
+
+	// SpeedUnexplored is the speed of tiles the client has not seen yet.
+	SpeedUnexplored = 0xFA
+	// SpeedUnwalkable is the speed of tiles nobody can walk onto.
+	SpeedUnwalkable = 0xFF
+	// maxSpeed is the highest speed stored for walkable tiles; speeds above
+	// it would be mistaken for the values above.
+	maxSpeed = SpeedUnexplored - 1
+)
+
+// Area is the automap of a single area of a floor.
+type Area struct {
+	X, Y  uint16 // Position of the area, in areas; X=1 starts at tile 256.
+	Floor uint8
+
+	// Colors and Speeds are indexed by x*AreaSize+y, where x and y are
+	// relative to the top left tile of the area.
+	Colors [AreaSize * AreaSize]byte
+	Speeds [AreaSize * AreaSize]byte
+}
+
+// FileName returns the name under which the client looks for the area's file.
+func (a *Area) FileName() string {
+	return fmt.Sprintf("%03d%03d%02d.map", a.X, a.Y, a.Floor)
+}
+
+// WriteTo writes the area in the client's format, without any marks.
+func (a *Area) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, b := range [][]byte{a.Colors[:], a.Speeds[:], {0, 0, 0, 0} /* mark count */} {
+		wn, err := w.Write(b)
+		n += int64(wn)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// itemInfo is what the automap needs to know about an item type.
+type itemInfo struct {
+	color    byte
+	colorOK  bool
+	speed    uint16 // ground speed; zero if not a ground item
+	blocking bool
+}
+
+// Generator paints automap areas of a map.
+type Generator struct {
+	m gameworld.MapDataSource
+
+	info  func(serverID uint16) itemInfo
+	infos map[uint16]itemInfo
+}
+
+// NewGenerator returns a generator painting the passed map, looking up map
+// colors and ground speeds of items in the passed things.
+func NewGenerator(m gameworld.MapDataSource, th *things.Things) *Generator {
+	return &Generator{
+		m: m,
+		info: func(serverID uint16) itemInfo {
+			thItem, err := th.Item(serverID, 854)
+			if err != nil {
+				glog.Errorf("could not get item of type %d: %v", serverID, err)
+				return itemInfo{}
+			}
+			if !thItem.ValidClientItem() {
+				return itemInfo{}
+			}
+			datItem := thItem.RawClientDatasetItem780()
+			return itemInfo{
+				color:    byte(datItem.MapColor),
+				colorOK:  datItem.MapColorOK,
+				speed:    datItem.GroundSpeed,
+				blocking: datItem.BlockingPlayer,
+			}
+		},
+		infos: map[uint16]itemInfo{},
+	}
+}
+
+func (g *Generator) itemInfo(serverID uint16) itemInfo {
+	info, ok := g.infos[serverID]
+	if !ok {
+		info = g.info(serverID)
+		g.infos[serverID] = info
+	}
+	return info
+}
+
+// Area paints the area at the passed position, in areas, of the passed
+// floor. It returns nil if there are no tiles on the area.
+//
+// Tiles which are not on the map are left unexplored. Other tiles take the
+// map color of the top-most item which has one, and the speed of their
+// ground, unless there is no ground or some item blocks players.
+func (g *Generator) Area(x, y uint16, floor uint8) *Area {
+	a := &Area{X: x, Y: y, Floor: floor}
+	empty := true
+	var items []uint16
+	for tx := 0; tx < AreaSize; tx++ {
+		for ty := 0; ty < AreaSize; ty++ {
+			idx := tx*AreaSize + ty
+			a.Speeds[idx] = SpeedUnexplored
+			t, err := g.m.GetMapTile(uint16(int(x)*AreaSize+tx), uint16(int(y)*AreaSize+ty), floor)
+			if err != nil {
+				continue
+			}
+			items = items[:0]
+			for item, err := t.GetItem(len(items)); err == nil; item, err = t.GetItem(len(items)) {
+				items = append(items, item.GetServerType())
+			}
+			if len(items) == 0 {
+				continue
+			}
+			empty = false
+
+			var speed uint16
+			blocking := false
+			colored := false
+			for i := len(items) - 1; i >= 0; i-- {
+				info := g.itemInfo(items[i])
+				if info.colorOK && !colored {
+					a.Colors[idx] = info.color
+					colored = true
+				}
+				if info.speed != 0 && speed == 0 {
+					speed = info.speed
+				}
+				blocking = blocking || info.blocking
+			}
+			switch {
+			case speed == 0 || blocking:
+				a.Speeds[idx] = SpeedUnwalkable
+			case speed > maxSpeed:
+				a.Speeds[idx] = maxSpeed
+			default:
+				a.Speeds[idx] = byte(speed)
+			}
+		}
+	}
+	if empty {
+		return nil
+	}
+	return a
+}
+
+// WriteAll writes the files of all areas with tiles on them into the passed
+// directory, and returns how many were written. The map must know its size.
+func (g *Generator) WriteAll(dir string) (int, error) {
+	width, height, ok := compositor.MinimapBounds(g.m)
+	if !ok {
+		return 0, errors.New("map does not know its size")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("creating automap directory: %v", err)
+	}
+
+	written := 0
+	for floor := uint8(0); floor <= 15; floor++ {
+		for x := 0; x*AreaSize < width; x++ {
+			for y := 0; y*AreaSize < height; y++ {
+				a := g.Area(uint16(x), uint16(y), floor)
+				if a == nil {
+					continue
+				}
+				if err := writeArea(filepath.Join(dir, a.FileName()), a); err != nil {
+					return written, fmt.Errorf("writing automap area %s: %v", a.FileName(), err)
+				}
+				written++
+			}
+		}
+	}
+	return written, nil
+}
+
+func writeArea(path string, a *Area) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := a.WriteTo(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadArea reads the area of the passed position and floor from its file, as
+// written by the client or by WriteTo. Marks are skipped.
+func ReadArea(r io.Reader, x, y uint16, floor uint8) (*Area, error) {
+	a := &Area{X: x, Y: y, Floor: floor}
+	if _, err := io.ReadFull(r, a.Colors[:]); err != nil {
+		return nil, fmt.Errorf("reading automap colors: %w", err)
+	}
+	if _, err := io.ReadFull(r, a.Speeds[:]); err != nil {
+		return nil, fmt.Errorf("reading automap speeds: %w", err)
+	}
+	var marks uint32
+	if err := binary.Read(r, binary.LittleEndian, &marks); err != nil {
+		return nil, fmt.Errorf("reading automap mark count: %w", err)
+	}
+	return a, nil
+}
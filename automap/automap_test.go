@@ -0,0 +1,150 @@
+package automap
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+)
+
+type testItem uint16
+
+func (i testItem) GetServerType() uint16 { return uint16(i) }
+func (i testItem) GetCount() uint16      { return 0 }
+
+type testTile struct {
+	gameworld.MapTile
+	items []uint16
+}
+
+func (t testTile) GetItem(idx int) (gameworld.MapItem, error) {
+	if idx >= len(t.items) {
+		return nil, errors.New("no such item")
+	}
+	return testItem(t.items[idx]), nil
+}
+
+// testMap is a map of the passed size, with tiles only where set.
+type testMap struct {
+	gameworld.MapDataSource
+	w, h  uint16
+	tiles map[[3]int][]uint16
+}
+
+func (m testMap) GetMapTile(x, y uint16, floor uint8) (gameworld.MapTile, error) {
+	items, ok := m.tiles[[3]int{int(x), int(y), int(floor)}]
+	if !ok {
+		return nil, errors.New("no tile")
+	}
+	return testTile{items: items}, nil
+}
+
+func (m testMap) Dimensions() (width, height uint16) {
+	return m.w, m.h
+}
+
+// testGenerator returns a generator knowing about grass (100, speed 150,
+// green), water (101, blocking, blue), a wall (200, blocking, gray), a
+// coin (300, no color) and a slow mud ground (102, speed 300, brown).
+func testGenerator(m gameworld.MapDataSource) *Generator {
+	g := NewGenerator(m, nil)
+	g.info = func(serverID uint16) itemInfo {
+		return map[uint16]itemInfo{
+			100: {color: 24, colorOK: true, speed: 150},
+			101: {color: 40, colorOK: true, speed: 100, blocking: true},
+			102: {color: 121, colorOK: true, speed: 300},
+			200: {color: 86, colorOK: true, blocking: true},
+		}[serverID]
+	}
+	return g
+}
+
+func TestArea(t *testing.T) {
+	m := testMap{w: 600, h: 300, tiles: map[[3]int][]uint16{
+		{256 + 1, 2, 7}: {100},
+		{256 + 2, 2, 7}: {100, 200},
+		{256 + 3, 2, 7}: {101},
+		{256 + 4, 2, 7}: {100, 300},
+		{256 + 5, 2, 7}: {300},
+		{256 + 6, 2, 7}: {102},
+		{256 + 7, 2, 7}: {},
+	}}
+	g := testGenerator(m)
+
+	if a := g.Area(0, 0, 7); a != nil {
+		t.Errorf("area without tiles was painted")
+	}
+	a := g.Area(1, 0, 7)
+	if a == nil {
+		t.Fatalf("area with tiles was not painted")
+	}
+	if got, want := a.FileName(), "00100007.map"; got != want {
+		t.Errorf("file name %q, want %q", got, want)
+	}
+	for _, tc := range []struct {
+		x, y         int
+		color, speed byte
+	}{
+		{0, 0, 0, SpeedUnexplored},
+		{1, 2, 24, 150},
+		{2, 2, 86, SpeedUnwalkable},
+		{3, 2, 40, SpeedUnwalkable},
+		{4, 2, 24, 150},
+		{5, 2, 0, SpeedUnwalkable},
+		{6, 2, 121, maxSpeed},
+		{7, 2, 0, SpeedUnexplored},
+		{2, 1, 0, SpeedUnexplored},
+	} {
+		idx := tc.x*AreaSize + tc.y
+		if got := a.Colors[idx]; got != tc.color {
+			t.Errorf("color of %d,%d is %d, want %d", tc.x, tc.y, got, tc.color)
+		}
+		if got := a.Speeds[idx]; got != tc.speed {
+			t.Errorf("speed of %d,%d is %d, want %d", tc.x, tc.y, got, tc.speed)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if n, err := a.WriteTo(buf); err != nil {
+		t.Fatalf("writing area: %v", err)
+	} else if want := int64(2*AreaSize*AreaSize + 4); n != want || int64(buf.Len()) != want {
+		t.Errorf("wrote %d bytes (reported %d), want %d", buf.Len(), n, want)
+	}
+	read, err := ReadArea(buf, a.X, a.Y, a.Floor)
+	if err != nil {
+		t.Fatalf("reading area: %v", err)
+	}
+	if *read != *a {
+		t.Errorf("area read back differs from area written")
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	m := testMap{w: 600, h: 300, tiles: map[[3]int][]uint16{
+		{10, 10, 7}:   {100},
+		{520, 260, 7}: {100},
+		{300, 10, 8}:  {101},
+	}}
+	dir := t.TempDir()
+	n, err := testGenerator(m).WriteAll(dir)
+	if err != nil {
+		t.Fatalf("writing automap: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("wrote %d areas, want 3", n)
+	}
+	for _, name := range []string{"00000007.map", "00200107.map", "00100008.map"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("area not written: %v", err)
+		} else if fi.Size() != 2*AreaSize*AreaSize+4 {
+			t.Errorf("area %s has %d bytes", name, fi.Size())
+		}
+	}
+
+	if _, err := testGenerator(testMap{}).WriteAll(dir); err == nil {
+		t.Errorf("writing automap of map without size succeeded")
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"fmt"
@@ -27,17 +30,27 @@ import (
 	"badc0de.net/pkg/go-tibia/secrets"
 	"badc0de.net/pkg/go-tibia/things/full"
 	"badc0de.net/pkg/go-tibia/web"
+	"badc0de.net/pkg/go-tibia/xmls"
 )
 
 var (
 	quitChan = make(chan int)
 
-	tibiaPicPath string
-	mapPath      string
+	tibiaPicPath    string
+	mapPath         string
+	monstersXMLPath string
 
 	loginListenAddr = flag.String("login_listen_address", ":7171", "where the login server will listen")
 	gameListenAddr  = flag.String("game_listen_address", ":7172", "where the game server will listen")
 
+	npcDir = flag.String("npc_dir", "", "directory containing per-NPC XML files, such as Sam.xml; defaults to the npc directory next to the directory containing monsters.xml")
+
+	houseStatePath = flag.String("house_state_path", "", "file in which house owners and access lists are kept; if unset, changes to them are lost on restart")
+
+	scriptsDir = flag.String("scripts_dir", "", "directory containing Starlark scripts (*.star) for items, tiles and words; reloaded on SIGHUP")
+
+	terrainSeed = flag.Int64("terrain_seed", 1, "seed of the terrain generated when map_path is ':terrain:'")
+
 	debugWebServer = flag.String("debug_web_server_listen_address", "", "where the debug server will listen")
 	muxRouter      *mux.Router
 )
@@ -46,6 +59,7 @@ func setupFilePathFlags() {
 	full.SetupFilePathFlags()
 	paths.SetupFilePathFlag("map.otbm", "map_path", &mapPath)
 	paths.SetupFilePathFlag("Tibia.pic", "tibia_pic_path", &tibiaPicPath)
+	paths.SetupFilePathFlag("monsters.xml", "monsters_xml_path", &monstersXMLPath)
 }
 
 func main() {
@@ -210,6 +224,78 @@ func serveLameDuck(l *net.TCPListener, stop chan bool, lgn *login.LoginServer, g
 	}
 }
 
+func readSpawns(path string) (*xmls.Spawns, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	spawns, err := xmls.ReadSpawns(f)
+	if err != nil {
+		return nil, err
+	}
+	return &spawns, nil
+}
+
+func readHouses(path string, tiles map[uint32][]tnet.Position) ([]*gameworld.House, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	houses, err := xmls.ReadHouses(f)
+	if err != nil {
+		return nil, err
+	}
+	return gameworld.NewHouses(houses, tiles), nil
+}
+
+func readMonsters(path string) (*xmls.Monsters, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return xmls.ReadMonsters(f, func(file string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(filepath.Dir(path), file))
+	})
+}
+
+func readOutfits() (*xmls.Outfits, error) {
+	f, err := paths.Open("outfits.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	outfits, err := xmls.ReadOutfits(f)
+	if err != nil {
+		return nil, err
+	}
+	return &outfits, nil
+}
+
+func readNPCs(dir string, names []string) (*xmls.NPCs, error) {
+	return xmls.ReadNPCs(names, func(file string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, file))
+	})
+}
+
+// reloadScriptsOnHangup reloads the gameworld's scripts whenever the process
+// receives SIGHUP.
+func reloadScriptsOnHangup(gw *gameworld.GameworldServer) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		glog.Infoln("reloading scripts")
+		if err := gw.ReloadScripts(); err != nil {
+			glog.Errorln("reloading scripts; keeping previously loaded scripts", err)
+		}
+	}
+}
+
 func games() {
 	l, err := net.Listen("tcp", ":7172")
 	if err != nil {
@@ -248,21 +334,51 @@ func games() {
 	///
 
 	gw.SetThings(t)
+	if outfits, err := readOutfits(); err != nil {
+		glog.Errorln("reading outfits; players will not be able to change outfits", err)
+	} else {
+		gw.SetOutfits(outfits)
+	}
 
 	var m gameworld.MapDataSource
+	var spawns *xmls.Spawns
+	var houses []*gameworld.House
 	if mapPath == ":test:" {
 		m = gameworld.NewMapDataSource()
+	} else if mapPath == ":terrain:" {
+		if err := gameworld.DefaultTerrainPalette.Check(t); err != nil {
+			glog.Warningf("generated terrain may not be walkable: %v", err)
+		}
+		m, err = gameworld.NewTerrainMapDataSource(gameworld.TerrainOptions{Seed: *terrainSeed})
+		if err != nil {
+			glog.Errorln("generating terrain", err)
+			return
+		}
 	} else {
 		f, err := os.Open(mapPath)
 		if err != nil {
 			glog.Errorln("opening map file", err)
 			return
 		}
-		m, err = otbm.New(f, t)
+		otm, err := otbm.New(f, t)
 		if err != nil {
 			glog.Errorln("reading map file", err)
 			return
 		}
+		m = otm
+
+		if otm.ExtSpawnFile() != "" {
+			spawns, err = readSpawns(filepath.Join(filepath.Dir(mapPath), otm.ExtSpawnFile()))
+			if err != nil {
+				glog.Errorln("reading spawn file; continuing without spawns", err)
+			}
+		}
+		if otm.ExtHouseFile() != "" {
+			houses, err = readHouses(filepath.Join(filepath.Dir(mapPath), otm.ExtHouseFile()), otm.HouseTiles())
+			if err != nil {
+				glog.Errorln("reading house file; continuing without houses", err)
+			}
+		}
 	}
 	if muxRouter != nil && webh != nil {
 		webh.RegisterMapRoute(muxRouter, m)
@@ -270,6 +386,48 @@ func games() {
 		glog.Infof("not registering webh map routes since web server is not enabled")
 	}
 	gw.SetMapDataSource(m)
+	if monstersXMLPath != "" {
+		monsters, err := readMonsters(monstersXMLPath)
+		if err != nil {
+			glog.Errorln("reading monsters; continuing without monster definitions", err)
+		} else {
+			if err := monsters.Validate(t); err != nil {
+				glog.Warningf("monster definitions refer to unknown things: %v", err)
+			}
+			gw.SetMonsters(monsters)
+		}
+	}
+	if spawns != nil && len(spawns.NPCNames()) > 0 {
+		dir := *npcDir
+		if dir == "" && monstersXMLPath != "" {
+			dir = filepath.Join(filepath.Dir(filepath.Dir(monstersXMLPath)), "npc")
+		}
+		npcs, err := readNPCs(dir, spawns.NPCNames())
+		if err != nil {
+			glog.Errorln("reading npcs; continuing without npc definitions", err)
+		} else {
+			gw.SetNPCs(npcs)
+		}
+	}
+	if spawns != nil {
+		gw.AddSpawns(*spawns)
+	}
+	if houses != nil {
+		gw.SetHouses(houses)
+		if *houseStatePath != "" {
+			if err := gw.SetHouseStatePath(*houseStatePath); err != nil {
+				glog.Errorln("loading house state; houses start without owners", err)
+			}
+		}
+	}
+	if *scriptsDir != "" {
+		if err := gw.LoadScripts(*scriptsDir); err != nil {
+			glog.Errorln("loading scripts; continuing without scripts", err)
+		} else {
+			go reloadScriptsOnHangup(gw)
+		}
+	}
+	gw.StartWorld()
 
 	///
 
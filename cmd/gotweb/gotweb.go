@@ -14,11 +14,13 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"badc0de.net/pkg/flagutil"
 
 	tdat "badc0de.net/pkg/go-tibia/dat"
 	"badc0de.net/pkg/go-tibia/gameworld"    // for map compositor
+	"badc0de.net/pkg/go-tibia/maptiles"     // for map tiles
 	otbm "badc0de.net/pkg/go-tibia/otb/map" // for map loader
 	"badc0de.net/pkg/go-tibia/paths"
 	"badc0de.net/pkg/go-tibia/spr"
@@ -44,6 +46,11 @@ var (
 	tibiaPicPath string
 	mapPath      string
 
+	mapTilesCacheDir     = flag.String("map_tiles_cache_dir", maptiles.DefaultCacheDir(), "directory in which to keep rendered map tiles served at /maptiles/; if empty, tiles are rendered on every request, which is very slow for zoomed out tiles")
+	renderMapTiles       = flag.Bool("render_map_tiles", false, "instead of serving, render all map tiles into --map_tiles_cache_dir and exit")
+	renderMapTilesFloors = flag.String("render_map_tiles_floors", "0-15", "floors whose tiles are rendered by --render_map_tiles, such as '7' or '0-7,9'")
+	mapTilesHTMLPath     string
+
 	htmlPath        string
 	appHTMLPath     string
 	itemsHTMLPath   string
@@ -62,6 +69,7 @@ func setupFilePathFlags() {
 	paths.SetupFilePathFlag(":test:", "map_path", &mapPath)
 	paths.SetupFilePathFlag("itemtable.html", "items_index_html_path", &itemsHTMLPath)
 	paths.SetupFilePathFlag("outfittable.html", "outfits_index_html_path", &outfitsHTMLPath)
+	paths.SetupFilePathFlag("maptiles.html", "maptiles_html_path", &mapTilesHTMLPath)
 	paths.SetupFilePathFlag("html/index.html", "app_html_path", &appHTMLPath)
 	paths.SetupFilePathFlag("vapid_subscriptions.json", "writable_vapid_subscriptions_json_path", &vapidSubscriptionsPath)
 	htmlPath = filepath.Dir(appHTMLPath)
@@ -605,27 +613,30 @@ func main() {
 	h := web.NewHandler(th, full.PathFlagValue(full.FlagTibiaSprPath), tibiaPicPath)
 	h.RegisterRoutes(r)
 
+	if *renderMapTiles {
+		floors, err := parseFloors(*renderMapTilesFloors)
+		if err != nil {
+			glog.Exitf("bad --render_map_tiles_floors: %v", err)
+		}
+		m, sig, err := loadMap()
+		if err != nil {
+			glog.Exitf("loading map: %v", err)
+		}
+		p := maptiles.New(m, th, maptiles.Options{CacheDir: *mapTilesCacheDir, MapSignature: sig})
+		if err := p.RenderAll(floors); err != nil {
+			glog.Exitf("rendering map tiles: %v", err)
+		}
+		return
+	}
+
 	go func() {
-		var m gameworld.MapDataSource
-		if mapPath == ":test:" {
-			m = gameworld.NewMapDataSource()
-		} else if mapPath == "" {
-			glog.Warningf("mappath passed is empty, despite default being :test:; assuming :test:")
-			m = gameworld.NewMapDataSource()
-		} else {
-			f, err := os.Open(mapPath)
-			if err != nil {
-				glog.Errorln("opening map file", err)
-				return
-			}
-			m, err = otbm.New(f, th)
-			if err != nil {
-				glog.Errorln("reading map file", err)
-				return
-			}
-			f.Close()
+		m, sig, err := loadMap()
+		if err != nil {
+			glog.Errorln(err)
+			return
 		}
 		h.RegisterMapRoute(r, m)
+		h.RegisterMapTilesRoutes(r, maptiles.New(m, th, maptiles.Options{CacheDir: *mapTilesCacheDir, MapSignature: sig}), mapTilesHTMLPath)
 	}()
 
 	if *vapidPrivate != "" {
@@ -667,6 +678,61 @@ func main() {
 	glog.Fatal(http.ListenAndServe(*listenAddress, handlers.LoggingHandler(os.Stderr, r)))
 }
 
+// loadMap loads the map passed with --map_path, and returns it along with a
+// signature of its contents.
+func loadMap() (gameworld.MapDataSource, string, error) {
+	if mapPath == ":test:" {
+		return gameworld.NewMapDataSource(), "test", nil
+	} else if mapPath == "" {
+		glog.Warningf("mappath passed is empty, despite default being :test:; assuming :test:")
+		return gameworld.NewMapDataSource(), "test", nil
+	}
+
+	f, err := os.Open(mapPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("opening map file: %v", err)
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, "", fmt.Errorf("reading map file: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", fmt.Errorf("reading map file: %v", err)
+	}
+	m, err := otbm.New(f, th)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading map file: %v", err)
+	}
+	return m, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// parseFloors parses a list of floors such as "0-7,9".
+func parseFloors(s string) ([]uint8, error) {
+	var floors []uint8
+	for _, part := range strings.Split(s, ",") {
+		from, to := part, part
+		if idx := strings.Index(part, "-"); idx >= 0 {
+			from, to = part[:idx], part[idx+1:]
+		}
+		f, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		t, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return nil, err
+		}
+		if f < 0 || t > 15 || f > t {
+			return nil, fmt.Errorf("bad floor range %q", part)
+		}
+		for floor := f; floor <= t; floor++ {
+			floors = append(floors, uint8(floor))
+		}
+	}
+	return floors, nil
+}
+
 func genericFileEtagForContent(fn, kind string, content io.ReadSeeker, w http.ResponseWriter, r *http.Request) string {
 	// TODO: do not set http error here
 	generation := 1
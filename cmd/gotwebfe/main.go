@@ -195,17 +195,33 @@ func loaderImp(resolve, reject jsValue) {
 	if mapPath == ":test:" {
 		m = gameworld.NewMapDataSource()
 	} else {
-		f, err := paths.Open(mapPath)
-		if err != nil {
-			failPromise(err, "opening map file", reject)
-			return
-		}
-		m, err = otbm.New(f, t)
-		if err != nil {
-			failPromise(err, "reading map file", reject)
-			return
+		if idx, err := loadMapIndex(otbm.IndexPath(mapPath)); err == nil {
+			// Only the parts of the map holding tile areas which are
+			// shown are downloaded, and only then. The file stays open.
+			f, err := paths.OpenRanged(mapPath)
+			if err != nil {
+				failPromise(err, "opening map file", reject)
+				return
+			}
+			m, err = otbm.NewLazy(f, idx, t)
+			if err != nil {
+				failPromise(err, "reading map file", reject)
+				return
+			}
+		} else {
+			log.Printf("not using map index: %v", err)
+			f, err := paths.Open(mapPath)
+			if err != nil {
+				failPromise(err, "opening map file", reject)
+				return
+			}
+			m, err = otbm.New(f, t)
+			if err != nil {
+				failPromise(err, "reading map file", reject)
+				return
+			}
+			f.Close()
 		}
-		f.Close()
 	}
 
 	globalMap = m
@@ -240,3 +256,12 @@ func showMap(this jsValue, arg []jsValue) interface{} {
 
 	return nil
 }
+
+func loadMapIndex(indexPath string) (*otbm.Index, error) {
+	f, err := paths.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return otbm.ReadIndex(f)
+}
@@ -31,6 +31,9 @@ var (
 	mapBot = flag.Int("map_bot", 7, "bottom of the rendered map")
 	mapTop = flag.Int("map_top", 0, "top of the rendered map")
 
+	mapMinimap      = flag.Bool("map_minimap", false, "whether to render the map as a minimap of floor map_bot, one square per tile; unless map_w or map_h are passed, the whole floor is rendered")
+	mapMinimapScale = flag.Int("map_minimap_scale", 1, "size of each tile on the minimap, in pixels")
+
 	itemsOTBPath string
 	itemsXMLPath string
 	tibiaDatPath string
@@ -74,7 +77,15 @@ func main() {
 	if *picID != 0 {
 		picHandler(*picID)
 	}
-	if *mapPath != "" {
+	if *mapPath != "" && *mapMinimap {
+		wholeFloor := true
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "map_w" || f.Name == "map_h" {
+				wholeFloor = false
+			}
+		})
+		minimapHandler(*mapPath, *mapX, *mapY, *mapW, *mapH, *mapBot, *mapMinimapScale, wholeFloor)
+	} else if *mapPath != "" {
 		mapHandler(*mapPath, *mapX, *mapY, *mapW, *mapH, *mapBot, *mapTop)
 	}
 }
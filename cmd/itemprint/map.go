@@ -17,6 +17,13 @@ func mapHandler(mapPath string, x, y, w, h, bot, top int) {
 		glog.Errorf("error loading map: %v", err)
 		return
 	}
+	if lm, ok := m.(*otbm.Map); ok {
+		// Only read the part of the map that will be rendered.
+		if err := lm.LoadRegion(uint16(x), uint16(y), w, h, uint8(top), uint8(bot)); err != nil {
+			glog.Errorf("error loading map region: %v", err)
+			return
+		}
+	}
 
 	// TODO: more input validation! never allow for number inside CompositeMap to go negative, e.g.
 	img := compositor.CompositeMap(m, th, uint16(x), uint16(y), uint8(top), uint8(bot), w, h, 32, 32)
@@ -25,6 +32,23 @@ func mapHandler(mapPath string, x, y, w, h, bot, top int) {
 
 }
 
+func minimapHandler(mapPath string, x, y, w, h, floor, scale int, wholeFloor bool) {
+	m, err := loadMap(mapPath)
+	if err != nil {
+		glog.Errorf("error loading map: %v", err)
+		return
+	}
+	if wholeFloor {
+		if mw, mh, ok := compositor.MinimapBounds(m); ok {
+			x, y, w, h = 0, 0, mw, mh
+		}
+	}
+
+	img := compositor.CompositeMinimap(m, th, uint16(x), uint16(y), uint8(floor), w, h, scale)
+
+	out(img)
+}
+
 func loadMap(mapPath string) (gameworld.MapDataSource, error) {
 	var m gameworld.MapDataSource
 	if mapPath == ":test:" {
@@ -34,6 +58,17 @@ func loadMap(mapPath string) (gameworld.MapDataSource, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "opening map file")
 		}
+		if idx, err := loadMapIndex(otbm.IndexPath(mapPath)); err == nil {
+			// The file stays open, as tiles are read as they are needed.
+			m, err = otbm.NewLazy(f, idx, th)
+			if err != nil {
+				f.Close()
+				return nil, errors.Wrap(err, "reading map file")
+			}
+			return m, nil
+		} else if !os.IsNotExist(err) {
+			glog.Warningf("not using map index: %v", err)
+		}
 		m, err = otbm.New(f, th)
 		if err != nil {
 			return nil, errors.Wrap(err, "reading map file")
@@ -42,3 +77,12 @@ func loadMap(mapPath string) (gameworld.MapDataSource, error) {
 	}
 	return m, nil
 }
+
+func loadMapIndex(indexPath string) (*otbm.Index, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return otbm.ReadIndex(f)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+
+	"badc0de.net/pkg/go-tibia/otb"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/otb/map"
+)
+
+// fileKind is the format of the file being dumped, deciding how its nodes
+// are decoded.
+type fileKind string
+
+const (
+	kindAuto  fileKind = "auto"
+	kindItems fileKind = "items"
+	kindMap   fileKind = "map"
+	kindRaw   fileKind = "raw"
+)
+
+// detectKind guesses the format of a file from its root node.
+func detectKind(root *otb.Node) fileKind {
+	p := root.Props
+	switch {
+	case len(p) >= 7 && p[4] == itemsotb.ROOT_ATTR_VERSION && binary.LittleEndian.Uint16(p[5:]) == 4+4+4+128:
+		// Flags, then the version attribute of a fixed size.
+		return kindItems
+	case len(p) == 16 && otbm.MapVersion(binary.LittleEndian.Uint32(p)) <= otbm.OTBM_VERSION_LATEST:
+		// Version, size and version of items.otb.
+		return kindMap
+	default:
+		return kindRaw
+	}
+}
+
+// field is a single decoded value from the props of a node.
+type field struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// dumpedNode is what is printed about a node.
+type dumpedNode struct {
+	Depth    int     `json:"depth"`
+	Offset   int64   `json:"offset,omitempty"`
+	Type     uint8   `json:"type"`
+	TypeName string  `json:"type_name,omitempty"`
+	PropsLen int     `json:"props_len"`
+	Fields   []field `json:"fields,omitempty"`
+	Rest     string  `json:"rest,omitempty"` // Props which were not decoded, in hex.
+}
+
+// decoder decodes the nodes of a file of a single kind, in the order they
+// are stored.
+type decoder struct {
+	kind       fileKind
+	mapVersion otbm.MapVersion
+}
+
+func (d *decoder) decode(n *otb.Node) dumpedNode {
+	dn := dumpedNode{Depth: n.Depth, Offset: n.Offset, Type: n.Type, PropsLen: len(n.Props)}
+	c := &cursor{b: n.Props, ok: true}
+	switch d.kind {
+	case kindItems:
+		if n.Depth == 0 {
+			dn.TypeName = "root"
+			d.itemsRoot(c)
+		} else {
+			dn.TypeName = itemsotb.ItemGroup(n.Type).String()
+			d.item(c)
+		}
+	case kindMap:
+		dn.TypeName = otbm.MapNodeType(n.Type).String()
+		if n.Depth == 0 {
+			d.mapRoot(c)
+		} else {
+			d.mapNode(otbm.MapNodeType(n.Type), c)
+		}
+	}
+	dn.Fields = c.fields
+	dn.Rest = hex.EncodeToString(c.b[c.done:])
+	return dn
+}
+
+func (d *decoder) itemsRoot(c *cursor) {
+	c.add("flags", c.u32())
+	for c.more() {
+		attr, size := c.u8(), c.u16()
+		if attr != itemsotb.ROOT_ATTR_VERSION || size != 4+4+4+128 {
+			c.add(fmt.Sprintf("attr %02x", attr), hex.EncodeToString(c.take(int(size))))
+			continue
+		}
+		c.add("major version", c.u32())
+		c.add("client version", itemsotb.ClientVersion(c.u32()).String())
+		c.add("build number", c.u32())
+		csd := c.take(128)
+		if i := bytes.IndexByte(csd, 0); i >= 0 {
+			csd = csd[:i]
+		}
+		c.add("csd version", string(csd))
+	}
+}
+
+func (d *decoder) item(c *cursor) {
+	flags := itemsotb.ItemsFlags(c.u32())
+	c.add("flags", fmt.Sprintf("%08x [%s]", uint32(flags), flags))
+	for c.more() {
+		attr, size := itemsotb.ItemsAttribute(c.u8()), c.u16()
+		name := attr.String()
+		if name == "invalid attribute" {
+			name = fmt.Sprintf("attr %02x", uint8(attr))
+		}
+		switch {
+		case size == 2 && (attr == itemsotb.ITEM_ATTR_SERVERID || attr == itemsotb.ITEM_ATTR_CLIENTID ||
+			attr == itemsotb.ITEM_ATTR_SPEED || attr == itemsotb.ITEM_ATTR_MINIMAPCOLOR || attr == itemsotb.ITEM_ATTR_WAREID):
+			c.add(name, c.u16())
+		case size == 1 && attr == itemsotb.ITEM_ATTR_TOPORDER:
+			c.add(name, c.u8())
+		case size == 4 && attr == itemsotb.ITEM_ATTR_LIGHT2:
+			c.add(name, fmt.Sprintf("level %d color %d", c.u16(), c.u16()))
+		case attr == itemsotb.ITEM_ATTR_NAME || attr == itemsotb.ITEM_ATTR_DESCR:
+			c.add(name, string(c.take(int(size))))
+		default:
+			c.add(name, hex.EncodeToString(c.take(int(size))))
+		}
+	}
+}
+
+func (d *decoder) mapRoot(c *cursor) {
+	d.mapVersion = otbm.MapVersion(c.u32())
+	c.add("version", uint32(d.mapVersion))
+	c.add("width", c.u16())
+	c.add("height", c.u16())
+	c.add("items major version", c.u32())
+	c.add("items minor version", c.u32())
+}
+
+func (d *decoder) mapNode(t otbm.MapNodeType, c *cursor) {
+	switch t {
+	case otbm.OTBM_MAP_DATA:
+		for c.more() {
+			switch attr := otbm.ItemAttribute(c.u8()); attr {
+			case otbm.OTBM_ATTR_DESCRIPTION, otbm.OTBM_ATTR_EXT_SPAWN_FILE, otbm.OTBM_ATTR_EXT_HOUSE_FILE:
+				c.add(attr.String(), c.str())
+			default:
+				c.stop()
+			}
+		}
+	case otbm.OTBM_TILE_AREA:
+		c.add("base", c.pos())
+	case otbm.OTBM_TILE, otbm.OTBM_HOUSETILE:
+		c.add("offset", fmt.Sprintf("%d,%d", c.u8(), c.u8()))
+		if t == otbm.OTBM_HOUSETILE {
+			c.add("house id", c.u32())
+		}
+		for c.more() {
+			switch attr := otbm.ItemAttribute(c.u8()); attr {
+			case otbm.OTBM_ATTR_TILE_FLAGS:
+				c.add(attr.String(), fmt.Sprintf("%08x", c.u32()))
+			case otbm.OTBM_ATTR_ITEM:
+				c.add(attr.String(), c.u16())
+				if d.mapVersion == otbm.OTBM_VERSION_1 {
+					// Whether a count follows depends on the item.
+					c.stop()
+				}
+			default:
+				c.stop()
+			}
+		}
+	case otbm.OTBM_ITEM:
+		c.add("id", c.u16())
+		if d.mapVersion == otbm.OTBM_VERSION_1 {
+			c.stop()
+		}
+		d.itemAttrs(c)
+	case otbm.OTBM_TOWN:
+		c.add("id", c.u32())
+		c.add("name", c.str())
+		c.add("temple", c.pos())
+	case otbm.OTBM_WAYPOINT:
+		c.add("name", c.str())
+		c.add("position", c.pos())
+	}
+}
+
+func (d *decoder) itemAttrs(c *cursor) {
+	for c.more() {
+		attr := otbm.ItemAttribute(c.u8())
+		switch attr {
+		case otbm.OTBM_ATTR_COUNT, otbm.OTBM_ATTR_RUNE_CHARGES, otbm.OTBM_ATTR_DECAYING_STATE, otbm.OTBM_ATTR_HOUSEDOORID:
+			c.add(attr.String(), c.u8())
+		case otbm.OTBM_ATTR_ACTION_ID, otbm.OTBM_ATTR_UNIQUE_ID, otbm.OTBM_ATTR_DEPOT_ID, otbm.OTBM_ATTR_CHARGES:
+			c.add(attr.String(), c.u16())
+		case otbm.OTBM_ATTR_DURATION, otbm.OTBM_ATTR_WRITTENDATE, otbm.OTBM_ATTR_SLEEPERGUID, otbm.OTBM_ATTR_SLEEPSTART:
+			c.add(attr.String(), c.u32())
+		case otbm.OTBM_ATTR_TEXT, otbm.OTBM_ATTR_DESC, otbm.OTBM_ATTR_WRITTENBY:
+			c.add(attr.String(), c.str())
+		case otbm.OTBM_ATTR_TELE_DEST:
+			c.add(attr.String(), c.pos())
+		case otbm.OTBM_ATTR_ATTRIBUTE_MAP:
+			c.add(attr.String(), c.attributeMap())
+		default:
+			c.stop()
+		}
+	}
+}
+
+// cursor reads values from props, keeping track of how far they were
+// decoded. Once a read fails, all further reads fail.
+type cursor struct {
+	b      []byte
+	at     int
+	ok     bool
+	fields []field
+	done   int // Props up to here are decoded into fields.
+}
+
+// add adds a decoded field, unless reading it failed.
+func (c *cursor) add(name string, value interface{}) {
+	if !c.ok {
+		return
+	}
+	c.fields = append(c.fields, field{Name: strings.ReplaceAll(name, " ", "_"), Value: value})
+	c.done = c.at
+}
+
+// stop stops decoding, leaving the rest of the props undecoded.
+func (c *cursor) stop() {
+	c.ok = false
+}
+
+// more returns whether there are props left to decode.
+func (c *cursor) more() bool {
+	return c.ok && c.at < len(c.b)
+}
+
+func (c *cursor) take(n int) []byte {
+	if !c.ok || n > len(c.b)-c.at {
+		c.ok = false
+		return nil
+	}
+	b := c.b[c.at : c.at+n]
+	c.at += n
+	return b
+}
+
+func (c *cursor) u8() uint8 {
+	if b := c.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (c *cursor) u16() uint16 {
+	if b := c.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (c *cursor) u32() uint32 {
+	if b := c.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// str reads a string prefixed by its 16-bit length.
+func (c *cursor) str() string {
+	return string(c.take(int(c.u16())))
+}
+
+// pos reads a position, as "x,y,floor".
+func (c *cursor) pos() string {
+	x, y, floor := c.u16(), c.u16(), c.u8()
+	return fmt.Sprintf("%d,%d,%d", x, y, floor)
+}
+
+// attributeMap reads a map of named attributes, whose strings are prefixed
+// by their 32-bit length.
+func (c *cursor) attributeMap() map[string]interface{} {
+	m := map[string]interface{}{}
+	for i, cnt := 0, int(c.u16()); i < cnt && c.ok; i++ {
+		key := c.str()
+		switch c.u8() {
+		case 1: // string
+			m[key] = string(c.take(int(c.u32())))
+		case 2: // integer
+			m[key] = int32(c.u32())
+		case 3: // float
+			m[key] = math.Float32frombits(c.u32())
+		case 4: // boolean
+			m[key] = c.u8() != 0
+		case 5: // double
+			m[key] = math.Float64frombits(uint64(c.u32()) | uint64(c.u32())<<32)
+		default:
+			c.stop()
+		}
+	}
+	return m
+}
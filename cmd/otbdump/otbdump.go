@@ -0,0 +1,178 @@
+// Binary otbdump prints the tree of nodes of an OTB file, such as items.otb
+// or an OTBM map, to help find out why a file does not load.
+//
+// Each node is printed with its depth, type and the length of its props.
+// Where the kind of file is known, the type is named, and the props are
+// decoded as far as they are understood; the rest is printed in hex.
+//
+// Nodes can be filtered by their type and depth. JSON output, with one node
+// per line, can be used to diff two files; pass --show_offsets=false so that
+// nodes which merely moved are not reported as changed.
+//
+// Usage:
+//
+//	otbdump [flags] file
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"badc0de.net/pkg/flagutil"
+	"github.com/golang/glog"
+
+	"badc0de.net/pkg/go-tibia/otb"
+)
+
+var (
+	format      = flag.String("format", "text", "output format: 'text', or 'json' with one node per line")
+	kind        = flag.String("kind", string(kindAuto), "kind of file: 'items' for items.otb, 'map' for otbm, 'raw' to only print props in hex, or 'auto' to detect it")
+	types       = flag.String("types", "", "comma separated types of nodes to print, as numbers or names; empty prints all")
+	minDepth    = flag.Int("min_depth", 0, "depth of the shallowest nodes to print; the root is at depth 0")
+	maxDepth    = flag.Int("max_depth", -1, "depth of the deepest nodes to print; negative prints all")
+	showOffsets = flag.Bool("show_offsets", true, "whether to print where in the file each node starts")
+	strict      = flag.Bool("strict", false, "whether a truncated file is an error, rather than a warning")
+)
+
+// options configures what dump prints.
+type options struct {
+	format      string
+	kind        fileKind
+	types       []string // Numbers or names; empty means all.
+	minDepth    int
+	maxDepth    int // Negative means no limit.
+	showOffsets bool
+	strict      bool
+}
+
+func main() {
+	flagutil.Parse()
+	if flag.NArg() != 1 {
+		glog.Exitf("usage: otbdump [flags] file")
+	}
+	if *format != "text" && *format != "json" {
+		glog.Exitf("unknown format %q", *format)
+	}
+	switch fileKind(*kind) {
+	case kindAuto, kindItems, kindMap, kindRaw:
+	default:
+		glog.Exitf("unknown kind %q", *kind)
+	}
+	opts := options{
+		format:      *format,
+		kind:        fileKind(*kind),
+		minDepth:    *minDepth,
+		maxDepth:    *maxDepth,
+		showOffsets: *showOffsets,
+		strict:      *strict,
+	}
+	if *types != "" {
+		opts.types = strings.Split(*types, ",")
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		glog.Exitf("opening file: %v", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	err = dump(f, w, opts)
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		glog.Exitf("dumping %s: %v", flag.Arg(0), err)
+	}
+}
+
+// dump prints the nodes of the OTB file read from r into w.
+func dump(r io.Reader, w io.Writer, opts options) error {
+	rd, err := otb.NewReader(r)
+	if err != nil {
+		return err
+	}
+	rd.Strict = opts.strict
+
+	d := &decoder{kind: opts.kind}
+	enc := json.NewEncoder(w)
+	for {
+		n, err := rd.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.kind == kindAuto {
+			d.kind = detectKind(n)
+		}
+
+		dn := d.decode(n)
+		if !opts.matches(dn) {
+			continue
+		}
+		if !opts.showOffsets {
+			dn.Offset = 0
+		}
+		if opts.format == "json" {
+			err = enc.Encode(dn)
+		} else {
+			_, err = fmt.Fprintln(w, dn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// matches returns whether the node is to be printed.
+func (o options) matches(dn dumpedNode) bool {
+	if dn.Depth < o.minDepth || (o.maxDepth >= 0 && dn.Depth > o.maxDepth) {
+		return false
+	}
+	if len(o.types) == 0 {
+		return true
+	}
+	for _, t := range o.types {
+		t = strings.TrimSpace(t)
+		if n, err := strconv.ParseUint(t, 0, 8); err == nil && uint8(n) == dn.Type {
+			return true
+		}
+		if dn.TypeName != "" && strings.EqualFold(t, dn.TypeName) {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the node as a line of text, indented by its depth.
+func (dn dumpedNode) String() string {
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat("  ", dn.Depth))
+	if dn.Offset != 0 {
+		fmt.Fprintf(&sb, "@%d ", dn.Offset)
+	}
+	fmt.Fprintf(&sb, "%02x", dn.Type)
+	if dn.TypeName != "" {
+		fmt.Fprintf(&sb, " %s", dn.TypeName)
+	}
+	fmt.Fprintf(&sb, " (%d bytes)", dn.PropsLen)
+	for _, f := range dn.Fields {
+		if s, ok := f.Value.(string); ok {
+			fmt.Fprintf(&sb, " %s=%q", f.Name, s)
+		} else {
+			fmt.Fprintf(&sb, " %s=%v", f.Name, f.Value)
+		}
+	}
+	if dn.Rest != "" {
+		fmt.Fprintf(&sb, " rest=%s", dn.Rest)
+	}
+	return sb.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/otb"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/otb/map"
+)
+
+// testMap returns an otbm file with a single tile holding a ground and an
+// item with an action ID, followed by an attribute the dump does not know.
+func testMap(t *testing.T) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := otb.NewWriter(buf)
+	if err != nil {
+		t.Fatalf("creating writer: %v", err)
+	}
+	node := func(typ otbm.MapNodeType, props ...interface{}) {
+		w.StartNode(uint8(typ))
+		for _, p := range props {
+			binary.Write(w, binary.LittleEndian, p)
+		}
+	}
+	node(otbm.OTBM_ROOT, uint32(otbm.OTBM_VERSION_3), uint16(256), uint16(256), uint32(3), uint32(20))
+	node(otbm.OTBM_MAP_DATA, otbm.OTBM_ATTR_DESCRIPTION, uint16(4), []byte("test"))
+	node(otbm.OTBM_TILE_AREA, uint16(256), uint16(512), uint8(7))
+	node(otbm.OTBM_TILE, uint8(1), uint8(2), otbm.OTBM_ATTR_ITEM, uint16(100))
+	node(otbm.OTBM_ITEM, uint16(200), otbm.OTBM_ATTR_ACTION_ID, uint16(1000), uint8(0x7F), []byte{0xFF})
+	for i := 0; i < 5; i++ {
+		w.EndNode()
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("flushing writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func dumpLines(t *testing.T, file []byte, opts options) []string {
+	t.Helper()
+	out := &bytes.Buffer{}
+	if err := dump(bytes.NewReader(file), out, opts); err != nil {
+		t.Fatalf("dumping: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func TestDumpMap(t *testing.T) {
+	got := dumpLines(t, testMap(t), options{format: "text", kind: kindAuto, maxDepth: -1})
+	want := []string{
+		`00 root (16 bytes) version=2 width=256 height=256 items_major_version=3 items_minor_version=20`,
+		`  02 map_data (7 bytes) description="test"`,
+		`    04 tile_area (5 bytes) base="256,512,7"`,
+		`      05 tile (5 bytes) offset="1,2" item=100`,
+		`        06 item (7 bytes) id=200 action_id=1000 rest=7fff`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dumped:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	got = dumpLines(t, testMap(t), options{format: "json", kind: kindAuto, types: []string{"tile", "0x06"}, maxDepth: 3, showOffsets: true})
+	if len(got) != 1 {
+		t.Fatalf("dumped %d nodes, want 1: %v", len(got), got)
+	}
+	var dn dumpedNode
+	if err := json.Unmarshal([]byte(got[0]), &dn); err != nil {
+		t.Fatalf("decoding dumped node %s: %v", got[0], err)
+	}
+	if dn.TypeName != "tile" || dn.Depth != 3 || dn.Offset == 0 || len(dn.Fields) != 2 || dn.Fields[1].Value != float64(100) {
+		t.Errorf("dumped %+v", dn)
+	}
+}
+
+func TestDumpItems(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := otb.NewWriter(buf)
+	w.StartNode(0)
+	version := struct {
+		Flags    uint32
+		Attr     uint8
+		Size     uint16
+		Versions [3]uint32
+		CSD      [128]byte
+	}{Attr: itemsotb.ROOT_ATTR_VERSION, Size: 140, Versions: [3]uint32{3, uint32(itemsotb.CLIENT_VERSION_854), 42}}
+	copy(version.CSD[:], "OTB 3.42")
+	binary.Write(w, binary.LittleEndian, version)
+	w.StartNode(uint8(itemsotb.ITEM_GROUP_GROUND))
+	for _, p := range []interface{}{
+		itemsotb.FLAG_BLOCK_SOLID,
+		itemsotb.ITEM_ATTR_SERVERID, uint16(2), uint16(100),
+		itemsotb.ITEM_ATTR_SPRITEHASH, uint16(2), []byte{0xAB, 0xCD},
+	} {
+		binary.Write(w, binary.LittleEndian, p)
+	}
+	w.EndNode()
+	w.EndNode()
+	if err := w.Flush(); err != nil {
+		t.Fatalf("flushing writer: %v", err)
+	}
+
+	got := dumpLines(t, buf.Bytes(), options{format: "text", kind: kindAuto, maxDepth: -1})
+	want := []string{
+		`00 root (147 bytes) flags=0 major_version=3 client_version="8.54" build_number=42 csd_version="OTB 3.42"`,
+		`  01 ground (14 bytes) flags="00000001 [block solid]" server_id=100 spritehash="abcd"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dumped:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,54 @@
+// Binary otbmautomap reads an OTBM map along with items.otb and Tibia.dat,
+// and writes the entire map as the 8.x client's automap files, so that
+// players can start with a fully explored map.
+//
+// Copy the written files into the Automap directory of the client. Files of
+// areas already explored by the player are overwritten, along with any marks
+// placed on them.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"badc0de.net/pkg/flagutil"
+	"github.com/golang/glog"
+
+	"badc0de.net/pkg/go-tibia/automap"
+	"badc0de.net/pkg/go-tibia/otb/map"
+	"badc0de.net/pkg/go-tibia/paths"
+	"badc0de.net/pkg/go-tibia/things/full"
+)
+
+var (
+	mapPath   string
+	outputDir = flag.String("output_dir", "Automap", "directory to write the automap files into")
+)
+
+func main() {
+	full.SetupFilePathFlags()
+	paths.SetupFilePathFlag("map.otbm", "map_path", &mapPath)
+	flagutil.Parse()
+
+	// Sprites are not needed for the automap.
+	t, err := full.FromPaths(full.PathFlagValue(full.FlagItemsOTBPath), full.PathFlagValue(full.FlagItemsXMLPath), full.PathFlagValue(full.FlagTibiaDatPath), "")
+	if err != nil {
+		glog.Exitf("creating thing registry: %v", err)
+	}
+
+	f, err := os.Open(mapPath)
+	if err != nil {
+		glog.Exitf("opening map file: %v", err)
+	}
+	m, err := otbm.New(f, t)
+	f.Close()
+	if err != nil {
+		glog.Exitf("reading map file: %v", err)
+	}
+
+	n, err := automap.NewGenerator(m, t).WriteAll(*outputDir)
+	if err != nil {
+		glog.Exitf("writing automap: %v", err)
+	}
+	glog.Infof("wrote %d automap areas into %s", n, *outputDir)
+}
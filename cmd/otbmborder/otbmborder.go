@@ -0,0 +1,79 @@
+// Binary otbmborder reads an OTBM map along with items.otb, places borders
+// around all of its grounds using the ground brushes of Remere's Map Editor,
+// and writes the bordered map.
+//
+// Brushes are read from the editor's borders.xml and grounds.xml. Borders
+// already on the map are replaced, so grounds can be painted without borders
+// and the map bordered again after each change. Tiles whose ground is not
+// painted by any brush keep their borders.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"os"
+
+	"badc0de.net/pkg/flagutil"
+	"github.com/golang/glog"
+
+	"badc0de.net/pkg/go-tibia/autoborder"
+	"badc0de.net/pkg/go-tibia/otb/map"
+	"badc0de.net/pkg/go-tibia/paths"
+	"badc0de.net/pkg/go-tibia/things/full"
+)
+
+var (
+	mapPath, bordersPath, groundsPath string
+
+	outputPath = flag.String("output_path", "bordered.otbm", "where to write the bordered map")
+)
+
+func main() {
+	full.SetupFilePathFlags()
+	paths.SetupFilePathFlag("map.otbm", "map_path", &mapPath)
+	paths.SetupFilePathFlag("borders.xml", "borders_xml_path", &bordersPath)
+	paths.SetupFilePathFlag("grounds.xml", "grounds_xml_path", &groundsPath)
+	flagutil.Parse()
+
+	// Neither the dat nor sprites are needed to place borders.
+	t, err := full.FromPaths(full.PathFlagValue(full.FlagItemsOTBPath), full.PathFlagValue(full.FlagItemsXMLPath), "", "")
+	if err != nil {
+		glog.Exitf("creating thing registry: %v", err)
+	}
+	b, err := autoborder.Load(bordersPath, groundsPath)
+	if err != nil {
+		glog.Exitf("loading brushes: %v", err)
+	}
+
+	f, err := os.Open(mapPath)
+	if err != nil {
+		glog.Exitf("opening map file: %v", err)
+	}
+	m, err := otbm.New(f, t)
+	f.Close()
+	if err != nil {
+		glog.Exitf("reading map file: %v", err)
+	}
+
+	n, err := m.PlaceBorders(b)
+	if err != nil {
+		glog.Exitf("placing borders: %v", err)
+	}
+	glog.Infof("changed borders on %d tiles", n)
+
+	f, err = os.Create(*outputPath)
+	if err != nil {
+		glog.Exitf("creating map file: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	if err := m.Save(w); err != nil {
+		glog.Exitf("writing map: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		glog.Exitf("writing map: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		glog.Exitf("writing map: %v", err)
+	}
+	glog.Infof("wrote %s", m)
+}
@@ -0,0 +1,54 @@
+// Binary otbmindex builds the index of an OTBM map, allowing the map to be
+// read lazily one tile area at a time. The index is written next to the map,
+// where map loaders look for it, unless another path is passed.
+//
+// The index needs to be rebuilt whenever the map changes.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"badc0de.net/pkg/flagutil"
+	"github.com/golang/glog"
+
+	"badc0de.net/pkg/go-tibia/otb/map"
+)
+
+var (
+	mapPath   = flag.String("map_path", "", "path to the otbm file to index")
+	indexPath = flag.String("index_path", "", "path to write the index to; if empty, it is written next to the map")
+)
+
+func main() {
+	flagutil.Parse()
+	if *mapPath == "" {
+		glog.Exit("-map_path is required")
+	}
+	if *indexPath == "" {
+		*indexPath = otbm.IndexPath(*mapPath)
+	}
+
+	f, err := os.Open(*mapPath)
+	if err != nil {
+		glog.Exitf("opening map: %v", err)
+	}
+	defer f.Close()
+	idx, err := otbm.BuildIndex(f)
+	if err != nil {
+		glog.Exitf("indexing map: %v", err)
+	}
+
+	out, err := os.Create(*indexPath)
+	if err != nil {
+		glog.Exitf("creating index: %v", err)
+	}
+	if _, err := idx.WriteTo(out); err != nil {
+		out.Close()
+		glog.Exitf("writing index: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		glog.Exitf("writing index: %v", err)
+	}
+	glog.Infof("wrote index of %s to %s", *mapPath, *indexPath)
+}
@@ -0,0 +1,110 @@
+// Binary otbmvalidate reads an OTBM map along with items.otb and Tibia.dat,
+// and reports problems with the map: unknown items, tiles without ground,
+// creatures and temples placed where nobody can stand, walled in temples,
+// teleports into void or walls, duplicate unique IDs and house doors outside
+// houses.
+//
+// The spawn file referred to by the map is checked as well, if present.
+//
+// Problems are printed as text, one per line, or as a JSON array. The exit
+// status is 1 if any problems are found, so the tool can gate map changes.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"badc0de.net/pkg/flagutil"
+	"github.com/golang/glog"
+
+	"badc0de.net/pkg/go-tibia/otb/map"
+	"badc0de.net/pkg/go-tibia/paths"
+	"badc0de.net/pkg/go-tibia/things/full"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+var (
+	mapPath       string
+	format        = flag.String("format", "text", "output format: 'text' or 'json'")
+	minTempleArea = flag.Int("min_temple_area", otbm.DefaultMinTempleArea, "number of tiles which must be reachable from each temple")
+	checkSpawns   = flag.Bool("check_spawns", true, "whether to check creatures in the spawn file referred to by the map")
+)
+
+func main() {
+	full.SetupFilePathFlags()
+	paths.SetupFilePathFlag("map.otbm", "map_path", &mapPath)
+	flagutil.Parse()
+
+	if *format != "text" && *format != "json" {
+		glog.Exitf("unknown format %q", *format)
+	}
+
+	// Sprites are not needed to check the map.
+	t, err := full.FromPaths(full.PathFlagValue(full.FlagItemsOTBPath), full.PathFlagValue(full.FlagItemsXMLPath), full.PathFlagValue(full.FlagTibiaDatPath), "")
+	if err != nil {
+		glog.Exitf("creating thing registry: %v", err)
+	}
+
+	f, err := os.Open(mapPath)
+	if err != nil {
+		glog.Exitf("opening map file: %v", err)
+	}
+	m, err := otbm.New(f, t)
+	f.Close()
+	if err != nil {
+		glog.Exitf("reading map file: %v", err)
+	}
+
+	opts := otbm.ValidateOptions{MinTempleArea: *minTempleArea}
+	if *checkSpawns && m.ExtSpawnFile() != "" {
+		spawns, err := readSpawns(filepath.Join(filepath.Dir(mapPath), m.ExtSpawnFile()))
+		if err != nil {
+			glog.Errorf("reading spawn file; not checking spawns: %v", err)
+		} else {
+			opts.Spawns = spawns
+		}
+	}
+
+	problems, err := m.Validate(opts)
+	if err != nil {
+		glog.Exitf("validating map: %v", err)
+	}
+
+	switch *format {
+	case "json":
+		if problems == nil {
+			problems = []otbm.Problem{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(problems); err != nil {
+			glog.Exitf("writing problems: %v", err)
+		}
+	default:
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	}
+
+	if len(problems) > 0 {
+		glog.Infof("found %d problems", len(problems))
+		os.Exit(1)
+	}
+}
+
+func readSpawns(path string) (*xmls.Spawns, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	spawns, err := xmls.ReadSpawns(f)
+	if err != nil {
+		return nil, err
+	}
+	return &spawns, nil
+}
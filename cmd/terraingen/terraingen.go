@@ -0,0 +1,91 @@
+// Binary terraingen generates terrain, as served by gotserv when its map path
+// is ':terrain:', and writes it into an OTBM map, so that it can be edited in
+// map editors.
+//
+// The same seed always generates the same terrain. items.otb is needed to
+// know which of the items are grounds; the items used are checked against it.
+//
+// Given the borders.xml and grounds.xml of Remere's Map Editor, borders are
+// placed around the grounds of the terrain.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"os"
+
+	"badc0de.net/pkg/flagutil"
+	"github.com/golang/glog"
+
+	"badc0de.net/pkg/go-tibia/autoborder"
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/otb/map"
+	"badc0de.net/pkg/go-tibia/things/full"
+)
+
+var (
+	outputPath = flag.String("output_path", "terrain.otbm", "where to write the generated map")
+	seed       = flag.Int64("seed", 1, "seed selecting the terrain")
+	width      = flag.Int("width", 512, "width of the map, in tiles")
+	height     = flag.Int("height", 512, "height of the map, in tiles")
+	caveFloors = flag.Int("cave_floors", 3, "number of floors of caves below the surface")
+
+	bordersPath = flag.String("borders_xml_path", "", "borders.xml of the brushes placing borders; if empty, no borders are placed")
+	groundsPath = flag.String("grounds_xml_path", "", "grounds.xml of the brushes placing borders")
+)
+
+func main() {
+	full.SetupFilePathFlags()
+	flagutil.Parse()
+
+	// Neither the dat nor sprites are needed to write the map.
+	t, err := full.FromPaths(full.PathFlagValue(full.FlagItemsOTBPath), full.PathFlagValue(full.FlagItemsXMLPath), "", "")
+	if err != nil {
+		glog.Exitf("creating thing registry: %v", err)
+	}
+	if err := gameworld.DefaultTerrainPalette.Check(t); err != nil {
+		glog.Warningf("generated terrain may not be walkable: %v", err)
+	}
+
+	if *width <= 0 || *width > 0xFFFF || *height <= 0 || *height > 0xFFFF {
+		glog.Exitf("map size %dx%d out of range", *width, *height)
+	}
+	opts := gameworld.TerrainOptions{Seed: *seed, Width: uint16(*width), Height: uint16(*height), CaveFloors: *caveFloors}
+	if opts.CaveFloors == 0 {
+		opts.CaveFloors = -1 // Zero would mean the default.
+	}
+	if *bordersPath != "" {
+		if opts.Borders, err = autoborder.Load(*bordersPath, *groundsPath); err != nil {
+			glog.Exitf("loading brushes: %v", err)
+		}
+	}
+	src, err := gameworld.NewTerrainMapDataSource(opts)
+	if err != nil {
+		glog.Exitf("generating terrain: %v", err)
+	}
+
+	lastFloor := 7 + *caveFloors
+	if lastFloor < 7 {
+		lastFloor = 7
+	}
+	m, err := otbm.Import(src, t, 0, 0, 7, uint8(lastFloor))
+	if err != nil {
+		glog.Exitf("importing terrain: %v", err)
+	}
+
+	f, err := os.Create(*outputPath)
+	if err != nil {
+		glog.Exitf("creating map file: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	if err := m.Save(w); err != nil {
+		glog.Exitf("writing map: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		glog.Exitf("writing map: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		glog.Exitf("writing map: %v", err)
+	}
+	glog.Infof("wrote %s", m)
+}
@@ -38,6 +38,17 @@ type Light struct {
 }
 
 func CompositeMap(m gameworld.MapDataSource, th *things.Things, x, y uint16, floorTop, floorBottom uint8, width, height int, tileW, tileH int) image.Image {
+	return compositeMap(m, th, x, y, floorTop, floorBottom, width, height, tileW, tileH, true)
+}
+
+// CompositeMapArea paints the map like CompositeMap does, but without the
+// decorative character in the middle, so that neighbouring areas painted
+// separately can be put together.
+func CompositeMapArea(m gameworld.MapDataSource, th *things.Things, x, y uint16, floorTop, floorBottom uint8, width, height int, tileW, tileH int) image.Image {
+	return compositeMap(m, th, x, y, floorTop, floorBottom, width, height, tileW, tileH, false)
+}
+
+func compositeMap(m gameworld.MapDataSource, th *things.Things, x, y uint16, floorTop, floorBottom uint8, width, height int, tileW, tileH int, decorativeCharacter bool) image.Image {
 	fullSize := image.Rect(0, 0, width*tileW, height*tileH)
 	img := image.NewRGBA(fullSize)
 
@@ -46,7 +57,7 @@ func CompositeMap(m gameworld.MapDataSource, th *things.Things, x, y uint16, flo
 	//func compositeFloor(m gameworld.MapDataSource, th *things.Things, x, y uint16, z uint8, off int, width, height int, tileW, tileH int, ambientColor color.Color, ambientLevel uint8) image.Image {
 	for tz := int(floorBottom); tz >= int(floorTop); tz-- {
 		off := int(tz - int(floorBottom))
-		wantDecorativeCharacter := (tz == int(floorBottom))
+		wantDecorativeCharacter := decorativeCharacter && (tz == int(floorBottom))
 		floorImg := compositeFloor(m, th, x, y, uint8(tz), off, width, height, tileW, tileH, ambientColor, ambientLevel, wantDecorativeCharacter)
 
 		draw.Draw(img, fullSize, floorImg, image.ZP, draw.Over)
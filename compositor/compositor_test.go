@@ -41,3 +41,25 @@ func TestCompositeMap(t *testing.T) {
 	imageprint.PrintITerm(img, "1.png")
 	imageprint.PrintRasTerm(img)
 }
+
+func TestCompositeMinimap(t *testing.T) {
+	th, err := full.FromDefaultPaths(false)
+	if err != nil {
+		t.Skipf("skipping because no file: %v", err)
+		return
+	}
+	if th.Temp__DATItemCount(854) == 0 {
+		t.Skip("skipping because Tibia.dat is not loaded")
+	}
+
+	procMDS := gameworld.NewMapDataSource()
+	img := CompositeMinimap(procMDS, th, 90, 90, 7, 40, 30, 2)
+	if got, want := img.Bounds().Size(), image.Pt(80, 60); got != want {
+		t.Errorf("minimap size %v, want %v", got, want)
+	}
+	if _, _, _, a := img.At(20, 20).RGBA(); a == 0 {
+		t.Errorf("ground on the minimap is transparent")
+	}
+
+	imageprint.Print24bit(downsize(t, img, 1.0), true)
+}
@@ -0,0 +1,70 @@
+package compositor
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	"badc0de.net/pkg/go-tibia/things"
+	"github.com/golang/glog"
+)
+
+// CompositeMinimap paints a part of a single floor the way the client's
+// minimap shows it: each tile becomes a square of scale x scale pixels in the
+// map color of the top-most item on it which has one. Tiles without such an
+// item are left transparent.
+//
+// Unlike CompositeMap, this is cheap enough to paint an entire map.
+func CompositeMinimap(m gameworld.MapDataSource, th *things.Things, x, y uint16, floor uint8, width, height int, scale int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+
+	// Map colors by server ID; nil if the item has none.
+	colors := map[uint16]color.Color{}
+	mapColor := func(serverID uint16) color.Color {
+		if col, ok := colors[serverID]; ok {
+			return col
+		}
+		var col color.Color
+		if thItem, err := th.Item(serverID, 854); err != nil {
+			glog.Errorf("could not get item of type %d: %v", serverID, err)
+		} else if thItem.ValidClientItem() && thItem.MapColorOK() {
+			col = thItem.MapColor()
+		}
+		colors[serverID] = col
+		return col
+	}
+
+	var items []uint16
+	for ty := 0; ty < height; ty++ {
+		for tx := 0; tx < width; tx++ {
+			t, err := m.GetMapTile(uint16(int(x)+tx), uint16(int(y)+ty), floor)
+			if err != nil {
+				continue
+			}
+			items = items[:0]
+			for item, err := t.GetItem(len(items)); err == nil; item, err = t.GetItem(len(items)) {
+				items = append(items, item.GetServerType())
+			}
+			for idx := len(items) - 1; idx >= 0; idx-- {
+				if col := mapColor(items[idx]); col != nil {
+					draw.Draw(img, image.Rect(tx*scale, ty*scale, (tx+1)*scale, (ty+1)*scale), &image.Uniform{col}, image.ZP, draw.Src)
+					break
+				}
+			}
+		}
+	}
+	return img
+}
+
+// MinimapBounds returns the area of the map which CompositeMinimap should
+// paint to show an entire floor, if the map knows its size.
+func MinimapBounds(m gameworld.MapDataSource) (width, height int, ok bool) {
+	sized, ok := m.(gwmap.SizedMapDataSource)
+	if !ok {
+		return 0, 0, false
+	}
+	w, h := sized.Dimensions()
+	return int(w), int(h), w > 0 && h > 0
+}
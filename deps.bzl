@@ -145,6 +145,12 @@ def go_dependencies():
         sum = "h1:0ZgBzd3FehDUA8DJ70/phsnDH61/3aYMyx8Wd84KqQo=",
         version = "v1.0.1",
     )
+    go_repository(
+        name = "net_starlark_go",
+        importpath = "go.starlark.net",
+        sum = "h1:Uo/x0Ir5vQJ+683GXB9Ug+4fcjsbp7z7Ul8UaZbhsRM=",
+        version = "v0.0.0-20220328144851-d1966c6b9fcd",
+    )
     go_repository(
         name = "org_golang_x_crypto",
         importpath = "golang.org/x/crypto",
@@ -0,0 +1,123 @@
+package gameworld
+
+import (
+	"encoding/binary"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// ChatType describes the kind of a spoken message, determining who can hear it
+// and how the client presents it.
+type ChatType uint8
+
+const (
+	ChatTypeSay                ChatType = 0x01
+	ChatTypeWhisper            ChatType = 0x02
+	ChatTypeYell               ChatType = 0x03
+	ChatTypePrivatePlayerToNPC ChatType = 0x04 // Player talking in the NPCs channel.
+	ChatTypePrivateNPCToPlayer ChatType = 0x05 // NPC answering in the NPCs channel.
+	ChatTypePrivate            ChatType = 0x06
+	ChatTypeChannelYellow      ChatType = 0x07
+	ChatTypeBroadcast          ChatType = 0x0C
+	ChatTypeMonsterSay         ChatType = 0x13
+	ChatTypeMonsterYell        ChatType = 0x14
+)
+
+// hasPosition returns whether messages of this chat type are sent along with
+// the position of the speaker.
+func (t ChatType) hasPosition() bool {
+	switch t {
+	case ChatTypeSay, ChatTypeWhisper, ChatTypeYell, ChatTypePrivatePlayerToNPC, ChatTypePrivateNPCToPlayer, ChatTypeMonsterSay, ChatTypeMonsterYell:
+		return true
+	default:
+		return false
+	}
+}
+
+// creatureSay makes the creature say the text. All players that can hear the
+// creature are sent the message.
+//
+// Most messages are heard by players who can see the creature. Whispers are
+// heard only by players next to the creature, and yells by players within
+// twice the viewport range.
+func (c *GameworldServer) creatureSay(cr Creature, chatType ChatType, text string) error {
+	pos := cr.GetPos()
+	for _, gwConn := range c.connections {
+		if !gwConn.canHear(pos, chatType) {
+			continue
+		}
+		out := tnet.NewMessage()
+		if err := gwConn.creatureSayMessage(out, cr, chatType, text); err != nil {
+			return err
+		}
+		gwConn.queueMessage(out)
+	}
+	return nil
+}
+
+// creatureSayMessage writes a message containing text spoken by the creature.
+func (c *GameworldConnection) creatureSayMessage(out *tnet.Message, cr Creature, chatType ChatType, text string) error {
+	level := uint16(0)
+	if CreatureType(cr.GetID())&CreatureTypePlayer != 0 {
+		level = 1 // TODO(ivucica): send actual player level
+	}
+
+	out.Write([]byte{0xAA})
+	out.Write([]byte{0x00, 0x00, 0x00, 0x00}) // unkSpeak
+	out.WriteTibiaString(cr.GetName())
+	if err := binary.Write(out, binary.LittleEndian, level); err != nil {
+		return err
+	}
+	out.Write([]byte{byte(chatType)})
+	if chatType.hasPosition() {
+		out.WriteTibiaPosition(cr.GetPos())
+	}
+	out.WriteTibiaString(text)
+	return nil
+}
+
+// canHear returns whether the player on this connection can hear a message of
+// the passed type spoken at the passed position.
+func (c *GameworldConnection) canHear(pos tnet.Position, chatType ChatType) bool {
+	playerID, err := c.PlayerID()
+	if err != nil {
+		return false
+	}
+	player, err := c.server.mapDataSource.GetCreatureByID(playerID)
+	if err != nil {
+		return false
+	}
+	from := player.GetPos()
+
+	switch chatType {
+	case ChatTypeWhisper:
+		dx, dy := int(from.X)-int(pos.X), int(from.Y)-int(pos.Y)
+		return from.Floor == pos.Floor && dx >= -1 && dx <= 1 && dy >= -1 && dy <= 1
+	case ChatTypeYell, ChatTypeMonsterYell:
+		dx, dy := int(from.X)-int(pos.X), int(from.Y)-int(pos.Y)
+		rangeX, rangeY := int(c.viewportSizeW()), int(c.viewportSizeH())
+		return dx >= -rangeX && dx <= rangeX && dy >= -rangeY && dy <= rangeY
+	default:
+		return c.canSeeFrom(from, pos)
+	}
+}
+
+// TextMessageType describes how a text message from the server is presented by
+// the client.
+type TextMessageType uint8
+
+const (
+	TextMessageWarning       TextMessageType = 0x12 // Red text in the center of the game window, and in the console.
+	TextMessageEventAdvance  TextMessageType = 0x13 // White text in the center of the game window, and in the console.
+	TextMessageEventDefault  TextMessageType = 0x14 // White text at the bottom of the game window, and in the console.
+	TextMessageStatusDefault TextMessageType = 0x15 // White text at the bottom of the game window, and in the console.
+	TextMessageInfoDescr     TextMessageType = 0x16 // Green text in the center of the game window, and in the console.
+	TextMessageStatusSmall   TextMessageType = 0x17 // White text at the bottom of the game window.
+	TextMessageConsoleBlue   TextMessageType = 0x18 // Blue text in the console.
+)
+
+// textMessage writes a message with text from the server to the player.
+func (c *GameworldConnection) textMessage(out *tnet.Message, msgType TextMessageType, text string) {
+	out.Write([]byte{0xB4, byte(msgType)})
+	out.WriteTibiaString(text)
+}
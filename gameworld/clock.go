@@ -0,0 +1,99 @@
+package gameworld
+
+import (
+	"math"
+	"time"
+
+	"badc0de.net/pkg/go-tibia/dat"
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// tibianDayDuration is how long a day in the game world lasts in real time.
+//
+// The time of day is derived from the wall clock, so that everything looking
+// at the world (the game server, map renderers, etc.) agrees on it without
+// sharing any state.
+const tibianDayDuration = time.Hour
+
+// Times of day in the game world, in minutes since midnight, between which the
+// light changes from night to day and back.
+const (
+	minutesPerDay = 24 * 60
+	dawnStart     = 5 * 60
+	dawnEnd       = 7 * 60
+	duskStart     = 17 * 60
+	duskEnd       = 19 * 60
+)
+
+// TibianTime returns the time of day in the game world at the passed real
+// time, in minutes since midnight.
+func TibianTime(now time.Time) int {
+	sinceMidnight := now.UnixNano() % int64(tibianDayDuration)
+	if sinceMidnight < 0 {
+		sinceMidnight += int64(tibianDayDuration)
+	}
+	return int(sinceMidnight * minutesPerDay / int64(tibianDayDuration))
+}
+
+// AmbientLight returns the color and the level of the light in the game world
+// at the passed real time.
+//
+// Nights and days have constant light. During dawn the light gradually
+// brightens, passing through a twilight color; during dusk it darkens the same
+// way.
+func AmbientLight(now time.Time) (dat.DatasetColor, uint8) {
+	switch m := TibianTime(now); {
+	case m < dawnStart || m >= duskEnd:
+		return NightAmbient, NightAmbientLevel
+	case m >= dawnEnd && m < duskStart:
+		return DayAmbient, DayAmbientLevel
+	case m < dawnEnd:
+		return twilightLight(float64(m-dawnStart) / (dawnEnd - dawnStart))
+	default:
+		return twilightLight(1 - float64(m-duskStart)/(duskEnd-duskStart))
+	}
+}
+
+// twilightLight returns the light at the passed point between night (0) and
+// day (1).
+func twilightLight(day float64) (dat.DatasetColor, uint8) {
+	level := uint8(math.Round(float64(NightAmbientLevel) + day*float64(int(DayAmbientLevel)-int(NightAmbientLevel))))
+	if day < 0.5 {
+		return blendColors(NightAmbient, TwilightAmbient, day*2), level
+	}
+	return blendColors(TwilightAmbient, DayAmbient, day*2-1), level
+}
+
+// blendColors returns the color at the passed point between colors a (0) and b
+// (1). Colors are blended separately in each of the red, green and blue
+// components, which can each take 6 values.
+func blendColors(a, b dat.DatasetColor, at float64) dat.DatasetColor {
+	blend := func(x, y dat.DatasetColor) dat.DatasetColor {
+		return dat.DatasetColor(math.Round(float64(x) + at*(float64(y)-float64(x))))
+	}
+	r := blend(a/36, b/36)
+	g := blend(a/6%6, b/6%6)
+	bl := blend(a%6, b%6)
+	return r*36 + g*6 + bl
+}
+
+// lightTick informs all players about the light in the world, if it changed
+// since they were last informed. The world lock must be held by the caller.
+func (c *GameworldServer) lightTick(now time.Time) {
+	color, level := AmbientLight(now)
+	if color == c.ambientColor && level == c.ambientLevel {
+		return
+	}
+	c.ambientColor, c.ambientLevel = color, level
+
+	for _, gwConn := range c.connections {
+		out := tnet.NewMessage()
+		worldLightMessage(out, color, level)
+		gwConn.queueMessage(out)
+	}
+}
+
+// worldLightMessage writes the light in the game world to the message.
+func worldLightMessage(out *tnet.Message, color dat.DatasetColor, level uint8) {
+	out.Write([]byte{0x82, level, byte(color)})
+}
@@ -0,0 +1,75 @@
+package gameworld
+
+import (
+	"testing"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// tibianTimeAt returns a real time at which it is the passed time of day in
+// the game world.
+func tibianTimeAt(hour, minute int) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(hour*60+minute) * tibianDayDuration / minutesPerDay)
+}
+
+func TestAmbientLight(t *testing.T) {
+	for _, tc := range []struct {
+		hour, minute int
+		wantTime     int
+		wantLevel    uint8
+	}{
+		{0, 0, 0, NightAmbientLevel},
+		{4, 59, 4*60 + 59, NightAmbientLevel},
+		{6, 0, 6 * 60, 145},
+		{12, 0, 12 * 60, DayAmbientLevel},
+		{18, 0, 18 * 60, 145},
+		{23, 30, 23*60 + 30, NightAmbientLevel},
+	} {
+		now := tibianTimeAt(tc.hour, tc.minute)
+		if got := TibianTime(now); got != tc.wantTime {
+			t.Errorf("TibianTime(%v) = %d, want %d", now, got, tc.wantTime)
+		}
+		if _, level := AmbientLight(now); level != tc.wantLevel {
+			t.Errorf("light level at %02d:%02d = %d, want %d", tc.hour, tc.minute, level, tc.wantLevel)
+		}
+	}
+
+	if color, _ := AmbientLight(tibianTimeAt(6, 0)); color != TwilightAmbient {
+		t.Errorf("light color at dawn = %#x, want twilight %#x", color, TwilightAmbient)
+	}
+	if color, _ := AmbientLight(tibianTimeAt(12, 0)); color != DayAmbient {
+		t.Errorf("light color at noon = %#x, want day %#x", color, DayAmbient)
+	}
+
+	// The light brightens steadily through dawn.
+	_, prev := AmbientLight(tibianTimeAt(5, 0))
+	for m := 5*60 + 1; m <= 7*60; m++ {
+		_, level := AmbientLight(tibianTimeAt(0, m))
+		if level < prev || level-prev > 5 {
+			t.Errorf("light level jumped from %d to %d at minute %d of dawn", prev, level, m-5*60)
+		}
+		prev = level
+	}
+}
+
+func TestLightTick(t *testing.T) {
+	s := newScenario(t, nil)
+	gws := s.gws
+	conn := s.addPlayer(t, &creature{pos: tnet.Position{X: 500, Y: 500, Floor: 7}, look: 128})
+
+	gws.lightTick(tibianTimeAt(12, 0))
+	gws.lightTick(tibianTimeAt(12, 30))
+	if len(conn.senderChan) != 1 {
+		t.Fatalf("%d light updates sent during the day, want 1", len(conn.senderChan))
+	}
+	msg := <-conn.senderChan
+	if b := msg.Bytes(); len(b) != 3 || b[0] != 0x82 || b[1] != DayAmbientLevel || b[2] != byte(DayAmbient) {
+		t.Errorf("light update % x, want day light", b)
+	}
+
+	gws.lightTick(tibianTimeAt(18, 0))
+	if len(conn.senderChan) != 1 {
+		t.Errorf("%d light updates sent at dusk, want 1", len(conn.senderChan))
+	}
+}
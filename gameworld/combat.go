@@ -0,0 +1,111 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+
+	"github.com/golang/glog"
+)
+
+// creatureHealth informs all spectators about the creature's current health.
+func (c *GameworldServer) creatureHealth(cr *creature) {
+	for _, gwConn := range c.spectators(cr.GetPos()) {
+		out := tnet.NewMessage()
+		out.Write([]byte{0x8C})
+		if err := binary.Write(out, binary.LittleEndian, cr.GetID()); err != nil {
+			glog.Errorf("creature health: %v", err)
+			return
+		}
+		out.Write([]byte{cr.healthPercent()})
+		gwConn.queueMessage(out)
+	}
+
+	// Players also need to know their own health in absolute terms.
+	if gwConn, ok := c.connections[GameworldConnectionID(cr.GetID())]; ok {
+		out := tnet.NewMessage()
+		if err := gwConn.playerStats(out); err != nil {
+			glog.Errorf("player stats: %v", err)
+			return
+		}
+		gwConn.queueMessage(out)
+	}
+}
+
+// damageCreature takes the passed amount of health from the creature, showing
+// the passed effect and the amount to all spectators. A damage of zero or less
+// is presented as blocked.
+func (c *GameworldServer) damageCreature(target *creature, damage int, effect MagicEffect) error {
+	pos := target.GetPos()
+	if damage <= 0 {
+		c.magicEffect(pos, MagicEffectPuff)
+		return nil
+	}
+
+	c.magicEffect(pos, effect)
+	c.animatedText(pos, TextColorRed, strconv.Itoa(damage))
+
+	target.health -= damage
+	if target.health < 0 {
+		target.health = 0
+	}
+	c.creatureHealth(target)
+
+	if target.health == 0 {
+		return c.creatureDied(target)
+	}
+	return nil
+}
+
+// healCreature gives the passed amount of health to the creature, up to its
+// maximum health.
+func (c *GameworldServer) healCreature(target *creature, amount int, effect MagicEffect) {
+	if amount <= 0 || target.health >= target.maxHealth {
+		return
+	}
+	target.health += amount
+	if target.health > target.maxHealth {
+		target.health = target.maxHealth
+	}
+	c.magicEffect(target.GetPos(), effect)
+	c.creatureHealth(target)
+}
+
+// creatureDied handles a creature running out of health.
+//
+// Monsters and NPCs are removed from the map; if they were placed by a spawn,
+// they will be placed again. Players are told they died and have their health
+// restored, as there is nowhere else to send them yet.
+func (c *GameworldServer) creatureDied(cr *creature) error {
+	if gwConn, ok := c.connections[GameworldConnectionID(cr.GetID())]; ok {
+		cr.health = cr.maxHealth
+		c.creatureHealth(cr)
+
+		out := tnet.NewMessage()
+		gwConn.textMessage(out, TextMessageWarning, "You are dead.")
+		gwConn.queueMessage(out)
+		return nil
+	}
+
+	return c.removeCreature(cr)
+}
+
+// removeCreature removes the creature from the map, and informs all spectators
+// that it has disappeared.
+func (c *GameworldServer) removeCreature(cr Creature) error {
+	pos := cr.GetPos()
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return err
+	}
+	stackPos, err := creatureStackPos(t, cr.GetID())
+	if err != nil {
+		return err
+	}
+	if err := c.mapDataSource.RemoveCreatureByID(cr.GetID()); err != nil {
+		return err
+	}
+	c.creatureDisappear(cr, pos, stackPos, nil)
+	return nil
+}
@@ -0,0 +1,162 @@
+package gameworld
+
+import (
+	"strings"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// MagicEffect is a graphical effect shown on a single tile.
+//
+// Values are as sent on the wire, i.e. one more than the index of the effect
+// in the dat file.
+type MagicEffect uint8
+
+const (
+	MagicEffectDrawBlood     MagicEffect = iota + 1 // red spark
+	MagicEffectLoseEnergy                           // blue bubble
+	MagicEffectPuff                                 // poff
+	MagicEffectBlockHit                             // yellow spark
+	MagicEffectExplosionArea                        // explosion area
+	MagicEffectExplosionHit                         // explosion
+	MagicEffectFireArea                             // fire area
+	MagicEffectYellowRings                          // yellow bubble
+	MagicEffectGreenRings                           // green bubble
+	MagicEffectHitArea                              // black spark
+	MagicEffectTeleport                             // teleport
+	MagicEffectEnergyHit                            // energy
+	MagicEffectMagicBlue                            // blue shimmer
+	MagicEffectMagicRed                             // red shimmer
+	MagicEffectMagicGreen                           // green shimmer
+	MagicEffectHitByFire                            // fire
+	MagicEffectHitByPoison                          // green spark
+	MagicEffectMortArea                             // mort area
+)
+
+// magicEffectNames maps names of magic effects, as used in OT XML files, to
+// the effects.
+var magicEffectNames = map[string]MagicEffect{
+	"redspark":      MagicEffectDrawBlood,
+	"bluebubble":    MagicEffectLoseEnergy,
+	"poff":          MagicEffectPuff,
+	"yellowspark":   MagicEffectBlockHit,
+	"explosionarea": MagicEffectExplosionArea,
+	"explosion":     MagicEffectExplosionHit,
+	"firearea":      MagicEffectFireArea,
+	"yellowbubble":  MagicEffectYellowRings,
+	"greenbubble":   MagicEffectGreenRings,
+	"blackspark":    MagicEffectHitArea,
+	"teleport":      MagicEffectTeleport,
+	"energy":        MagicEffectEnergyHit,
+	"blueshimmer":   MagicEffectMagicBlue,
+	"redshimmer":    MagicEffectMagicRed,
+	"greenshimmer":  MagicEffectMagicGreen,
+	"fire":          MagicEffectHitByFire,
+	"greenspark":    MagicEffectHitByPoison,
+	"mortarea":      MagicEffectMortArea,
+}
+
+// MagicEffectByName returns the magic effect with the passed name, as used in
+// OT XML files, and whether it is known.
+func MagicEffectByName(name string) (MagicEffect, bool) {
+	e, ok := magicEffectNames[strings.ToLower(name)]
+	return e, ok
+}
+
+// ShootEffect is a graphical effect of a projectile flying between two tiles.
+//
+// Values are as sent on the wire, i.e. one more than the index of the effect
+// in the dat file.
+type ShootEffect uint8
+
+const (
+	ShootEffectSpear ShootEffect = iota + 1
+	ShootEffectBolt
+	ShootEffectArrow
+	ShootEffectFire
+	ShootEffectEnergy
+	ShootEffectPoisonArrow
+	ShootEffectBurstArrow
+	ShootEffectThrowingStar
+	ShootEffectThrowingKnife
+	ShootEffectSmallStone
+	ShootEffectDeath
+	ShootEffectLargeRock
+	ShootEffectSnowball
+	ShootEffectPowerBolt
+	ShootEffectPoison
+)
+
+// shootEffectNames maps names of projectiles, as used in OT XML files, to the
+// effects.
+var shootEffectNames = map[string]ShootEffect{
+	"spear":         ShootEffectSpear,
+	"bolt":          ShootEffectBolt,
+	"arrow":         ShootEffectArrow,
+	"fire":          ShootEffectFire,
+	"energy":        ShootEffectEnergy,
+	"poisonarrow":   ShootEffectPoisonArrow,
+	"burstarrow":    ShootEffectBurstArrow,
+	"throwingstar":  ShootEffectThrowingStar,
+	"throwingknife": ShootEffectThrowingKnife,
+	"smallstone":    ShootEffectSmallStone,
+	"death":         ShootEffectDeath,
+	"largerock":     ShootEffectLargeRock,
+	"snowball":      ShootEffectSnowball,
+	"powerbolt":     ShootEffectPowerBolt,
+	"poison":        ShootEffectPoison,
+}
+
+// ShootEffectByName returns the projectile with the passed name, as used in
+// OT XML files, and whether it is known.
+func ShootEffectByName(name string) (ShootEffect, bool) {
+	e, ok := shootEffectNames[strings.ToLower(name)]
+	return e, ok
+}
+
+// TextColor is the color of an animated text shown above a tile.
+type TextColor uint8
+
+const (
+	TextColorBlue  TextColor = 5
+	TextColorGreen TextColor = 30
+	TextColorRed   TextColor = 180
+	TextColorWhite TextColor = 215
+)
+
+// magicEffect shows the magic effect at the passed position to all spectators.
+func (c *GameworldServer) magicEffect(pos tnet.Position, effect MagicEffect) {
+	for _, gwConn := range c.spectators(pos) {
+		out := tnet.NewMessage()
+		out.Write([]byte{0x83})
+		out.WriteTibiaPosition(pos)
+		out.Write([]byte{byte(effect)})
+		gwConn.queueMessage(out)
+	}
+}
+
+// shootEffect shows a projectile flying between the passed positions to all
+// spectators.
+func (c *GameworldServer) shootEffect(from, to tnet.Position, effect ShootEffect) {
+	for _, gwConn := range c.spectators(from, to) {
+		out := tnet.NewMessage()
+		out.Write([]byte{0x85})
+		out.WriteTibiaPosition(from)
+		out.WriteTibiaPosition(to)
+		out.Write([]byte{byte(effect)})
+		gwConn.queueMessage(out)
+	}
+}
+
+// animatedText shows a short text, such as the damage dealt, rising above the
+// passed position to all spectators.
+func (c *GameworldServer) animatedText(pos tnet.Position, color TextColor, text string) {
+	for _, gwConn := range c.spectators(pos) {
+		out := tnet.NewMessage()
+		out.Write([]byte{0x84})
+		out.WriteTibiaPosition(pos)
+		out.Write([]byte{byte(color)})
+		out.WriteTibiaString(text)
+		gwConn.queueMessage(out)
+	}
+}
@@ -9,14 +9,16 @@ import (
 	"math/rand"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"badc0de.net/pkg/go-tibia/dat"
 	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
 	tnet "badc0de.net/pkg/go-tibia/net"
-	"badc0de.net/pkg/go-tibia/paths"
+	"badc0de.net/pkg/go-tibia/scripting"
 	"badc0de.net/pkg/go-tibia/things"
 	"badc0de.net/pkg/go-tibia/xmls"
 )
@@ -135,6 +137,10 @@ type GameworldConnection struct {
 	mainLoopQuit chan struct{}      // Signal to quit the main loop goroutine.
 
 	clientVersion uint16
+
+	disconnecting bool // whether the client is being disconnected, and no more messages are queued
+
+	houseEdit *houseList // access list being edited in the house window, if any
 }
 
 // PlayerID returns the player ID for this connection.
@@ -164,6 +170,34 @@ type GameworldServer struct {
 
 	// TODO: all these must be per network connection
 	connections map[GameworldConnectionID]*GameworldConnection
+
+	// worldLock must be held while reading or modifying the state of the
+	// world, e.g. while handling a message from a client, or while processing
+	// world-wide periodic work.
+	worldLock sync.Mutex
+	worldQuit chan struct{} // Signal to quit the world ticker goroutine.
+
+	spawnedCreatures []*spawnedCreature // creatures placed from spawns
+	monsters         *xmls.Monsters     // monster definitions
+
+	monsterBrains map[CreatureID]*monsterBrain // behavior of placed monsters
+
+	npcs      *xmls.NPCs               // NPC definitions
+	npcBrains map[CreatureID]*npcBrain // behavior of placed NPCs
+
+	outfits *xmls.Outfits // outfits players may choose from
+
+	ambientColor dat.DatasetColor // light in the world, as last sent to players
+	ambientLevel uint8
+
+	scripts *scripting.Engine // scripted behavior of items, words, etc.
+
+	stepInHandlers  map[uint16]StepHandler // by action ID of items stepped onto
+	stepOutHandlers map[uint16]StepHandler // by action ID of items stepped off
+
+	houses         map[uint32]*House // by house ID
+	houseStatePath string            // where owners and access lists of houses are kept
+	houseWindowSeq uint32            // ID of the last house window opened
 }
 
 // NewServer creates a new GameworldServer which decodes the initial login message using the passed private key.
@@ -192,6 +226,33 @@ func (c *GameworldServer) SetThings(t *things.Things) error {
 	return nil
 }
 
+// SetMonsters sets the monster definitions, used to determine how monsters
+// placed in the world look and behave.
+func (c *GameworldServer) SetMonsters(monsters *xmls.Monsters) error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	c.monsters = monsters
+	return nil
+}
+
+// SetNPCs sets the NPC definitions, used to determine how NPCs placed in the
+// world look, talk and trade.
+func (c *GameworldServer) SetNPCs(npcs *xmls.NPCs) error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	c.npcs = npcs
+	return nil
+}
+
+// SetOutfits sets the outfit definitions, used to determine which outfits
+// players may choose from.
+func (c *GameworldServer) SetOutfits(outfits *xmls.Outfits) error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	c.outfits = outfits
+	return nil
+}
+
 func (c *GameworldConnection) TestOnly_Setter(clientVersion uint16, gws *GameworldServer, id GameworldConnectionID) {
 	c.clientVersion = clientVersion
 	c.server = gws
@@ -315,49 +376,52 @@ func (c *GameworldServer) Serve(conn net.Conn, initialMessage *tnet.Message) err
 		return nil
 	}
 
-	return c.serveGame(conn, initialMessage, gwConn, playerID)
+	return c.serveGame(conn, initialMessage, gwConn, playerID, char)
 }
 
-func (c *GameworldServer) serveGame(conn net.Conn, initialMessage *tnet.Message, gwConn *GameworldConnection, playerID CreatureID) error {
-
-	defPos := c.mapDataSource.Private_And_Temp__DefaultPlayerSpawnPoint(playerID)
-
-	playerCreature := &creature{
-		pos: defPos,
-		id:  playerID, //0xAA + 0xBB>>8 + 0xCC>>16 + 0xDD>>24,
-
-		dir:  things.CreatureDirectionSouth,
-		look: 129,
-		col: [4]things.OutfitColor{
-			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
-			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
-			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
-			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
-		},
+func (c *GameworldServer) serveGame(conn net.Conn, initialMessage *tnet.Message, gwConn *GameworldConnection, playerID CreatureID, name string) error {
+	playerCreature := newPlayerCreature(playerID, name)
+	c.worldLock.Lock()
+	pos, err := c.playerSpawnPosition(playerCreature.townID)
+	if err != nil {
+		c.worldLock.Unlock()
+		return err
 	}
+	playerCreature.pos = pos
 	c.mapDataSource.AddCreature(playerCreature)
 
 	cols := playerCreature.GetOutfitColors()
 	glog.Infof("  -> colors %d %d %d %d", cols[0], cols[1], cols[2], cols[3])
 
 	gwConn.senderQuit = make(chan struct{})
-	gwConn.senderChan = make(chan *tnet.Message)
+	gwConn.senderChan = make(chan *tnet.Message, senderQueueLength)
+	gwConn.mainLoopQuit = make(chan struct{})
 	// TODO: how to clean up and close channels safely?
 	//defer func() { close(c.senderChan) ; close(c.senderQuit) }()
 	go gwConn.networkSender()
 
 	if err := gwConn.initialAppear(); err != nil {
+		c.mapDataSource.RemoveCreatureByID(playerID)
+		c.worldLock.Unlock()
 		return fmt.Errorf("failed to send initial appear: %v", err)
 	}
 	conn.SetDeadline(time.Time{}) // Disable deadline
 
-	gwConn.mainLoopQuit = make(chan struct{})
 	gwConn.receiverChan = make(chan *tnet.Message)
 	go gwConn.networkReceiver()
 
-	defer c.mapDataSource.RemoveCreatureByID(playerID)
-
 	c.connections[gwConn.id] = gwConn
+	if err := c.creatureAppear(playerCreature, gwConn); err != nil {
+		glog.Errorf("informing others about player %d appearing: %v", playerID, err)
+	}
+	if c.scripts != nil {
+		if err := c.scripts.Login(playerID); err != nil {
+			glog.Errorf("login scripts for player %d: %v", playerID, err)
+		}
+	}
+	c.worldLock.Unlock()
+
+	defer c.playerDisappear(gwConn, playerCreature)
 
 mainLoop:
 	for {
@@ -379,81 +443,11 @@ mainLoop:
 			}
 
 			glog.Infof("received message: %x", msgType)
-			switch msgType {
-			case 0x14: // logout
-				return nil
-			case 0x65: // move north
-				if err := gwConn.playerMoveNorth(); err != nil {
-					glog.Errorf("error moving player to the north: %v", err)
-					continue mainLoop
-				}
-				break
-			case 0x66: // move east
-				if err := gwConn.playerMoveEast(); err != nil {
-					glog.Errorf("error moving player to the east: %v", err)
-					continue mainLoop
-				}
-				break
-			case 0x67: // move south
-				if err := gwConn.playerMoveSouth(); err != nil {
-					glog.Errorf("error moving player to the south: %v", err)
-					continue mainLoop
-				}
-				break
-			case 0x68: // move west
-				if err := gwConn.playerMoveWest(); err != nil {
-					glog.Errorf("error moving player to the west: %v", err)
-					continue mainLoop
-				}
-				break
-			// case 0x69: // stop autowalk
-			case 0x6A: // move northeast
-				gwConn.playerCancelMove(1)
-				break
-			case 0x6B: // move southeast
-				gwConn.playerCancelMove(2)
-				break
-			case 0x6C: // move southwest
-				gwConn.playerCancelMove(3)
-				break
-			case 0x6D: // move northwest
-				gwConn.playerCancelMove(0)
-				break
-			case 0x96: // say
-				if err := gwConn.playerSay(msg, playerID); err != nil {
-					glog.Errorf("error handling say message: %v", err)
-					continue mainLoop
-				}
-			case 0xA0: // set fight modes
-				var fightMode FightMode
-				var chaseMode ChaseMode
-				var safeMode uint8 // ?
-				if fightModeB, err := msg.ReadByte(); err != nil {
-					return err
-				} else {
-					fightMode = FightMode(fightModeB)
-				}
-
-				if chaseModeB, err := msg.ReadByte(); err != nil {
-					return err
-				} else {
-					chaseMode = ChaseMode(chaseModeB)
-				}
-
-				if safeModeB, err := msg.ReadByte(); err != nil {
-					return err
-				} else {
-					safeMode = safeModeB
-				}
-
-				glog.Infof("fight mode: %v; chase mode: %v; safe mode: %02x", fightMode, chaseMode, safeMode)
-			case 0xD2: // request outfit window
-				out := tnet.NewMessage()
-				if err := gwConn.outfitWindow(out); err != nil {
-					glog.Errorf("could not provide outfit window: %v", err)
-				} else {
-					gwConn.senderChan <- out
-				}
+			c.worldLock.Lock()
+			quit, err := c.handleMessage(gwConn, playerID, msgType, msg)
+			c.worldLock.Unlock()
+			if quit {
+				return err
 			}
 		case <-gwConn.mainLoopQuit:
 			break mainLoop
@@ -463,41 +457,224 @@ mainLoop:
 	return nil
 }
 
+// newPlayerCreature returns the creature of a player logging in as the named
+// character. Until players are loaded from storage, only the name comes from
+// the character; everything else is the same for all players.
+func newPlayerCreature(id CreatureID, name string) *creature {
+	return &creature{
+		id:   id,
+		name: name,
+
+		dir:  things.CreatureDirectionSouth,
+		look: 129,
+
+		health:    playerMaxHealth,
+		maxHealth: playerMaxHealth,
+		money:     playerStartingMoney,
+
+		premium:         true,
+		unlockedOutfits: playerUnlockedOutfits,
+		townID:          playerHomeTown,
+		col: [4]things.OutfitColor{
+			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
+			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
+			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
+			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
+		},
+	}
+}
+
+// playerMaxHealth is the maximum health of all players, until players are
+// loaded from storage.
+const playerMaxHealth = 100
+
+// playerStartingMoney is the gold all players have to trade with, until
+// players are loaded from storage and carry their gold in their inventory.
+const playerStartingMoney = 1000
+
+// playerHomeTown is the ID of the town all players live in, until players are
+// loaded from storage.
+const playerHomeTown = 1
+
+// playerUnlockedOutfits are the IDs of outfits which all players may wear in
+// addition to the default ones, until players are loaded from storage.
+var playerUnlockedOutfits = []int{
+	12, // pirate
+}
+
+// senderQueueLength is the number of messages that can be queued for sending
+// to a single client before the client is disconnected for not keeping up.
+const senderQueueLength = 64
+
+// playerDisappear removes the player's creature from the map once the player
+// is gone, and informs other players about it.
+func (c *GameworldServer) playerDisappear(gwConn *GameworldConnection, playerCreature Creature) {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+
+	if c.scripts != nil {
+		if err := c.scripts.Logout(playerCreature.GetID()); err != nil {
+			glog.Errorf("logout scripts for player %d: %v", playerCreature.GetID(), err)
+		}
+	}
+
+	delete(c.connections, gwConn.id)
+
+	pos := playerCreature.GetPos()
+	stackPos := -1
+	if t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor); err == nil {
+		if sp, err := creatureStackPos(t, playerCreature.GetID()); err == nil {
+			stackPos = sp
+		}
+	}
+
+	if err := c.mapDataSource.RemoveCreatureByID(playerCreature.GetID()); err != nil {
+		glog.Errorf("removing player %d: %v", playerCreature.GetID(), err)
+	}
+	if stackPos >= 0 {
+		c.creatureDisappear(playerCreature, pos, stackPos, gwConn)
+	}
+}
+
+// handleMessage handles a single message received from the client on the
+// passed connection. The world lock must be held by the caller.
+//
+// If the connection should be closed, quit is returned as true, along with
+// the error that caused it, if any.
+func (c *GameworldServer) handleMessage(gwConn *GameworldConnection, playerID CreatureID, msgType byte, msg *tnet.Message) (quit bool, err error) {
+	switch msgType {
+	case 0x14: // logout
+		return true, nil
+	case 0x65: // move north
+		if err := gwConn.playerMoveNorth(); err != nil {
+			glog.Errorf("error moving player to the north: %v", err)
+		}
+	case 0x66: // move east
+		if err := gwConn.playerMoveEast(); err != nil {
+			glog.Errorf("error moving player to the east: %v", err)
+		}
+	case 0x67: // move south
+		if err := gwConn.playerMoveSouth(); err != nil {
+			glog.Errorf("error moving player to the south: %v", err)
+		}
+	case 0x68: // move west
+		if err := gwConn.playerMoveWest(); err != nil {
+			glog.Errorf("error moving player to the west: %v", err)
+		}
+	// case 0x69: // stop autowalk
+	case 0x6A: // move northeast
+		gwConn.playerCancelMove(1)
+	case 0x6B: // move southeast
+		gwConn.playerCancelMove(2)
+	case 0x6C: // move southwest
+		gwConn.playerCancelMove(3)
+	case 0x6D: // move northwest
+		gwConn.playerCancelMove(0)
+	case 0x79: // look at item in trade window
+		if err := gwConn.playerShopLook(msg, playerID); err != nil {
+			glog.Errorf("error handling shop look: %v", err)
+		}
+	case 0x7A: // buy item in trade window
+		if err := gwConn.playerShopPurchase(msg, playerID); err != nil {
+			glog.Errorf("error handling shop purchase: %v", err)
+		}
+	case 0x7B: // sell item in trade window
+		if err := gwConn.playerShopSale(msg, playerID); err != nil {
+			glog.Errorf("error handling shop sale: %v", err)
+		}
+	case 0x7C: // close trade window
+		gwConn.playerShopClose(playerID)
+	case 0x82: // use item
+		if err := gwConn.playerUseItem(msg, playerID); err != nil {
+			glog.Errorf("error handling item use: %v", err)
+		}
+	case 0x8A: // edit house access list
+		if err := gwConn.playerEditHouseList(msg, playerID); err != nil {
+			glog.Errorf("error editing house access list: %v", err)
+		}
+	case 0x96: // say
+		if err := gwConn.playerSay(msg, playerID); err != nil {
+			glog.Errorf("error handling say message: %v", err)
+		}
+	case 0xA0: // set fight modes
+		var fightMode FightMode
+		var chaseMode ChaseMode
+		var safeMode uint8 // ?
+		if fightModeB, err := msg.ReadByte(); err != nil {
+			return true, err
+		} else {
+			fightMode = FightMode(fightModeB)
+		}
+
+		if chaseModeB, err := msg.ReadByte(); err != nil {
+			return true, err
+		} else {
+			chaseMode = ChaseMode(chaseModeB)
+		}
+
+		if safeModeB, err := msg.ReadByte(); err != nil {
+			return true, err
+		} else {
+			safeMode = safeModeB
+		}
+
+		glog.Infof("fight mode: %v; chase mode: %v; safe mode: %02x", fightMode, chaseMode, safeMode)
+	case 0xD2: // request outfit window
+		out := tnet.NewMessage()
+		if err := gwConn.outfitWindow(out); err != nil {
+			glog.Errorf("could not provide outfit window: %v", err)
+		} else {
+			gwConn.queueMessage(out)
+		}
+	case 0xD3: // set outfit
+		if err := gwConn.playerSetOutfit(msg, playerID); err != nil {
+			glog.Errorf("error setting outfit: %v", err)
+		}
+	}
+	return false, nil
+}
+
 // playerSay handles the player's say message. This is a message that the client
 // sends when the player types a message in the chat box and presses enter. The
 // message is then sent to all other players in the gameworld that are meant
-// to hear it (currently, all players).
+// to hear it, and NPCs nearby get to react to it.
+//
+// Words handled by a script, such as commands, and house commands are not
+// spoken.
 func (c *GameworldConnection) playerSay(msg *tnet.Message, playerID gwmap.CreatureID) error {
 	chatType, err := msg.ReadByte()
 	if err != nil {
 		return fmt.Errorf("error reading chat type: %w", err)
 	}
-	switch chatType {
-	case 0x01: // say
+	switch ChatType(chatType) {
+	case ChatTypeSay, ChatTypeWhisper, ChatTypeYell, ChatTypePrivatePlayerToNPC:
 		chatText, err := msg.ReadTibiaString()
 		if err != nil {
 			return fmt.Errorf("error reading chat text: %w", err)
 		}
-		glog.Infof("%v: %v", "Demo Character", chatText)
 		playerCr, err := c.server.mapDataSource.GetCreatureByID(playerID)
 		if err != nil {
 			return fmt.Errorf("error getting player creature by id: %w", err)
 		}
+		glog.Infof("%v: %v", playerCr.GetName(), chatText)
+
+		if handled, err := c.houseCommand(playerCr, chatText); handled || err != nil {
+			return err
+		}
+		if c.server.scripts != nil {
+			handled, err := c.server.scripts.Say(playerID, chatText)
+			if err != nil {
+				return err
+			}
+			if handled {
+				return nil
+			}
+		}
 
-		for _, otherGwConn := range c.server.connections {
-			out := tnet.NewMessage()
-			out.Write([]byte{0xAA})
-			out.Write([]byte{0x00, 0x00, 0x00, 0x00}) // unkSpeak
-			out.WriteTibiaString("Demo Character")
-			out.Write([]byte{0x01, 0x00}) // level
-			out.Write([]byte{0x01})       // type - i.e. 'say' in this case
-			out.WriteTibiaPosition(playerCr.GetPos())
-			out.WriteTibiaString(chatText)
-			//gwConn.senderChan <- out
-			go func(otherGwConn *GameworldConnection, msg *tnet.Message) {
-				otherGwConn.senderChan <- out
-			}(otherGwConn, out)
+		if err := c.server.creatureSay(playerCr, ChatType(chatType), chatText); err != nil {
+			return err
 		}
+		return c.server.npcHear(playerCr, chatText, time.Now())
 	}
 	return nil
 }
@@ -559,7 +736,12 @@ func (c *GameworldConnection) networkReceiver() error {
 			return err
 		}
 		glog.Infof("dispatching message to receiver chan")
-		c.receiverChan <- msg
+		select {
+		case c.receiverChan <- msg:
+		case <-c.mainLoopQuit:
+			// Disconnected; nobody handles the message anymore.
+			return nil
+		}
 		glog.Infof("dispatched message to receiver chan")
 	}
 }
@@ -648,7 +830,7 @@ func (c *GameworldConnection) initialAppear() error {
 		return err
 	}
 
-	c.senderChan <- outMap
+	c.queueMessage(outMap)
 	return nil
 }
 
@@ -717,6 +899,14 @@ func (c *GameworldConnection) playerStats(out *tnet.Message) error {
 		StaminaMinutes:    500,
 	}
 
+	if playerID, err := c.PlayerID(); err == nil {
+		if player, err := c.server.mapDataSource.GetCreatureByID(playerID); err == nil {
+			if player, ok := player.(*creature); ok && player.maxHealth > 0 {
+				stats.Health, stats.MaxHealth = uint16(player.health), uint16(player.maxHealth)
+			}
+		}
+	}
+
 	if stats.Experience < 0 {
 		stats.Experience = 0
 	}
@@ -771,18 +961,10 @@ func (c *GameworldConnection) playerSkills(out *tnet.Message) error {
 	return nil
 }
 
+// worldLight sends the light in the game world at the current time of day.
 func (c *GameworldConnection) worldLight(out *tnet.Message) error {
-	out.Write([]byte{0x82})
-	light := struct {
-		Level uint8
-		Color uint8
-	}{
-		Level: 40, // LIGHT_LEVEL_NIGHT
-		Color: 0xD7,
-	}
-	if err := binary.Write(out, binary.LittleEndian, light); err != nil {
-		return err
-	}
+	color, level := c.server.mapDataSource.GetAmbientLight()
+	worldLightMessage(out, color, level)
 	return nil
 }
 
@@ -813,8 +995,8 @@ func (c *GameworldConnection) playerIcons(out *tnet.Message) error {
 // window that allows the player to select a new outfit for their character.
 // The outfit window is opened by the client when the player right-clicks on
 // their character and selects "Outfit". The outfit window is populated with
-// outfits that the player can select from. The outfits are read from the
-// outfits.xml file.
+// outfits that the player can select from, as determined by
+// permittedOutfits.
 func (c *GameworldConnection) outfitWindow(out *tnet.Message) error {
 	playerID, err := c.PlayerID()
 	if err != nil {
@@ -825,65 +1007,7 @@ func (c *GameworldConnection) outfitWindow(out *tnet.Message) error {
 		return errors.Wrap(err, "outfitWindow: getting player creature")
 	}
 
-	// TODO(ivucica): move reading the file to process startup; cache in GameworldServer or so
-	f, err := paths.Open("outfits.xml")
-	if err != nil {
-		return errors.Wrap(err, "outfitWindow: failed to open outfits.xml")
-	}
-	defer f.Close()
-
-	outfits, err := xmls.ReadOutfits(f)
-	if err != nil {
-		return errors.Wrap(err, "outfitWindow: failed to parse outfits.xml")
-	}
-
-	// TODO(ivucica): actually query player's characteristics to determine which looks are permitted
-	permittedTypes := []string{"male", "femalecm"}
-	hasPremium := true
-	unlockedIDs := []int{
-		12, // pirate
-	}
-
-	var looks []xmls.OutfitListEntry
-
-	for _, outfit := range outfits.Outfit {
-		if outfit.Default == "" {
-			outfit.Default = "1"
-		}
-
-		// If not unlocked by default, player must have unlocked it somehow.
-		if outfit.Default == "0" {
-			unlocked := false
-			for _, i := range unlockedIDs {
-				if i == outfit.ID {
-					unlocked = true
-				}
-			}
-			if !unlocked {
-				continue
-			}
-		}
-
-		// Must have premium for premium outfits.
-		if !(outfit.Premium == 0 || hasPremium) {
-			continue
-		}
-
-		// Otherwise we can proceed.
-		for _, look := range outfit.List {
-			permitted := false
-			for _, typ := range permittedTypes {
-				if typ == string(look.Type) {
-					permitted = true
-					break
-				}
-			}
-			if permitted {
-				looks = append(looks, look)
-			}
-		}
-	}
-
+	looks := c.server.permittedOutfits(playerCreature)
 	if len(looks) > 25 { // max number of outfits allowed is 25
 		looks = looks[:25]
 	}
@@ -905,8 +1029,7 @@ func (c *GameworldConnection) outfitWindow(out *tnet.Message) error {
 			return err
 		}
 		out.WriteTibiaString(look.Name)
-		out.Write([]byte{0}) // addon count
-		// TODO(ivucica): support addon count (by examining dat file)
+		out.Write([]byte{c.outfitAddons(uint16(look.LookType))})
 	}
 
 	return nil
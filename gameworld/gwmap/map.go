@@ -18,7 +18,40 @@ type MapDataSource interface {
 	RemoveCreatureByID(CreatureID) error
 	GetAmbientLight() (ambientColor dat.DatasetColor, ambientLevel uint8) // This might not belong in this interface: it's useful for renderer, but how would we combine multiple backing data sources and the fact this is really gameworld-wide? It might belong in gameworld instead.
 
-	Private_And_Temp__DefaultPlayerSpawnPoint(CreatureID) tnet.Position
+	// GetTownTemple returns the position of the temple of the town with the
+	// passed ID, where players living in the town appear when logging in.
+	GetTownTemple(townID uint32) (tnet.Position, error)
+}
+
+// Town is a town on the map. Each player has a home town, in whose temple
+// they appear.
+type Town struct {
+	ID        uint32
+	Name      string
+	TemplePos tnet.Position
+}
+
+// Waypoint is a named position on the map, as placed in map editors so that
+// tools and scripts can refer to it.
+type Waypoint struct {
+	Name string
+	Pos  tnet.Position
+}
+
+// LandmarkMapDataSource is optionally implemented by map data sources which
+// know the towns and waypoints on the map, in the order they are defined.
+type LandmarkMapDataSource interface {
+	MapDataSource
+	Towns() []Town
+	Waypoints() []Waypoint
+}
+
+// SizedMapDataSource is optionally implemented by map data sources which know
+// the size of the map, as recorded by the map editor. Tiles are not
+// guaranteed to lie within it.
+type SizedMapDataSource interface {
+	MapDataSource
+	Dimensions() (width, height uint16)
 }
 
 // MapTile is an interface for a map tile. A map tile is a single tile on the
@@ -30,6 +63,25 @@ type MapTile interface {
 	RemoveCreature(Creature) error
 }
 
+// ItemsMapTile is optionally implemented by map tiles whose items can be
+// changed while the game runs, such as by scripts. Items are addressed by
+// their index, the same as in GetItem.
+type ItemsMapTile interface {
+	MapTile
+	// AddItem places a new item of the passed server type and count on the
+	// tile, and returns its index.
+	AddItem(serverType, count uint16) (int, error)
+	// RemoveItem removes the item at the passed index.
+	RemoveItem(idx int) error
+	// TransformItem turns the item at the passed index into an item of the
+	// passed server type, keeping its count and attributes, and returns its
+	// index afterwards.
+	TransformItem(idx int, serverType uint16) (int, error)
+	// SetItemActionID sets the action ID of the item at the passed index;
+	// zero removes it.
+	SetItemActionID(idx int, actionID uint16) error
+}
+
 // MapItem is an interface for an item on a map tile. An item is anything that
 // can be placed on a map tile, such as a tree, a rock, a corpse, etc.
 type MapItem interface {
@@ -37,6 +89,72 @@ type MapItem interface {
 	GetCount() uint16
 }
 
+// MapItemWithIDs is optionally implemented by map items which can carry an
+// action ID or a unique ID, as set in map editors to bind scripted behavior to
+// individual items. Zero means the item does not have the respective ID.
+type MapItemWithIDs interface {
+	MapItem
+	GetActionID() uint16
+	GetUniqueID() uint16
+}
+
+// TeleportMapItem is optionally implemented by map items which can move
+// creatures stepping onto them elsewhere, such as magic forcefields.
+type TeleportMapItem interface {
+	MapItem
+	// GetTeleportDestination returns where creatures stepping onto the item
+	// are moved to, and whether the item has a destination at all.
+	GetTeleportDestination() (tnet.Position, bool)
+}
+
+// DepotMapItem is optionally implemented by map items which can give access
+// to a player's depot, such as depot lockers.
+type DepotMapItem interface {
+	MapItem
+	// GetDepotID returns the ID of the depot (usually of the town the depot
+	// is in), or zero if the item does not give access to a depot.
+	GetDepotID() uint16
+}
+
+// HouseMapTile is optionally implemented by map tiles which can belong to a
+// house.
+type HouseMapTile interface {
+	MapTile
+	// GetHouseID returns the ID of the house the tile belongs to, or zero if
+	// it does not belong to a house.
+	GetHouseID() uint32
+}
+
+// TileFlags describe rules applying to creatures on a map tile, as set in
+// map editors.
+type TileFlags uint32
+
+const (
+	TILE_FLAG_PROTECTIONZONE TileFlags = 1 << 0 // No fighting.
+	TILE_FLAG_NOPVP          TileFlags = 1 << 2 // No fighting between players.
+	TILE_FLAG_NOLOGOUT       TileFlags = 1 << 3 // Players cannot log out.
+	TILE_FLAG_PVPZONE        TileFlags = 1 << 4 // Fighting between players has no consequences.
+	TILE_FLAG_REFRESH        TileFlags = 1 << 5 // Items on the tile are periodically restored.
+)
+
+// FlaggedMapTile is optionally implemented by map tiles which can carry
+// tile flags.
+type FlaggedMapTile interface {
+	MapTile
+	// GetFlags returns the flags set on the tile, or zero if none are set.
+	GetFlags() TileFlags
+}
+
+// HouseDoorMapItem is optionally implemented by map items which can be doors
+// of a house. Each door of a house has its own list of players allowed to
+// open it.
+type HouseDoorMapItem interface {
+	MapItem
+	// GetHouseDoorID returns the ID of the door within its house, or zero if
+	// the item is not a house door.
+	GetHouseDoorID() uint8
+}
+
 // MapTileEventSubscriber is an interface for an object that can subscribe to
 // events that occur on a map tile. This is important so the game server can be
 // notified either locally or over an RPC call when a creature moves, its health
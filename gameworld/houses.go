@@ -0,0 +1,453 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/xmls"
+
+	"github.com/golang/glog"
+)
+
+// House is a part of the map which players can own, and into which the owner
+// can invite other players.
+//
+// The owner, subowners and guests may enter the house. The owner and
+// subowners may open any of its doors, and manage the guests; everyone else
+// needs to be on the access list of the door they want to open.
+type House struct {
+	ID        uint32
+	Name      string
+	Entry     tnet.Position // where players kicked out of the house end up
+	Rent      int
+	TownID    uint32
+	Guildhall bool
+
+	Tiles []tnet.Position // tiles belonging to the house, as recorded in the map
+
+	Owner     string               // name of the owning player, if any
+	Subowners AccessList           // players allowed to manage the house
+	Guests    AccessList           // players allowed to enter the house
+	Doors     map[uint8]AccessList // players allowed to open each door, by door ID
+}
+
+// NewHouses combines the houses described by a house file with the tiles
+// belonging to each house, as recorded in the map.
+func NewHouses(houses xmls.Houses, tiles map[uint32][]tnet.Position) []*House {
+	var out []*House
+	for _, h := range houses.House {
+		out = append(out, &House{
+			ID:        h.HouseID,
+			Name:      h.Name,
+			Entry:     tnet.Position{X: uint16(h.EntryX), Y: uint16(h.EntryY), Floor: uint8(h.EntryZ)},
+			Rent:      h.Rent,
+			TownID:    h.TownID,
+			Guildhall: h.Guildhall,
+			Tiles:     tiles[h.HouseID],
+		})
+	}
+	return out
+}
+
+// IsOwner returns whether the named player owns the house.
+func (h *House) IsOwner(name string) bool {
+	return h.Owner != "" && strings.EqualFold(h.Owner, name)
+}
+
+// CanManage returns whether the named player may manage the guests and doors
+// of the house, and kick players out of it.
+func (h *House) CanManage(name string) bool {
+	return h.IsOwner(name) || h.Subowners.Contains(name)
+}
+
+// CanEnter returns whether the named player may enter the house.
+func (h *House) CanEnter(name string) bool {
+	return h.CanManage(name) || h.Guests.Contains(name)
+}
+
+// CanOpenDoor returns whether the named player may open the door with the
+// passed door ID.
+func (h *House) CanOpenDoor(name string, doorID uint8) bool {
+	return h.CanManage(name) || h.Doors[doorID].Contains(name)
+}
+
+// AccessList is a list of players, as edited by the house owner. Each entry
+// is a player name, or a pattern where '*' matches any sequence of characters
+// and '?' matches any single character. Names are compared ignoring case.
+//
+// In its text form, entries are separated by newlines, and lines starting
+// with '#' are comments.
+type AccessList []string
+
+// ParseAccessList parses the text form of an access list.
+func ParseAccessList(text string) AccessList {
+	var l AccessList
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l = append(l, line)
+	}
+	return l
+}
+
+// String returns the text form of the access list.
+func (l AccessList) String() string {
+	return strings.Join(l, "\n")
+}
+
+// Contains returns whether the named player is on the list.
+func (l AccessList) Contains(name string) bool {
+	name = strings.ToLower(name)
+	for _, entry := range l {
+		// Player names cannot contain slashes, the only character treated
+		// specially by path.Match other than the supported wildcards.
+		if ok, err := path.Match(strings.ToLower(entry), name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHouses sets the houses in the world, replacing any set previously. If
+// house state was loaded already, it is applied to the houses.
+func (c *GameworldServer) SetHouses(houses []*House) error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	c.houses = map[uint32]*House{}
+	for _, h := range houses {
+		c.houses[h.ID] = h
+	}
+	return c.loadHouseState()
+}
+
+// SetHouseStatePath sets the file in which the owners and access lists of the
+// houses are kept, and loads them from it, if it exists. The file is
+// rewritten whenever they change.
+func (c *GameworldServer) SetHouseStatePath(statePath string) error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	c.houseStatePath = statePath
+	return c.loadHouseState()
+}
+
+// houseState is the persisted state of a single house.
+type houseState struct {
+	Owner     string               `json:"owner,omitempty"`
+	Subowners AccessList           `json:"subowners,omitempty"`
+	Guests    AccessList           `json:"guests,omitempty"`
+	Doors     map[uint8]AccessList `json:"doors,omitempty"`
+}
+
+// loadHouseState applies the state in the house state file, if any, to the
+// houses. The world lock must be held by the caller.
+func (c *GameworldServer) loadHouseState() error {
+	if c.houseStatePath == "" || len(c.houses) == 0 {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(c.houseStatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading house state: %w", err)
+	}
+	var state map[uint32]houseState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return fmt.Errorf("parsing house state %q: %w", c.houseStatePath, err)
+	}
+	for id, s := range state {
+		h, ok := c.houses[id]
+		if !ok {
+			glog.Warningf("house state refers to unknown house %d", id)
+			continue
+		}
+		h.Owner, h.Subowners, h.Guests, h.Doors = s.Owner, s.Subowners, s.Guests, s.Doors
+	}
+	return nil
+}
+
+// saveHouseState writes the owners and access lists of all houses into the
+// house state file, if one is set. The world lock must be held by the caller.
+func (c *GameworldServer) saveHouseState() error {
+	if c.houseStatePath == "" {
+		return nil
+	}
+	state := map[uint32]houseState{}
+	for id, h := range c.houses {
+		state[id] = houseState{Owner: h.Owner, Subowners: h.Subowners, Guests: h.Guests, Doors: h.Doors}
+	}
+	buf, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write the state next to the old one, and only then replace it, so that
+	// it is not lost if writing fails halfway.
+	tmp, err := ioutil.TempFile(filepath.Dir(c.houseStatePath), filepath.Base(c.houseStatePath)+".*")
+	if err != nil {
+		return fmt.Errorf("saving house state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving house state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving house state: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.houseStatePath)
+}
+
+// houseAt returns the house the tile at the passed position belongs to, or
+// nil if it does not belong to a known house.
+func (c *GameworldServer) houseAt(pos tnet.Position) *House {
+	if len(c.houses) == 0 {
+		return nil
+	}
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return nil
+	}
+	ht, ok := t.(gwmap.HouseMapTile)
+	if !ok || ht.GetHouseID() == 0 {
+		return nil
+	}
+	return c.houses[ht.GetHouseID()]
+}
+
+// houseDoorAt returns the ID of the house door on the tile at the passed
+// position, or zero if there is none.
+func (c *GameworldServer) houseDoorAt(pos tnet.Position) uint8 {
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return 0
+	}
+	for idx := 0; ; idx++ {
+		item, err := t.GetItem(idx)
+		if err != nil {
+			return 0
+		}
+		if door, ok := item.(gwmap.HouseDoorMapItem); ok && door.GetHouseDoorID() != 0 {
+			return door.GetHouseDoorID()
+		}
+	}
+}
+
+// errNotInvited is returned when a player tries to step into a house they are
+// not invited into.
+var errNotInvited = errors.New("not invited into the house")
+
+// houseEntryAllowed returns errNotInvited if the creature may not step onto
+// the tile at the passed position, because it belongs to a house the creature
+// is not invited into. Only players are kept out of houses.
+func (c *GameworldServer) houseEntryAllowed(cr Creature, pos tnet.Position) error {
+	if _, ok := c.connections[GameworldConnectionID(cr.GetID())]; !ok {
+		return nil
+	}
+	h := c.houseAt(pos)
+	if h == nil || h.CanEnter(cr.GetName()) {
+		return nil
+	}
+	// Players already inside, e.g. after being removed from the guest list,
+	// may still walk out.
+	if c.houseAt(cr.GetPos()) == h {
+		return nil
+	}
+	return errNotInvited
+}
+
+// houseDoorAllowed returns whether the player may use the passed item on the
+// tile at the passed position. Only house doors are restricted.
+func (c *GameworldServer) houseDoorAllowed(player Creature, item MapItem, pos tnet.Position) bool {
+	door, ok := item.(gwmap.HouseDoorMapItem)
+	if !ok || door.GetHouseDoorID() == 0 {
+		return true
+	}
+	h := c.houseAt(pos)
+	if h == nil {
+		return true
+	}
+	return h.CanOpenDoor(player.GetName(), door.GetHouseDoorID())
+}
+
+// houseList identifies an access list of a house being edited by a player.
+type houseList struct {
+	windowID uint32
+	houseID  uint32
+	kind     string // "guests", "subowners" or "door"
+	doorID   uint8
+}
+
+// list returns the access list identified, within the passed house.
+func (l *houseList) list(h *House) AccessList {
+	switch l.kind {
+	case "guests":
+		return h.Guests
+	case "subowners":
+		return h.Subowners
+	default:
+		return h.Doors[l.doorID]
+	}
+}
+
+// setList replaces the access list identified, within the passed house.
+func (l *houseList) setList(h *House, al AccessList) {
+	switch l.kind {
+	case "guests":
+		h.Guests = al
+	case "subowners":
+		h.Subowners = al
+	default:
+		if h.Doors == nil {
+			h.Doors = map[uint8]AccessList{}
+		}
+		h.Doors[l.doorID] = al
+	}
+}
+
+// houseCommand handles the spells the player can say to manage the house they
+// are standing in:
+//
+//   - "aleta sio" edits the list of guests,
+//   - "aleta som" edits the list of subowners,
+//   - "aleta grav" edits the list of players allowed to open the door the
+//     player is facing,
+//   - "alana sio <name>" kicks the named player out of the house.
+//
+// It returns whether the text was a house command.
+func (c *GameworldConnection) houseCommand(player Creature, text string) (bool, error) {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) < 2 || (words[0] != "aleta" && words[0] != "alana") {
+		return false, nil
+	}
+	var edit *houseList
+	switch {
+	case words[0] == "aleta" && words[1] == "sio" && len(words) == 2:
+		edit = &houseList{kind: "guests"}
+	case words[0] == "aleta" && words[1] == "som" && len(words) == 2:
+		edit = &houseList{kind: "subowners"}
+	case words[0] == "aleta" && words[1] == "grav" && len(words) == 2:
+		edit = &houseList{kind: "door"}
+	case words[0] == "alana" && words[1] == "sio" && len(words) > 2:
+		// Names are matched ignoring case, so the lowercased name will do.
+		return true, c.houseKick(player, strings.Join(words[2:], " "))
+	default:
+		return false, nil
+	}
+
+	h := c.server.houseAt(player.GetPos())
+	if h == nil {
+		return true, c.statusMessage("You are not inside a house.")
+	}
+	if edit.kind == "subowners" && !h.IsOwner(player.GetName()) || !h.CanManage(player.GetName()) {
+		return true, c.statusMessage("You are not allowed to do this.")
+	}
+	if edit.kind == "door" {
+		facing := stepInDirection(player.GetPos(), player.GetDir())
+		edit.doorID = c.server.houseDoorAt(facing)
+		if edit.doorID == 0 || c.server.houseAt(facing) != h {
+			return true, c.statusMessage("You have to be looking at the door of the house.")
+		}
+	}
+
+	c.server.houseWindowSeq++
+	edit.windowID = c.server.houseWindowSeq
+	edit.houseID = h.ID
+	c.houseEdit = edit
+
+	out := tnet.NewMessage()
+	if err := c.houseWindow(out, edit.windowID, edit.list(h).String()); err != nil {
+		return true, err
+	}
+	c.queueMessage(out)
+	return true, nil
+}
+
+// houseKick moves the named player out of the house the player is standing
+// in, to its entry. Anyone may leave the house this way; kicking others out
+// requires managing the house.
+func (c *GameworldConnection) houseKick(player Creature, name string) error {
+	h := c.server.houseAt(player.GetPos())
+	if h == nil {
+		return c.statusMessage("You are not inside a house.")
+	}
+	self := strings.EqualFold(name, player.GetName())
+	if !self && !h.CanManage(player.GetName()) {
+		return c.statusMessage("You are not allowed to do this.")
+	}
+	for id := range c.server.connections {
+		target, err := c.server.mapDataSource.GetCreatureByID(CreatureID(id))
+		if err != nil || !strings.EqualFold(target.GetName(), name) {
+			continue
+		}
+		if c.server.houseAt(target.GetPos()) != h || (!self && h.IsOwner(target.GetName())) {
+			break
+		}
+		c.server.magicEffect(target.GetPos(), MagicEffectTeleport)
+		if err := c.server.teleportCreature(target, h.Entry); err != nil {
+			return err
+		}
+		c.server.magicEffect(h.Entry, MagicEffectTeleport)
+		return nil
+	}
+	return c.statusMessage("Not possible.")
+}
+
+// houseWindow writes the window in which the player edits an access list of
+// the house, prefilled with its current text.
+func (c *GameworldConnection) houseWindow(out *tnet.Message, windowID uint32, text string) error {
+	out.WriteByte(0x97)
+	out.WriteByte(0x00) // kind of window; only access lists exist
+	if err := binary.Write(out, binary.LittleEndian, windowID); err != nil {
+		return err
+	}
+	return out.WriteTibiaString(text)
+}
+
+// playerEditHouseList handles the player submitting the house window opened
+// by a house command, replacing the access list being edited.
+func (c *GameworldConnection) playerEditHouseList(msg *tnet.Message, playerID gwmap.CreatureID) error {
+	if _, err := msg.ReadByte(); err != nil { // kind of window
+		return fmt.Errorf("error reading window kind: %w", err)
+	}
+	var windowID uint32
+	if err := binary.Read(msg, binary.LittleEndian, &windowID); err != nil {
+		return fmt.Errorf("error reading window id: %w", err)
+	}
+	text, err := msg.ReadTibiaString()
+	if err != nil {
+		return fmt.Errorf("error reading access list: %w", err)
+	}
+
+	edit := c.houseEdit
+	if edit == nil || edit.windowID != windowID {
+		return fmt.Errorf("player %d edited unknown house window %d", playerID, windowID)
+	}
+	c.houseEdit = nil
+
+	player, err := c.server.mapDataSource.GetCreatureByID(playerID)
+	if err != nil {
+		return fmt.Errorf("error getting player creature by id: %w", err)
+	}
+	h, ok := c.server.houses[edit.houseID]
+	if !ok {
+		return fmt.Errorf("player %d edited unknown house %d", playerID, edit.houseID)
+	}
+	// Permissions may have changed while the window was open.
+	if edit.kind == "subowners" && !h.IsOwner(player.GetName()) || !h.CanManage(player.GetName()) {
+		return c.statusMessage("You are not allowed to do this.")
+	}
+	edit.setList(h, ParseAccessList(text))
+	glog.Infof("%s edited the %s of house %d (%s)", player.GetName(), edit.kind, h.ID, h.Name)
+	return c.server.saveHouseState()
+}
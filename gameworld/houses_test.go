@@ -0,0 +1,236 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// houseTestTile is a map tile which may belong to a house.
+type houseTestTile struct {
+	stepTestTile
+	houseID uint32
+}
+
+func (t *houseTestTile) GetHouseID() uint32 { return t.houseID }
+
+// houseTestDoor is a map item which is a house door.
+type houseTestDoor struct {
+	stepTestItem
+	doorID uint8
+}
+
+func (i *houseTestDoor) GetHouseDoorID() uint8 { return i.doorID }
+
+func TestAccessList(t *testing.T) {
+	l := ParseAccessList("# friends\nAlice\n\n  b?b  \n*smith\n")
+	if got, want := l.String(), "Alice\nb?b\n*smith"; got != want {
+		t.Errorf("access list is %q, want %q", got, want)
+	}
+	for name, want := range map[string]bool{
+		"alice":      true,
+		"Bob":        true,
+		"Bobby":      false,
+		"John Smith": true,
+		"# friends":  false,
+		"Carol":      false,
+	} {
+		if got := l.Contains(name); got != want {
+			t.Errorf("list contains %q: %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestHouseAccessByLoginName(t *testing.T) {
+	h := &House{
+		ID:     1,
+		Owner:  "Alice",
+		Guests: ParseAccessList("Carol"),
+		Doors:  map[uint8]AccessList{1: ParseAccessList("Bob")},
+	}
+	for _, tc := range []struct {
+		name                string
+		enter, door1, door2 bool
+	}{
+		{"Alice", true, true, true},
+		{"Bob", false, true, false},
+		{"Carol", true, false, false},
+		{"Dave", false, false, false},
+	} {
+		player := newPlayerCreature(NewCreatureID(CreatureTypePlayer), tc.name)
+		if got := player.GetName(); got != tc.name {
+			t.Errorf("player logged in as %q is named %q", tc.name, got)
+		}
+		if got := h.CanEnter(player.GetName()); got != tc.enter {
+			t.Errorf("%s can enter: %v, want %v", tc.name, got, tc.enter)
+		}
+		if got := h.CanOpenDoor(player.GetName(), 1); got != tc.door1 {
+			t.Errorf("%s can open door 1: %v, want %v", tc.name, got, tc.door1)
+		}
+		if got := h.CanOpenDoor(player.GetName(), 2); got != tc.door2 {
+			t.Errorf("%s can open door 2: %v, want %v", tc.name, got, tc.door2)
+		}
+	}
+}
+
+func TestHouses(t *testing.T) {
+	// House 1 covers the tiles north of y=500 and east of x=510. Its door has
+	// door ID 1.
+	s := newStepTestScenario(t, nil)
+	gws := s.gws
+	gws.mapDataSource.(*mapDataSource).mapTileGenerator = func(x, y uint16, z uint8) (MapTile, error) {
+		tile := &houseTestTile{stepTestTile: stepTestTile{items: []MapItem{&stepTestItem{serverType: stepTestGround}}}}
+		if x >= 510 && y < 500 {
+			tile.houseID = 1
+		}
+		if x == 511 && y == 499 {
+			tile.items = append(tile.items, &houseTestDoor{stepTestItem: stepTestItem{serverType: stepTestGround}, doorID: 1})
+		}
+		return tile, nil
+	}
+
+	dir, err := ioutil.TempDir("", "houses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "houses.json")
+	if err := ioutil.WriteFile(statePath, []byte(`{"1": {"owner": "Alice"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entry := tnet.Position{X: 505, Y: 505, Floor: 7}
+	if err := gws.SetHouses([]*House{{ID: 1, Name: "Market Street 1", Entry: entry}}); err != nil {
+		t.Fatalf("setting houses: %v", err)
+	}
+	if err := gws.SetHouseStatePath(statePath); err != nil {
+		t.Fatalf("loading house state: %v", err)
+	}
+
+	addPlayer := func(name string, pos tnet.Position) (*creature, *GameworldConnection) {
+		player := newPlayerCreature(NewCreatureID(CreatureTypePlayer), name)
+		player.pos = pos
+		return player, s.addPlayer(t, player)
+	}
+	alice, aliceConn := addPlayer("Alice", tnet.Position{X: 520, Y: 500, Floor: 7})
+	bob, bobConn := addPlayer("Bob", tnet.Position{X: 511, Y: 500, Floor: 7})
+
+	useDoor := func(conn *GameworldConnection, player *creature) []string {
+		use := tnet.NewMessage()
+		use.WriteTibiaPosition(tnet.Position{X: 511, Y: 499, Floor: 7})
+		binary.Write(use, binary.LittleEndian, uint16(stepTestGround))
+		use.Write([]byte{1, 0})
+		drainOpcodes(conn)
+		if quit, err := gws.handleMessage(conn, player.GetID(), 0x82, use); quit || err != nil {
+			t.Fatalf("using door: quit %v, error %v", quit, err)
+		}
+		_, texts := drainOpcodes(conn)
+		return texts
+	}
+	say := func(conn *GameworldConnection, player *creature, text string) []byte {
+		msg := tnet.NewMessage()
+		msg.Write([]byte{byte(ChatTypeSay)})
+		msg.WriteTibiaString(text)
+		drainOpcodes(conn)
+		if err := conn.playerSay(msg, player.GetID()); err != nil {
+			t.Fatalf("saying %q: %v", text, err)
+		}
+		opcodes, _ := drainOpcodes(conn)
+		return opcodes
+	}
+
+	// Uninvited players cannot enter, nor open the doors.
+	drainOpcodes(bobConn)
+	if err := bobConn.playerMoveNorth(); err != nil {
+		t.Fatalf("moving north: %v", err)
+	}
+	if want := (tnet.Position{X: 511, Y: 500, Floor: 7}); bob.GetPos() != want {
+		t.Errorf("uninvited player at %v, want %v", bob.GetPos(), want)
+	}
+	if opcodes, texts := drainOpcodes(bobConn); len(opcodes) != 2 || opcodes[0] != 0xB5 || len(texts) != 1 || texts[0] != "You are not invited." {
+		t.Errorf("uninvited player sent %x with texts %q, want the move cancelled", opcodes, texts)
+	}
+	if texts := useDoor(bobConn, bob); len(texts) != 1 || texts[0] != "It is locked." {
+		t.Errorf("uninvited player told %q when using the door, want it to be locked", texts)
+	}
+	if opcodes := say(bobConn, bob, "aleta sio"); strings.IndexByte(string(opcodes), 0x97) >= 0 {
+		t.Errorf("uninvited player sent %x when editing guests, want no house window", opcodes)
+	}
+
+	// The owner can enter, and the door lets them through to the scripts.
+	if _, err := gws.relocateCreature(alice, tnet.Position{X: 512, Y: 500, Floor: 7}); err != nil {
+		t.Fatalf("placing owner: %v", err)
+	}
+	if err := aliceConn.playerMoveNorth(); err != nil {
+		t.Fatalf("moving north: %v", err)
+	}
+	if want := (tnet.Position{X: 512, Y: 499, Floor: 7}); alice.GetPos() != want {
+		t.Errorf("owner at %v, want %v", alice.GetPos(), want)
+	}
+	if _, err := gws.relocateCreature(alice, tnet.Position{X: 511, Y: 498, Floor: 7}); err != nil {
+		t.Fatalf("placing owner: %v", err)
+	}
+	if texts := useDoor(aliceConn, alice); len(texts) != 1 || texts[0] != "You cannot use this object." {
+		t.Errorf("owner told %q when using the door, want the use passed on to the scripts", texts)
+	}
+
+	// The owner invites guests through the house window.
+	drainOpcodes(aliceConn)
+	msg := tnet.NewMessage()
+	msg.Write([]byte{byte(ChatTypeSay)})
+	msg.WriteTibiaString("Aleta Sio")
+	if err := aliceConn.playerSay(msg, alice.GetID()); err != nil {
+		t.Fatalf("saying aleta sio: %v", err)
+	}
+	if len(aliceConn.senderChan) != 1 {
+		t.Fatalf("owner sent %d messages after saying aleta sio, want the house window only", len(aliceConn.senderChan))
+	}
+	window := <-aliceConn.senderChan
+	var opcode, kind byte
+	var windowID uint32
+	binary.Read(window, binary.LittleEndian, &opcode)
+	binary.Read(window, binary.LittleEndian, &kind)
+	binary.Read(window, binary.LittleEndian, &windowID)
+	if text, _ := window.ReadTibiaString(); opcode != 0x97 || text != "" {
+		t.Errorf("house window is opcode %02x with text %q, want 97 with no guests", opcode, text)
+	}
+	edit := tnet.NewMessage()
+	edit.Write([]byte{kind})
+	binary.Write(edit, binary.LittleEndian, windowID)
+	edit.WriteTibiaString("# friends\nb*")
+	if quit, err := gws.handleMessage(aliceConn, alice.GetID(), 0x8A, edit); quit || err != nil {
+		t.Fatalf("editing guests: quit %v, error %v", quit, err)
+	}
+
+	if err := bobConn.playerMoveNorth(); err != nil {
+		t.Fatalf("moving north: %v", err)
+	}
+	if want := (tnet.Position{X: 511, Y: 499, Floor: 7}); bob.GetPos() != want {
+		t.Errorf("invited player at %v, want %v", bob.GetPos(), want)
+	}
+
+	// Guests can be kicked out, and subowners can only be edited by the owner.
+	if opcodes := say(bobConn, bob, "alana sio alice"); strings.IndexByte(string(opcodes), 0x64) >= 0 {
+		t.Errorf("guest sent %x when kicking the owner, want the owner to stay", opcodes)
+	}
+	if opcodes := say(aliceConn, alice, "alana sio bob"); len(opcodes) == 0 {
+		t.Errorf("owner sent nothing when kicking a guest")
+	}
+	if bob.GetPos() != entry {
+		t.Errorf("kicked player at %v, want %v", bob.GetPos(), entry)
+	}
+
+	// The guests are persisted.
+	reloaded := &GameworldServer{}
+	reloaded.SetHouses([]*House{{ID: 1}})
+	if err := reloaded.SetHouseStatePath(statePath); err != nil {
+		t.Fatalf("reloading house state: %v", err)
+	}
+	if h := reloaded.houses[1]; h.Owner != "Alice" || h.Guests.String() != "b*" {
+		t.Errorf("reloaded house has owner %q and guests %q, want the edited state", h.Owner, h.Guests)
+	}
+}
@@ -20,11 +20,13 @@ import (
 var (
 	ItemNotFound     error // In case an item is not found, this error is returned.
 	CreatureNotFound error // In case a creature is not found, this error is returned.
+	TownNotFound     error // In case a town is not found, this error is returned.
 )
 
 func init() {
 	ItemNotFound = fmt.Errorf("item not found")
 	CreatureNotFound = fmt.Errorf("creature not found")
+	TownNotFound = fmt.Errorf("town not found")
 }
 
 ////// Interfaces //////
@@ -38,6 +40,8 @@ type (
 	MapTile                = gwmap.MapTile
 	MapTileEventSubscriber = gwmap.MapTileEventSubscriber
 	MapItem                = gwmap.MapItem
+	Town                   = gwmap.Town
+	Waypoint               = gwmap.Waypoint
 )
 
 ////////////////////////
@@ -334,12 +338,12 @@ func (c *GameworldConnection) itemDescription(out *tnet.Message, item MapItem) e
 		byte(itemClientID % 256), byte(itemClientID / 256), // item
 	})
 
-	if itemOTBItem.Flags&itemsotb.FLAG_STACKABLE != 0 {
+	if itemOTBItem.Flags.Stackable() {
 		out.Write([]byte{
 			byte(item.GetCount()),
 		})
 	}
-	if itemOTBItem.Group == itemsotb.ITEM_GROUP_FLUID || itemOTBItem.Group == itemsotb.ITEM_GROUP_SPLASH || itemOTBItem.Flags&itemsotb.FLAG_CLIENTCHARGES != 0 {
+	if itemOTBItem.Group == itemsotb.ITEM_GROUP_FLUID || itemOTBItem.Group == itemsotb.ITEM_GROUP_SPLASH || itemOTBItem.Flags.ClientCharges() {
 		// either count or fluid color
 		out.Write([]byte{
 			byte(4),
@@ -412,8 +416,13 @@ func (c *GameworldConnection) creatureDescription(outMap *tnet.Message, cr Creat
 
 	outMap.WriteTibiaString(cr.GetName())
 
+	healthPercent, stepSpeed := uint8(100), uint16(0x0384)
+	if cr, ok := cr.(*creature); ok {
+		healthPercent, stepSpeed = cr.healthPercent(), cr.stepSpeed()
+	}
+
 	outMap.Write([]byte{
-		100,                // health
+		healthPercent,      // health
 		uint8(cr.GetDir()), // dir,
 	})
 
@@ -421,7 +430,7 @@ func (c *GameworldConnection) creatureDescription(outMap *tnet.Message, cr Creat
 
 	outMap.Write([]byte{
 		0x00, 0x00, // light level and color
-		0x84, 0x03, // step speed, uint16
+		byte(stepSpeed % 256), byte(stepSpeed / 256), // step speed, uint16
 		0,    //skull
 		0,    // party shield
 		0,    // 0x61, therefore required to send war emblem in 8.53+
@@ -454,6 +463,10 @@ func (c *GameworldConnection) creatureOutfit(out *tnet.Message, cr Creature) err
 			return errors.Wrapf(err, "unsupported creature %08x on scene", cr.GetID())
 		}
 		cols := cr.GetOutfitColors()
+		addons := uint8(0)
+		if cr, ok := cr.(*creature); ok {
+			addons = cr.addons
+		}
 		netOutfit := struct {
 			LookType               uint16
 			Head, Body, Legs, Feet uint8
@@ -464,7 +477,7 @@ func (c *GameworldConnection) creatureOutfit(out *tnet.Message, cr Creature) err
 			Body:     uint8(cols[1]),
 			Legs:     uint8(cols[2]),
 			Feet:     uint8(cols[3]),
-			Addons:   uint8(0),
+			Addons:   addons,
 		}
 		if netOutfit.LookType == 0 {
 			return fmt.Errorf("creature %08x look has clientside id of 0", cr.GetID())
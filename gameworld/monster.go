@@ -0,0 +1,427 @@
+package gameworld
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/things"
+	"badc0de.net/pkg/go-tibia/xmls"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// defaultGroundSpeed is the speed of the ground assumed when working out
+	// how long a step takes.
+	defaultGroundSpeed = 150
+
+	// defaultTargetChangeInterval is how often a monster reconsiders its
+	// target, unless its definition says otherwise.
+	defaultTargetChangeInterval = 2 * time.Second
+
+	// defaultSpellInterval is the interval between uses of an attack or a
+	// defense, unless its definition says otherwise.
+	defaultSpellInterval = 2 * time.Second
+
+	// defaultVoiceInterval is how often a monster considers saying
+	// something, unless its definition says otherwise.
+	defaultVoiceInterval = 5 * time.Second
+)
+
+// monsterBrain drives the behavior of a single monster: picking a target among
+// the players that can see it, moving towards it or keeping distance from it,
+// fleeing when at low health, attacking, defending itself, and talking.
+type monsterBrain struct {
+	cr  *creature
+	def *xmls.Monster
+
+	target CreatureID // Currently targeted player, or zero.
+
+	nextTargetChangeAt time.Time
+	nextStepAt         time.Time
+	nextAttackAt       []time.Time // Indexed the same way as def.Attacks.
+	nextDefenseAt      []time.Time // Indexed the same way as def.Defenses.Spells.
+	nextVoiceAt        time.Time
+}
+
+// newMonsterBrain creates a brain for the monster creature, behaving as
+// described by the passed definition.
+func newMonsterBrain(cr *creature, def *xmls.Monster, now time.Time) *monsterBrain {
+	return &monsterBrain{
+		cr:  cr,
+		def: def,
+
+		nextAttackAt:  make([]time.Time, len(def.Attacks)),
+		nextDefenseAt: make([]time.Time, len(def.Defenses.Spells)),
+		nextVoiceAt:   now.Add(voiceInterval(def)),
+	}
+}
+
+// voiceInterval returns the interval between the monster's attempts to say
+// something.
+func voiceInterval(def *xmls.Monster) time.Duration {
+	if def.Voices.Interval <= 0 {
+		return defaultVoiceInterval
+	}
+	return time.Duration(def.Voices.Interval) * time.Millisecond
+}
+
+// stepDuration returns how long the monster takes to walk a single tile.
+func (b *monsterBrain) stepDuration() time.Duration {
+	speed := int(b.cr.stepSpeed())
+	if speed <= 0 {
+		speed = 1
+	}
+	return time.Duration(1000*defaultGroundSpeed/speed) * time.Millisecond
+}
+
+// fleeing returns whether the monster's health is low enough for it to run
+// away from its target.
+func (b *monsterBrain) fleeing() bool {
+	return b.def.Flags.RunOnHealth > 0 && b.cr.health <= b.def.Flags.RunOnHealth
+}
+
+// monsterTick has all monsters with a brain think about what to do at the
+// passed time.
+func (c *GameworldServer) monsterTick(now time.Time) error {
+	for id, b := range c.monsterBrains {
+		if _, err := c.mapDataSource.GetCreatureByID(id); err == CreatureNotFound {
+			delete(c.monsterBrains, id)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := c.monsterThink(b, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMonsterBrain attaches a brain to the monster creature, so that it behaves
+// as described by the passed definition.
+func (c *GameworldServer) addMonsterBrain(cr *creature, def *xmls.Monster, now time.Time) {
+	if c.monsterBrains == nil {
+		c.monsterBrains = map[CreatureID]*monsterBrain{}
+	}
+	c.monsterBrains[cr.GetID()] = newMonsterBrain(cr, def, now)
+}
+
+// monsterHasTarget returns whether the creature is a monster currently busy
+// with a target.
+func (c *GameworldServer) monsterHasTarget(id CreatureID) bool {
+	b, ok := c.monsterBrains[id]
+	return ok && b.target != 0
+}
+
+// monsterThink runs a single step of the monster's brain.
+func (c *GameworldServer) monsterThink(b *monsterBrain, now time.Time) error {
+	target := c.monsterUpdateTarget(b, now)
+
+	if target != nil {
+		if err := c.monsterAttack(b, target, now); err != nil {
+			return err
+		}
+		if !now.Before(b.nextStepAt) {
+			if err := c.monsterMove(b, target, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.monsterDefend(b, now)
+
+	if !now.Before(b.nextVoiceAt) {
+		b.nextVoiceAt = now.Add(voiceInterval(b.def))
+		if len(b.def.Voices.Voice) > 0 && roll(b.def.Voices.Chance) {
+			voice := b.def.Voices.Voice[rand.Intn(len(b.def.Voices.Voice))]
+			chatType := ChatTypeMonsterSay
+			if voice.Yell != 0 {
+				chatType = ChatTypeMonsterYell
+			}
+			if err := c.creatureSay(b.cr, chatType, voice.Sentence); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// monsterUpdateTarget drops the monster's target if it is gone or no longer
+// visible, and picks a new one when the monster is due to reconsider it. The
+// current target is returned, or nil if there is none.
+func (c *GameworldServer) monsterUpdateTarget(b *monsterBrain, now time.Time) *creature {
+	var target *creature
+	if b.target != 0 {
+		if cr, err := c.mapDataSource.GetCreatureByID(b.target); err == nil {
+			if cr, ok := cr.(*creature); ok && c.monsterCanSee(b, cr.GetPos()) {
+				target = cr
+			}
+		}
+		if target == nil {
+			glog.V(2).Infof("monster %d lost target %d", b.cr.GetID(), b.target)
+			b.target = 0
+		}
+	}
+
+	if !b.def.Flags.Hostile {
+		b.target = 0
+		return nil
+	}
+
+	if target != nil && now.Before(b.nextTargetChangeAt) {
+		return target
+	}
+	interval := defaultTargetChangeInterval
+	if b.def.TargetChange.Interval > 0 {
+		interval = time.Duration(b.def.TargetChange.Interval) * time.Millisecond
+	}
+	b.nextTargetChangeAt = now.Add(interval)
+	if target != nil && !roll(b.def.TargetChange.Chance) {
+		return target
+	}
+
+	// Pick the closest player the monster can see.
+	bestDist := math.MaxInt32
+	for _, gwConn := range c.connections {
+		playerID, err := gwConn.PlayerID()
+		if err != nil {
+			continue
+		}
+		cr, err := c.mapDataSource.GetCreatureByID(playerID)
+		if err != nil {
+			continue
+		}
+		player, ok := cr.(*creature)
+		if !ok || !c.monsterCanSee(b, player.GetPos()) {
+			continue
+		}
+		if dist := distance(b.cr.GetPos(), player.GetPos()); dist < bestDist {
+			bestDist = dist
+			target = player
+		}
+	}
+	if target != nil && target.GetID() != b.target {
+		glog.V(2).Infof("monster %d now targeting %d", b.cr.GetID(), target.GetID())
+		b.target = target.GetID()
+	}
+	return target
+}
+
+// monsterCanSee returns whether the monster can see the passed position: it
+// needs to be on the same floor and within the viewport range.
+func (c *GameworldServer) monsterCanSee(b *monsterBrain, pos tnet.Position) bool {
+	from := b.cr.GetPos()
+	if from.Floor != pos.Floor {
+		return false
+	}
+	dx, dy := int(pos.X)-int(from.X), int(pos.Y)-int(from.Y)
+	return dx >= -8 && dx <= 9 && dy >= -6 && dy <= 7
+}
+
+// monsterMove takes a single step towards the target, away from it, or to
+// keep the distance prescribed by the monster's definition.
+func (c *GameworldServer) monsterMove(b *monsterBrain, target *creature, now time.Time) error {
+	from, targetPos := b.cr.GetPos(), target.GetPos()
+	dist := distance(from, targetPos)
+
+	// score rates how good a position is; lower is better.
+	var score func(tnet.Position) int
+	switch {
+	case b.fleeing():
+		score = func(p tnet.Position) int { return -(distance(p, targetPos)*100 + manhattan(p, targetPos)) }
+	default:
+		want := b.def.Flags.TargetDistance
+		if want < 1 {
+			want = 1
+		}
+		if dist == want {
+			return nil
+		}
+		score = func(p tnet.Position) int {
+			d := distance(p, targetPos) - want
+			if d < 0 {
+				d = -d
+			}
+			if want == 1 {
+				// Prefer approaching along the longer axis.
+				return d*100 + manhattan(p, targetPos)
+			}
+			return d * 100
+		}
+	}
+
+	best, bestDir, bestScore := from, things.CreatureDirection(0), score(from)
+	for dir := things.CreatureDirectionNorth; dir <= things.CreatureDirectionWest; dir++ {
+		p := stepInDirection(from, dir)
+		if s := score(p); s < bestScore && c.tileWalkable(p) && !c.stepMovesElsewhere(p) {
+			best, bestDir, bestScore = p, dir, s
+		}
+	}
+	if best == from {
+		return nil
+	}
+
+	b.nextStepAt = now.Add(b.stepDuration())
+	if err := c.moveCreature(b.cr, best); err != nil {
+		return err
+	}
+	return b.cr.SetDir(bestDir)
+}
+
+// monsterAttack uses the monster's attacks on the target, as long as they are
+// due, in range and their chance allows it.
+func (c *GameworldServer) monsterAttack(b *monsterBrain, target *creature, now time.Time) error {
+	from, targetPos := b.cr.GetPos(), target.GetPos()
+	dist := distance(from, targetPos)
+
+	for i := range b.def.Attacks {
+		spell := &b.def.Attacks[i]
+		if now.Before(b.nextAttackAt[i]) {
+			continue
+		}
+		b.nextAttackAt[i] = now.Add(spellInterval(spell))
+
+		if strings.EqualFold(spell.SpellName, "melee") {
+			if dist > 1 {
+				continue
+			}
+			if spell.Chance > 0 && !roll(spell.Chance) {
+				continue
+			}
+			if err := c.damageCreature(target, meleeDamage(spell), MagicEffectDrawBlood); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Other spells hit the target when it is within their range, or
+		// within their radius when they are cast around the monster.
+		reach := spell.Range
+		if reach == 0 {
+			reach = spell.Radius
+		}
+		if reach == 0 || dist > reach {
+			continue
+		}
+		if !roll(spellChance(spell)) {
+			continue
+		}
+
+		if name, ok := spell.Attribute("shootEffect"); ok {
+			if effect, ok := ShootEffectByName(name); ok {
+				c.shootEffect(from, targetPos, effect)
+			}
+		}
+		effect := MagicEffectDrawBlood
+		if name, ok := spell.Attribute("areaEffect"); ok {
+			if e, ok := MagicEffectByName(name); ok {
+				effect = e
+			}
+		}
+		if err := c.damageCreature(target, -spellValue(spell), effect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// monsterDefend uses the monster's defensive spells, as long as they are due
+// and their chance allows it. Currently only healing is supported.
+func (c *GameworldServer) monsterDefend(b *monsterBrain, now time.Time) {
+	for i := range b.def.Defenses.Spells {
+		spell := &b.def.Defenses.Spells[i]
+		if now.Before(b.nextDefenseAt[i]) {
+			continue
+		}
+		b.nextDefenseAt[i] = now.Add(spellInterval(spell))
+
+		if !strings.EqualFold(spell.SpellName, "healing") || b.cr.health >= b.cr.maxHealth {
+			continue
+		}
+		if !roll(spellChance(spell)) {
+			continue
+		}
+		effect := MagicEffectMagicBlue
+		if name, ok := spell.Attribute("areaEffect"); ok {
+			if e, ok := MagicEffectByName(name); ok {
+				effect = e
+			}
+		}
+		c.healCreature(b.cr, spellValue(spell), effect)
+	}
+}
+
+// spellInterval returns the interval between uses of the spell.
+func spellInterval(spell *xmls.MonsterSpell) time.Duration {
+	if spell.Interval <= 0 {
+		return defaultSpellInterval
+	}
+	return time.Duration(spell.Interval) * time.Millisecond
+}
+
+// spellChance returns the chance of the spell being used when it is due, in
+// percent. Spells without a chance are always used.
+func spellChance(spell *xmls.MonsterSpell) int {
+	if spell.Chance <= 0 {
+		return 100
+	}
+	return spell.Chance
+}
+
+// spellValue returns a random value between the spell's min and max. Attacks
+// usually have negative values.
+func spellValue(spell *xmls.MonsterSpell) int {
+	lo, hi := spell.Min, spell.Max
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return lo + rand.Intn(hi-lo+1)
+}
+
+// meleeDamage returns the damage dealt by a melee attack. Attacks defined with
+// skill and attack values are approximated the same way as weapons are.
+func meleeDamage(spell *xmls.MonsterSpell) int {
+	if spell.Skill > 0 || spell.Attack > 0 {
+		maxDamage := int(math.Ceil(float64(spell.Attack*(spell.Skill+5)) / 20))
+		return rand.Intn(maxDamage + 1)
+	}
+	return -spellValue(spell)
+}
+
+// roll returns true with the passed chance, in percent.
+func roll(chance int) bool {
+	return rand.Intn(100) < chance
+}
+
+// distance returns the number of steps between two positions on the same
+// floor, when diagonal steps are allowed.
+func distance(a, b tnet.Position) int {
+	dx, dy := int(a.X)-int(b.X), int(a.Y)-int(b.Y)
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+// manhattan returns the number of steps between two positions on the same
+// floor, when diagonal steps are not allowed.
+func manhattan(a, b tnet.Position) int {
+	dx, dy := int(a.X)-int(b.X), int(a.Y)-int(b.Y)
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	return dx + dy
+}
@@ -0,0 +1,139 @@
+package gameworld
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+// monsterScenario is a world on the procedural map containing a single player
+// and a single monster with a brain.
+type monsterScenario struct {
+	*scenario
+	conn   *GameworldConnection
+	player *creature
+	cr     *creature
+}
+
+func newMonsterScenario(t *testing.T, def *xmls.Monster, playerPos, monsterPos tnet.Position) *monsterScenario {
+	t.Helper()
+	s := &monsterScenario{scenario: newScenario(t, nil)}
+	s.player = &creature{
+		pos:       playerPos,
+		look:      128,
+		health:    playerMaxHealth,
+		maxHealth: playerMaxHealth,
+	}
+	s.conn = s.addPlayer(t, s.player)
+
+	if def.Health.Max == 0 {
+		def.Health.Max = 100
+	}
+	if def.Health.Now == 0 {
+		def.Health.Now = def.Health.Max
+	}
+	monsters := &xmls.Monsters{}
+	monsters.Add(def)
+	s.gws.SetMonsters(monsters)
+	s.gws.AddSpawns(xmls.Spawns{
+		Spawn: []xmls.Spawn{{
+			CenterX: int(monsterPos.X), CenterY: int(monsterPos.Y), CenterZ: int(monsterPos.Floor), Radius: 1,
+			Monster: []xmls.SpawnCreature{{Name: def.MonsterName, Z: int(monsterPos.Floor)}},
+		}},
+	})
+
+	s.tick(t, worldTickInterval)
+	cr, err := s.gws.mapDataSource.GetCreatureByID(s.gws.spawnedCreatures[0].id)
+	if err != nil {
+		t.Fatalf("monster not placed: %v", err)
+	}
+	s.cr = cr.(*creature)
+	if s.gws.monsterBrains[s.cr.GetID()] == nil {
+		t.Fatalf("monster has no brain")
+	}
+	return s
+}
+
+func TestMonsterChasesTarget(t *testing.T) {
+	s := newMonsterScenario(t,
+		&xmls.Monster{MonsterName: "Rat", Speed: 300, Flags: xmls.MonsterFlags{Hostile: true, TargetDistance: 1}},
+		tnet.Position{X: 400, Y: 400, Floor: 7}, tnet.Position{X: 405, Y: 403, Floor: 7})
+
+	s.run(t, 5*time.Second)
+	if d := distance(s.cr.GetPos(), s.player.GetPos()); d != 1 {
+		t.Errorf("monster at %v is %d tiles away from player at %v, want 1", s.cr.GetPos(), d, s.player.GetPos())
+	}
+	if opcodes, _ := drainOpcodes(s.conn); bytes.IndexByte(opcodes, 0x6D) == -1 {
+		t.Errorf("player was not told about the monster moving")
+	}
+}
+
+func TestMonsterKeepsDistance(t *testing.T) {
+	s := newMonsterScenario(t,
+		&xmls.Monster{MonsterName: "Archer", Speed: 300, Flags: xmls.MonsterFlags{Hostile: true, TargetDistance: 4}},
+		tnet.Position{X: 400, Y: 400, Floor: 7}, tnet.Position{X: 401, Y: 401, Floor: 7})
+
+	s.run(t, 5*time.Second)
+	if d := distance(s.cr.GetPos(), s.player.GetPos()); d != 4 {
+		t.Errorf("monster at %v is %d tiles away from player at %v, want 4", s.cr.GetPos(), d, s.player.GetPos())
+	}
+}
+
+func TestMonsterFleesAtLowHealth(t *testing.T) {
+	s := newMonsterScenario(t,
+		&xmls.Monster{MonsterName: "Coward", Speed: 300, Flags: xmls.MonsterFlags{Hostile: true, TargetDistance: 1, RunOnHealth: 50}},
+		tnet.Position{X: 400, Y: 400, Floor: 7}, tnet.Position{X: 402, Y: 400, Floor: 7})
+
+	s.cr.health = 10
+	start := distance(s.cr.GetPos(), s.player.GetPos())
+	s.run(t, 2*time.Second)
+	if d := distance(s.cr.GetPos(), s.player.GetPos()); d <= start {
+		t.Errorf("fleeing monster is %d tiles away from player, want more than %d", d, start)
+	}
+}
+
+func TestMonsterMeleeAttack(t *testing.T) {
+	s := newMonsterScenario(t,
+		&xmls.Monster{
+			MonsterName: "Rat",
+			Flags:       xmls.MonsterFlags{Hostile: true, TargetDistance: 1},
+			Attacks:     []xmls.MonsterSpell{{SpellName: "melee", Interval: 1000, Min: -5, Max: -5}},
+		},
+		tnet.Position{X: 400, Y: 400, Floor: 7}, tnet.Position{X: 401, Y: 400, Floor: 7})
+
+	s.run(t, 2500*time.Millisecond)
+	if s.player.health >= playerMaxHealth {
+		t.Fatalf("player health %d, want less than %d", s.player.health, playerMaxHealth)
+	}
+	if (playerMaxHealth-s.player.health)%5 != 0 {
+		t.Errorf("player lost %d health, want a multiple of 5", playerMaxHealth-s.player.health)
+	}
+	opcodes, _ := drainOpcodes(s.conn)
+	for _, op := range []byte{0x83, 0x84, 0x8C, 0xA0} {
+		if bytes.IndexByte(opcodes, op) == -1 {
+			t.Errorf("player was not sent a %02x message", op)
+		}
+	}
+}
+
+func TestMonsterVoices(t *testing.T) {
+	s := newMonsterScenario(t,
+		&xmls.Monster{
+			MonsterName: "Parrot",
+			Voices: xmls.MonsterVoices{
+				Interval: 1000, Chance: 100,
+				Voice: []xmls.MonsterVoice{{Sentence: "Hello!"}},
+			},
+		},
+		tnet.Position{X: 400, Y: 400, Floor: 7}, tnet.Position{X: 403, Y: 403, Floor: 7})
+	drainOpcodes(s.conn)
+
+	s.run(t, 3*time.Second)
+	opcodes, _ := drainOpcodes(s.conn)
+	if got := bytes.Count(opcodes, []byte{0xAA}); got < 2 {
+		t.Errorf("player heard the monster %d times, want at least 2", got)
+	}
+}
@@ -0,0 +1,477 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+	"badc0de.net/pkg/go-tibia/xmls"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// defaultNPCTalkRange is the distance from which players can talk to an
+	// NPC, unless its definition says otherwise.
+	defaultNPCTalkRange = 3
+
+	// defaultNPCIdleTime is how long an NPC keeps talking to a silent player,
+	// unless its definition says otherwise.
+	defaultNPCIdleTime = 60 * time.Second
+)
+
+var (
+	// Words greeting an NPC, saying farewell to it, and asking it to trade,
+	// in addition to any defined by the NPC's dialogue.
+	npcGreetWords    = []string{"hi", "hello"}
+	npcFarewellWords = []string{"bye", "farewell"}
+	npcTradeWords    = []string{"trade", "offer"}
+)
+
+// npcBrain drives the behavior of a single NPC: talking to a single player at
+// a time following its dialogue tree, and trading with them.
+type npcBrain struct {
+	cr        *creature
+	def       *xmls.NPC
+	interacts []xmls.NPCInteract
+	shop      []xmls.NPCShopItem
+
+	focus       CreatureID         // Player the NPC is talking to, or zero.
+	lastHeardAt time.Time          // When the focused player last said something.
+	pending     []xmls.NPCInteract // Interacts following up on the NPC's last response.
+	trading     bool               // Whether the focused player has the trade window open.
+}
+
+// talkRange returns the distance from which players can talk to the NPC.
+func (b *npcBrain) talkRange() int {
+	if b.def.Interaction != nil && b.def.Interaction.Range > 0 {
+		return b.def.Interaction.Range
+	}
+	return defaultNPCTalkRange
+}
+
+// idleTime returns how long the NPC keeps talking to a silent player.
+func (b *npcBrain) idleTime() time.Duration {
+	if b.def.Interaction != nil && b.def.Interaction.IdleTime > 0 {
+		return time.Duration(b.def.Interaction.IdleTime) * time.Second
+	}
+	return defaultNPCIdleTime
+}
+
+// inRange returns whether a player at the passed position can talk to the NPC.
+func (b *npcBrain) inRange(pos tnet.Position) bool {
+	from := b.cr.GetPos()
+	return from.Floor == pos.Floor && distance(from, pos) <= b.talkRange()
+}
+
+// addNPCBrain attaches a brain to the NPC creature, so that it talks and
+// trades as described by the passed definition.
+func (c *GameworldServer) addNPCBrain(cr *creature, def *xmls.NPC) {
+	shop, err := def.Shop()
+	if err != nil {
+		glog.Warningf("npc %q will not trade: %v", def.NPCName, err)
+		shop = nil
+	}
+	if c.npcBrains == nil {
+		c.npcBrains = map[CreatureID]*npcBrain{}
+	}
+	c.npcBrains[cr.GetID()] = &npcBrain{
+		cr:        cr,
+		def:       def,
+		interacts: def.Interacts(),
+		shop:      shop,
+	}
+}
+
+// npcHasFocus returns whether the creature is an NPC currently talking to a
+// player.
+func (c *GameworldServer) npcHasFocus(id CreatureID) bool {
+	b, ok := c.npcBrains[id]
+	return ok && b.focus != 0
+}
+
+// npcTick has all NPCs stop talking to players who walked away or went
+// silent.
+func (c *GameworldServer) npcTick(now time.Time) error {
+	for id, b := range c.npcBrains {
+		if _, err := c.mapDataSource.GetCreatureByID(id); err == CreatureNotFound {
+			delete(c.npcBrains, id)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if b.focus == 0 {
+			continue
+		}
+
+		player, err := c.mapDataSource.GetCreatureByID(b.focus)
+		if err != nil {
+			c.npcReleaseFocus(b)
+			continue
+		}
+		if !b.inRange(player.GetPos()) {
+			if err := c.npcSay(b, player, b.def.Message("walkaway", "How rude!")); err != nil {
+				return err
+			}
+			c.npcReleaseFocus(b)
+			continue
+		}
+		if now.Sub(b.lastHeardAt) > b.idleTime() {
+			if err := c.npcSay(b, player, b.def.Message("farewell", "Good bye, |PLAYERNAME|.")); err != nil {
+				return err
+			}
+			c.npcReleaseFocus(b)
+		}
+	}
+	return nil
+}
+
+// npcHear lets all NPCs within range of the speaking player react to what
+// they said.
+func (c *GameworldServer) npcHear(speaker Creature, text string, now time.Time) error {
+	if CreatureType(speaker.GetID())&CreatureTypePlayer == 0 {
+		return nil
+	}
+	for _, b := range c.npcBrains {
+		if !b.inRange(speaker.GetPos()) {
+			continue
+		}
+		if err := c.npcHandleText(b, speaker, text, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// npcHandleText has the NPC react to the text said by the player, following
+// its dialogue tree.
+func (c *GameworldServer) npcHandleText(b *npcBrain, player Creature, text string, now time.Time) error {
+	said := strings.ToLower(strings.TrimSpace(text))
+
+	if b.focus != player.GetID() {
+		greet := matchInteract(b.interacts, text, (*xmls.NPCInteract).StartsFocus)
+		if greet == nil && !oneOf(said, npcGreetWords) {
+			return nil
+		}
+		if b.focus != 0 {
+			return c.npcSay(b, player, b.def.Message("placedinqueue", "|PLAYERNAME|, please wait for your turn."))
+		}
+
+		b.focus = player.GetID()
+		b.lastHeardAt = now
+		b.pending = nil
+		if err := c.npcFace(b, player.GetPos()); err != nil {
+			return err
+		}
+		if greet != nil && len(greet.Response) > 0 {
+			b.pending = greet.Response[0].Interact
+			return c.npcSay(b, player, greet.Response[0].Text)
+		}
+		return c.npcSay(b, player, b.def.Message("greet", "Hello, |PLAYERNAME|."))
+	}
+
+	b.lastHeardAt = now
+
+	if farewell := matchInteract(b.interacts, text, (*xmls.NPCInteract).EndsFocus); farewell != nil || oneOf(said, npcFarewellWords) {
+		msg := b.def.Message("farewell", "Good bye, |PLAYERNAME|.")
+		if farewell != nil && len(farewell.Response) > 0 {
+			msg = farewell.Response[0].Text
+		}
+		if err := c.npcSay(b, player, msg); err != nil {
+			return err
+		}
+		c.npcReleaseFocus(b)
+		return nil
+	}
+
+	if len(b.shop) > 0 && oneOf(said, npcTradeWords) {
+		if err := c.npcOpenShop(b, player); err != nil {
+			return err
+		}
+		return c.npcSay(b, player, b.def.Message("sendtrade", "Here's my offer, |PLAYERNAME|. Don't you like it?"))
+	}
+
+	notFocus := func(i *xmls.NPCInteract) bool { return !i.StartsFocus() && !i.EndsFocus() }
+	interact := matchInteract(b.pending, text, notFocus)
+	if interact == nil {
+		interact = matchInteract(b.interacts, text, notFocus)
+	}
+	b.pending = nil
+	if interact == nil || len(interact.Response) == 0 {
+		return nil
+	}
+	b.pending = interact.Response[0].Interact
+	return c.npcSay(b, player, interact.Response[0].Text)
+}
+
+// npcSay makes the NPC say the text to the player, replacing the placeholders
+// for the player's name.
+func (c *GameworldServer) npcSay(b *npcBrain, player Creature, text string) error {
+	text = strings.NewReplacer("|NAME|", player.GetName(), "|PLAYERNAME|", player.GetName()).Replace(text)
+	return c.creatureSay(b.cr, ChatTypePrivateNPCToPlayer, text)
+}
+
+// npcFace turns the NPC towards the passed position.
+func (c *GameworldServer) npcFace(b *npcBrain, pos tnet.Position) error {
+	from := b.cr.GetPos()
+	dx, dy := int(pos.X)-int(from.X), int(pos.Y)-int(from.Y)
+	var dir things.CreatureDirection
+	switch {
+	case dx == 0 && dy == 0:
+		return nil
+	case dx*dx > dy*dy && dx > 0:
+		dir = things.CreatureDirectionEast
+	case dx*dx > dy*dy:
+		dir = things.CreatureDirectionWest
+	case dy > 0:
+		dir = things.CreatureDirectionSouth
+	default:
+		dir = things.CreatureDirectionNorth
+	}
+	if dir == b.cr.GetDir() {
+		return nil
+	}
+	if err := b.cr.SetDir(dir); err != nil {
+		return err
+	}
+	return c.creatureTurned(b.cr)
+}
+
+// npcReleaseFocus has the NPC stop talking to its focused player, closing the
+// trade window if it is open.
+func (c *GameworldServer) npcReleaseFocus(b *npcBrain) {
+	if b.trading {
+		if gwConn, ok := c.connections[GameworldConnectionID(b.focus)]; ok {
+			out := tnet.NewMessage()
+			out.Write([]byte{0x7C})
+			gwConn.queueMessage(out)
+		}
+	}
+	b.focus = 0
+	b.pending = nil
+	b.trading = false
+}
+
+// npcOpenShop opens the trade window with the NPC's offer for the player.
+func (c *GameworldServer) npcOpenShop(b *npcBrain, player Creature) error {
+	gwConn, ok := c.connections[GameworldConnectionID(player.GetID())]
+	if !ok {
+		return nil
+	}
+	out := tnet.NewMessage()
+	if err := gwConn.shopWindow(out, b.shop); err != nil {
+		return err
+	}
+	if err := gwConn.shopGoods(out, player); err != nil {
+		return err
+	}
+	gwConn.queueMessage(out)
+	b.trading = true
+	return nil
+}
+
+// npcTradingWith returns the brain of the NPC with which the player has the
+// trade window open, or nil.
+func (c *GameworldServer) npcTradingWith(playerID CreatureID) *npcBrain {
+	for _, b := range c.npcBrains {
+		if b.focus == playerID && b.trading {
+			return b
+		}
+	}
+	return nil
+}
+
+// shopWindow writes a message opening the trade window with the passed
+// items. Items unknown to the client are left out.
+func (c *GameworldConnection) shopWindow(out *tnet.Message, shop []xmls.NPCShopItem) error {
+	type shopEntry struct {
+		item     *xmls.NPCShopItem
+		clientID uint16
+		otbItem  *itemsotb.Item
+	}
+	var entries []shopEntry
+	for i := range shop {
+		item := &shop[i]
+		clientID := c.server.things.Temp__GetClientIDForServerID(uint16(item.ID), c.clientVersion)
+		if clientID == 0 {
+			glog.Warningf("shop item %q (%d) is not known to the client; not offering it", item.Name, item.ID)
+			continue
+		}
+		entries = append(entries, shopEntry{item, clientID, c.server.things.Temp__GetItemFromOTB(uint16(item.ID), c.clientVersion)})
+		if len(entries) == 255 {
+			break
+		}
+	}
+
+	out.Write([]byte{0x7A, byte(len(entries))})
+	for _, e := range entries {
+		subType := byte(1)
+		if e.otbItem.Flags.Stackable() || e.otbItem.Group == itemsotb.ITEM_GROUP_FLUID || e.otbItem.Group == itemsotb.ITEM_GROUP_SPLASH || e.otbItem.Flags.ClientCharges() {
+			subType = byte(e.item.SubType)
+		}
+		if err := binary.Write(out, binary.LittleEndian, e.clientID); err != nil {
+			return err
+		}
+		out.Write([]byte{subType})
+		out.WriteTibiaString(e.item.Name)
+		for _, v := range []uint32{uint32(e.otbItem.Weight()), uint32(e.item.BuyPrice), uint32(e.item.SellPrice)} {
+			if err := binary.Write(out, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shopGoods writes a message telling the trade window how much money the
+// player has, and which items they can sell.
+func (c *GameworldConnection) shopGoods(out *tnet.Message, player Creature) error {
+	money := 0
+	if cr, ok := player.(*creature); ok {
+		money = cr.money
+	}
+	out.Write([]byte{0x7B})
+	if err := binary.Write(out, binary.LittleEndian, uint32(money)); err != nil {
+		return err
+	}
+	// TODO(ivucica): list sellable items once players have an inventory.
+	out.Write([]byte{0x00})
+	return nil
+}
+
+// shopItem returns the item in the NPC's shop with the passed client ID and
+// subtype, as sent by the player's client, or nil.
+func (c *GameworldConnection) shopItem(b *npcBrain, clientID uint16, subType uint8) *xmls.NPCShopItem {
+	for i := range b.shop {
+		item := &b.shop[i]
+		if c.server.things.Temp__GetClientIDForServerID(uint16(item.ID), c.clientVersion) != clientID {
+			continue
+		}
+		if item.SubType != 0 && item.SubType != int(subType) {
+			continue
+		}
+		return item
+	}
+	return nil
+}
+
+// playerShopLook handles the player looking at an item in the trade window.
+func (c *GameworldConnection) playerShopLook(msg *tnet.Message, playerID CreatureID) error {
+	var clientID uint16
+	if err := binary.Read(msg, binary.LittleEndian, &clientID); err != nil {
+		return fmt.Errorf("error reading item id: %w", err)
+	}
+	subType, err := msg.ReadByte()
+	if err != nil {
+		return fmt.Errorf("error reading item subtype: %w", err)
+	}
+
+	b := c.server.npcTradingWith(playerID)
+	if b == nil {
+		return nil
+	}
+	item := c.shopItem(b, clientID, subType)
+	if item == nil {
+		return fmt.Errorf("item %d is not in the shop of %q", clientID, b.def.NPCName)
+	}
+	out := tnet.NewMessage()
+	c.textMessage(out, TextMessageInfoDescr, fmt.Sprintf("You see %s.", item.Name))
+	c.queueMessage(out)
+	return nil
+}
+
+// playerShopPurchase handles the player buying an item in the trade window.
+func (c *GameworldConnection) playerShopPurchase(msg *tnet.Message, playerID CreatureID) error {
+	var clientID uint16
+	if err := binary.Read(msg, binary.LittleEndian, &clientID); err != nil {
+		return fmt.Errorf("error reading item id: %w", err)
+	}
+	var buf [4]byte // subtype, amount, ignore capacity, buy with backpacks
+	if _, err := msg.Read(buf[:]); err != nil {
+		return fmt.Errorf("error reading purchase: %w", err)
+	}
+	subType, amount := buf[0], int(buf[1])
+
+	b := c.server.npcTradingWith(playerID)
+	if b == nil {
+		return nil
+	}
+	item := c.shopItem(b, clientID, subType)
+	if item == nil || item.BuyPrice == 0 {
+		return fmt.Errorf("item %d cannot be bought from %q", clientID, b.def.NPCName)
+	}
+	cr, err := c.server.mapDataSource.GetCreatureByID(playerID)
+	if err != nil {
+		return err
+	}
+	player, ok := cr.(*creature)
+	if !ok {
+		return nil
+	}
+
+	price := item.BuyPrice * amount
+	if player.money < price {
+		return c.server.npcSay(b, player, b.def.Message("needmoney", "You do not have enough money."))
+	}
+	// TODO(ivucica): take the money and place the item into the player's
+	// inventory once there is one. Until then, nothing is sold, so that no
+	// gold is lost.
+	out := tnet.NewMessage()
+	c.textMessage(out, TextMessageStatusSmall, "You cannot carry this.")
+	c.queueMessage(out)
+	return nil
+}
+
+// playerShopSale handles the player selling an item in the trade window.
+func (c *GameworldConnection) playerShopSale(msg *tnet.Message, playerID CreatureID) error {
+	var clientID uint16
+	if err := binary.Read(msg, binary.LittleEndian, &clientID); err != nil {
+		return fmt.Errorf("error reading item id: %w", err)
+	}
+	var buf [2]byte // subtype, amount
+	if _, err := msg.Read(buf[:]); err != nil {
+		return fmt.Errorf("error reading sale: %w", err)
+	}
+
+	b := c.server.npcTradingWith(playerID)
+	if b == nil {
+		return nil
+	}
+	// TODO(ivucica): take the item from the player's inventory once there is one.
+	out := tnet.NewMessage()
+	c.textMessage(out, TextMessageStatusSmall, "You do not have this object.")
+	c.queueMessage(out)
+	return nil
+}
+
+// playerShopClose handles the player closing the trade window.
+func (c *GameworldConnection) playerShopClose(playerID CreatureID) {
+	if b := c.server.npcTradingWith(playerID); b != nil {
+		b.trading = false
+	}
+}
+
+// matchInteract returns the first of the passed interacts that matches the
+// text and satisfies the passed predicate, or nil.
+func matchInteract(interacts []xmls.NPCInteract, text string, pred func(*xmls.NPCInteract) bool) *xmls.NPCInteract {
+	for i := range interacts {
+		if pred(&interacts[i]) && interacts[i].Matches(text) {
+			return &interacts[i]
+		}
+	}
+	return nil
+}
+
+// oneOf returns whether s is one of the passed words.
+func oneOf(s string, words []string) bool {
+	for _, w := range words {
+		if s == w {
+			return true
+		}
+	}
+	return false
+}
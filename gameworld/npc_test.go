@@ -0,0 +1,254 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+const testNPC = `<?xml version="1.0"?>
+<npc name="Sam" walkinterval="0">
+	<look type="131" head="38" body="38" legs="38" feet="38"/>
+	<parameters>
+		<parameter key="message_greet" value="Hello |PLAYERNAME|!"/>
+		<parameter key="shop_buyable" value="dagger,2379,4"/>
+		<parameter key="keywords" value="job"/>
+		<parameter key="keyword_reply1" value="I am the blacksmith."/>
+	</parameters>
+	<interaction range="3" idletime="10">
+		<interact keywords="weapons">
+			<response text="Do you want to see my weapons?">
+				<interact keywords="yes">
+					<response text="Here they are."/>
+				</interact>
+			</response>
+		</interact>
+	</interaction>
+</npc>`
+
+// npcScenario is a world on the procedural map containing an NPC with a brain
+// and players that can talk to it.
+type npcScenario struct {
+	*scenario
+	npc *creature
+}
+
+func newNPCScenario(t *testing.T) *npcScenario {
+	t.Helper()
+	s := &npcScenario{scenario: newScenario(t, nil)}
+
+	// A things registry knowing only about the dagger, so that it can be
+	// offered in the trade window.
+	th, _ := things.New()
+	th.AddItemsOTB(&itemsotb.Items{
+		Items: []itemsotb.Item{{
+			Attributes: map[itemsotb.ItemsAttribute]interface{}{
+				itemsotb.ITEM_ATTR_SERVERID: uint16(2379),
+				itemsotb.ITEM_ATTR_CLIENTID: uint16(3291),
+			},
+		}},
+		ServerIDToArrayIndex: map[uint16]int{2379: 0},
+		ClientIDToArrayIndex: map[uint16]int{3291: 0},
+	})
+	s.gws.SetThings(th)
+
+	def, err := xmls.ReadNPC(strings.NewReader(testNPC))
+	if err != nil {
+		t.Fatalf("reading npc: %v", err)
+	}
+	npcs := &xmls.NPCs{}
+	npcs.Add(def)
+	s.gws.SetNPCs(npcs)
+	s.gws.AddSpawns(xmls.Spawns{
+		Spawn: []xmls.Spawn{{
+			CenterX: 500, CenterY: 500, CenterZ: 7, Radius: 1,
+			NPC: []xmls.SpawnCreature{{Name: "Sam", Z: 7}},
+		}},
+	})
+
+	s.tick(t, 0)
+	cr, err := s.gws.mapDataSource.GetCreatureByID(s.gws.spawnedCreatures[0].id)
+	if err != nil {
+		t.Fatalf("npc not placed: %v", err)
+	}
+	s.npc = cr.(*creature)
+	if s.npc.GetName() != "Sam" || s.npc.GetServerType() != 131 {
+		t.Fatalf("npc placed as %q looking like %d, want Sam looking like 131", s.npc.GetName(), s.npc.GetServerType())
+	}
+	return s
+}
+
+// addCustomer adds a player with money to spend at the passed position.
+func (s *npcScenario) addCustomer(t *testing.T, name string, pos tnet.Position) (*creature, *GameworldConnection) {
+	t.Helper()
+	player := &creature{
+		pos:   pos,
+		look:  128,
+		name:  name,
+		money: playerStartingMoney,
+	}
+	return player, s.addPlayer(t, player)
+}
+
+// say has the player on the connection say the text in the NPCs channel, and
+// returns what the NPC answered, if anything.
+func (s *npcScenario) say(t *testing.T, conn *GameworldConnection, text string) string {
+	t.Helper()
+	msg := tnet.NewMessage()
+	msg.Write([]byte{byte(ChatTypePrivatePlayerToNPC)})
+	msg.WriteTibiaString(text)
+	playerID, _ := conn.PlayerID()
+	if err := conn.playerSay(msg, playerID); err != nil {
+		t.Fatalf("saying %q: %v", text, err)
+	}
+	return npcAnswer(t, conn)
+}
+
+// npcAnswer drains the messages sent to the connection, returning the last
+// text spoken by an NPC.
+func npcAnswer(t *testing.T, conn *GameworldConnection) string {
+	t.Helper()
+	answer := ""
+	for {
+		select {
+		case msg := <-conn.senderChan:
+			if opcode, _ := msg.ReadByte(); opcode != 0xAA {
+				continue
+			}
+			msg.Next(4) // unkSpeak
+			msg.ReadTibiaString()
+			msg.Next(2) // level
+			if chatType, _ := msg.ReadByte(); ChatType(chatType) != ChatTypePrivateNPCToPlayer {
+				continue
+			}
+			msg.ReadTibiaPosition()
+			answer, _ = msg.ReadTibiaString()
+		default:
+			return answer
+		}
+	}
+}
+
+func TestNPCDialogue(t *testing.T) {
+	s := newNPCScenario(t)
+	player, conn := s.addCustomer(t, "Alice", tnet.Position{X: 502, Y: 500, Floor: 7})
+
+	if got := s.say(t, conn, "job"); got != "" {
+		t.Errorf("npc answered %q before being greeted", got)
+	}
+	for _, tc := range []struct{ say, want string }{
+		{"hi", "Hello Alice!"},
+		{"what is your job?", "I am the blacksmith."},
+		{"yes", ""},
+		{"weapons", "Do you want to see my weapons?"},
+		{"yes", "Here they are."},
+		{"yes", ""},
+	} {
+		if got := s.say(t, conn, tc.say); got != tc.want {
+			t.Errorf("after saying %q, npc answered %q, want %q", tc.say, got, tc.want)
+		}
+	}
+	if s.npc.GetDir() != things.CreatureDirectionEast {
+		t.Errorf("npc is facing %v, want to face the player to the east", s.npc.GetDir())
+	}
+
+	// While the NPC is talking to Alice, Bob has to wait.
+	_, bobConn := s.addCustomer(t, "Bob", tnet.Position{X: 500, Y: 501, Floor: 7})
+	if got, want := s.say(t, bobConn, "hello"), "Bob, please wait for your turn."; got != want {
+		t.Errorf("npc answered busy greeting with %q, want %q", got, want)
+	}
+
+	if got, want := s.say(t, conn, "bye"), "Good bye, Alice."; got != want {
+		t.Errorf("npc answered farewell with %q, want %q", got, want)
+	}
+	if s.gws.npcHasFocus(s.npc.GetID()) {
+		t.Errorf("npc still talking to a player after farewell")
+	}
+
+	// Players walking away or going silent are dropped.
+	s.say(t, conn, "hi")
+	player.pos = tnet.Position{X: 505, Y: 500, Floor: 7}
+	s.tick(t, time.Second)
+	if got, want := npcAnswer(t, conn), "How rude!"; got != want {
+		t.Errorf("npc reacted to player walking away with %q, want %q", got, want)
+	}
+	player.pos = tnet.Position{X: 502, Y: 500, Floor: 7}
+	s.say(t, conn, "hi")
+	s.tick(t, 5*time.Second)
+	if !s.gws.npcHasFocus(s.npc.GetID()) {
+		t.Errorf("npc stopped talking to player too soon")
+	}
+	s.tick(t, 20*time.Second)
+	if s.gws.npcHasFocus(s.npc.GetID()) {
+		t.Errorf("npc still talking to a silent player")
+	}
+}
+
+func TestNPCTrade(t *testing.T) {
+	s := newNPCScenario(t)
+	player, conn := s.addCustomer(t, "Alice", tnet.Position{X: 501, Y: 501, Floor: 7})
+
+	s.say(t, conn, "hi")
+	msg := tnet.NewMessage()
+	msg.Write([]byte{byte(ChatTypePrivatePlayerToNPC)})
+	msg.WriteTibiaString("trade")
+	if err := conn.playerSay(msg, player.GetID()); err != nil {
+		t.Fatalf("saying trade: %v", err)
+	}
+
+	var window *tnet.Message
+	for len(conn.senderChan) > 0 {
+		if m := <-conn.senderChan; m.Bytes()[0] == 0x7A {
+			window = m
+		}
+	}
+	if window == nil {
+		t.Fatalf("trade window not opened")
+	}
+	window.Next(1)
+	if count, _ := window.ReadByte(); count != 1 {
+		t.Fatalf("trade window has %d items, want 1", count)
+	}
+	var clientID uint16
+	binary.Read(window, binary.LittleEndian, &clientID)
+	window.Next(1) // subtype
+	name, _ := window.ReadTibiaString()
+	var weight, buy, sell uint32
+	binary.Read(window, binary.LittleEndian, &weight)
+	binary.Read(window, binary.LittleEndian, &buy)
+	binary.Read(window, binary.LittleEndian, &sell)
+	if clientID != 3291 || name != "dagger" || buy != 4 || sell != 0 {
+		t.Errorf("trade window offers %q (%d) for %d/%d, want dagger (3291) for 4/0", name, clientID, buy, sell)
+	}
+	if window.Len() < 5 || window.Bytes()[0] != 0x7B {
+		t.Errorf("trade window not followed by player's goods")
+	}
+
+	// Buying three daggers is refused without taking any gold, as there is
+	// no inventory to place them in.
+	purchase := tnet.NewMessage()
+	binary.Write(purchase, binary.LittleEndian, uint16(3291))
+	purchase.Write([]byte{1, 3, 0, 0})
+	drainOpcodes(conn)
+	if err := conn.playerShopPurchase(purchase, player.GetID()); err != nil {
+		t.Fatalf("purchase: %v", err)
+	}
+	if player.money != playerStartingMoney {
+		t.Errorf("player has %d gold after refused purchase, want %d", player.money, playerStartingMoney)
+	}
+	if _, texts := drainOpcodes(conn); len(texts) != 1 || texts[0] != "You cannot carry this." {
+		t.Errorf("refused purchase told %q, want it to be refused", texts)
+	}
+
+	// Saying farewell closes the trade window.
+	s.say(t, conn, "bye")
+	if s.gws.npcTradingWith(player.GetID()) != nil {
+		t.Errorf("player still trading after farewell")
+	}
+}
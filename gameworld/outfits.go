@@ -0,0 +1,123 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/things"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+// permittedOutfits returns the looks the creature may choose from: those
+// matching its sex, from outfits which are worn by default or which it has
+// unlocked. Premium outfits are only permitted to premium players.
+func (c *GameworldServer) permittedOutfits(cr Creature) []xmls.OutfitListEntry {
+	if c.outfits == nil {
+		return nil
+	}
+	crc, ok := cr.(*creature)
+	if !ok {
+		return nil
+	}
+
+	typ := xmls.OutfitTypeMale
+	if crc.female {
+		typ = xmls.OutfitTypeFemale
+	}
+
+	var looks []xmls.OutfitListEntry
+	for _, outfit := range c.outfits.Outfit {
+		// If not unlocked by default, player must have unlocked it somehow.
+		if !outfit.IsDefault() && !crc.outfitUnlocked(outfit.ID) {
+			continue
+		}
+
+		// Must have premium for premium outfits.
+		if outfit.Premium != 0 && !crc.premium {
+			continue
+		}
+
+		// Otherwise we can proceed.
+		for _, look := range outfit.List {
+			if look.Type == typ {
+				looks = append(looks, look)
+			}
+		}
+	}
+	return looks
+}
+
+// outfitUnlocked returns whether the creature has unlocked the outfit with the
+// passed ID.
+func (c *creature) outfitUnlocked(id int) bool {
+	for _, unlocked := range c.unlockedOutfits {
+		if unlocked == id {
+			return true
+		}
+	}
+	return false
+}
+
+// outfitAddons returns the addons which may be worn with the look type, as a
+// bitmask, based on the number of addons present in the dat file.
+func (c *GameworldConnection) outfitAddons(lookType uint16) uint8 {
+	thCr, err := c.server.things.CreatureWithClientID(lookType, c.clientVersion)
+	if err != nil {
+		return 0
+	}
+	return uint8(1<<uint(thCr.AddonCount()) - 1)
+}
+
+// playerSetOutfit handles the player choosing an outfit in the outfit window.
+// The outfit has to be one of the permitted outfits, and only addons available
+// for it are applied. All spectators are told about the new outfit.
+func (c *GameworldConnection) playerSetOutfit(msg *tnet.Message, playerID gwmap.CreatureID) error {
+	var netOutfit struct {
+		LookType               uint16
+		Head, Body, Legs, Feet uint8
+		Addons                 uint8
+	}
+	if err := binary.Read(msg, binary.LittleEndian, &netOutfit); err != nil {
+		return fmt.Errorf("error reading outfit: %w", err)
+	}
+
+	player, err := c.server.mapDataSource.GetCreatureByID(playerID)
+	if err != nil {
+		return fmt.Errorf("error getting player creature by id: %w", err)
+	}
+	crc, ok := player.(*creature)
+	if !ok {
+		return fmt.Errorf("player %d cannot change outfits", playerID)
+	}
+
+	permitted := false
+	for _, look := range c.server.permittedOutfits(player) {
+		if uint16(look.LookType) == netOutfit.LookType {
+			permitted = true
+			break
+		}
+	}
+	if !permitted {
+		return fmt.Errorf("player %d may not wear look type %d", playerID, netOutfit.LookType)
+	}
+
+	cols := [4]things.OutfitColor{
+		things.OutfitColor(netOutfit.Head),
+		things.OutfitColor(netOutfit.Body),
+		things.OutfitColor(netOutfit.Legs),
+		things.OutfitColor(netOutfit.Feet),
+	}
+	for _, col := range cols {
+		if int(col) >= things.OutfitColorCount() {
+			return fmt.Errorf("player %d chose unknown outfit color %d", playerID, col)
+		}
+	}
+
+	crc.look = netOutfit.LookType
+	crc.col = cols
+	crc.addons = netOutfit.Addons & c.outfitAddons(netOutfit.LookType)
+	c.server.creatureOutfitChanged(crc)
+	return nil
+}
@@ -0,0 +1,103 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+const testOutfits = `<?xml version="1.0"?>
+<outfits>
+	<outfit id="1" premium="0">
+		<list type="female" looktype="136" name="Citizen"/>
+		<list type="male" looktype="128" name="Citizen"/>
+	</outfit>
+	<outfit id="5" premium="1">
+		<list type="female" looktype="140" name="Noblewoman"/>
+		<list type="male" looktype="132" name="Nobleman"/>
+	</outfit>
+	<outfit id="12" premium="0" default="0">
+		<list type="female" looktype="155" name="Pirate"/>
+		<list type="male" looktype="151" name="Pirate"/>
+	</outfit>
+</outfits>`
+
+func TestPermittedOutfits(t *testing.T) {
+	outfits, err := xmls.ReadOutfits(strings.NewReader(testOutfits))
+	if err != nil {
+		t.Fatalf("reading outfits: %v", err)
+	}
+	gws := &GameworldServer{}
+	gws.SetOutfits(&outfits)
+
+	for _, tc := range []struct {
+		name string
+		cr   *creature
+		want []int
+	}{
+		{"free male", &creature{}, []int{128}},
+		{"premium female", &creature{female: true, premium: true}, []int{136, 140}},
+		{"male pirate", &creature{unlockedOutfits: []int{12}}, []int{128, 151}},
+	} {
+		var got []int
+		for _, look := range gws.permittedOutfits(tc.cr) {
+			got = append(got, look.LookType)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s may wear %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s may wear %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPlayerSetOutfit(t *testing.T) {
+	outfits, err := xmls.ReadOutfits(strings.NewReader(testOutfits))
+	if err != nil {
+		t.Fatalf("reading outfits: %v", err)
+	}
+	s := newScenario(t, nil)
+	s.gws.SetOutfits(&outfits)
+
+	player := &creature{
+		pos:  tnet.Position{X: 500, Y: 500, Floor: 7},
+		look: 128,
+	}
+	conn := s.addPlayer(t, player)
+
+	setOutfit := func(lookType uint16, head, body, legs, feet, addons uint8) error {
+		msg := tnet.NewMessage()
+		binary.Write(msg, binary.LittleEndian, lookType)
+		msg.Write([]byte{head, body, legs, feet, addons})
+		return conn.playerSetOutfit(msg, player.GetID())
+	}
+
+	if err := setOutfit(132, 1, 2, 3, 4, 0); err == nil {
+		t.Errorf("free player put on a premium outfit")
+	}
+	if err := setOutfit(128, 1, 2, 200, 4, 0); err == nil {
+		t.Errorf("player put on an unknown color")
+	}
+	if player.look != 128 || player.col[0] != 0 {
+		t.Errorf("rejected outfits changed the player's look to %d %v", player.look, player.col)
+	}
+
+	player.unlockedOutfits = []int{12}
+	if err := setOutfit(151, 1, 2, 3, 4, 3); err != nil {
+		t.Fatalf("putting on unlocked outfit: %v", err)
+	}
+	if player.look != 151 || player.col[0] != 1 || player.col[3] != 4 {
+		t.Errorf("player looks like %d %v, want 151 [1 2 3 4]", player.look, player.col)
+	}
+	if player.addons != 0 {
+		t.Errorf("player wears addons %d not present in the dat", player.addons)
+	}
+}
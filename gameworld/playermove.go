@@ -2,6 +2,7 @@ package gameworld
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 
 	tnet "badc0de.net/pkg/go-tibia/net"
@@ -28,7 +29,7 @@ func (c *GameworldConnection) playerCancelMove(dir byte) error {
 	out.Write([]byte{
 		dir, // direction
 	})
-	c.senderChan <- out
+	c.queueMessage(out)
 	return nil
 }
 
@@ -37,11 +38,11 @@ func (c *GameworldConnection) playerCancelMove(dir byte) error {
 func (c *GameworldConnection) playerMoveNorth() error {
 	outMove := tnet.NewMessage()
 	if err := c.playerMoveNorthImpl(outMove); err != nil {
-		return err
+		return c.playerMoveFailed(err, 0)
 	}
 
-	c.senderChan <- outMove
-	return nil
+	c.queueMessage(outMove)
+	return c.playerSteppedIn()
 }
 
 // playerMoveEast tells the client to move the player east by one tile. The
@@ -49,11 +50,11 @@ func (c *GameworldConnection) playerMoveNorth() error {
 func (c *GameworldConnection) playerMoveEast() error {
 	outMove := tnet.NewMessage()
 	if err := c.playerMoveEastImpl(outMove); err != nil {
-		return err
+		return c.playerMoveFailed(err, 1)
 	}
 
-	c.senderChan <- outMove
-	return nil
+	c.queueMessage(outMove)
+	return c.playerSteppedIn()
 }
 
 // playerMoveSouth tells the client to move the player south by one tile. The
@@ -61,11 +62,11 @@ func (c *GameworldConnection) playerMoveEast() error {
 func (c *GameworldConnection) playerMoveSouth() error {
 	outMove := tnet.NewMessage()
 	if err := c.playerMoveSouthImpl(outMove); err != nil {
-		return err
+		return c.playerMoveFailed(err, 2)
 	}
 
-	c.senderChan <- outMove
-	return nil
+	c.queueMessage(outMove)
+	return c.playerSteppedIn()
 }
 
 // playerMoveWest tells the client to move the player west by one tile. The
@@ -73,20 +74,57 @@ func (c *GameworldConnection) playerMoveSouth() error {
 func (c *GameworldConnection) playerMoveWest() error {
 	outMove := tnet.NewMessage()
 	if err := c.playerMoveWestImpl(outMove); err != nil {
+		return c.playerMoveFailed(err, 3)
+	}
+
+	c.queueMessage(outMove)
+	return c.playerSteppedIn()
+}
+
+// playerMoveFailed handles the player's move in the passed direction having
+// failed with the passed error. Moves refused by the world are cancelled, and
+// the player is told why; other errors are returned.
+func (c *GameworldConnection) playerMoveFailed(err error, dir byte) error {
+	if !errors.Is(err, errNotInvited) {
 		return err
 	}
+	if err := c.playerCancelMove(dir); err != nil {
+		return err
+	}
+	return c.statusMessage("You are not invited.")
+}
 
-	c.senderChan <- outMove
-	return nil
+// playerSteppedIn processes the player stepping onto their current tile, once
+// the client has been told about the step.
+func (c *GameworldConnection) playerSteppedIn() error {
+	pid, err := c.PlayerID()
+	if err != nil {
+		return err
+	}
+	player, err := c.server.mapDataSource.GetCreatureByID(pid)
+	if err != nil {
+		return err
+	}
+	return c.server.creatureSteppedIn(player)
 }
 
 // moveCreature moves a creature from its current position to a new position,
 // and generates the network traffic to inform the client of the move. The
 // player's position is updated in the data source, and the creature is removed
 // from the old tile and added to the new tile.
+//
+// Other players that can see the move are informed of it as well.
 func (c *GameworldConnection) moveCreature(outMove *tnet.Message, player Creature, newP tnet.Position) error {
 	p := player.GetPos()
-	pid := player.GetID()
+
+	if err := c.server.houseEntryAllowed(player, newP); err != nil {
+		return err
+	}
+	stackPos, err := c.server.relocateCreature(player, newP)
+	if err != nil {
+		return err
+	}
+	glog.Infof("moving from stackpos %d", stackPos)
 
 	outMove.Write([]byte{0x6D})
 
@@ -94,68 +132,111 @@ func (c *GameworldConnection) moveCreature(outMove *tnet.Message, player Creatur
 	if err := binary.Write(outMove, binary.LittleEndian, p); err != nil {
 		return err
 	}
-	// get source tile
-	t, err := c.server.mapDataSource.GetMapTile(p.X, p.Y, p.Floor)
+	outMove.Write([]byte{byte(stackPos)})
+	// write new position to message
+	if err := binary.Write(outMove, binary.LittleEndian, newP); err != nil {
+		return err
+	}
+
+	if err := c.server.creatureMoved(player, p, stackPos, c); err != nil {
+		return err
+	}
+	return c.server.creatureSteppedOut(player, p)
+}
+
+// moveCreature moves a creature which is not controlled by a connection (such
+// as a monster or an NPC) to a new position, and informs all players that can
+// see the move.
+func (c *GameworldServer) moveCreature(cr Creature, newP tnet.Position) error {
+	p := cr.GetPos()
+	stackPos, err := c.relocateCreature(cr, newP)
 	if err != nil {
 		return err
 	}
+	if err := c.creatureMoved(cr, p, stackPos, nil); err != nil {
+		return err
+	}
+	if err := c.creatureSteppedOut(cr, p); err != nil {
+		return err
+	}
+	return c.creatureSteppedIn(cr)
+}
 
-	// find source index for creature.
+// teleportCreature moves the creature to the passed position, regardless of
+// the distance. Spectators see the creature disappear from its old position
+// and appear at the new one, rather than walking there, and a player being
+// teleported is sent the full description of the map around the destination.
+func (c *GameworldServer) teleportCreature(cr Creature, newP tnet.Position) error {
+	if _, err := c.mapDataSource.GetMapTile(newP.X, newP.Y, newP.Floor); err != nil {
+		return fmt.Errorf("teleporting creature %d to %v: %w", cr.GetID(), newP, err)
+	}
 
-	// first, count items.
-	var itemCount int
-	for itemCount = 0; ; itemCount++ {
-		// TODO(ivucica): this loop is really silly; expose item count in tile interface
-		_, err := t.GetItem(itemCount)
-		if err == ItemNotFound {
-			break
-		}
-		if err != nil {
-			return err
-		}
+	gwConn := c.connections[GameworldConnectionID(cr.GetID())]
+
+	p := cr.GetPos()
+	stackPos, err := c.relocateCreature(cr, newP)
+	if err != nil {
+		return err
 	}
-	// now, find the creature.
-	for i := 0; ; i++ {
-		// TODO(ivucica): allow fetching item stackindex using tile interface
-		c, err := t.GetCreature(i)
-		if err == CreatureNotFound {
-			return fmt.Errorf("creature not found at expected tile (%v / %v)", p, t)
-		}
-		if err != nil {
+	c.creatureDisappear(cr, p, stackPos, gwConn)
+	if err := c.creatureAppear(cr, gwConn); err != nil {
+		return err
+	}
+
+	if gwConn != nil {
+		out := tnet.NewMessage()
+		if err := gwConn.initialAppearMap(out); err != nil {
 			return err
 		}
-		if c.GetID() == pid {
-			outMove.Write([]byte{byte(i + itemCount)})
-			glog.Infof("moving from stackpos %d", i+itemCount)
-			break
-		}
+		gwConn.queueMessage(out)
 	}
+	return nil
+}
 
-	// remove creature from source tile
-	if err := t.RemoveCreature(player); err != nil {
-		return err
+// relocateCreature removes the creature from the tile at its current position,
+// updates its position, and adds it to the tile at the new position. It
+// returns the stack position the creature had on the source tile.
+//
+// No network traffic is generated.
+func (c *GameworldServer) relocateCreature(cr Creature, newP tnet.Position) (int, error) {
+	p := cr.GetPos()
+
+	// get source tile
+	t, err := c.mapDataSource.GetMapTile(p.X, p.Y, p.Floor)
+	if err != nil {
+		return 0, err
 	}
 
-	// apply new position to player creature on the server side
-	if err := player.SetPos(newP); err != nil {
-		return err
+	// find source index for creature.
+	stackPos, err := creatureStackPos(t, cr.GetID())
+	if err == CreatureNotFound {
+		return 0, fmt.Errorf("creature not found at expected tile (%v / %v)", p, t)
 	}
-	// write new position to message
-	if err := binary.Write(outMove, binary.LittleEndian, newP); err != nil {
-		return err
+	if err != nil {
+		return 0, err
+	}
+
+	// remove creature from source tile
+	if err := t.RemoveCreature(cr); err != nil {
+		return 0, err
+	}
+
+	// apply new position to the creature on the server side
+	if err := cr.SetPos(newP); err != nil {
+		return 0, err
 	}
 
 	// get destination tile
-	t, err = c.server.mapDataSource.GetMapTile(newP.X, newP.Y, newP.Floor)
+	t, err = c.mapDataSource.GetMapTile(newP.X, newP.Y, newP.Floor)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	// add player creature to the destination tile
-	if err := t.AddCreature(player); err != nil {
-		return err
+	// add the creature to the destination tile
+	if err := t.AddCreature(cr); err != nil {
+		return 0, err
 	}
 
-	return nil
+	return stackPos, nil
 }
 
 // TestOnly_PlayerMoveNorthImpl is a test-only function that allows testing the
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"sync"
+	"time"
 
 	"badc0de.net/pkg/go-tibia/dat"
 	tnet "badc0de.net/pkg/go-tibia/net"
@@ -20,6 +21,7 @@ func NewMapDataSource() MapDataSource {
 		creatures:         map[CreatureID]Creature{},
 		generatedMapTiles: map[tnet.Position]MapTile{},
 		mapTileGenerator:  generateMapTileImpl,
+		demoCreatures:     true,
 	}
 }
 
@@ -36,8 +38,46 @@ type creature struct {
 	id  CreatureID
 	dir things.CreatureDirection
 
-	look uint16
-	col  [4]things.OutfitColor
+	look   uint16
+	col    [4]things.OutfitColor
+	addons uint8 // Addons worn with the outfit, as a bitmask.
+
+	name string // If empty, a generic name is used.
+
+	health, maxHealth int    // If maxHealth is zero, the creature is presented with full health.
+	speed             uint16 // If zero, a default speed is used.
+
+	money int // Gold held by a player, spent and earned by trading with NPCs.
+
+	female          bool   // Whether a player wears female rather than male outfits.
+	premium         bool   // Whether a player may wear premium outfits.
+	unlockedOutfits []int  // IDs of outfits which a player may wear even though they are not worn by default.
+	townID          uint32 // Home town of a player, in whose temple they appear.
+}
+
+// healthPercent returns the creature's health as percentage of its maximum
+// health, as sent to the clients.
+func (c *creature) healthPercent() uint8 {
+	if c.maxHealth <= 0 {
+		return 100
+	}
+	if c.health <= 0 {
+		return 0
+	}
+	pct := c.health * 100 / c.maxHealth
+	if pct == 0 {
+		// Still alive, so don't present it as dead.
+		pct = 1
+	}
+	return uint8(pct)
+}
+
+// stepSpeed returns the speed of the creature as sent to the clients.
+func (c *creature) stepSpeed() uint16 {
+	if c.speed == 0 {
+		return 0x0384
+	}
+	return c.speed
 }
 
 func (c *creature) GetPos() tnet.Position {
@@ -47,6 +87,9 @@ func (c *creature) GetID() CreatureID {
 	return c.id
 }
 func (c *creature) GetName() string {
+	if c.name != "" {
+		return c.name
+	}
 	return "Demo Character"
 }
 func (c *creature) GetServerType() uint16 {
@@ -79,9 +122,14 @@ type mapDataSource struct {
 	generatedMapTilesLock sync.Mutex
 
 	mapTileGenerator func(x, y uint16, z uint8) (MapTile, error)
+
+	// demoCreatures places a few creatures next to the temple of the test
+	// map, as tiles around them are generated.
+	demoCreatures bool
 }
 type mapTile struct {
 	ground    MapItem
+	items     []MapItem // On top of the ground.
 	creatures []Creature
 
 	subscribers []MapTileEventSubscriber
@@ -105,20 +153,36 @@ const (
 	NightAmbientLevel = uint8(40)
 	DayAmbient        = dat.DatasetColor(0xD7)
 	DayAmbientLevel   = uint8(250)
+	TwilightAmbient   = dat.DatasetColor(0xC7) // orange, halfway through dawn and dusk
 )
 
 ///////////////////////////
 
-func (ds *mapDataSource) Private_And_Temp__DefaultPlayerSpawnPoint(c CreatureID) tnet.Position {
-	return tnet.Position{
-		X:     uint16(32768 + 18/2 + int(c)),
-		Y:     32768 + 14/2,
-		Floor: 7,
+// proceduralTown is the only town on the generated map. Its temple is in the
+// middle of the area generated first.
+var proceduralTown = Town{
+	ID:        1,
+	Name:      "Genesis",
+	TemplePos: tnet.Position{X: 32768 + 18/2, Y: 32768 + 14/2, Floor: 7},
+}
+
+func (ds *mapDataSource) GetTownTemple(townID uint32) (tnet.Position, error) {
+	if townID != proceduralTown.ID {
+		return tnet.Position{}, TownNotFound
 	}
+	return proceduralTown.TemplePos, nil
+}
+
+func (ds *mapDataSource) Towns() []Town {
+	return []Town{proceduralTown}
+}
+
+func (ds *mapDataSource) Waypoints() []Waypoint {
+	return nil
 }
 
 func (ds *mapDataSource) GetAmbientLight() (dat.DatasetColor, uint8) {
-	return NightAmbient, NightAmbientLevel
+	return AmbientLight(time.Now())
 }
 
 func (ds *mapDataSource) GetMapTile(x, y uint16, z uint8) (MapTile, error) {
@@ -135,6 +199,9 @@ func (ds *mapDataSource) GetMapTile(x, y uint16, z uint8) (MapTile, error) {
 
 	ds.generatedMapTiles[tnet.Position{x, y, z}] = generatedMapTile
 
+	if !ds.demoCreatures {
+		return generatedMapTile, nil
+	}
 	if x == 32768+5 && y == 32768+5 && z == 7 {
 		cr := &creature{id: CreatureID(1234 | CreatureTypePlayer), pos: tnet.Position{X: x, Y: y, Floor: z}, look: 128, col: [4]things.OutfitColor{
 			things.OutfitColor(rand.Int() % things.OutfitColorCount()),
@@ -261,11 +328,72 @@ func (t *mapTile) GetCreature(idx int) (Creature, error) {
 	return t.creatures[idx], nil
 }
 func (t *mapTile) GetItem(idx int) (MapItem, error) {
-	if idx == 0 && t.ground != nil && t.ground.GetServerType() != 0 {
-		return t.ground, nil
+	if t.ground != nil && t.ground.GetServerType() != 0 {
+		if idx == 0 {
+			return t.ground, nil
+		}
+		idx--
+	}
+	if idx >= 0 && idx < len(t.items) {
+		return t.items[idx], nil
 	}
 	return nil, ItemNotFound
 }
+
+// hasGround returns whether the first item of the tile is its ground.
+func (t *mapTile) hasGround() bool {
+	return t.ground != nil && t.ground.GetServerType() != 0
+}
+
+// AddItem places a new item of the passed type on top of the other items on
+// the tile. Procedural items are never stacked, so the count is ignored.
+func (t *mapTile) AddItem(serverType, count uint16) (int, error) {
+	t.items = append(t.items, mapItemOfType(int(serverType)))
+	if t.hasGround() {
+		return len(t.items), nil
+	}
+	return len(t.items) - 1, nil
+}
+
+// RemoveItem removes the item at the passed index.
+func (t *mapTile) RemoveItem(idx int) error {
+	if t.hasGround() {
+		if idx == 0 {
+			t.ground = nil
+			return nil
+		}
+		idx--
+	}
+	if idx < 0 || idx >= len(t.items) {
+		return ItemNotFound
+	}
+	t.items = append(t.items[:idx], t.items[idx+1:]...)
+	return nil
+}
+
+// TransformItem turns the item at the passed index into an item of the passed
+// type, in the same place.
+func (t *mapTile) TransformItem(idx int, serverType uint16) (int, error) {
+	if _, err := t.GetItem(idx); err != nil {
+		return 0, err
+	}
+	if t.hasGround() && idx == 0 {
+		t.ground = mapItemOfType(int(serverType))
+		return idx, nil
+	}
+	if t.hasGround() {
+		t.items[idx-1] = mapItemOfType(int(serverType))
+	} else {
+		t.items[idx] = mapItemOfType(int(serverType))
+	}
+	return idx, nil
+}
+
+// SetItemActionID fails, as procedural items cannot carry action IDs.
+func (t *mapTile) SetItemActionID(idx int, actionID uint16) error {
+	return fmt.Errorf("procedural map items cannot carry action IDs")
+}
+
 func (t *mapTile) AddCreature(c Creature) error {
 	t.creatures = append(t.creatures, c)
 	return nil
@@ -0,0 +1,82 @@
+package gameworld
+
+import (
+	"testing"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// scenario is a world in which tests have players act through their
+// connections, and run the world tick by tick rather than in real time.
+type scenario struct {
+	gws *GameworldServer
+	now time.Time
+}
+
+// newScenario returns a scenario on the passed map, or on the procedural map
+// if it is nil.
+func newScenario(t *testing.T, ds MapDataSource) *scenario {
+	t.Helper()
+	gws := &GameworldServer{
+		connections: map[GameworldConnectionID]*GameworldConnection{},
+	}
+	if ds == nil {
+		ds = NewMapDataSource()
+	}
+	gws.SetMapDataSource(ds)
+	return &scenario{gws: gws, now: time.Now()}
+}
+
+// addPlayer places the player on the map, giving them a new ID unless they
+// already have one, and returns their connection. Messages sent to the player
+// are queued on the connection until drained.
+func (s *scenario) addPlayer(t *testing.T, player *creature) *GameworldConnection {
+	t.Helper()
+	if player.id == 0 {
+		player.id = NewCreatureID(CreatureTypePlayer)
+	}
+	if err := s.gws.mapDataSource.AddCreature(player); err != nil {
+		t.Fatalf("adding player %q: %v", player.GetName(), err)
+	}
+	conn := &GameworldConnection{
+		id:         GameworldConnectionID(player.GetID()),
+		server:     s.gws,
+		senderChan: make(chan *tnet.Message, 1000),
+	}
+	s.gws.connections[conn.id] = conn
+	return conn
+}
+
+// tick runs the world forward by the passed duration, in a single tick.
+func (s *scenario) tick(t *testing.T, d time.Duration) {
+	t.Helper()
+	s.now = s.now.Add(d)
+	if err := s.gws.worldTick(s.now); err != nil {
+		t.Fatalf("world tick: %v", err)
+	}
+}
+
+// run runs the world for the passed duration, tick by tick.
+func (s *scenario) run(t *testing.T, d time.Duration) {
+	t.Helper()
+	for end := s.now.Add(d); s.now.Before(end); {
+		s.tick(t, worldTickInterval)
+	}
+}
+
+// drainOpcodes returns the opcodes of the messages sent to the connection, and
+// the texts of server text messages among them.
+func drainOpcodes(conn *GameworldConnection) (opcodes []byte, texts []string) {
+	for len(conn.senderChan) > 0 {
+		msg := <-conn.senderChan
+		opcode, _ := msg.ReadByte()
+		opcodes = append(opcodes, opcode)
+		if opcode == 0xB4 {
+			msg.ReadByte() // type
+			text, _ := msg.ReadTibiaString()
+			texts = append(texts, text)
+		}
+	}
+	return opcodes, texts
+}
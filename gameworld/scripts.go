@@ -0,0 +1,440 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/scripting"
+
+	"github.com/golang/glog"
+)
+
+// LoadScripts loads the scripts in the passed directory, replacing any scripts
+// loaded previously. If the scripts cannot be loaded, the previously loaded
+// scripts, if any, are kept.
+func (c *GameworldServer) LoadScripts(dir string) error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+
+	scripts := c.scripts
+	if scripts == nil {
+		scripts = scripting.New(&scriptWorld{server: c})
+	}
+	if err := scripts.LoadDir(dir); err != nil {
+		return err
+	}
+	c.scripts = scripts
+	return nil
+}
+
+// ReloadScripts loads the scripts from the directory they were previously
+// loaded from, so that they can be changed without restarting the server.
+func (c *GameworldServer) ReloadScripts() error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+
+	if c.scripts == nil {
+		return fmt.Errorf("no scripts loaded")
+	}
+	return c.scripts.Reload()
+}
+
+// scriptsStepIn dispatches the creature stepping onto its current tile to the
+// scripts handling any of the items on it. The world lock must be held by the
+// caller.
+func (c *GameworldServer) scriptsStepIn(cr Creature) {
+	if c.scripts == nil {
+		return
+	}
+	pos := cr.GetPos()
+	items, err := c.scriptItems(pos)
+	if err != nil {
+		glog.Errorf("listing items stepped on by creature %d: %v", cr.GetID(), err)
+		return
+	}
+	for _, item := range items {
+		handled, err := c.scripts.StepIn(cr.GetID(), item)
+		if err != nil {
+			glog.Errorf("creature %d stepping on item %d: %v", cr.GetID(), item.ServerID, err)
+		}
+		if handled || cr.GetPos() != pos {
+			return
+		}
+	}
+}
+
+// scriptItems returns the items on the tile at the passed position, as seen by
+// the scripts.
+func (c *GameworldServer) scriptItems(pos tnet.Position) ([]scripting.Item, error) {
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return nil, err
+	}
+	var items []scripting.Item
+	for idx := 0; ; idx++ {
+		item, err := t.GetItem(idx)
+		if err == ItemNotFound {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, scriptItem(item, pos, idx))
+	}
+}
+
+// scriptItem describes the map item at the passed position and stack
+// position to the scripts.
+func scriptItem(item MapItem, pos tnet.Position, stackPos int) scripting.Item {
+	si := scripting.Item{
+		ServerID: item.GetServerType(),
+		Count:    item.GetCount(),
+		Pos:      pos,
+		StackPos: stackPos,
+	}
+	if withIDs, ok := item.(gwmap.MapItemWithIDs); ok {
+		si.ActionID = withIDs.GetActionID()
+		si.UniqueID = withIDs.GetUniqueID()
+	}
+	return si
+}
+
+// playerUseItem handles the player using an item, such as pulling a lever or
+// opening a door. Doors of houses only open for players allowed to open them;
+// otherwise, the use is dispatched to the scripts.
+//
+// Only items on the map can be used for now; the inventory and containers are
+// not supported yet.
+func (c *GameworldConnection) playerUseItem(msg *tnet.Message, playerID gwmap.CreatureID) error {
+	pos, err := msg.ReadTibiaPosition()
+	if err != nil {
+		return fmt.Errorf("error reading item position: %w", err)
+	}
+	var clientID uint16
+	if err := binary.Read(msg, binary.LittleEndian, &clientID); err != nil {
+		return fmt.Errorf("error reading item id: %w", err)
+	}
+	stackPos, err := msg.ReadByte()
+	if err != nil {
+		return fmt.Errorf("error reading item stack position: %w", err)
+	}
+	if _, err := msg.ReadByte(); err != nil { // index of the container to open
+		return fmt.Errorf("error reading container index: %w", err)
+	}
+
+	if pos.X == 0xFFFF {
+		// TODO: use items in the inventory and in containers.
+		return c.statusMessage("You cannot use this object.")
+	}
+
+	player, err := c.server.mapDataSource.GetCreatureByID(playerID)
+	if err != nil {
+		return fmt.Errorf("error getting player creature by id: %w", err)
+	}
+	if p := player.GetPos(); p.Floor != pos.Floor || distance(p, pos) > 1 {
+		return c.statusMessage("Destination is out of reach.")
+	}
+
+	item, idx, err := c.tileItemByClientID(pos, int(stackPos), clientID)
+	if err != nil {
+		return err
+	}
+	if item != nil && !c.server.houseDoorAllowed(player, item, pos) {
+		return c.statusMessage("It is locked.")
+	}
+	if item == nil || c.server.scripts == nil {
+		return c.statusMessage("You cannot use this object.")
+	}
+	handled, err := c.server.scripts.Use(playerID, scriptItem(item, pos, idx))
+	if err != nil {
+		return err
+	}
+	if !handled {
+		return c.statusMessage("You cannot use this object.")
+	}
+	return nil
+}
+
+// tileItemByClientID returns the item with the passed client ID on the tile at
+// the passed position, preferring the one at the passed stack position. If
+// there is no such item, nil is returned. The index of the item on the tile is
+// returned with it.
+func (c *GameworldConnection) tileItemByClientID(pos tnet.Position, stackPos int, clientID uint16) (MapItem, int, error) {
+	if c.server.things == nil {
+		return nil, 0, fmt.Errorf("no things registry to look up item %d", clientID)
+	}
+	t, err := c.server.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return nil, 0, err
+	}
+	var found MapItem
+	foundIdx := 0
+	for idx := 0; ; idx++ {
+		item, err := t.GetItem(idx)
+		if err == ItemNotFound {
+			return found, foundIdx, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if c.server.things.Temp__GetClientIDForServerID(item.GetServerType(), c.clientVersion) != clientID {
+			continue
+		}
+		if idx == stackPos {
+			return item, idx, nil
+		}
+		if found == nil {
+			found, foundIdx = item, idx
+		}
+	}
+}
+
+// statusMessage sends the text to the player at the bottom of the game window.
+func (c *GameworldConnection) statusMessage(text string) error {
+	out := tnet.NewMessage()
+	c.textMessage(out, TextMessageStatusSmall, text)
+	c.queueMessage(out)
+	return nil
+}
+
+// scriptWorld exposes the gameworld to the scripts. Scripts are only run while
+// the world lock is held, so its methods do not take it.
+type scriptWorld struct {
+	server *GameworldServer
+}
+
+// scriptTextMessageTypes maps the kinds of text messages scripts can send to
+// how they are presented.
+var scriptTextMessageTypes = map[string]TextMessageType{
+	"info":    TextMessageInfoDescr,
+	"warning": TextMessageWarning,
+	"event":   TextMessageEventAdvance,
+	"status":  TextMessageStatusDefault,
+	"console": TextMessageConsoleBlue,
+}
+
+func (w *scriptWorld) creature(id gwmap.CreatureID) (*creature, error) {
+	cr, err := w.server.mapDataSource.GetCreatureByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("creature %d: %w", id, err)
+	}
+	crc, ok := cr.(*creature)
+	if !ok {
+		return nil, fmt.Errorf("creature %d is not managed by the gameworld", id)
+	}
+	return crc, nil
+}
+
+func (w *scriptWorld) CreatureName(id gwmap.CreatureID) (string, error) {
+	cr, err := w.creature(id)
+	if err != nil {
+		return "", err
+	}
+	return cr.GetName(), nil
+}
+
+func (w *scriptWorld) CreaturePosition(id gwmap.CreatureID) (tnet.Position, error) {
+	cr, err := w.creature(id)
+	if err != nil {
+		return tnet.Position{}, err
+	}
+	return cr.GetPos(), nil
+}
+
+func (w *scriptWorld) CreatureHealth(id gwmap.CreatureID) (int, int, error) {
+	cr, err := w.creature(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cr.health, cr.maxHealth, nil
+}
+
+func (w *scriptWorld) AddHealth(id gwmap.CreatureID, amount int) error {
+	cr, err := w.creature(id)
+	if err != nil {
+		return err
+	}
+	if amount < 0 {
+		return w.server.damageCreature(cr, -amount, MagicEffectDrawBlood)
+	}
+	w.server.healCreature(cr, amount, MagicEffectMagicBlue)
+	return nil
+}
+
+func (w *scriptWorld) Teleport(id gwmap.CreatureID, pos tnet.Position) error {
+	cr, err := w.creature(id)
+	if err != nil {
+		return err
+	}
+	return w.server.teleportCreature(cr, pos)
+}
+
+func (w *scriptWorld) Say(id gwmap.CreatureID, text string) error {
+	cr, err := w.creature(id)
+	if err != nil {
+		return err
+	}
+	return w.server.creatureSay(cr, ChatTypeSay, text)
+}
+
+func (w *scriptWorld) SendTextMessage(player gwmap.CreatureID, kind, text string) error {
+	msgType, ok := scriptTextMessageTypes[kind]
+	if !ok {
+		return fmt.Errorf("unknown message kind %q", kind)
+	}
+	gwConn, ok := w.server.connections[GameworldConnectionID(player)]
+	if !ok {
+		return fmt.Errorf("creature %d is not a connected player", player)
+	}
+	out := tnet.NewMessage()
+	gwConn.textMessage(out, msgType, text)
+	gwConn.queueMessage(out)
+	return nil
+}
+
+func (w *scriptWorld) MagicEffect(pos tnet.Position, effect string) error {
+	e, ok := MagicEffectByName(effect)
+	if !ok {
+		return fmt.Errorf("unknown magic effect %q", effect)
+	}
+	w.server.magicEffect(pos, e)
+	return nil
+}
+
+func (w *scriptWorld) TileItems(pos tnet.Position) ([]scripting.Item, error) {
+	return w.server.scriptItems(pos)
+}
+
+// itemsTile returns the tile the item passed by a script is on, making sure
+// the item is still where the script saw it.
+func (w *scriptWorld) itemsTile(item scripting.Item) (gwmap.ItemsMapTile, error) {
+	pos := item.Pos
+	t, err := w.server.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return nil, err
+	}
+	it, ok := t.(gwmap.ItemsMapTile)
+	if !ok {
+		return nil, fmt.Errorf("items on tile %v cannot be changed", pos)
+	}
+	mi, err := t.GetItem(item.StackPos)
+	if err == ItemNotFound || (err == nil && mi.GetServerType() != item.ServerID) {
+		return nil, fmt.Errorf("item %d not found at %v, stack position %d", item.ServerID, pos, item.StackPos)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// scriptItemAt describes the item at the passed index of the tile to the
+// scripts.
+func (w *scriptWorld) scriptItemAt(t MapTile, pos tnet.Position, idx int) (scripting.Item, error) {
+	mi, err := t.GetItem(idx)
+	if err != nil {
+		return scripting.Item{}, err
+	}
+	return scriptItem(mi, pos, idx), nil
+}
+
+func (w *scriptWorld) TransformItem(item scripting.Item, serverID uint16) (scripting.Item, error) {
+	t, err := w.itemsTile(item)
+	if err != nil {
+		return scripting.Item{}, err
+	}
+	idx, err := t.TransformItem(item.StackPos, serverID)
+	if err != nil {
+		return scripting.Item{}, err
+	}
+	if idx == item.StackPos {
+		err = w.server.itemChanged(item.Pos, idx)
+	} else {
+		w.server.itemDisappear(item.Pos, item.StackPos)
+		err = w.server.itemAppear(item.Pos, idx)
+	}
+	if err != nil {
+		return scripting.Item{}, err
+	}
+	return w.scriptItemAt(t, item.Pos, idx)
+}
+
+func (w *scriptWorld) CreateItem(pos tnet.Position, serverID, count uint16) (scripting.Item, error) {
+	t, err := w.server.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return scripting.Item{}, err
+	}
+	it, ok := t.(gwmap.ItemsMapTile)
+	if !ok {
+		return scripting.Item{}, fmt.Errorf("items on tile %v cannot be changed", pos)
+	}
+	idx, err := it.AddItem(serverID, count)
+	if err != nil {
+		return scripting.Item{}, err
+	}
+	if err := w.server.itemAppear(pos, idx); err != nil {
+		return scripting.Item{}, err
+	}
+	return w.scriptItemAt(t, pos, idx)
+}
+
+func (w *scriptWorld) RemoveItem(item scripting.Item) error {
+	t, err := w.itemsTile(item)
+	if err != nil {
+		return err
+	}
+	if err := t.RemoveItem(item.StackPos); err != nil {
+		return err
+	}
+	w.server.itemDisappear(item.Pos, item.StackPos)
+	return nil
+}
+
+func (w *scriptWorld) SetItemActionID(item scripting.Item, actionID uint16) (scripting.Item, error) {
+	t, err := w.itemsTile(item)
+	if err != nil {
+		return scripting.Item{}, err
+	}
+	if err := t.SetItemActionID(item.StackPos, actionID); err != nil {
+		return scripting.Item{}, err
+	}
+	// Clients do not know about action IDs, so spectators are not informed.
+	return w.scriptItemAt(t, item.Pos, item.StackPos)
+}
+
+func (w *scriptWorld) TileCreatures(pos tnet.Position) ([]gwmap.CreatureID, error) {
+	t, err := w.server.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return nil, err
+	}
+	var ids []gwmap.CreatureID
+	for idx := 0; ; idx++ {
+		cr, err := t.GetCreature(idx)
+		if err == CreatureNotFound {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, cr.GetID())
+	}
+}
+
+func (w *scriptWorld) TileWalkable(pos tnet.Position) (bool, error) {
+	return w.server.tileWalkable(pos), nil
+}
+
+func (w *scriptWorld) Towns() []Town {
+	if landmarks, ok := w.server.mapDataSource.(gwmap.LandmarkMapDataSource); ok {
+		return landmarks.Towns()
+	}
+	return nil
+}
+
+func (w *scriptWorld) Waypoints() []Waypoint {
+	if landmarks, ok := w.server.mapDataSource.(gwmap.LandmarkMapDataSource); ok {
+		return landmarks.Waypoints()
+	}
+	return nil
+}
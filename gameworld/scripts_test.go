@@ -0,0 +1,191 @@
+package gameworld
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+const testScript = `
+def lever(player, item):
+    game.send_message(player, "You pulled lever %d." % item.id)
+    game.teleport(player, position(600, 600, 7))
+
+def thorns(creature, item):
+    game.add_health(creature, -10)
+
+def where(player, words, param):
+    p = game.creature_position(player)
+    game.send_message(player, "%d %d %d" % (p.x, p.y, p.z), kind = "console")
+
+on_use(lever, item_id = 2379)
+on_step_in(thorns, item_id = 2379)
+on_say("!where", where)
+`
+
+func TestScripts(t *testing.T) {
+	// A map covered entirely by a single kind of item, which is the only item
+	// known to the things registry.
+	ds := NewMapDataSource().(*mapDataSource)
+	ds.mapTileGenerator = func(x, y uint16, z uint8) (MapTile, error) {
+		if z != 7 {
+			return &mapTile{}, nil
+		}
+		return &mapTile{ground: mapItemOfType(2379)}, nil
+	}
+	s := newScenario(t, ds)
+	gws := s.gws
+	th, _ := things.New()
+	th.AddItemsOTB(&itemsotb.Items{
+		Items: []itemsotb.Item{{
+			Attributes: map[itemsotb.ItemsAttribute]interface{}{
+				itemsotb.ITEM_ATTR_SERVERID: uint16(2379),
+				itemsotb.ITEM_ATTR_CLIENTID: uint16(3291),
+			},
+		}},
+		ServerIDToArrayIndex: map[uint16]int{2379: 0},
+		ClientIDToArrayIndex: map[uint16]int{3291: 0},
+	})
+	gws.SetThings(th)
+
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.star"), []byte(testScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gws.LoadScripts(dir); err != nil {
+		t.Fatalf("loading scripts: %v", err)
+	}
+
+	player := &creature{
+		pos:       tnet.Position{X: 500, Y: 500, Floor: 7},
+		look:      128,
+		name:      "Alice",
+		health:    playerMaxHealth,
+		maxHealth: playerMaxHealth,
+	}
+	conn := s.addPlayer(t, player)
+
+	// Stepping onto the thorns hurts.
+	if err := conn.playerMoveNorth(); err != nil {
+		t.Fatalf("moving north: %v", err)
+	}
+	if player.health != playerMaxHealth-10 {
+		t.Errorf("player has %d health after stepping on thorns, want %d", player.health, playerMaxHealth-10)
+	}
+	drainOpcodes(conn)
+
+	// Using the item next to the player teleports them.
+	use := tnet.NewMessage()
+	use.WriteTibiaPosition(tnet.Position{X: 501, Y: 499, Floor: 7})
+	binary.Write(use, binary.LittleEndian, uint16(3291))
+	use.Write([]byte{0, 0})
+	if quit, err := gws.handleMessage(conn, player.GetID(), 0x82, use); quit || err != nil {
+		t.Fatalf("using item: quit %v, error %v", quit, err)
+	}
+	if want := (tnet.Position{X: 600, Y: 600, Floor: 7}); player.GetPos() != want {
+		t.Errorf("player at %v after using lever, want %v", player.GetPos(), want)
+	}
+	opcodes, texts := drainOpcodes(conn)
+	if len(texts) != 1 || texts[0] != "You pulled lever 2379." {
+		t.Errorf("player told %q after using lever, want a single message about the lever", texts)
+	}
+	if len(opcodes) == 0 || opcodes[len(opcodes)-1] != 0x64 {
+		t.Errorf("player sent %x after teleporting, want a full map description last", opcodes)
+	}
+
+	// Items out of reach cannot be used.
+	use = tnet.NewMessage()
+	use.WriteTibiaPosition(tnet.Position{X: 500, Y: 500, Floor: 7})
+	binary.Write(use, binary.LittleEndian, uint16(3291))
+	use.Write([]byte{0, 0})
+	gws.handleMessage(conn, player.GetID(), 0x82, use)
+	if _, texts := drainOpcodes(conn); len(texts) != 1 || texts[0] != "Destination is out of reach." {
+		t.Errorf("player told %q after using a faraway item, want to be told it is out of reach", texts)
+	}
+
+	// Scripted words are not spoken.
+	say := tnet.NewMessage()
+	say.Write([]byte{byte(ChatTypeSay)})
+	say.WriteTibiaString("!where")
+	if err := conn.playerSay(say, player.GetID()); err != nil {
+		t.Fatalf("saying !where: %v", err)
+	}
+	if opcodes, texts := drainOpcodes(conn); len(opcodes) != 1 || len(texts) != 1 || texts[0] != "600 600 7" {
+		t.Errorf("player sent %x with texts %q after saying !where, want only the position", opcodes, texts)
+	}
+}
+
+func TestScriptTransformItem(t *testing.T) {
+	lever := tnet.Position{X: 501, Y: 499, Floor: 7}
+	ds := NewMapDataSource().(*mapDataSource)
+	ds.mapTileGenerator = func(x, y uint16, z uint8) (MapTile, error) {
+		if x == lever.X && y == lever.Y && z == lever.Floor {
+			return &mapTile{ground: mapItemOfType(2379), items: []MapItem{mapItemOfType(1945)}}, nil
+		}
+		return &mapTile{ground: mapItemOfType(2379)}, nil
+	}
+	s := newScenario(t, ds)
+	gws := s.gws
+	th, _ := things.New()
+	otb := &itemsotb.Items{
+		ServerIDToArrayIndex: map[uint16]int{},
+		ClientIDToArrayIndex: map[uint16]int{},
+	}
+	for idx, ids := range [][2]uint16{{2379, 3291}, {1945, 2772}, {1946, 2773}} {
+		otb.Items = append(otb.Items, itemsotb.Item{
+			Attributes: map[itemsotb.ItemsAttribute]interface{}{
+				itemsotb.ITEM_ATTR_SERVERID: ids[0],
+				itemsotb.ITEM_ATTR_CLIENTID: ids[1],
+			},
+		})
+		otb.ServerIDToArrayIndex[ids[0]] = idx
+		otb.ClientIDToArrayIndex[ids[1]] = idx
+	}
+	th.AddItemsOTB(otb)
+	gws.SetThings(th)
+
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := "on_use(lambda player, item: game.transform_item(item, 1946), item_id = 1945)"
+	if err := ioutil.WriteFile(filepath.Join(dir, "lever.star"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gws.LoadScripts(dir); err != nil {
+		t.Fatalf("loading scripts: %v", err)
+	}
+
+	player := &creature{
+		pos:  tnet.Position{X: 500, Y: 500, Floor: 7},
+		look: 128,
+	}
+	conn := s.addPlayer(t, player)
+
+	use := tnet.NewMessage()
+	use.WriteTibiaPosition(lever)
+	binary.Write(use, binary.LittleEndian, uint16(2772))
+	use.Write([]byte{1, 0})
+	if quit, err := gws.handleMessage(conn, player.GetID(), 0x82, use); quit || err != nil {
+		t.Fatalf("using lever: quit %v, error %v", quit, err)
+	}
+
+	tile, _ := gws.mapDataSource.GetMapTile(lever.X, lever.Y, lever.Floor)
+	if item, err := tile.GetItem(1); err != nil || item.GetServerType() != 1946 {
+		t.Errorf("lever tile has item %v (error %v) after using lever, want 1946", item, err)
+	}
+	if opcodes, texts := drainOpcodes(conn); len(opcodes) != 1 || opcodes[0] != 0x6B {
+		t.Errorf("player sent %x with texts %q after using lever, want only the lever transforming", opcodes, texts)
+	}
+}
@@ -0,0 +1,246 @@
+package gameworld
+
+import (
+	"math/rand"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/things"
+	"badc0de.net/pkg/go-tibia/xmls"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// defaultSpawnLook is the server-side look type of spawned creatures,
+	// used when the creature's definition does not determine their look.
+	defaultSpawnLook = 128
+
+	// spawnRetryInterval is how long to wait before retrying to place a
+	// creature whose spawn position is occupied.
+	spawnRetryInterval = 5 * time.Second
+
+	// Spawned creatures wander around every wanderIntervalMin to
+	// wanderIntervalMin + wanderIntervalJitter.
+	wanderIntervalMin    = 2 * time.Second
+	wanderIntervalJitter = 3 * time.Second
+)
+
+// spawnedCreature is a single creature placed by a spawn. It keeps track of
+// the creature currently on the map, so that a new one can be placed after it
+// disappears.
+type spawnedCreature struct {
+	center tnet.Position // Center of the spawn.
+	radius int           // Radius of the spawn; the creature will not wander outside it.
+
+	name            string
+	kind            CreatureType
+	pos             tnet.Position // Where the creature is placed.
+	dir             things.CreatureDirection
+	respawnInterval time.Duration
+
+	id           CreatureID    // Currently placed creature, or zero if none is placed.
+	respawnAt    time.Time     // When to place the creature, if none is placed.
+	nextWanderAt time.Time     // When to next take a step, if a creature is placed.
+	walkInterval time.Duration // Fixed interval between steps; if zero, a random interval is used.
+	stationary   bool          // Whether the placed creature does not wander at all.
+}
+
+// AddSpawns registers all creatures from the passed spawns. They are placed on
+// the map by the world (see StartWorld), and are placed again once they
+// disappear and their spawn time passes.
+func (c *GameworldServer) AddSpawns(spawns xmls.Spawns) error {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+
+	for _, spawn := range spawns.Spawn {
+		center := tnet.Position{X: uint16(spawn.CenterX), Y: uint16(spawn.CenterY), Floor: uint8(spawn.CenterZ)}
+		add := func(sc xmls.SpawnCreature, kind CreatureType) {
+			c.spawnedCreatures = append(c.spawnedCreatures, &spawnedCreature{
+				center: center,
+				radius: spawn.Radius,
+
+				name: sc.Name,
+				kind: kind,
+				pos: tnet.Position{
+					X:     uint16(spawn.CenterX + sc.X),
+					Y:     uint16(spawn.CenterY + sc.Y),
+					Floor: uint8(sc.Z),
+				},
+				dir:             things.CreatureDirection(sc.Direction),
+				respawnInterval: sc.RespawnInterval(),
+			})
+		}
+		for _, m := range spawn.Monster {
+			add(m, CreatureTypeMonster)
+		}
+		for _, n := range spawn.NPC {
+			add(n, CreatureTypeNPC)
+		}
+	}
+	glog.Infof("registered %d spawned creatures", len(c.spawnedCreatures))
+	return nil
+}
+
+// spawnTick places creatures whose spawn time has passed, and has the placed
+// creatures wander around within their spawn.
+func (c *GameworldServer) spawnTick(now time.Time) error {
+	for _, sc := range c.spawnedCreatures {
+		if sc.id != 0 {
+			if _, err := c.mapDataSource.GetCreatureByID(sc.id); err == CreatureNotFound {
+				glog.V(2).Infof("spawned creature %q (%d) disappeared; placing again in %v", sc.name, sc.id, sc.respawnInterval)
+				sc.id = 0
+				sc.respawnAt = now.Add(sc.respawnInterval)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if sc.id == 0 {
+			if now.Before(sc.respawnAt) {
+				continue
+			}
+			if err := c.placeSpawnedCreature(sc, now); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if sc.stationary || c.monsterHasTarget(sc.id) || c.npcHasFocus(sc.id) {
+			continue
+		}
+		if !now.Before(sc.nextWanderAt) {
+			if err := c.wanderSpawnedCreature(sc, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// placeSpawnedCreature places a new creature at the spawn position of the
+// passed spawned creature, if the position is free.
+func (c *GameworldServer) placeSpawnedCreature(sc *spawnedCreature, now time.Time) error {
+	if !c.tileWalkable(sc.pos) {
+		glog.V(2).Infof("cannot place spawned creature %q at %v; retrying later", sc.name, sc.pos)
+		sc.respawnAt = now.Add(spawnRetryInterval)
+		return nil
+	}
+
+	cr := &creature{
+		pos:  sc.pos,
+		id:   NewCreatureID(sc.kind),
+		dir:  sc.dir,
+		look: defaultSpawnLook,
+		name: sc.name,
+	}
+	var def *xmls.Monster
+	if sc.kind == CreatureTypeMonster {
+		if def = c.monsters.ByName(sc.name); def != nil {
+			cr.name = def.MonsterName
+			if def.Look.Type != 0 {
+				cr.look = uint16(def.Look.Type)
+				cr.col = def.Look.OutfitColors()
+			}
+			cr.health, cr.maxHealth = def.Health.Now, def.Health.Max
+			if cr.health <= 0 {
+				cr.health = cr.maxHealth
+			}
+			cr.speed = uint16(def.Speed)
+		} else if c.monsters != nil {
+			glog.Warningf("spawned monster %q has no definition", sc.name)
+		}
+	}
+	var npcDef *xmls.NPC
+	if sc.kind == CreatureTypeNPC {
+		if npcDef = c.npcs.ByName(sc.name); npcDef != nil {
+			cr.name = npcDef.NPCName
+			if npcDef.Look.Type != 0 {
+				cr.look = uint16(npcDef.Look.Type)
+				cr.col = npcDef.Look.OutfitColors()
+			}
+			cr.health, cr.maxHealth = npcDef.Health.Now, npcDef.Health.Max
+			sc.walkInterval = time.Duration(npcDef.WalkInterval) * time.Millisecond
+			sc.stationary = npcDef.WalkInterval <= 0
+		} else if c.npcs != nil {
+			glog.Warningf("spawned npc %q has no definition", sc.name)
+		}
+	}
+	if err := c.mapDataSource.AddCreature(cr); err != nil {
+		return err
+	}
+	sc.id = cr.id
+	if def != nil {
+		c.addMonsterBrain(cr, def, now)
+	}
+	if npcDef != nil {
+		c.addNPCBrain(cr, npcDef)
+	}
+	sc.nextWanderAt = now.Add(sc.wanderInterval())
+	glog.V(2).Infof("placed spawned creature %q (%d) at %v", sc.name, sc.id, sc.pos)
+
+	return c.creatureAppear(cr, nil)
+}
+
+// wanderSpawnedCreature has the placed creature take a single step in a random
+// direction, as long as the step keeps it within its spawn.
+func (c *GameworldServer) wanderSpawnedCreature(sc *spawnedCreature, now time.Time) error {
+	sc.nextWanderAt = now.Add(sc.wanderInterval())
+
+	cr, err := c.mapDataSource.GetCreatureByID(sc.id)
+	if err != nil {
+		return err
+	}
+
+	dir := things.CreatureDirection(rand.Intn(4))
+	newP := stepInDirection(cr.GetPos(), dir)
+	// Creatures that left their spawn, e.g. while chasing a target, are only
+	// allowed to step back towards it.
+	if !sc.withinRadius(newP) && manhattan(newP, sc.center) >= manhattan(cr.GetPos(), sc.center) {
+		return nil
+	}
+	if !c.tileWalkable(newP) || c.stepMovesElsewhere(newP) {
+		return nil
+	}
+
+	if err := c.moveCreature(cr, newP); err != nil {
+		return err
+	}
+	return cr.SetDir(dir)
+}
+
+// withinRadius returns whether the passed position is within the spawn of the
+// spawned creature.
+func (sc *spawnedCreature) withinRadius(pos tnet.Position) bool {
+	if pos.Floor != sc.center.Floor {
+		return false
+	}
+	dx := int(pos.X) - int(sc.center.X)
+	dy := int(pos.Y) - int(sc.center.Y)
+	return dx >= -sc.radius && dx <= sc.radius && dy >= -sc.radius && dy <= sc.radius
+}
+
+// wanderInterval returns the delay until the wandering creature takes its next
+// step; unless the creature walks at a fixed interval, the delay is random.
+func (sc *spawnedCreature) wanderInterval() time.Duration {
+	if sc.walkInterval > 0 {
+		return sc.walkInterval
+	}
+	return wanderIntervalMin + time.Duration(rand.Int63n(int64(wanderIntervalJitter)))
+}
+
+// stepInDirection returns the position one tile away from the passed position
+// in the passed direction.
+func stepInDirection(pos tnet.Position, dir things.CreatureDirection) tnet.Position {
+	switch dir {
+	case things.CreatureDirectionNorth:
+		pos.Y--
+	case things.CreatureDirectionEast:
+		pos.X++
+	case things.CreatureDirectionSouth:
+		pos.Y++
+	case things.CreatureDirectionWest:
+		pos.X--
+	}
+	return pos
+}
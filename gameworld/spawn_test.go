@@ -0,0 +1,125 @@
+package gameworld
+
+import (
+	"testing"
+	"time"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+func TestSpawnPlacesWandersAndRespawns(t *testing.T) {
+	gws := &GameworldServer{
+		connections: map[GameworldConnectionID]*GameworldConnection{},
+	}
+	gws.SetMapDataSource(NewMapDataSource())
+
+	err := gws.AddSpawns(xmls.Spawns{
+		Spawn: []xmls.Spawn{{
+			CenterX: 200, CenterY: 200, CenterZ: 7, Radius: 2,
+			Monster: []xmls.SpawnCreature{{Name: "Rat", X: 1, Y: 0, Z: 7, SpawnTime: 10}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("adding spawns: %v", err)
+	}
+	sc := gws.spawnedCreatures[0]
+
+	now := time.Now()
+	if err := gws.worldTick(now); err != nil {
+		t.Fatalf("world tick: %v", err)
+	}
+	if sc.id == 0 {
+		t.Fatalf("creature was not placed")
+	}
+	if CreatureType(sc.id)&CreatureTypeMonster == 0 {
+		t.Errorf("placed creature %08x is not a monster", sc.id)
+	}
+	cr, err := gws.mapDataSource.GetCreatureByID(sc.id)
+	if err != nil {
+		t.Fatalf("placed creature not found: %v", err)
+	}
+	if want := (tnet.Position{X: 201, Y: 200, Floor: 7}); cr.GetPos() != want {
+		t.Errorf("creature placed at %v, want %v", cr.GetPos(), want)
+	}
+	if cr.GetName() != "Rat" {
+		t.Errorf("creature name %q, want %q", cr.GetName(), "Rat")
+	}
+
+	moved := false
+	for i := 0; i < 200; i++ {
+		now = now.Add(time.Second)
+		if err := gws.worldTick(now); err != nil {
+			t.Fatalf("world tick: %v", err)
+		}
+		if !sc.withinRadius(cr.GetPos()) {
+			t.Fatalf("creature wandered outside of spawn to %v", cr.GetPos())
+		}
+		if cr.GetPos() != (tnet.Position{X: 201, Y: 200, Floor: 7}) {
+			moved = true
+		}
+	}
+	if !moved {
+		t.Errorf("creature never wandered away from its spawn position")
+	}
+
+	// Make the creature disappear, and make sure it gets placed again only
+	// after the spawn time passes.
+	oldID := sc.id
+	if err := gws.mapDataSource.RemoveCreatureByID(oldID); err != nil {
+		t.Fatalf("removing creature: %v", err)
+	}
+	if err := gws.worldTick(now); err != nil {
+		t.Fatalf("world tick: %v", err)
+	}
+	if sc.id != 0 {
+		t.Fatalf("creature placed again immediately")
+	}
+	if err := gws.worldTick(now.Add(5 * time.Second)); err != nil {
+		t.Fatalf("world tick: %v", err)
+	}
+	if sc.id != 0 {
+		t.Fatalf("creature placed again before spawn time passed")
+	}
+	if err := gws.worldTick(now.Add(10 * time.Second)); err != nil {
+		t.Fatalf("world tick: %v", err)
+	}
+	if sc.id == 0 || sc.id == oldID {
+		t.Fatalf("creature not placed again after spawn time passed (id %08x, old id %08x)", sc.id, oldID)
+	}
+}
+
+func TestSpawnUsesMonsterDefinition(t *testing.T) {
+	gws := &GameworldServer{
+		connections: map[GameworldConnectionID]*GameworldConnection{},
+	}
+	gws.SetMapDataSource(NewMapDataSource())
+
+	monsters := &xmls.Monsters{}
+	monsters.Add(&xmls.Monster{MonsterName: "Rat", Look: xmls.MonsterLook{Type: 21, Body: 3}})
+	gws.SetMonsters(monsters)
+
+	gws.AddSpawns(xmls.Spawns{
+		Spawn: []xmls.Spawn{{
+			CenterX: 300, CenterY: 300, CenterZ: 7, Radius: 1,
+			Monster: []xmls.SpawnCreature{{Name: "rat", Z: 7}},
+		}},
+	})
+	if err := gws.worldTick(time.Now()); err != nil {
+		t.Fatalf("world tick: %v", err)
+	}
+
+	cr, err := gws.mapDataSource.GetCreatureByID(gws.spawnedCreatures[0].id)
+	if err != nil {
+		t.Fatalf("placed creature not found: %v", err)
+	}
+	if cr.GetName() != "Rat" {
+		t.Errorf("creature name %q, want %q", cr.GetName(), "Rat")
+	}
+	if cr.GetServerType() != 21 {
+		t.Errorf("creature look %d, want %d", cr.GetServerType(), 21)
+	}
+	if cr.GetOutfitColors()[1] != 3 {
+		t.Errorf("creature body color %d, want %d", cr.GetOutfitColors()[1], 3)
+	}
+}
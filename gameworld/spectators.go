@@ -0,0 +1,321 @@
+package gameworld
+
+import (
+	"encoding/binary"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+
+	"github.com/golang/glog"
+)
+
+// canSee returns whether the passed position is within the viewport of the
+// player on this connection.
+func (c *GameworldConnection) canSee(pos tnet.Position) bool {
+	playerID, err := c.PlayerID()
+	if err != nil {
+		return false
+	}
+	player, err := c.server.mapDataSource.GetCreatureByID(playerID)
+	if err != nil {
+		return false
+	}
+	return c.canSeeFrom(player.GetPos(), pos)
+}
+
+// canSeeFrom returns whether a player standing at from would have pos within
+// their viewport.
+//
+// Floors above the ground level are visible only while above the ground, and
+// underground floors are visible only up to two floors away. The visible area
+// is offset by one tile per floor of difference, the same way the map
+// description is.
+func (c *GameworldConnection) canSeeFrom(from, pos tnet.Position) bool {
+	if int8(from.Floor) <= c.floorGroundLevel() {
+		if int8(pos.Floor) > c.floorGroundLevel() {
+			return false
+		}
+	} else {
+		if dz := int(from.Floor) - int(pos.Floor); dz > 2 || dz < -2 {
+			return false
+		}
+	}
+
+	offsetZ := int(from.Floor) - int(pos.Floor)
+	minX := int(from.X) - int(c.viewportSizeW()/2-1) + offsetZ
+	maxX := int(from.X) + int(c.viewportSizeW()/2) + offsetZ
+	minY := int(from.Y) - int(c.viewportSizeH()/2-1) + offsetZ
+	maxY := int(from.Y) + int(c.viewportSizeH()/2) + offsetZ
+
+	return int(pos.X) >= minX && int(pos.X) <= maxX && int(pos.Y) >= minY && int(pos.Y) <= maxY
+}
+
+// spectators returns all connections whose players can see at least one of
+// the passed positions.
+func (c *GameworldServer) spectators(positions ...tnet.Position) []*GameworldConnection {
+	var out []*GameworldConnection
+	for _, gwConn := range c.connections {
+		for _, pos := range positions {
+			if gwConn.canSee(pos) {
+				out = append(out, gwConn)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// queueMessage queues the message to be sent to the client on this connection.
+//
+// Messages are never dropped, as the client would no longer know what the
+// world looks like. If the connection is not (or no longer) able to keep up
+// with sending the messages, the client is disconnected instead of blocking
+// the caller, which may be holding the world lock.
+func (c *GameworldConnection) queueMessage(out *tnet.Message) {
+	if c.senderChan == nil || c.disconnecting {
+		return
+	}
+	select {
+	case c.senderChan <- out:
+	default:
+		glog.Warningf("connection %d: sender queue full; disconnecting", c.id)
+		c.disconnect()
+	}
+}
+
+// disconnect closes the connection to the client, and makes the main loop of
+// the connection quit, so that the player disappears. The world lock must be
+// held by the caller.
+func (c *GameworldConnection) disconnect() {
+	if c.disconnecting {
+		return
+	}
+	c.disconnecting = true
+	if c.mainLoopQuit != nil {
+		close(c.mainLoopQuit)
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// creatureStackPos returns the stack position of the creature on the passed
+// tile, as seen by the client: all items are counted first, followed by the
+// creatures.
+func creatureStackPos(t MapTile, id CreatureID) (int, error) {
+	// first, count items.
+	var itemCount int
+	for itemCount = 0; ; itemCount++ {
+		// TODO(ivucica): this loop is really silly; expose item count in tile interface
+		_, err := t.GetItem(itemCount)
+		if err == ItemNotFound {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	// now, find the creature.
+	for i := 0; ; i++ {
+		// TODO(ivucica): allow fetching item stackindex using tile interface
+		c, err := t.GetCreature(i)
+		if err != nil {
+			return 0, err
+		}
+		if c.GetID() == id {
+			return i + itemCount, nil
+		}
+	}
+}
+
+// creatureAppear informs all spectators, except for the passed connection,
+// that the creature has appeared at its current position.
+func (c *GameworldServer) creatureAppear(cr Creature, except *GameworldConnection) error {
+	pos := cr.GetPos()
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return err
+	}
+	stackPos, err := creatureStackPos(t, cr.GetID())
+	if err != nil {
+		return err
+	}
+
+	for _, gwConn := range c.spectators(pos) {
+		if gwConn == except {
+			continue
+		}
+		out := tnet.NewMessage()
+		out.Write([]byte{0x6A})
+		out.WriteTibiaPosition(pos)
+		out.Write([]byte{byte(stackPos)})
+		if err := gwConn.creatureDescription(out, cr); err != nil {
+			glog.Errorf("creature %d appearing to connection %d: %v", cr.GetID(), gwConn.id, err)
+			continue
+		}
+		gwConn.queueMessage(out)
+	}
+	return nil
+}
+
+// creatureDisappear informs all spectators, except for the passed connection,
+// that the creature has disappeared from the passed position, where it used to
+// be at the passed stack position.
+func (c *GameworldServer) creatureDisappear(cr Creature, pos tnet.Position, stackPos int, except *GameworldConnection) {
+	for _, gwConn := range c.spectators(pos) {
+		if gwConn == except {
+			continue
+		}
+		out := tnet.NewMessage()
+		out.Write([]byte{0x6C})
+		out.WriteTibiaPosition(pos)
+		out.Write([]byte{byte(stackPos)})
+		gwConn.queueMessage(out)
+	}
+}
+
+// creatureMoved informs all spectators, except for the passed connection, that
+// the creature has moved from the passed old position (where it was at the
+// passed stack position) to its current position.
+//
+// Spectators that can see both positions are told about the move. Spectators
+// that can see only one of the positions are told about the creature
+// disappearing or appearing, respectively.
+func (c *GameworldServer) creatureMoved(cr Creature, oldPos tnet.Position, oldStackPos int, except *GameworldConnection) error {
+	newPos := cr.GetPos()
+	for _, gwConn := range c.spectators(oldPos, newPos) {
+		if gwConn == except {
+			continue
+		}
+		seesOld, seesNew := gwConn.canSee(oldPos), gwConn.canSee(newPos)
+
+		out := tnet.NewMessage()
+		switch {
+		case seesOld && seesNew:
+			out.Write([]byte{0x6D})
+			if err := binary.Write(out, binary.LittleEndian, oldPos); err != nil {
+				return err
+			}
+			out.Write([]byte{byte(oldStackPos)})
+			if err := binary.Write(out, binary.LittleEndian, newPos); err != nil {
+				return err
+			}
+		case seesOld:
+			out.Write([]byte{0x6C})
+			out.WriteTibiaPosition(oldPos)
+			out.Write([]byte{byte(oldStackPos)})
+		case seesNew:
+			t, err := c.mapDataSource.GetMapTile(newPos.X, newPos.Y, newPos.Floor)
+			if err != nil {
+				return err
+			}
+			newStackPos, err := creatureStackPos(t, cr.GetID())
+			if err != nil {
+				return err
+			}
+			out.Write([]byte{0x6A})
+			out.WriteTibiaPosition(newPos)
+			out.Write([]byte{byte(newStackPos)})
+			if err := gwConn.creatureDescription(out, cr); err != nil {
+				glog.Errorf("creature %d appearing to connection %d: %v", cr.GetID(), gwConn.id, err)
+				continue
+			}
+		}
+		gwConn.queueMessage(out)
+	}
+	return nil
+}
+
+// creatureTurned informs all spectators that the creature is now facing its
+// current direction.
+func (c *GameworldServer) creatureTurned(cr Creature) error {
+	pos := cr.GetPos()
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return err
+	}
+	stackPos, err := creatureStackPos(t, cr.GetID())
+	if err != nil {
+		return err
+	}
+
+	for _, gwConn := range c.spectators(pos) {
+		out := tnet.NewMessage()
+		out.Write([]byte{0x6B})
+		out.WriteTibiaPosition(pos)
+		out.Write([]byte{byte(stackPos)})
+		out.Write([]byte{0x63, 0x00}) // creature, as opposed to an item
+		if err := binary.Write(out, binary.LittleEndian, cr.GetID()); err != nil {
+			return err
+		}
+		out.Write([]byte{byte(cr.GetDir())})
+		gwConn.queueMessage(out)
+	}
+	return nil
+}
+
+// itemAppear informs all spectators that the item at the passed stack
+// position of the tile at the passed position has appeared.
+func (c *GameworldServer) itemAppear(pos tnet.Position, stackPos int) error {
+	return c.itemUpdate(0x6A, pos, stackPos)
+}
+
+// itemChanged informs all spectators that the item at the passed stack
+// position of the tile at the passed position has been replaced in place.
+func (c *GameworldServer) itemChanged(pos tnet.Position, stackPos int) error {
+	return c.itemUpdate(0x6B, pos, stackPos)
+}
+
+// itemDisappear informs all spectators that the item at the passed stack
+// position of the tile at the passed position has disappeared.
+func (c *GameworldServer) itemDisappear(pos tnet.Position, stackPos int) {
+	for _, gwConn := range c.spectators(pos) {
+		out := tnet.NewMessage()
+		out.Write([]byte{0x6C})
+		out.WriteTibiaPosition(pos)
+		out.Write([]byte{byte(stackPos)})
+		gwConn.queueMessage(out)
+	}
+}
+
+// itemUpdate sends the item at the passed stack position of the tile at the
+// passed position to all spectators, as the passed tile update.
+func (c *GameworldServer) itemUpdate(opcode byte, pos tnet.Position, stackPos int) error {
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return err
+	}
+	item, err := t.GetItem(stackPos)
+	if err != nil {
+		return err
+	}
+	for _, gwConn := range c.spectators(pos) {
+		out := tnet.NewMessage()
+		out.Write([]byte{opcode})
+		out.WriteTibiaPosition(pos)
+		out.Write([]byte{byte(stackPos)})
+		if err := gwConn.itemDescription(out, item); err != nil {
+			glog.Errorf("item %d at %v to connection %d: %v", item.GetServerType(), pos, gwConn.id, err)
+			continue
+		}
+		gwConn.queueMessage(out)
+	}
+	return nil
+}
+
+// creatureOutfitChanged informs all spectators that the creature now wears
+// its current outfit.
+func (c *GameworldServer) creatureOutfitChanged(cr Creature) {
+	for _, gwConn := range c.spectators(cr.GetPos()) {
+		out := tnet.NewMessage()
+		out.Write([]byte{0x8E})
+		if err := binary.Write(out, binary.LittleEndian, cr.GetID()); err != nil {
+			glog.Errorf("creature outfit: %v", err)
+			return
+		}
+		if err := gwConn.creatureOutfit(out, cr); err != nil {
+			glog.Errorf("creature %d outfit to connection %d: %v", cr.GetID(), gwConn.id, err)
+			continue
+		}
+		gwConn.queueMessage(out)
+	}
+}
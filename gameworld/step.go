@@ -0,0 +1,274 @@
+package gameworld
+
+import (
+	"fmt"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+
+	"github.com/golang/glog"
+)
+
+// StepHandler handles a creature stepping onto or off a tile containing an
+// item with the action ID the handler is registered for. The item and its
+// position are passed.
+//
+// Handlers are called with the world lock held.
+type StepHandler func(cr Creature, item MapItem, pos tnet.Position) error
+
+// HandleStepIn registers the handler to be called when a creature steps onto
+// a tile containing an item with the passed action ID. It replaces any handler
+// previously registered for the action ID.
+func (c *GameworldServer) HandleStepIn(actionID uint16, h StepHandler) {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	if c.stepInHandlers == nil {
+		c.stepInHandlers = map[uint16]StepHandler{}
+	}
+	c.stepInHandlers[actionID] = h
+}
+
+// HandleStepOut registers the handler to be called when a creature steps off
+// a tile containing an item with the passed action ID. It replaces any handler
+// previously registered for the action ID.
+func (c *GameworldServer) HandleStepOut(actionID uint16, h StepHandler) {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	if c.stepOutHandlers == nil {
+		c.stepOutHandlers = map[uint16]StepHandler{}
+	}
+	c.stepOutHandlers[actionID] = h
+}
+
+// floorChangeFlags are the item flags which make creatures stepping onto an
+// item go up a floor, in the direction given by the flag.
+const floorChangeFlags = itemsotb.FLAG_FLOORCHANGENORTH | itemsotb.FLAG_FLOORCHANGEEAST | itemsotb.FLAG_FLOORCHANGESOUTH | itemsotb.FLAG_FLOORCHANGEWEST
+
+// creatureSteppedIn processes the creature having stepped onto its current
+// tile:
+//
+//   - teleport items move it to their destination,
+//   - floor-changing items, such as stairs and holes, move it up or down,
+//   - players stepping next to their depot are told about its contents,
+//   - handlers registered for action IDs of items on the tile are called,
+//   - and finally, the step is dispatched to the scripts.
+//
+// Once the creature has been moved elsewhere, no further processing is done.
+func (c *GameworldServer) creatureSteppedIn(cr Creature) error {
+	pos := cr.GetPos()
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return err
+	}
+
+	if dest, ok := c.teleportDestination(t); ok {
+		c.magicEffect(pos, MagicEffectTeleport)
+		if err := c.teleportCreature(cr, dest); err != nil {
+			return err
+		}
+		c.magicEffect(dest, MagicEffectTeleport)
+		return nil
+	}
+
+	if dest, ok := c.floorChangeDestination(pos); ok {
+		return c.teleportCreature(cr, dest)
+	}
+
+	if gwConn, ok := c.connections[GameworldConnectionID(cr.GetID())]; ok {
+		c.depotNearby(gwConn, pos)
+	}
+
+	if err := c.callStepHandlers(c.stepInHandlers, cr, t, pos); err != nil {
+		return err
+	}
+	if cr.GetPos() != pos {
+		return nil
+	}
+
+	c.scriptsStepIn(cr)
+	return nil
+}
+
+// creatureSteppedOut processes the creature having stepped off the tile at the
+// passed position, calling handlers registered for action IDs of items on the
+// tile.
+func (c *GameworldServer) creatureSteppedOut(cr Creature, pos tnet.Position) error {
+	if len(c.stepOutHandlers) == 0 {
+		return nil
+	}
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return err
+	}
+	return c.callStepHandlers(c.stepOutHandlers, cr, t, pos)
+}
+
+// callStepHandlers calls the handlers registered for the action IDs of the
+// items on the tile, until the creature is moved elsewhere.
+func (c *GameworldServer) callStepHandlers(handlers map[uint16]StepHandler, cr Creature, t MapTile, pos tnet.Position) error {
+	if len(handlers) == 0 {
+		return nil
+	}
+	p := cr.GetPos()
+	for idx := 0; ; idx++ {
+		item, err := t.GetItem(idx)
+		if err == ItemNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		withIDs, ok := item.(gwmap.MapItemWithIDs)
+		if !ok || withIDs.GetActionID() == 0 {
+			continue
+		}
+		h, ok := handlers[withIDs.GetActionID()]
+		if !ok {
+			continue
+		}
+		if err := h(cr, item, pos); err != nil {
+			return fmt.Errorf("step handler for action ID %d: %w", withIDs.GetActionID(), err)
+		}
+		if cr.GetPos() != p {
+			return nil
+		}
+	}
+}
+
+// teleportDestination returns the destination of the first teleport item on
+// the tile, if any.
+func (c *GameworldServer) teleportDestination(t MapTile) (tnet.Position, bool) {
+	for idx := 0; ; idx++ {
+		item, err := t.GetItem(idx)
+		if err != nil {
+			return tnet.Position{}, false
+		}
+		if tp, ok := item.(gwmap.TeleportMapItem); ok {
+			if dest, ok := tp.GetTeleportDestination(); ok {
+				return dest, true
+			}
+		}
+	}
+}
+
+// floorChangeDestination returns where a creature stepping onto the tile at
+// the passed position ends up, if the tile makes it change floors.
+//
+// Holes and stairs going down move the creature one floor down. If it lands on
+// a ramp, it is moved off the ramp in the direction opposite to where the ramp
+// leads, so that it does not go right back up.
+//
+// Ramps and ladders going up move the creature one floor up, and one tile in
+// the direction they lead.
+func (c *GameworldServer) floorChangeDestination(pos tnet.Position) (tnet.Position, bool) {
+	flags := c.tileFlags(pos)
+	switch {
+	case flags&itemsotb.FLAG_FLOORCHANGEDOWN != 0:
+		if pos.Floor >= 15 {
+			return tnet.Position{}, false
+		}
+		dest := tnet.Position{X: pos.X, Y: pos.Y, Floor: pos.Floor + 1}
+		below := c.tileFlags(dest)
+		if below&itemsotb.FLAG_FLOORCHANGENORTH != 0 {
+			dest.Y++
+		}
+		if below&itemsotb.FLAG_FLOORCHANGESOUTH != 0 {
+			dest.Y--
+		}
+		if below&itemsotb.FLAG_FLOORCHANGEEAST != 0 {
+			dest.X--
+		}
+		if below&itemsotb.FLAG_FLOORCHANGEWEST != 0 {
+			dest.X++
+		}
+		return dest, true
+	case flags&floorChangeFlags != 0:
+		if pos.Floor == 0 {
+			return tnet.Position{}, false
+		}
+		dest := tnet.Position{X: pos.X, Y: pos.Y, Floor: pos.Floor - 1}
+		if flags&itemsotb.FLAG_FLOORCHANGENORTH != 0 {
+			dest.Y--
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGESOUTH != 0 {
+			dest.Y++
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGEEAST != 0 {
+			dest.X++
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGEWEST != 0 {
+			dest.X--
+		}
+		return dest, true
+	}
+	return tnet.Position{}, false
+}
+
+// tileFlags returns the combined flags of all items on the tile at the passed
+// position. Without a things registry, no flags are known.
+func (c *GameworldServer) tileFlags(pos tnet.Position) itemsotb.ItemsFlags {
+	if c.things == nil {
+		return 0
+	}
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return 0
+	}
+	var flags itemsotb.ItemsFlags
+	for idx := 0; ; idx++ {
+		item, err := t.GetItem(idx)
+		if err != nil {
+			return flags
+		}
+		if otbItem := c.things.Temp__GetItemFromOTB(item.GetServerType(), 0); otbItem != nil {
+			flags |= otbItem.Flags
+		}
+	}
+}
+
+// stepMovesElsewhere returns whether a creature stepping onto the tile at the
+// passed position would be moved elsewhere, e.g. by a teleport or stairs.
+// Creatures controlled by the server avoid such tiles.
+func (c *GameworldServer) stepMovesElsewhere(pos tnet.Position) bool {
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return false
+	}
+	if _, ok := c.teleportDestination(t); ok {
+		return true
+	}
+	_, ok := c.floorChangeDestination(pos)
+	return ok
+}
+
+// depotNearby tells the player about the contents of their depot, if there is
+// a depot locker next to the passed position.
+func (c *GameworldServer) depotNearby(gwConn *GameworldConnection, pos tnet.Position) {
+	for _, p := range []tnet.Position{
+		{X: pos.X, Y: pos.Y - 1, Floor: pos.Floor},
+		{X: pos.X + 1, Y: pos.Y, Floor: pos.Floor},
+		{X: pos.X, Y: pos.Y + 1, Floor: pos.Floor},
+		{X: pos.X - 1, Y: pos.Y, Floor: pos.Floor},
+	} {
+		t, err := c.mapDataSource.GetMapTile(p.X, p.Y, p.Floor)
+		if err != nil {
+			continue
+		}
+		for idx := 0; ; idx++ {
+			item, err := t.GetItem(idx)
+			if err != nil {
+				break
+			}
+			if depot, ok := item.(gwmap.DepotMapItem); ok && depot.GetDepotID() != 0 {
+				glog.V(2).Infof("player %d is next to depot %d", gwConn.id, depot.GetDepotID())
+				// TODO: count the items in the player's depot, once players
+				// have depots.
+				out := tnet.NewMessage()
+				gwConn.textMessage(out, TextMessageEventDefault, "Your depot contains 0 items.")
+				gwConn.queueMessage(out)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package gameworld
+
+import (
+	"strings"
+	"testing"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+// stepTestItem is a map item which can carry any of the optional properties
+// map items may have.
+type stepTestItem struct {
+	serverType uint16
+	actionID   uint16
+	depotID    uint16
+	dest       *tnet.Position
+}
+
+func (i *stepTestItem) GetServerType() uint16 { return i.serverType }
+func (i *stepTestItem) GetCount() uint16      { return 1 }
+func (i *stepTestItem) GetActionID() uint16   { return i.actionID }
+func (i *stepTestItem) GetUniqueID() uint16   { return 0 }
+func (i *stepTestItem) GetDepotID() uint16    { return i.depotID }
+func (i *stepTestItem) GetTeleportDestination() (tnet.Position, bool) {
+	if i.dest == nil {
+		return tnet.Position{}, false
+	}
+	return *i.dest, true
+}
+
+// stepTestTile is a map tile holding a list of items.
+type stepTestTile struct {
+	mapTile
+	items []MapItem
+}
+
+func (t *stepTestTile) GetItem(idx int) (MapItem, error) {
+	if idx >= len(t.items) {
+		return nil, ItemNotFound
+	}
+	return t.items[idx], nil
+}
+
+// Server IDs of items on the test map. Client IDs are the same.
+const (
+	stepTestGround     = 100
+	stepTestStairsDown = 200
+	stepTestRampNorth  = 300
+	stepTestTeleport   = 400
+	stepTestLocker     = 500
+)
+
+func newStepTestScenario(t *testing.T, special map[tnet.Position][]MapItem) *scenario {
+	t.Helper()
+	ds := NewMapDataSource().(*mapDataSource)
+	ds.mapTileGenerator = func(x, y uint16, z uint8) (MapTile, error) {
+		items := []MapItem{&stepTestItem{serverType: stepTestGround}}
+		items = append(items, special[tnet.Position{X: x, Y: y, Floor: z}]...)
+		return &stepTestTile{items: items}, nil
+	}
+	s := newScenario(t, ds)
+
+	otb := &itemsotb.Items{
+		ServerIDToArrayIndex: map[uint16]int{},
+		ClientIDToArrayIndex: map[uint16]int{},
+	}
+	for i, item := range []struct {
+		id    uint16
+		flags itemsotb.ItemsFlags
+	}{
+		{stepTestGround, 0},
+		{stepTestStairsDown, itemsotb.FLAG_FLOORCHANGEDOWN},
+		{stepTestRampNorth, itemsotb.FLAG_FLOORCHANGENORTH},
+		{stepTestTeleport, 0},
+		{stepTestLocker, itemsotb.FLAG_BLOCK_SOLID},
+	} {
+		otb.Items = append(otb.Items, itemsotb.Item{
+			Flags: item.flags,
+			Attributes: map[itemsotb.ItemsAttribute]interface{}{
+				itemsotb.ITEM_ATTR_SERVERID: item.id,
+				itemsotb.ITEM_ATTR_CLIENTID: item.id,
+			},
+		})
+		otb.ServerIDToArrayIndex[item.id] = i
+		otb.ClientIDToArrayIndex[item.id] = i
+	}
+	th, _ := things.New()
+	th.AddItemsOTB(otb)
+	s.gws.SetThings(th)
+	return s
+}
+
+func TestStepIn(t *testing.T) {
+	teleportDest := tnet.Position{X: 600, Y: 600, Floor: 7}
+	s := newStepTestScenario(t, map[tnet.Position][]MapItem{
+		{X: 500, Y: 499, Floor: 7}: {&stepTestItem{serverType: stepTestTeleport, dest: &teleportDest}},
+		{X: 510, Y: 499, Floor: 7}: {&stepTestItem{serverType: stepTestStairsDown}},
+		{X: 510, Y: 499, Floor: 8}: {&stepTestItem{serverType: stepTestRampNorth}},
+		{X: 520, Y: 499, Floor: 7}: {&stepTestItem{serverType: stepTestRampNorth}},
+		{X: 530, Y: 499, Floor: 7}: {&stepTestItem{serverType: stepTestGround, actionID: 1000}},
+		{X: 540, Y: 498, Floor: 7}: {&stepTestItem{serverType: stepTestLocker, depotID: 1}},
+	})
+
+	gws := s.gws
+
+	var steppedIn, steppedOut []tnet.Position
+	gws.HandleStepIn(1000, func(cr Creature, item MapItem, pos tnet.Position) error {
+		steppedIn = append(steppedIn, pos)
+		return nil
+	})
+	gws.HandleStepOut(1000, func(cr Creature, item MapItem, pos tnet.Position) error {
+		steppedOut = append(steppedOut, pos)
+		return nil
+	})
+
+	player := &creature{
+		pos:  tnet.Position{X: 500, Y: 500, Floor: 7},
+		look: 128,
+	}
+	conn := s.addPlayer(t, player)
+
+	for _, tc := range []struct {
+		name      string
+		fromX     uint16
+		want      tnet.Position
+		wantTexts []string
+	}{
+		{"teleport", 500, teleportDest, nil},
+		{"stairs down onto a ramp", 510, tnet.Position{X: 510, Y: 500, Floor: 8}, nil},
+		{"ramp up", 520, tnet.Position{X: 520, Y: 498, Floor: 6}, nil},
+		{"action ID", 530, tnet.Position{X: 530, Y: 499, Floor: 7}, nil},
+		{"depot", 540, tnet.Position{X: 540, Y: 499, Floor: 7}, []string{"Your depot contains 0 items."}},
+	} {
+		if _, err := gws.relocateCreature(player, tnet.Position{X: tc.fromX, Y: 500, Floor: 7}); err != nil {
+			t.Fatalf("%s: placing player: %v", tc.name, err)
+		}
+		drainOpcodes(conn)
+
+		if err := conn.playerMoveNorth(); err != nil {
+			t.Fatalf("%s: moving north: %v", tc.name, err)
+		}
+		if player.GetPos() != tc.want {
+			t.Errorf("%s: player at %v, want %v", tc.name, player.GetPos(), tc.want)
+		}
+		opcodes, texts := drainOpcodes(conn)
+		if fullMap := strings.IndexByte(string(opcodes), 0x64) >= 0; fullMap != (tc.want.Y != 499) {
+			t.Errorf("%s: player sent %x, want a full map description only when moved elsewhere", tc.name, opcodes)
+		}
+		if len(texts) != len(tc.wantTexts) || (len(texts) > 0 && texts[0] != tc.wantTexts[0]) {
+			t.Errorf("%s: player told %q, want %q", tc.name, texts, tc.wantTexts)
+		}
+	}
+
+	if len(steppedIn) != 1 || len(steppedOut) != 0 {
+		t.Errorf("step handlers called for %v in and %v out, want one step in", steppedIn, steppedOut)
+	}
+	if _, err := gws.relocateCreature(player, tnet.Position{X: 530, Y: 499, Floor: 7}); err != nil {
+		t.Fatalf("placing player: %v", err)
+	}
+	if err := gws.moveCreature(player, tnet.Position{X: 531, Y: 499, Floor: 7}); err != nil {
+		t.Fatalf("moving player off the action ID: %v", err)
+	}
+	if len(steppedOut) != 1 {
+		t.Errorf("step out handler called for %v, want one step out", steppedOut)
+	}
+
+	// Creatures controlled by the server avoid tiles moving them elsewhere.
+	for _, pos := range []tnet.Position{{X: 500, Y: 499, Floor: 7}, {X: 510, Y: 499, Floor: 7}, {X: 520, Y: 499, Floor: 7}} {
+		if !gws.stepMovesElsewhere(pos) {
+			t.Errorf("stepping onto %v does not move creatures elsewhere", pos)
+		}
+	}
+	if gws.stepMovesElsewhere(tnet.Position{X: 530, Y: 499, Floor: 7}) {
+		t.Errorf("stepping onto plain ground moves creatures elsewhere")
+	}
+}
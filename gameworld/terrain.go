@@ -0,0 +1,476 @@
+package gameworld
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"badc0de.net/pkg/go-tibia/autoborder"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+// TerrainPalette lists the server IDs of the items generated terrain is made
+// of.
+type TerrainPalette struct {
+	Grass, Sand, Water, Mountain uint16 // Grounds of the surface biomes.
+	Path, Bridge                 uint16 // Grounds of roads over land and over water.
+	CaveFloor                    uint16
+	Hole                         uint16   // Ground leading one floor down.
+	Stairs                       uint16   // Item leading one floor up, to the north.
+	Trees                        []uint16 // Items placed on grass.
+}
+
+// DefaultTerrainPalette uses items of the usual 8.54 items.otb.
+var DefaultTerrainPalette = TerrainPalette{
+	Grass:     4526,
+	Sand:      231,
+	Water:     4608,
+	Mountain:  919,
+	Path:      103,
+	Bridge:    405,
+	CaveFloor: 351,
+	Hole:      383,
+	Stairs:    1385,
+	Trees:     []uint16{2700, 2701, 2702, 2703},
+}
+
+// Check returns an error describing all items of the palette which are not
+// what the generator expects them to be according to the passed things:
+// grounds which are not grounds, water, mountains and trees which do not
+// block, and holes and stairs which do not change floors.
+func (p TerrainPalette) Check(th *things.Things) error {
+	var problems []string
+	check := func(what string, id uint16, ground bool, flags itemsotb.ItemsFlags) {
+		otbItem := th.Temp__GetItemFromOTB(id, 0)
+		switch {
+		case otbItem == nil:
+			problems = append(problems, fmt.Sprintf("%s %d is not in items.otb", what, id))
+		case ground && otbItem.Group != itemsotb.ITEM_GROUP_GROUND:
+			problems = append(problems, fmt.Sprintf("%s %d is not a ground", what, id))
+		case otbItem.Flags&flags != flags:
+			problems = append(problems, fmt.Sprintf("%s %d does not have flags %s", what, id, flags))
+		}
+	}
+	check("grass", p.Grass, true, 0)
+	check("sand", p.Sand, true, 0)
+	check("water", p.Water, true, itemsotb.FLAG_BLOCK_SOLID)
+	check("mountain", p.Mountain, true, itemsotb.FLAG_BLOCK_SOLID)
+	check("path", p.Path, true, 0)
+	check("bridge", p.Bridge, true, 0)
+	check("cave floor", p.CaveFloor, true, 0)
+	check("hole", p.Hole, true, itemsotb.FLAG_FLOORCHANGEDOWN)
+	check("stairs", p.Stairs, false, itemsotb.FLAG_FLOORCHANGENORTH)
+	for _, id := range p.Trees {
+		check("tree", id, false, itemsotb.FLAG_BLOCK_SOLID)
+	}
+	if len(p.Trees) == 0 {
+		problems = append(problems, "no trees")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("terrain palette does not match items.otb: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+const (
+	// MaxTerrainCaveFloors is the largest number of cave floors which can be
+	// generated below the surface.
+	MaxTerrainCaveFloors = 5
+
+	terrainSurface = 7
+
+	// Roads run through the middle of each terrainCell x terrainCell cell,
+	// one from north to south and one from west to east, meandering up to
+	// terrainMeander tiles to either side. Roads are two tiles wide.
+	terrainCell    = 64
+	terrainMeander = 8
+
+	// Holes leading from floor z to floor z+1 are placed just east of the
+	// road running from north to south, terrainSiteRow+terrainSiteStep*(z-7)
+	// tiles from the top of their cell, clear of the road running from west
+	// to east.
+	terrainSiteRow  = terrainCell/2 + 14
+	terrainSiteStep = 4
+
+	// The temple is surrounded by a square of road this many tiles to each
+	// side.
+	terrainPlaza = 4
+)
+
+// Salts telling apart the noise used for different purposes.
+const (
+	saltHeight = iota + 1
+	saltMoisture
+	saltTree
+	saltRoadNS
+	saltRoadWE
+	saltSite
+	saltCave // Plus the floor.
+)
+
+// TerrainOptions configures the terrain generated by NewTerrainMapDataSource.
+type TerrainOptions struct {
+	// Seed selects the terrain; the same seed always generates the same
+	// terrain.
+	Seed int64
+
+	// Width and Height of the map, in tiles. Tiles outside of the map are
+	// empty. Zero means 512.
+	Width, Height uint16
+
+	// CaveFloors is the number of floors of caves below the surface; at
+	// most MaxTerrainCaveFloors. Zero means 3; negative means none.
+	CaveFloors int
+
+	// Palette lists the items the terrain is made of. Nil means
+	// DefaultTerrainPalette.
+	Palette *TerrainPalette
+
+	// Borders places borders around the grounds of the terrain, if the
+	// palette's grounds are painted by its brushes. Nil means no borders.
+	Borders *autoborder.Brushes
+}
+
+// terrain generates tiles of the map from noise, without keeping any state
+// other than the options, so tiles can be generated in any order.
+//
+// The surface, on floor 7, is water, sand, grass with trees, and mountains,
+// depending on its height and moisture. A grid of roads crosses it, so that
+// all of it can be reached; roads turn into bridges over water. Caves below
+// have tunnels under the roads, and rooms around them. Floors are connected
+// by holes, each placed above stairs leading back up.
+type terrain struct {
+	seed          int64
+	width, height int
+	caveFloors    int
+	p             TerrainPalette
+	borders       *autoborder.Brushes
+	town          Town
+}
+
+// terrainMapDataSource is the map data source of generated terrain.
+type terrainMapDataSource struct {
+	*mapDataSource
+	gen *terrain
+}
+
+// NewTerrainMapDataSource returns a map data source generating terrain as
+// configured by the passed options. The terrain has a single town, whose
+// temple is on the road crossing nearest to the middle of the map.
+func NewTerrainMapDataSource(opts TerrainOptions) (MapDataSource, error) {
+	gen, err := newTerrain(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &terrainMapDataSource{
+		mapDataSource: &mapDataSource{
+			creatures:         map[CreatureID]Creature{},
+			generatedMapTiles: map[tnet.Position]MapTile{},
+			mapTileGenerator:  gen.tile,
+		},
+		gen: gen,
+	}, nil
+}
+
+func newTerrain(opts TerrainOptions) (*terrain, error) {
+	g := &terrain{
+		seed:       opts.Seed,
+		width:      int(opts.Width),
+		height:     int(opts.Height),
+		caveFloors: opts.CaveFloors,
+		p:          DefaultTerrainPalette,
+		borders:    opts.Borders,
+	}
+	if g.width == 0 {
+		g.width = 512
+	}
+	if g.height == 0 {
+		g.height = 512
+	}
+	if g.caveFloors == 0 {
+		g.caveFloors = 3
+	} else if g.caveFloors < 0 {
+		g.caveFloors = 0
+	}
+	if opts.Palette != nil {
+		g.p = *opts.Palette
+	}
+	if g.width < terrainCell || g.height < terrainCell {
+		return nil, fmt.Errorf("terrain of %dx%d tiles is smaller than %dx%d", g.width, g.height, terrainCell, terrainCell)
+	}
+	if g.caveFloors > MaxTerrainCaveFloors {
+		return nil, fmt.Errorf("%d cave floors requested; at most %d can be generated", g.caveFloors, MaxTerrainCaveFloors)
+	}
+	if len(g.p.Trees) == 0 {
+		return nil, fmt.Errorf("terrain palette has no trees")
+	}
+
+	k := g.width / 2 / terrainCell
+	if !g.roadNSExists(k) {
+		k--
+	}
+	y := (g.height/2/terrainCell)*terrainCell + terrainCell/2
+	g.town = Town{
+		ID:        1,
+		Name:      "Genesis",
+		TemplePos: tnet.Position{X: uint16(g.roadNS(k, y)), Y: uint16(y), Floor: terrainSurface},
+	}
+	return g, nil
+}
+
+func (ds *terrainMapDataSource) GetTownTemple(townID uint32) (tnet.Position, error) {
+	if townID != ds.gen.town.ID {
+		return tnet.Position{}, TownNotFound
+	}
+	return ds.gen.town.TemplePos, nil
+}
+
+func (ds *terrainMapDataSource) Towns() []Town {
+	return []Town{ds.gen.town}
+}
+
+// Dimensions returns the size of the generated terrain.
+func (ds *terrainMapDataSource) Dimensions() (width, height uint16) {
+	return uint16(ds.gen.width), uint16(ds.gen.height)
+}
+
+// tile generates the tile at the passed position.
+func (g *terrain) tile(x16, y16 uint16, z uint8) (MapTile, error) {
+	x, y := int(x16), int(y16)
+	t := g.bareTile(x, y, int(z))
+	if g.borders == nil {
+		return t, nil
+	}
+	// Borders go below anything else placed on the ground.
+	var items []MapItem
+	for _, id := range g.borders.Borders(func(dx, dy int) uint16 { return g.groundAt(x+dx, y+dy, int(z)) }) {
+		items = append(items, mapItemOfType(int(id)))
+	}
+	if items != nil {
+		t.items = append(items, t.items...)
+	}
+	return t, nil
+}
+
+// groundAt returns the server ID of the ground of the passed tile, or zero if
+// it has none.
+func (g *terrain) groundAt(x, y, z int) uint16 {
+	if x < 0 || y < 0 {
+		return 0
+	}
+	t := g.bareTile(x, y, z)
+	if t.ground == nil {
+		return 0
+	}
+	return t.ground.GetServerType()
+}
+
+// bareTile generates the tile at the passed position, without borders.
+func (g *terrain) bareTile(x, y, z int) *mapTile {
+	if x >= g.width || y >= g.height {
+		return &mapTile{}
+	}
+	switch {
+	case z == terrainSurface:
+		return g.surfaceTile(x, y)
+	case z > terrainSurface && z <= terrainSurface+g.caveFloors:
+		return g.caveTile(x, y, z)
+	default:
+		return &mapTile{}
+	}
+}
+
+func (g *terrain) surfaceTile(x, y int) *mapTile {
+	// Roads are laid out without regard for the biomes, so that they are
+	// always connected; they only look at whether they need to be bridges.
+	h := g.heightAt(x, y)
+	road := g.p.Path
+	if h < terrainWaterLevel {
+		road = g.p.Bridge
+	}
+
+	if hole, ok := g.site(x, y, terrainSurface); ok {
+		if hole {
+			return &mapTile{ground: mapItemOfType(int(g.p.Hole))}
+		}
+		return &mapTile{ground: mapItemOfType(int(road))}
+	}
+	if g.onRoad(x, y) || g.inPlaza(x, y) {
+		return &mapTile{ground: mapItemOfType(int(road))}
+	}
+
+	m := g.moistureAt(x, y)
+	switch {
+	case h < terrainWaterLevel:
+		return &mapTile{ground: mapItemOfType(int(g.p.Water))}
+	case h < terrainBeachLevel || m < terrainDesertMoisture:
+		return &mapTile{ground: mapItemOfType(int(g.p.Sand))}
+	case h > terrainMountainLevel:
+		return &mapTile{ground: mapItemOfType(int(g.p.Mountain))}
+	}
+
+	t := &mapTile{ground: mapItemOfType(int(g.p.Grass))}
+	chance := uint64(3) // percent
+	if m > terrainForestMoisture {
+		chance = 30
+	}
+	if r := hash(g.seed, saltTree, x, y); r%100 < chance {
+		t.items = append(t.items, mapItemOfType(int(g.p.Trees[(r/100)%uint64(len(g.p.Trees))])))
+	}
+	return t
+}
+
+func (g *terrain) caveTile(x, y, z int) *mapTile {
+	if hole, ok := g.site(x, y, z); ok {
+		if hole {
+			return &mapTile{ground: mapItemOfType(int(g.p.Hole))}
+		}
+		return &mapTile{ground: mapItemOfType(int(g.p.CaveFloor))}
+	}
+	if stairs, ok := g.site(x, y, z-1); ok {
+		t := &mapTile{ground: mapItemOfType(int(g.p.CaveFloor))}
+		if stairs {
+			t.items = append(t.items, mapItemOfType(int(g.p.Stairs)))
+		}
+		return t
+	}
+	if g.onRoad(x, y) || g.fbm(saltCave+z, float64(x)/24, float64(y)/24) > terrainCaveLevel {
+		return &mapTile{ground: mapItemOfType(int(g.p.CaveFloor))}
+	}
+	// Solid rock.
+	return &mapTile{}
+}
+
+// Thresholds of height and moisture, which are mostly within [-0.5, 0.5].
+const (
+	terrainWaterLevel     = -0.2
+	terrainBeachLevel     = -0.15
+	terrainMountainLevel  = 0.25
+	terrainDesertMoisture = -0.3
+	terrainForestMoisture = 0.1
+	terrainCaveLevel      = 0.15
+)
+
+func (g *terrain) heightAt(x, y int) float64 {
+	return g.fbm(saltHeight, float64(x)/64, float64(y)/64)
+}
+
+func (g *terrain) moistureAt(x, y int) float64 {
+	return g.fbm(saltMoisture, float64(x)/96, float64(y)/96)
+}
+
+// roadNS returns the westernmost x of the road running from north to south
+// through cells in column k, on row y.
+func (g *terrain) roadNS(k, y int) int {
+	return k*terrainCell + terrainCell/2 + g.meander(saltRoadNS, k, y)
+}
+
+// roadWE returns the northernmost y of the road running from west to east
+// through cells in row j, on column x.
+func (g *terrain) roadWE(j, x int) int {
+	return j*terrainCell + terrainCell/2 + g.meander(saltRoadWE, j, x)
+}
+
+// roadNSExists returns whether the road through cells in column k lies
+// entirely within the map, along with anything placed next to it.
+func (g *terrain) roadNSExists(k int) bool {
+	return k >= 0 && (k+1)*terrainCell <= g.width
+}
+
+func (g *terrain) roadWEExists(j int) bool {
+	return j >= 0 && (j+1)*terrainCell <= g.height
+}
+
+// meander returns how far a road strays from the middle of its cells at the
+// passed distance along it. Neighbouring tiles stray at most one tile apart,
+// so two tile wide roads never break apart.
+func (g *terrain) meander(salt, road, along int) int {
+	return int(math.Round(terrainMeander * g.noise(salt, road, float64(along)/32, 0)))
+}
+
+func (g *terrain) onRoad(x, y int) bool {
+	k, j := x/terrainCell, y/terrainCell
+	if rx := g.roadNS(k, y); g.roadNSExists(k) && (x == rx || x == rx+1) {
+		return true
+	}
+	if ry := g.roadWE(j, x); g.roadWEExists(j) && (y == ry || y == ry+1) {
+		return true
+	}
+	return false
+}
+
+func (g *terrain) inPlaza(x, y int) bool {
+	dx, dy := x-int(g.town.TemplePos.X), y-int(g.town.TemplePos.Y)
+	return dx >= -terrainPlaza && dx <= terrainPlaza && dy >= -terrainPlaza && dy <= terrainPlaza
+}
+
+// site returns whether the passed tile is a hole leading from floor z to
+// floor z+1 (or the stairs below it), and whether it is in the clearing
+// around one.
+//
+// Each cell may have such a hole for each floor. Holes and stairs are
+// surrounded by a clearing, connected to the road next to it, so that
+// creatures can get to them, and so that there is a tile to arrive on.
+func (g *terrain) site(x, y, z int) (center, ok bool) {
+	if z < terrainSurface || z >= terrainSurface+g.caveFloors {
+		return false, false
+	}
+	k, j := x/terrainCell, y/terrainCell
+	sy := j*terrainCell + terrainSiteRow + terrainSiteStep*(z-terrainSurface)
+	if y < sy-1 || y > sy+1 || !g.roadNSExists(k) || sy+1 >= g.height {
+		return false, false
+	}
+	sx := g.roadNS(k, sy) + 3
+	if x < sx-1 || x > sx+1 {
+		return false, false
+	}
+	// At least the cell with the town always leads down.
+	if hash(g.seed, saltSite, k*0x10000+j, z)%3 != 0 && (k != int(g.town.TemplePos.X)/terrainCell || j != int(g.town.TemplePos.Y)/terrainCell) {
+		return false, false
+	}
+	return x == sx && y == sy, true
+}
+
+// fbm returns fractal noise in roughly [-1, 1], adding up four octaves of
+// value noise.
+func (g *terrain) fbm(salt int, x, y float64) float64 {
+	var sum, amp, total float64 = 0, 1, 0
+	for octave := 0; octave < 4; octave++ {
+		sum += amp * g.noise(salt, octave, x, y)
+		total += amp
+		x, y, amp = x*2, y*2, amp/2
+	}
+	return sum / total
+}
+
+// noise returns value noise in [-1, 1]: random values at integer
+// coordinates, smoothly interpolated in between.
+func (g *terrain) noise(salt, octave int, x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	fx, fy = fx*fx*(3-2*fx), fy*fy*(3-2*fy)
+	ix, iy := int(x0), int(y0)
+	corner := func(dx, dy int) float64 {
+		return float64(hash(g.seed, salt*0x100+octave, ix+dx, iy+dy)>>11)/(1<<52) - 1
+	}
+	top := corner(0, 0) + fx*(corner(1, 0)-corner(0, 0))
+	bottom := corner(0, 1) + fx*(corner(1, 1)-corner(0, 1))
+	return top + fy*(bottom-top)
+}
+
+// hash mixes the passed values into a random 64-bit value.
+func hash(seed int64, salt, a, b int) uint64 {
+	h := splitmix(uint64(seed))
+	h = splitmix(h ^ uint64(salt))
+	h = splitmix(h ^ uint64(a))
+	return splitmix(h ^ uint64(b))
+}
+
+func splitmix(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
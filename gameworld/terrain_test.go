@@ -0,0 +1,270 @@
+package gameworld
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/autoborder"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+// terrainTestThings returns a things registry knowing the items of the passed
+// palette, with the flags the generator expects, except for the passed
+// overrides.
+func terrainTestThings(t *testing.T, p TerrainPalette, override map[uint16]itemsotb.ItemsFlags) *things.Things {
+	t.Helper()
+	type item struct {
+		id     uint16
+		ground bool
+		flags  itemsotb.ItemsFlags
+	}
+	all := []item{
+		{p.Grass, true, 0},
+		{p.Sand, true, 0},
+		{p.Water, true, itemsotb.FLAG_BLOCK_SOLID},
+		{p.Mountain, true, itemsotb.FLAG_BLOCK_SOLID},
+		{p.Path, true, 0},
+		{p.Bridge, true, 0},
+		{p.CaveFloor, true, 0},
+		{p.Hole, true, itemsotb.FLAG_FLOORCHANGEDOWN},
+		{p.Stairs, false, itemsotb.FLAG_FLOORCHANGENORTH},
+	}
+	for _, id := range p.Trees {
+		all = append(all, item{id, false, itemsotb.FLAG_BLOCK_SOLID})
+	}
+
+	otb := &itemsotb.Items{
+		ServerIDToArrayIndex: map[uint16]int{},
+		ClientIDToArrayIndex: map[uint16]int{},
+	}
+	for i, item := range all {
+		group := itemsotb.ITEM_GROUP_NONE
+		if item.ground {
+			group = itemsotb.ITEM_GROUP_GROUND
+		}
+		flags, ok := override[item.id]
+		if !ok {
+			flags = item.flags
+		}
+		otb.Items = append(otb.Items, itemsotb.Item{
+			Group: group,
+			Flags: flags,
+			Attributes: map[itemsotb.ItemsAttribute]interface{}{
+				itemsotb.ITEM_ATTR_SERVERID: item.id,
+				itemsotb.ITEM_ATTR_CLIENTID: item.id,
+			},
+		})
+		otb.ServerIDToArrayIndex[item.id] = i
+		otb.ClientIDToArrayIndex[item.id] = i
+	}
+	th, _ := things.New()
+	th.AddItemsOTB(otb)
+	return th
+}
+
+// tileItems returns the server IDs of the items on the tile.
+func tileItems(t *testing.T, ds MapDataSource, pos tnet.Position) []uint16 {
+	t.Helper()
+	tile, err := ds.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		t.Fatalf("getting tile at %v: %v", pos, err)
+	}
+	var ids []uint16
+	for item, err := tile.GetItem(0); err == nil; item, err = tile.GetItem(len(ids)) {
+		ids = append(ids, item.GetServerType())
+	}
+	return ids
+}
+
+func TestTerrainDeterministic(t *testing.T) {
+	newTerrain := func(seed int64) MapDataSource {
+		ds, err := NewTerrainMapDataSource(TerrainOptions{Seed: seed, Width: 256, Height: 256})
+		if err != nil {
+			t.Fatalf("creating terrain: %v", err)
+		}
+		return ds
+	}
+	a, b, other := newTerrain(42), newTerrain(42), newTerrain(43)
+
+	differs := 0
+	for floor := uint8(6); floor <= 11; floor++ {
+		for y := uint16(0); y < 256; y += 7 {
+			// Generate tiles in a different order for each map.
+			for x := uint16(0); x < 256; x += 5 {
+				pos := tnet.Position{X: x, Y: y, Floor: floor}
+				rev := tnet.Position{X: 255 - x, Y: 255 - y, Floor: floor}
+				if got, want := tileItems(t, a, pos), tileItems(t, b, pos); !reflect.DeepEqual(got, want) {
+					t.Fatalf("tile at %v is %v on one map and %v on another with the same seed", pos, got, want)
+				}
+				if !reflect.DeepEqual(tileItems(t, other, rev), tileItems(t, a, rev)) {
+					differs++
+				}
+			}
+		}
+	}
+	if differs == 0 {
+		t.Errorf("terrains with different seeds are the same")
+	}
+
+	if got := a.(*terrainMapDataSource).Towns(); len(got) != 1 {
+		t.Errorf("got towns %v, want a single town", got)
+	}
+}
+
+// TestTerrainReachable walks the generated terrain the way players would,
+// starting at the temple, and checks that all roads and all cave floors can
+// be reached.
+func TestTerrainReachable(t *testing.T) {
+	const size = 256
+	ds, err := NewTerrainMapDataSource(TerrainOptions{Seed: 7, Width: size, Height: size, CaveFloors: 3})
+	if err != nil {
+		t.Fatalf("creating terrain: %v", err)
+	}
+	gws := &GameworldServer{}
+	gws.SetMapDataSource(ds)
+	gws.SetThings(terrainTestThings(t, DefaultTerrainPalette, nil))
+
+	walkable := func(pos tnet.Position) bool {
+		if pos.X >= size || pos.Y >= size {
+			return false
+		}
+		ids := tileItems(t, ds, pos)
+		return len(ids) > 0 && gws.tileFlags(pos)&itemsotb.FLAG_BLOCK_SOLID == 0
+	}
+
+	temple, err := ds.GetTownTemple(1)
+	if err != nil {
+		t.Fatalf("getting temple: %v", err)
+	}
+	if !walkable(temple) {
+		t.Fatalf("temple at %v is not walkable", temple)
+	}
+
+	seen := map[tnet.Position]bool{temple: true}
+	queue := []tnet.Position{temple}
+	floors := map[uint8]int{}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		floors[pos.Floor]++
+		for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+			next := tnet.Position{X: uint16(int(pos.X) + d[0]), Y: uint16(int(pos.Y) + d[1]), Floor: pos.Floor}
+			if seen[next] || !walkable(next) {
+				continue
+			}
+			seen[next] = true
+			if dest, ok := gws.floorChangeDestination(next); ok {
+				if !walkable(dest) {
+					t.Errorf("floor change at %v leads to %v, which is not walkable", next, dest)
+					continue
+				}
+				next = dest
+				if seen[next] {
+					continue
+				}
+				seen[next] = true
+			}
+			queue = append(queue, next)
+		}
+	}
+
+	for floor := uint8(8); floor <= 10; floor++ {
+		if floors[floor] == 0 {
+			t.Errorf("cave floor %d cannot be reached", floor)
+		}
+	}
+	if floors[11] != 0 {
+		t.Errorf("%d tiles reached on floor 11, below the caves", floors[11])
+	}
+
+	g := ds.(*terrainMapDataSource).gen
+	unreached := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if _, site := g.site(x, y, terrainSurface); site {
+				continue // Stepping on a hole leads elsewhere.
+			}
+			if g.onRoad(x, y) && !seen[tnet.Position{X: uint16(x), Y: uint16(y), Floor: terrainSurface}] {
+				unreached++
+			}
+		}
+	}
+	if unreached > 0 {
+		t.Errorf("%d road tiles cannot be reached", unreached)
+	}
+}
+
+func TestTerrainPaletteCheck(t *testing.T) {
+	p := DefaultTerrainPalette
+	if err := p.Check(terrainTestThings(t, p, nil)); err != nil {
+		t.Errorf("checking palette: %v", err)
+	}
+	err := p.Check(terrainTestThings(t, p, map[uint16]itemsotb.ItemsFlags{p.Water: 0, p.Stairs: 0}))
+	if err == nil || !strings.Contains(err.Error(), "water") || !strings.Contains(err.Error(), "stairs") {
+		t.Errorf("checking palette with walkable water and stairs which do not lead up: got error %v", err)
+	}
+	if err := p.Check(terrainTestThings(t, TerrainPalette{Grass: 1, Trees: []uint16{2}}, nil)); err == nil {
+		t.Errorf("checking palette against registry without its items succeeded")
+	}
+
+	for _, opts := range []TerrainOptions{
+		{Width: 32},
+		{CaveFloors: MaxTerrainCaveFloors + 1},
+		{Palette: &TerrainPalette{}},
+	} {
+		if _, err := NewTerrainMapDataSource(opts); err == nil {
+			t.Errorf("creating terrain with options %+v succeeded", opts)
+		}
+	}
+}
+
+func TestTerrainBorders(t *testing.T) {
+	p := DefaultTerrainPalette
+	b, err := autoborder.New(xmls.Borders{Border: []xmls.Border{{ID: 1, Item: []xmls.BorderItem{
+		{Edge: "n", Item: 9001}, {Edge: "e", Item: 9002}, {Edge: "s", Item: 9003}, {Edge: "w", Item: 9004},
+	}}}}, xmls.Grounds{Brush: []xmls.GroundBrush{
+		{Name: "grass", Type: "ground", ZOrder: 3500, Item: []xmls.BrushItem{{ID: int(p.Grass)}}, Border: []xmls.BrushBorder{{Align: "outer", ID: 1}}},
+		{Name: "sand", Type: "ground", ZOrder: 3400, Item: []xmls.BrushItem{{ID: int(p.Sand)}}},
+	}})
+	if err != nil {
+		t.Fatalf("creating brushes: %v", err)
+	}
+	plain, err := NewTerrainMapDataSource(TerrainOptions{Seed: 3, Width: 128, Height: 128, CaveFloors: -1})
+	if err != nil {
+		t.Fatalf("creating terrain: %v", err)
+	}
+	bordered, err := NewTerrainMapDataSource(TerrainOptions{Seed: 3, Width: 128, Height: 128, CaveFloors: -1, Borders: b})
+	if err != nil {
+		t.Fatalf("creating bordered terrain: %v", err)
+	}
+
+	borders := 0
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			groundAt := func(dx, dy int) uint16 {
+				if x+dx < 0 || y+dy < 0 {
+					return 0
+				}
+				if ids := tileItems(t, plain, tnet.Position{X: uint16(x + dx), Y: uint16(y + dy), Floor: 7}); len(ids) > 0 {
+					return ids[0]
+				}
+				return 0
+			}
+			pos := tnet.Position{X: uint16(x), Y: uint16(y), Floor: 7}
+			items := tileItems(t, plain, pos)
+			want := append([]uint16{items[0]}, b.Borders(groundAt)...)
+			want = append(want, items[1:]...)
+			if got := tileItems(t, bordered, pos); !reflect.DeepEqual(got, want) {
+				t.Fatalf("tile at %v has items %v, want %v", pos, got, want)
+			}
+			borders += len(want) - len(items)
+		}
+	}
+	if borders == 0 {
+		t.Errorf("no borders placed")
+	}
+}
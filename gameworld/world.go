@@ -0,0 +1,139 @@
+package gameworld
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+
+	"github.com/golang/glog"
+)
+
+// worldTickInterval is how often world-wide periodic work (such as respawning
+// creatures or having them wander around) is processed.
+const worldTickInterval = 100 * time.Millisecond
+
+// StartWorld begins processing world-wide periodic work, such as placing
+// creatures from spawns and having them wander around.
+//
+// The work happens in a background goroutine until StopWorld is called.
+func (c *GameworldServer) StartWorld() {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	if c.worldQuit != nil {
+		return
+	}
+	c.worldQuit = make(chan struct{})
+	go c.worldTicker(c.worldQuit)
+}
+
+// StopWorld stops processing world-wide periodic work started by StartWorld.
+func (c *GameworldServer) StopWorld() {
+	c.worldLock.Lock()
+	defer c.worldLock.Unlock()
+	if c.worldQuit == nil {
+		return
+	}
+	close(c.worldQuit)
+	c.worldQuit = nil
+}
+
+// worldTicker invokes worldTick every worldTickInterval until told to quit.
+func (c *GameworldServer) worldTicker(quit chan struct{}) {
+	ticker := time.NewTicker(worldTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.worldLock.Lock()
+			if err := c.worldTick(now); err != nil {
+				glog.Errorf("world tick: %v", err)
+			}
+			c.worldLock.Unlock()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// worldTick processes all the world-wide periodic work that is due at the
+// passed time. The world lock must be held by the caller.
+func (c *GameworldServer) worldTick(now time.Time) error {
+	if c.mapDataSource == nil {
+		return nil
+	}
+	c.lightTick(now)
+	if err := c.spawnTick(now); err != nil {
+		return err
+	}
+	if err := c.monsterTick(now); err != nil {
+		return err
+	}
+	return c.npcTick(now)
+}
+
+// tileWalkable returns whether a creature could step onto the tile at the
+// passed position: the tile needs to have ground, must not contain any items
+// blocking the movement, and must not be occupied by another creature.
+//
+// Without a things registry, only the presence of ground is checked for
+// items.
+func (c *GameworldServer) tileWalkable(pos tnet.Position) bool {
+	t, err := c.mapDataSource.GetMapTile(pos.X, pos.Y, pos.Floor)
+	if err != nil {
+		return false
+	}
+	if _, err := t.GetCreature(0); err != CreatureNotFound {
+		return false
+	}
+	for idx := 0; ; idx++ {
+		item, err := t.GetItem(idx)
+		if err != nil {
+			// no ground means not walkable
+			return idx > 0
+		}
+		if c.things == nil {
+			continue
+		}
+		if otbItem := c.things.Temp__GetItemFromOTB(item.GetServerType(), 0); otbItem != nil && otbItem.Flags.BlockSolid() {
+			return false
+		}
+	}
+}
+
+// playerSpawnPosition returns where a player living in the passed town appears
+// when logging in: in the temple of their home town, next to the temple
+// position if it is occupied. If the map does not have the town, the first
+// town on the map is used instead.
+func (c *GameworldServer) playerSpawnPosition(townID uint32) (tnet.Position, error) {
+	temple, err := c.mapDataSource.GetTownTemple(townID)
+	if errors.Is(err, TownNotFound) {
+		landmarks, ok := c.mapDataSource.(gwmap.LandmarkMapDataSource)
+		if !ok || len(landmarks.Towns()) == 0 {
+			return tnet.Position{}, fmt.Errorf("no town for the player to appear in: %w", err)
+		}
+		town := landmarks.Towns()[0]
+		glog.Warningf("town %d not found; players will appear in %s (%d) instead", townID, town.Name, town.ID)
+		temple = town.TemplePos
+	} else if err != nil {
+		return tnet.Position{}, err
+	}
+
+	// Look for a free tile in rings around the temple position, closest first.
+	for r := 0; r <= 2; r++ {
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				if dx != -r && dx != r && dy != -r && dy != r {
+					continue
+				}
+				pos := tnet.Position{X: uint16(int(temple.X) + dx), Y: uint16(int(temple.Y) + dy), Floor: temple.Floor}
+				if c.tileWalkable(pos) {
+					return pos, nil
+				}
+			}
+		}
+	}
+	return temple, nil
+}
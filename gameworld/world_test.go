@@ -0,0 +1,61 @@
+package gameworld
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+func TestPlayerSpawnPosition(t *testing.T) {
+	gws := newStepTestScenario(t, nil).gws
+	temple := proceduralTown.TemplePos
+
+	pos, err := gws.playerSpawnPosition(proceduralTown.ID)
+	if err != nil || pos != temple {
+		t.Errorf("player appears at %v (error %v), want the temple at %v", pos, err, temple)
+	}
+
+	// Unknown towns fall back to the first town on the map.
+	if pos, err := gws.playerSpawnPosition(1234); err != nil || pos != temple {
+		t.Errorf("player from unknown town appears at %v (error %v), want the temple at %v", pos, err, temple)
+	}
+	if _, err := gws.mapDataSource.GetTownTemple(1234); !errors.Is(err, TownNotFound) {
+		t.Errorf("looking up unknown town returned %v, want TownNotFound", err)
+	}
+
+	// Players do not appear on top of each other.
+	if err := gws.mapDataSource.AddCreature(&creature{pos: temple, id: NewCreatureID(CreatureTypePlayer)}); err != nil {
+		t.Fatalf("adding player: %v", err)
+	}
+	pos, err = gws.playerSpawnPosition(proceduralTown.ID)
+	if err != nil || pos == temple || distance(pos, temple) != 1 || pos.Floor != temple.Floor {
+		t.Errorf("second player appears at %v (error %v), want next to the temple at %v", pos, err, temple)
+	}
+}
+
+func TestQueueMessageDisconnectsSlowClient(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &GameworldConnection{
+		conn:         server,
+		senderChan:   make(chan *tnet.Message, senderQueueLength),
+		mainLoopQuit: make(chan struct{}),
+	}
+	for i := 0; i < senderQueueLength+2; i++ {
+		conn.queueMessage(tnet.NewMessage())
+	}
+
+	select {
+	case <-conn.mainLoopQuit:
+	default:
+		t.Errorf("main loop not told to quit after the sender queue filled up")
+	}
+	if _, err := server.Write([]byte{0}); err == nil {
+		t.Errorf("connection still open after the sender queue filled up")
+	}
+	if n := len(conn.senderChan); n != senderQueueLength {
+		t.Errorf("%d messages queued, want %d", n, senderQueueLength)
+	}
+}
@@ -0,0 +1,334 @@
+// Package maptiles paints the map as a pyramid of square tiles, addressed by
+// floor, zoom level and position, as used by "slippy" map viewers.
+//
+// At the deepest zoom level, each tile shows 8x8 tiles of the map, painted
+// by the compositor at their full size. Each level above shows four times as
+// much of the map at the same size, by putting together and downsampling the
+// four tiles below it. At zoom level zero, a single tile shows the entire map.
+//
+// Painting is slow, so tiles are best kept in an on-disk cache, which can
+// also be filled ahead of time with RenderAll.
+package maptiles
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"badc0de.net/pkg/go-tibia/compositor"
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	"badc0de.net/pkg/go-tibia/things"
+
+	"github.com/golang/glog"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	TileSize = 256 // Width and height of each tile, in pixels.
+
+	deepestMapTiles = TileSize / 32 // Map tiles across a tile at the deepest zoom level.
+
+	// margin is the number of map tiles painted around each tile at the
+	// deepest zoom level, and then cut off, so that lights and large items
+	// reaching into the tile from its neighbours are painted as well.
+	margin = 4
+
+	// generation is bumped whenever the way tiles are painted changes, so
+	// that tiles cached earlier are not used.
+	generation = 1
+)
+
+// DefaultMapSize is the width and height of the map, in map tiles, assumed
+// for maps which do not know their size.
+const DefaultMapSize = 1024
+
+// ErrNoTile is returned for tiles outside of the pyramid.
+var ErrNoTile = errors.New("no such map tile")
+
+// Options configures a Pyramid.
+type Options struct {
+	// CacheDir is where painted tiles are kept, such as DefaultCacheDir. If
+	// empty, tiles are painted every time they are requested, which for
+	// the lowest zoom levels means painting most of the map.
+	CacheDir string
+
+	// MapSignature identifies the contents of the map, such as a hash of
+	// the map file, so that tiles cached for a different map or a
+	// different version of the map are not used.
+	MapSignature string
+}
+
+// DefaultCacheDir returns a directory in which to keep painted tiles: under
+// the user's cache directory, or else under the temporary directory. Tiles of
+// different maps and data files can share it, as they are kept apart by Key.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "go-tibia", "maptiles")
+}
+
+// Pyramid paints tiles of the map.
+type Pyramid struct {
+	m  gameworld.MapDataSource
+	th *things.Things
+
+	width, height int // of the map, in map tiles
+	maxZoom       int
+	cacheDir      string
+	key           string
+
+	group    singleflight.Group
+	rendered int64 // tiles painted at the deepest zoom level, for progress
+}
+
+// New returns a pyramid of tiles for the passed map, painted using the passed
+// things.
+func New(m gameworld.MapDataSource, th *things.Things, opts Options) *Pyramid {
+	p := &Pyramid{
+		m:        m,
+		th:       th,
+		width:    DefaultMapSize,
+		height:   DefaultMapSize,
+		cacheDir: opts.CacheDir,
+		key:      fmt.Sprintf("%s-%08x-%08x-%d", opts.MapSignature, th.TibiaDatasetSignature(), th.SpriteSetSignature(), generation),
+	}
+	if sized, ok := m.(gwmap.SizedMapDataSource); ok {
+		if w, h := sized.Dimensions(); w > 0 && h > 0 {
+			p.width, p.height = int(w), int(h)
+		}
+	}
+	for deepestMapTiles<<p.maxZoom < p.width || deepestMapTiles<<p.maxZoom < p.height {
+		p.maxZoom++
+	}
+	return p
+}
+
+// MaxZoom returns the deepest zoom level.
+func (p *Pyramid) MaxZoom() int {
+	return p.maxZoom
+}
+
+// MapSize returns the width and height of the map covered by the pyramid,
+// in map tiles.
+func (p *Pyramid) MapSize() (width, height int) {
+	return p.width, p.height
+}
+
+// Key identifies the contents of all the tiles; it changes whenever the
+// map, the data files or the way tiles are painted change.
+func (p *Pyramid) Key() string {
+	return p.key
+}
+
+// Tile returns the tile at the passed floor, zoom level and position,
+// encoded as PNG. Tile 0,0 is at the top left of the map.
+func (p *Pyramid) Tile(floor uint8, zoom, x, y int) ([]byte, error) {
+	if floor > 15 || zoom < 0 || zoom > p.maxZoom || x < 0 || y < 0 || x >= 1<<zoom || y >= 1<<zoom {
+		return nil, ErrNoTile
+	}
+	span := deepestMapTiles << (p.maxZoom - zoom)
+	if x*span >= p.width || y*span >= p.height {
+		return blankTile, nil
+	}
+
+	path := p.cachePath(floor, zoom, x, y)
+	if path != "" {
+		if b, err := os.ReadFile(path); err == nil {
+			return b, nil
+		}
+	}
+	b, err, _ := p.group.Do(fmt.Sprintf("%d/%d/%d/%d", floor, zoom, x, y), func() (interface{}, error) {
+		img, err := p.render(floor, zoom, x, y)
+		if err != nil {
+			return nil, err
+		}
+		b := blankTile
+		if img != nil {
+			buf := &bytes.Buffer{}
+			if err := png.Encode(buf, img); err != nil {
+				return nil, fmt.Errorf("encoding map tile: %v", err)
+			}
+			b = buf.Bytes()
+		}
+		if path != "" {
+			if err := writeFile(path, b); err != nil {
+				glog.Warningf("not caching map tile: %v", err)
+			}
+		}
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.([]byte), nil
+}
+
+// RenderAll paints all tiles on the passed floors into the cache, so they can
+// be served right away.
+func (p *Pyramid) RenderAll(floors []uint8) error {
+	if p.cacheDir == "" {
+		return errors.New("no cache to render map tiles into")
+	}
+	for _, floor := range floors {
+		glog.Infof("rendering map tiles of floor %d", floor)
+		if _, err := p.Tile(floor, 0, 0, 0); err != nil {
+			return fmt.Errorf("rendering map tiles of floor %d: %w", floor, err)
+		}
+	}
+	glog.Infof("rendered %d map tiles at zoom level %d", atomic.LoadInt64(&p.rendered), p.maxZoom)
+	return nil
+}
+
+// render paints the tile, returning nil if it is blank.
+func (p *Pyramid) render(floor uint8, zoom, x, y int) (*image.RGBA, error) {
+	if zoom == p.maxZoom {
+		return p.renderArea(floor, x*deepestMapTiles, y*deepestMapTiles), nil
+	}
+
+	// Put the four tiles below together, then halve them.
+	mosaic := image.NewRGBA(image.Rect(0, 0, 2*TileSize, 2*TileSize))
+	blank := true
+	for dy := 0; dy < 2; dy++ {
+		for dx := 0; dx < 2; dx++ {
+			b, err := p.Tile(floor, zoom+1, 2*x+dx, 2*y+dy)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(b, blankTile) {
+				continue
+			}
+			img, err := png.Decode(bytes.NewReader(b))
+			if err != nil {
+				return nil, fmt.Errorf("decoding map tile %d/%d/%d/%d: %v", floor, zoom+1, 2*x+dx, 2*y+dy, err)
+			}
+			draw.Draw(mosaic, image.Rect(dx*TileSize, dy*TileSize, (dx+1)*TileSize, (dy+1)*TileSize), img, image.ZP, draw.Src)
+			blank = false
+		}
+	}
+	if blank {
+		return nil, nil
+	}
+	return halve(mosaic), nil
+}
+
+// renderArea paints the map tiles in a tile at the deepest zoom level,
+// starting with the passed map tile. It returns nil if there is nothing to
+// paint.
+func (p *Pyramid) renderArea(floor uint8, mx, my int) *image.RGBA {
+	x0, y0 := mx-margin, my-margin
+	if x0 < 0 {
+		x0 = 0
+	}
+	if y0 < 0 {
+		y0 = 0
+	}
+	x1, y1 := mx+deepestMapTiles+margin, my+deepestMapTiles+margin
+	if x1 > 0x10000 {
+		x1 = 0x10000
+	}
+	if y1 > 0x10000 {
+		y1 = 0x10000
+	}
+	if p.empty(floor, x0, y0, x1, y1) {
+		return nil
+	}
+
+	n := atomic.AddInt64(&p.rendered, 1)
+	if n%1000 == 0 {
+		glog.Infof("rendered %d map tiles", n)
+	}
+	area := compositor.CompositeMapArea(p.m, p.th, uint16(x0), uint16(y0), floor, floor, x1-x0, y1-y0, 32, 32)
+	img := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
+	draw.Draw(img, img.Bounds(), area, image.Pt((mx-x0)*32, (my-y0)*32), draw.Src)
+	return img
+}
+
+// empty returns whether none of the map tiles in the passed area have items.
+func (p *Pyramid) empty(floor uint8, x0, y0, x1, y1 int) bool {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			t, err := p.m.GetMapTile(uint16(x), uint16(y), floor)
+			if err != nil {
+				continue
+			}
+			if _, err := t.GetItem(0); err == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// halve returns the passed image at half its size, averaging each 2x2 block
+// of pixels.
+func halve(src *image.RGBA) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx()/2, b.Dy()/2))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			var sum [4]int
+			for _, off := range [4]int{
+				src.PixOffset(2*x, 2*y), src.PixOffset(2*x+1, 2*y),
+				src.PixOffset(2*x, 2*y+1), src.PixOffset(2*x+1, 2*y+1),
+			} {
+				for c := 0; c < 4; c++ {
+					sum[c] += int(src.Pix[off+c])
+				}
+			}
+			off := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[off+c] = uint8(sum[c] / 4)
+			}
+		}
+	}
+	return dst
+}
+
+// cachePath returns where the tile is cached, or an empty string if tiles
+// are not cached.
+func (p *Pyramid) cachePath(floor uint8, zoom, x, y int) string {
+	if p.cacheDir == "" {
+		return ""
+	}
+	return filepath.Join(p.cacheDir, p.key, strconv.Itoa(int(floor)), strconv.Itoa(zoom), strconv.Itoa(x), strconv.Itoa(y)+".png")
+}
+
+// writeFile writes the file such that it is never seen partially written.
+func writeFile(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// blankTile is a fully transparent tile, encoded as PNG.
+var blankTile = func() []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}()
@@ -0,0 +1,109 @@
+package maptiles
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+// emptyMap is a map of the passed size without any tiles.
+type emptyMap struct {
+	gameworld.MapDataSource
+	w, h uint16
+}
+
+func (m emptyMap) GetMapTile(x, y uint16, floor uint8) (gameworld.MapTile, error) {
+	return nil, errors.New("no tiles on empty map")
+}
+
+func (m emptyMap) Dimensions() (width, height uint16) {
+	return m.w, m.h
+}
+
+func emptyThings(t *testing.T) *things.Things {
+	th, err := things.New()
+	if err != nil {
+		t.Fatalf("creating things registry: %v", err)
+	}
+	return th
+}
+
+func TestMaxZoom(t *testing.T) {
+	for _, tc := range []struct {
+		w, h uint16
+		want int
+	}{
+		{8, 8, 0},
+		{9, 1, 1},
+		{500, 300, 6},
+		{2048, 2048, 8},
+		{0, 0, 7}, // DefaultMapSize
+	} {
+		if got := New(emptyMap{w: tc.w, h: tc.h}, emptyThings(t), Options{}).MaxZoom(); got != tc.want {
+			t.Errorf("max zoom of %dx%d map is %d, want %d", tc.w, tc.h, got, tc.want)
+		}
+	}
+}
+
+func TestBlankTiles(t *testing.T) {
+	p := New(emptyMap{w: 500, h: 500}, emptyThings(t), Options{CacheDir: t.TempDir(), MapSignature: "test"})
+	if err := p.RenderAll([]uint8{7}); err != nil {
+		t.Fatalf("rendering all tiles: %v", err)
+	}
+	b, err := p.Tile(7, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	if !bytes.Equal(b, blankTile) {
+		t.Errorf("tile of empty map is not blank")
+	}
+	if _, err := os.Stat(p.cachePath(7, 0, 0, 0)); err != nil {
+		t.Errorf("tile not cached: %v", err)
+	}
+
+	for _, tc := range []struct {
+		floor      uint8
+		zoom, x, y int
+	}{
+		{16, 0, 0, 0},
+		{7, -1, 0, 0},
+		{7, p.MaxZoom() + 1, 0, 0},
+		{7, 1, 2, 0},
+		{7, 1, 0, -1},
+	} {
+		if _, err := p.Tile(tc.floor, tc.zoom, tc.x, tc.y); err != ErrNoTile {
+			t.Errorf("tile %+v: got error %v, want %v", tc, err, ErrNoTile)
+		}
+	}
+
+	if err := New(emptyMap{w: 500, h: 500}, emptyThings(t), Options{}).RenderAll([]uint8{7}); err == nil {
+		t.Errorf("rendering all tiles without a cache succeeded")
+	}
+}
+
+func TestHalve(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	src.Set(0, 0, color.RGBA{200, 0, 0, 255})
+	src.Set(1, 1, color.RGBA{0, 100, 0, 255})
+	src.Set(2, 0, color.RGBA{40, 40, 40, 255})
+	src.Set(3, 0, color.RGBA{40, 40, 40, 255})
+	src.Set(2, 1, color.RGBA{40, 40, 40, 255})
+	src.Set(3, 1, color.RGBA{40, 40, 40, 255})
+
+	dst := halve(src)
+	if got, want := dst.Bounds().Size(), image.Pt(2, 1); got != want {
+		t.Fatalf("halved size %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{50, 25, 0, 127}); got != want {
+		t.Errorf("pixel 0,0 is %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(1, 0), (color.RGBA{40, 40, 40, 255}); got != want {
+		t.Errorf("pixel 1,0 is %v, want %v", got, want)
+	}
+}
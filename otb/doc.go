@@ -1,4 +1,4 @@
-// Package otb reads in the 'OpenTibia Binary' format.
+// Package otb reads and writes the 'OpenTibia Binary' format.
 //
 // This format is used in items.otb specifying item attributes, as well as a
 // mapping from a client ID to a persistent server ID.
@@ -0,0 +1,332 @@
+package itemsotb
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Speed returns the speed of walking over the item, if it is a ground. If it
+// is not set, zero is returned.
+func (i *Item) Speed() uint16 {
+	val, _ := i.uint16Attribute(ITEM_ATTR_SPEED)
+	return val
+}
+
+// Light returns the light the item emits. If the item does not emit light,
+// a zero Light is returned.
+func (i *Item) Light() Light {
+	switch val := i.Attributes[ITEM_ATTR_LIGHT2].(type) {
+	case Light:
+		return val
+	case []byte:
+		if len(val) == 4 {
+			return Light{LightLevel: binary.LittleEndian.Uint16(val), LightColor: binary.LittleEndian.Uint16(val[2:])}
+		}
+	}
+	return Light{}
+}
+
+// TopOrder returns the order in which the item is stacked on top of other
+// items, and whether it is set at all. Borders have 1, other items which are
+// always on top (see FLAG_ALWAYSONTOP) have 2 or 3.
+func (i *Item) TopOrder() (uint8, bool) {
+	switch val := i.Attributes[ITEM_ATTR_TOPORDER].(type) {
+	case uint8:
+		return val, true
+	case []byte:
+		if len(val) == 1 {
+			return val[0], true
+		}
+	}
+	return 0, false
+}
+
+// WareID returns the server ID of the item traded on the market in place of
+// this one. Files for clients before 9.x do not have it, and zero is
+// returned.
+func (i *Item) WareID() uint16 {
+	val, _ := i.uint16Attribute(ITEM_ATTR_WAREID)
+	return val
+}
+
+// MinimapColor returns the color of the item on the minimap, as an index
+// into the 8-bit palette of the client. If it is not set, zero is returned.
+func (i *Item) MinimapColor() uint16 {
+	val, _ := i.uint16Attribute(ITEM_ATTR_MINIMAPCOLOR)
+	return val
+}
+
+// SpriteHash returns the hash of the sprites of the item, used by item
+// editors to match items across client versions. If it is not set, nil is
+// returned.
+func (i *Item) SpriteHash() []byte {
+	val, _ := i.Attributes[ITEM_ATTR_SPRITEHASH].([]byte)
+	return val
+}
+
+// OTBName returns the name of the item as stored in items.otb, without
+// consulting items.xml. Most files do not store it, in which case an empty
+// string is returned.
+func (i *Item) OTBName() string {
+	val, _ := i.Attributes[ITEM_ATTR_NAME].(string)
+	return val
+}
+
+// OTBDescription returns the description of the item as stored in
+// items.otb, without consulting items.xml. Most files do not store it, in
+// which case an empty string is returned.
+func (i *Item) OTBDescription() string {
+	val, _ := i.Attributes[ITEM_ATTR_DESCR].(string)
+	return val
+}
+
+// The attributes below are deprecated: only files made for old clients store
+// them, and items.xml describes the same properties for newer ones. They are
+// kept as bytes when read, and decoded by their accessors using the layouts
+// of the editors which wrote them.
+
+// Weapon represents the data structure of the ITEM_ATTR_WEAPON and
+// ITEM_ATTR_WEAPON2 attributes.
+type Weapon struct {
+	WeaponType   uint8
+	AmmoType     uint8
+	ShootType    uint8
+	Attribute    uint8
+	AttributeMod uint8
+}
+
+// Ammunition represents the data structure of the ITEM_ATTR_AMU and
+// ITEM_ATTR_AMU2 attributes.
+type Ammunition struct {
+	AmmoType     uint8
+	ShootType    uint8
+	Attribute    uint8
+	AttributeMod uint8
+}
+
+// Armor represents the data structure of the ITEM_ATTR_ARMOR and
+// ITEM_ATTR_ARMOR2 attributes.
+type Armor struct {
+	Armor        uint16
+	Weight       float64
+	SlotPosition uint16
+}
+
+// Writeable represents the data structure of the ITEM_ATTR_WRITEABLE,
+// ITEM_ATTR_WRITEABLE2 and ITEM_ATTR_WRITEABLE3 attributes. Only the last
+// stores MaxTextLen.
+type Writeable struct {
+	ReadOnlyID uint16
+	MaxTextLen uint16
+}
+
+// Decay represents the data structure of the ITEM_ATTR_DECAY and
+// ITEM_ATTR_DECAY2 attributes.
+type Decay struct {
+	DecayTo   uint16
+	DecayTime uint16
+}
+
+// Slot returns the slot the item is worn in, as stored in old items.otb
+// files. If it is not set, zero is returned.
+func (i *Item) Slot() uint16 {
+	val, _ := i.uint16Attribute(ITEM_ATTR_SLOT)
+	return val
+}
+
+// MaxItems returns how many items fit into the item, if it is a container,
+// as stored in old items.otb files. If it is not set, zero is returned.
+func (i *Item) MaxItems() uint16 {
+	val, _ := i.uint16Attribute(ITEM_ATTR_MAXITEMS)
+	return val
+}
+
+// OTBWeight returns the weight of the item in ounces as stored in old
+// items.otb files, without consulting items.xml. If it is not set, zero is
+// returned.
+func (i *Item) OTBWeight() float64 {
+	var val float64
+	i.bytesAttribute(&val, ITEM_ATTR_WEIGHT)
+	return val
+}
+
+// Weapon returns what kind of weapon the item is, and whether it is set.
+func (i *Item) Weapon() (Weapon, bool) {
+	var val Weapon
+	ok := i.bytesAttribute(&val, ITEM_ATTR_WEAPON, ITEM_ATTR_WEAPON2)
+	return val, ok
+}
+
+// Ammunition returns what kind of ammunition the item is, and whether it is
+// set.
+func (i *Item) Ammunition() (Ammunition, bool) {
+	var val Ammunition
+	ok := i.bytesAttribute(&val, ITEM_ATTR_AMU, ITEM_ATTR_AMU2)
+	return val, ok
+}
+
+// Armor returns the armor the item gives when worn, and whether it is set.
+func (i *Item) Armor() (Armor, bool) {
+	var val Armor
+	ok := i.bytesAttribute(&val, ITEM_ATTR_ARMOR, ITEM_ATTR_ARMOR2)
+	return val, ok
+}
+
+// MagicLevel returns the magic level needed to use the item. If it is not
+// set, zero is returned.
+func (i *Item) MagicLevel() uint16 {
+	val, _ := i.uint16Attribute(ITEM_ATTR_MAGLEVEL)
+	return val
+}
+
+// MagicFieldType returns the kind of magic field the item is. If it is not
+// set, zero is returned.
+func (i *Item) MagicFieldType() uint8 {
+	var val uint8
+	i.bytesAttribute(&val, ITEM_ATTR_MAGFIELDTYPE)
+	return val
+}
+
+// Writeable returns how text can be written onto the item, and whether it is
+// set.
+func (i *Item) Writeable() (Writeable, bool) {
+	var val Writeable
+	if i.bytesAttribute(&val, ITEM_ATTR_WRITEABLE3) {
+		return val, true
+	}
+	ok := i.bytesAttribute(&val.ReadOnlyID, ITEM_ATTR_WRITEABLE, ITEM_ATTR_WRITEABLE2)
+	return val, ok
+}
+
+// RotateTo returns the server ID of the item this one turns into when
+// rotated. If it is not set, zero is returned.
+func (i *Item) RotateTo() uint16 {
+	val, _ := i.uint16Attribute(ITEM_ATTR_ROTATETO)
+	return val
+}
+
+// Decay returns what the item decays into and after how long, and whether it
+// is set.
+func (i *Item) Decay() (Decay, bool) {
+	var val Decay
+	ok := i.bytesAttribute(&val, ITEM_ATTR_DECAY, ITEM_ATTR_DECAY2)
+	return val, ok
+}
+
+// bytesAttribute decodes the first of the passed attributes kept as bytes of
+// the size of val into val, and returns whether one was.
+func (i *Item) bytesAttribute(val interface{}, attrs ...ItemsAttribute) bool {
+	for _, attr := range attrs {
+		b, ok := i.Attributes[attr].([]byte)
+		if !ok || len(b) != binary.Size(val) {
+			continue
+		}
+		return binary.Read(bytes.NewReader(b), binary.LittleEndian, val) == nil
+	}
+	return false
+}
+
+// uint16Attribute returns a 16-bit attribute, whether decoded or kept as
+// bytes, and whether it is set.
+func (i *Item) uint16Attribute(attr ItemsAttribute) (uint16, bool) {
+	switch val := i.Attributes[attr].(type) {
+	case uint16:
+		return val, true
+	case []byte:
+		if len(val) == 2 {
+			return binary.LittleEndian.Uint16(val), true
+		}
+	}
+	return 0, false
+}
+
+// Has returns whether all of the passed flags are set.
+func (f ItemsFlags) Has(flags ItemsFlags) bool {
+	return f&flags == flags
+}
+
+// BlockSolid returns whether creatures cannot walk onto the item.
+func (f ItemsFlags) BlockSolid() bool { return f.Has(FLAG_BLOCK_SOLID) }
+
+// BlockProjectile returns whether the item stops missiles and spells.
+func (f ItemsFlags) BlockProjectile() bool { return f.Has(FLAG_BLOCK_PROJECTILE) }
+
+// BlockPathfind returns whether paths are not found across the item, even
+// though it can be walked onto, such as with fields.
+func (f ItemsFlags) BlockPathfind() bool { return f.Has(FLAG_BLOCK_PATHFIND) }
+
+// HasHeight returns whether items on top of the item are drawn raised.
+func (f ItemsFlags) HasHeight() bool { return f.Has(FLAG_HAS_HEIGHT) }
+
+// Useable returns whether the item can be used.
+func (f ItemsFlags) Useable() bool { return f.Has(FLAG_USEABLE) }
+
+// Pickupable returns whether the item can be picked up.
+func (f ItemsFlags) Pickupable() bool { return f.Has(FLAG_PICKUPABLE) }
+
+// Moveable returns whether the item can be moved.
+func (f ItemsFlags) Moveable() bool { return f.Has(FLAG_MOVEABLE) }
+
+// Stackable returns whether items of this kind stack, carrying a count.
+func (f ItemsFlags) Stackable() bool { return f.Has(FLAG_STACKABLE) }
+
+// FloorChangeDown returns whether stepping onto the item moves a creature to
+// the floor below.
+func (f ItemsFlags) FloorChangeDown() bool { return f.Has(FLAG_FLOORCHANGEDOWN) }
+
+// FloorChangeNorth returns whether stepping onto the item moves a creature
+// to the floor above, to the north.
+func (f ItemsFlags) FloorChangeNorth() bool { return f.Has(FLAG_FLOORCHANGENORTH) }
+
+// FloorChangeEast returns whether stepping onto the item moves a creature to
+// the floor above, to the east.
+func (f ItemsFlags) FloorChangeEast() bool { return f.Has(FLAG_FLOORCHANGEEAST) }
+
+// FloorChangeSouth returns whether stepping onto the item moves a creature
+// to the floor above, to the south.
+func (f ItemsFlags) FloorChangeSouth() bool { return f.Has(FLAG_FLOORCHANGESOUTH) }
+
+// FloorChangeWest returns whether stepping onto the item moves a creature to
+// the floor above, to the west.
+func (f ItemsFlags) FloorChangeWest() bool { return f.Has(FLAG_FLOORCHANGEWEST) }
+
+// AlwaysOnTop returns whether the item is stacked above other items; see
+// Item.TopOrder.
+func (f ItemsFlags) AlwaysOnTop() bool { return f.Has(FLAG_ALWAYSONTOP) }
+
+// Readable returns whether the item carries text which can be read.
+func (f ItemsFlags) Readable() bool { return f.Has(FLAG_READABLE) }
+
+// Rotatable returns whether the item can be turned into another item.
+func (f ItemsFlags) Rotatable() bool { return f.Has(FLAG_ROTABLE) }
+
+// Hangable returns whether the item can be hung on walls.
+func (f ItemsFlags) Hangable() bool { return f.Has(FLAG_HANGABLE) }
+
+// Vertical returns whether the item is a wall running north to south, onto
+// which items can be hung.
+func (f ItemsFlags) Vertical() bool { return f.Has(FLAG_VERTICAL) }
+
+// Horizontal returns whether the item is a wall running west to east, onto
+// which items can be hung.
+func (f ItemsFlags) Horizontal() bool { return f.Has(FLAG_HORIZONTAL) }
+
+// CannotDecay returns whether the item never decays.
+func (f ItemsFlags) CannotDecay() bool { return f.Has(FLAG_CANNOTDECAY) }
+
+// AllowDistRead returns whether the text on the item can be read from afar.
+func (f ItemsFlags) AllowDistRead() bool { return f.Has(FLAG_ALLOWDISTREAD) }
+
+// ClientCharges returns whether the item carries charges shown by the
+// client. It is only set in older files.
+func (f ItemsFlags) ClientCharges() bool { return f.Has(FLAG_CLIENTCHARGES) }
+
+// LookThrough returns whether looking at the tile skips the item.
+func (f ItemsFlags) LookThrough() bool { return f.Has(FLAG_LOOKTHROUGH) }
+
+// Animation returns whether the item is animated.
+func (f ItemsFlags) Animation() bool { return f.Has(FLAG_ANIMATION) }
+
+// WalkStack returns whether creatures can walk over the item even when it
+// is stacked on other items.
+func (f ItemsFlags) WalkStack() bool { return f.Has(FLAG_WALKSTACK) }
@@ -1,5 +1,10 @@
-// Package itemsotb reads in an items.otb file.
+// Package itemsotb reads, edits and writes items.otb files.
 //
 // Top level OTB node's data represents version header, and its children
-// represents individual items.
+// represents individual items. Files for clients from 7.50 through 10.98 are
+// read.
+//
+// Attributes of items are stored in Item.Attributes; accessors such as
+// Item.Speed and Item.Light return them typed, and ItemsFlags has a getter
+// for each of its bits.
 package itemsotb
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"badc0de.net/pkg/go-tibia/otb"
@@ -28,6 +29,8 @@ type Items struct {
 
 	MinClientID, MaxClientID uint16
 	MinServerID, MaxServerID uint16
+
+	rootFlags uint32 // Kept only to be written back.
 }
 
 type (
@@ -69,6 +72,43 @@ const (
 	CLIENT_VERSION_861                     = ClientVersion(21)
 	CLIENT_VERSION_862                     = ClientVersion(22)
 	CLIENT_VERSION_870                     = ClientVersion(23)
+	CLIENT_VERSION_871                     = ClientVersion(24)
+	CLIENT_VERSION_872                     = ClientVersion(25)
+	CLIENT_VERSION_873                     = ClientVersion(26)
+	CLIENT_VERSION_900                     = ClientVersion(27)
+	CLIENT_VERSION_910                     = ClientVersion(28)
+	CLIENT_VERSION_920                     = ClientVersion(29)
+	CLIENT_VERSION_940                     = ClientVersion(30)
+	CLIENT_VERSION_944_V1                  = ClientVersion(31)
+	CLIENT_VERSION_944_V2                  = ClientVersion(32)
+	CLIENT_VERSION_944_V3                  = ClientVersion(33)
+	CLIENT_VERSION_944_V4                  = ClientVersion(34)
+	CLIENT_VERSION_946                     = ClientVersion(35)
+	CLIENT_VERSION_950                     = ClientVersion(36)
+	CLIENT_VERSION_952                     = ClientVersion(37)
+	CLIENT_VERSION_953                     = ClientVersion(38)
+	CLIENT_VERSION_954                     = ClientVersion(39)
+	CLIENT_VERSION_960                     = ClientVersion(40)
+	CLIENT_VERSION_961                     = ClientVersion(41)
+	CLIENT_VERSION_963                     = ClientVersion(42)
+	CLIENT_VERSION_970                     = ClientVersion(43)
+	CLIENT_VERSION_980                     = ClientVersion(44)
+	CLIENT_VERSION_981                     = ClientVersion(45)
+	CLIENT_VERSION_982                     = ClientVersion(46)
+	CLIENT_VERSION_983                     = ClientVersion(47)
+	CLIENT_VERSION_985                     = ClientVersion(48)
+	CLIENT_VERSION_986                     = ClientVersion(49)
+	CLIENT_VERSION_1010                    = ClientVersion(50)
+	CLIENT_VERSION_1020                    = ClientVersion(51)
+	CLIENT_VERSION_1021                    = ClientVersion(52)
+	CLIENT_VERSION_1030                    = ClientVersion(53)
+	CLIENT_VERSION_1031                    = ClientVersion(54)
+	CLIENT_VERSION_1035                    = ClientVersion(55)
+	CLIENT_VERSION_1076                    = ClientVersion(56)
+	CLIENT_VERSION_1098                    = ClientVersion(57)
+
+	CLIENT_VERSION_FIRST  = CLIENT_VERSION_750
+	CLIENT_VERSION_LATEST = CLIENT_VERSION_1098
 )
 
 // Enumeration containing recognized protocol versions for which a particular
@@ -124,6 +164,74 @@ func (v ClientVersion) String() string {
 		return "8.62"
 	case CLIENT_VERSION_870:
 		return "8.70"
+	case CLIENT_VERSION_871:
+		return "8.71"
+	case CLIENT_VERSION_872:
+		return "8.72"
+	case CLIENT_VERSION_873:
+		return "8.73"
+	case CLIENT_VERSION_900:
+		return "9.00"
+	case CLIENT_VERSION_910:
+		return "9.10"
+	case CLIENT_VERSION_920:
+		return "9.20"
+	case CLIENT_VERSION_940:
+		return "9.40"
+	case CLIENT_VERSION_944_V1:
+		return "9.44 (v1)"
+	case CLIENT_VERSION_944_V2:
+		return "9.44 (v2)"
+	case CLIENT_VERSION_944_V3:
+		return "9.44 (v3)"
+	case CLIENT_VERSION_944_V4:
+		return "9.44 (v4)"
+	case CLIENT_VERSION_946:
+		return "9.46"
+	case CLIENT_VERSION_950:
+		return "9.50"
+	case CLIENT_VERSION_952:
+		return "9.52"
+	case CLIENT_VERSION_953:
+		return "9.53"
+	case CLIENT_VERSION_954:
+		return "9.54"
+	case CLIENT_VERSION_960:
+		return "9.60"
+	case CLIENT_VERSION_961:
+		return "9.61"
+	case CLIENT_VERSION_963:
+		return "9.63"
+	case CLIENT_VERSION_970:
+		return "9.70"
+	case CLIENT_VERSION_980:
+		return "9.80"
+	case CLIENT_VERSION_981:
+		return "9.81"
+	case CLIENT_VERSION_982:
+		return "9.82"
+	case CLIENT_VERSION_983:
+		return "9.83"
+	case CLIENT_VERSION_985:
+		return "9.85"
+	case CLIENT_VERSION_986:
+		return "9.86"
+	case CLIENT_VERSION_1010:
+		return "10.10"
+	case CLIENT_VERSION_1020:
+		return "10.20"
+	case CLIENT_VERSION_1021:
+		return "10.21"
+	case CLIENT_VERSION_1030:
+		return "10.30"
+	case CLIENT_VERSION_1031:
+		return "10.31"
+	case CLIENT_VERSION_1035:
+		return "10.35"
+	case CLIENT_VERSION_1076:
+		return "10.76"
+	case CLIENT_VERSION_1098:
+		return "10.98"
 	}
 	return fmt.Sprintf("client version %d unknown", v)
 }
@@ -323,6 +431,7 @@ const (
 	ITEM_ATTR_LIGHT2
 	ITEM_ATTR_TOPORDER
 	ITEM_ATTR_WRITEABLE3 // deprecated
+	ITEM_ATTR_WAREID     // 9.x onwards; the item traded on the market in its place
 	ITEM_ATTR_LAST
 )
 
@@ -388,6 +497,8 @@ func (a ItemsAttribute) String() string {
 		return "toporder"
 	case ITEM_ATTR_WRITEABLE3:
 		return "writeable3"
+	case ITEM_ATTR_WAREID:
+		return "wareid"
 	case ITEM_ATTR_LAST:
 		return "last (invalid value)"
 	default:
@@ -419,6 +530,25 @@ func (v ItemsVersion) CSDVersionAsString() string {
 	return stringFromCStr(v.CSDVersion[:])
 }
 
+// check returns an error if items.otb files of this version cannot be read.
+func (v ItemsVersion) check() error {
+	// Major versions 1 and 2 are older revisions of the format. Their item
+	// nodes are laid out the same, but may carry attributes which were since
+	// deprecated; those are kept as raw bytes.
+	if v.MajorVersion < 1 || v.MajorVersion > 3 {
+		return fmt.Errorf("unsupported itemsotb major version: got %d, want [%d, %d]", v.MajorVersion, 1, 3)
+	}
+	if v.MinorVersion < CLIENT_VERSION_FIRST || v.MinorVersion > CLIENT_VERSION_LATEST {
+		return fmt.Errorf("unsupported itemsotb client version: got %d, want [%d, %d]", v.MinorVersion, CLIENT_VERSION_FIRST, CLIENT_VERSION_LATEST)
+	}
+	switch v.MinorVersion {
+	case CLIENT_VERSION_854_BAD, CLIENT_VERSION_860_OLD:
+		// Files for these were superseded by ones under the next number.
+		glog.Warningf("items.otb for %s was superseded by a later file for the same client", v.MinorVersion)
+	}
+	return nil
+}
+
 // stringFromCStr turns a byte slice representing a null-terminated C-style
 // string into a Go string.
 func stringFromCStr(cstr []byte) string {
@@ -430,20 +560,14 @@ func stringFromCStr(cstr []byte) string {
 }
 
 // New reads an OTB file from a given reader.
-func New(r io.ReadSeeker) (*Items, error) {
+func New(r io.Reader) (*Items, error) {
 	f, err := otb.NewOTB(r)
 	if err != nil {
 		return nil, fmt.Errorf("newitemsotb failed to use fileloader: %s", err)
 	}
 
 	otb := Items{
-		OTB:                  *f,
-		ClientIDToArrayIndex: make(map[uint16]int),
-		ServerIDToArrayIndex: make(map[uint16]int),
-		MinClientID:          0xFFFF, // largest 16bit int; we reduce it below
-		MinServerID:          19999,  // 20000 is where other descriptions may begin, like fluids
-
-		ServerIDToExtantClientItemArrayIDXs: make(map[uint16]int),
+		OTB: *f,
 	}
 
 	root := otb.ChildNode(nil)
@@ -456,7 +580,7 @@ func New(r io.ReadSeeker) (*Items, error) {
 	if err := binary.Read(props, binary.LittleEndian, &flags); err != nil {
 		return nil, fmt.Errorf("error reading itemsotb root node flags: %v", err)
 	}
-	flags = flags // seemingly unused
+	otb.rootFlags = flags // seemingly unused
 
 	var attr ItemsAttribute
 	if err := binary.Read(props, binary.LittleEndian, &attr); err != nil {
@@ -475,16 +599,8 @@ func New(r io.ReadSeeker) (*Items, error) {
 		glog.V(2).Infof("items.otb version %d.%d.%d, csd %s", vers.Version.MajorVersion, vers.Version.MinorVersion, vers.Version.BuildNumber, stringFromCStr(vers.Version.CSDVersion[:]))
 		if vers.Version.MajorVersion == 0xFFFFFFFF {
 			glog.Warning("generic items.otb found, skipping version check")
-		} else {
-			if vers.Version.MajorVersion != 3 {
-				return nil, fmt.Errorf("unsupported itemsotb major version: got %d, want %d", vers.Version.MajorVersion, 3)
-			}
-
-			minVersion := CLIENT_VERSION_854 // development dat files are 8.54
-			maxVersion := CLIENT_VERSION_870 // reference source code was 8.70
-			if vers.Version.MinorVersion < minVersion || vers.Version.MinorVersion > maxVersion {
-				return nil, fmt.Errorf("unsupported itemsotb major version: got %d, want [%d, %d]", vers.Version.MinorVersion, minVersion, maxVersion)
-			}
+		} else if err := vers.Version.check(); err != nil {
+			return nil, err
 		}
 		otb.Version = vers.Version
 	default:
@@ -496,44 +612,63 @@ func New(r io.ReadSeeker) (*Items, error) {
 	}
 
 	for node := otb.ChildNode(root); node != nil; node = node.NextNode() {
-		if item, err := otb.readChildNode(node); err == nil {
-			if id, ok := item.Attributes[ITEM_ATTR_CLIENTID]; ok {
-				id := id.(uint16)
-				otb.ClientIDToArrayIndex[id] = len(otb.Items)
-				if id < otb.MinClientID {
-					otb.MinClientID = id
-				}
-				if id > otb.MaxClientID {
-					otb.MaxClientID = id
-				}
-				otb.ExtantClientItemIDs = append(otb.ExtantClientItemIDs, id)
-				otb.ExtantClientItemArrayIdxs = append(otb.ExtantClientItemArrayIdxs, len(otb.Items))
-			}
-			if id, ok := item.Attributes[ITEM_ATTR_SERVERID]; ok {
-				id := id.(uint16)
-				otb.ServerIDToArrayIndex[id] = len(otb.Items)
-				if id < otb.MinServerID {
-					otb.MinServerID = id
-				}
-				if id > otb.MaxServerID {
-					otb.MaxServerID = id
-				}
-				otb.ExtantServerItemIDs = append(otb.ExtantServerItemIDs, id)
-				otb.ExtantServerItemArrayIdxs = append(otb.ExtantServerItemArrayIdxs, len(otb.Items))
-				if _, ok := item.Attributes[ITEM_ATTR_CLIENTID]; ok {
-					otb.ServerIDToExtantClientItemArrayIDXs[id] = len(otb.ExtantClientItemArrayIdxs) - 1
-				}
-				// TODO(ivucica): we should detect duplicate server IDs (duplicate client IDs are, theoretically, permissible)
-			}
-			// TODO(ivucica): main OTB loader could give us a count of child nodes, and we could use that to preallocate space instead of appending all the time
-			otb.Items = append(otb.Items, *item)
-		} else {
+		item, err := otb.readChildNode(node)
+		if err != nil {
 			return nil, err
 		}
+		// TODO(ivucica): main OTB loader could give us a count of child nodes, and we could use that to preallocate space instead of appending all the time
+		otb.Items = append(otb.Items, *item)
 	}
+	otb.Reindex()
 	return &otb, nil
 }
 
+// Reindex rebuilds the lookups of items by their IDs, along with the ranges
+// of IDs, from Items. It needs to be called after Items is changed other than
+// through AddItem, UpdateItem and RemoveItem.
+func (otb *Items) Reindex() {
+	otb.ClientIDToArrayIndex = make(map[uint16]int)
+	otb.ServerIDToArrayIndex = make(map[uint16]int)
+	otb.ServerIDToExtantClientItemArrayIDXs = make(map[uint16]int)
+	otb.ExtantClientItemIDs = nil
+	otb.ExtantServerItemIDs = nil
+	otb.ExtantClientItemArrayIdxs = nil
+	otb.ExtantServerItemArrayIdxs = nil
+	otb.MinClientID, otb.MaxClientID = 0xFFFF, 0 // largest 16bit int; we reduce it below
+	otb.MinServerID, otb.MaxServerID = 19999, 0  // 20000 is where other descriptions may begin, like fluids
+
+	for idx, item := range otb.Items {
+		if id, ok := item.Attributes[ITEM_ATTR_CLIENTID]; ok {
+			id := id.(uint16)
+			otb.ClientIDToArrayIndex[id] = idx
+			if id < otb.MinClientID {
+				otb.MinClientID = id
+			}
+			if id > otb.MaxClientID {
+				otb.MaxClientID = id
+			}
+			otb.ExtantClientItemIDs = append(otb.ExtantClientItemIDs, id)
+			otb.ExtantClientItemArrayIdxs = append(otb.ExtantClientItemArrayIdxs, idx)
+		}
+		if id, ok := item.Attributes[ITEM_ATTR_SERVERID]; ok {
+			id := id.(uint16)
+			otb.ServerIDToArrayIndex[id] = idx
+			if id < otb.MinServerID {
+				otb.MinServerID = id
+			}
+			if id > otb.MaxServerID {
+				otb.MaxServerID = id
+			}
+			otb.ExtantServerItemIDs = append(otb.ExtantServerItemIDs, id)
+			otb.ExtantServerItemArrayIdxs = append(otb.ExtantServerItemArrayIdxs, idx)
+			if _, ok := item.Attributes[ITEM_ATTR_CLIENTID]; ok {
+				otb.ServerIDToExtantClientItemArrayIDXs[id] = len(otb.ExtantClientItemArrayIdxs) - 1
+			}
+			// TODO(ivucica): we should detect duplicate server IDs (duplicate client IDs are, theoretically, permissible)
+		}
+	}
+}
+
 // readChildNode reads a single "OTB node", as read from an OTB file.
 func (*Items) readChildNode(node *otb.OTBNode) (*Item, error) {
 	props := node.PropsBuffer()
@@ -556,7 +691,14 @@ func (*Items) readChildNode(node *otb.OTBNode) (*Item, error) {
 			return nil, fmt.Errorf("error reading itemsotb child node data len: %v", err)
 		}
 		switch attr {
-		case ITEM_ATTR_SERVERID: // TODO: max is 20000
+		case ITEM_ATTR_MINIMAPCOLOR, ITEM_ATTR_WAREID:
+			if datalen != 2 {
+				// Not a known layout; keep the bytes as they are.
+				item.Attributes[attr] = props.Next(int(datalen))
+				break
+			}
+			fallthrough
+		case ITEM_ATTR_SERVERID: // up to 20000 before 9.x, as fluids are described above it
 			fallthrough
 		case ITEM_ATTR_CLIENTID:
 			fallthrough
@@ -578,6 +720,8 @@ func (*Items) readChildNode(node *otb.OTBNode) (*Item, error) {
 				return nil, fmt.Errorf("error reading itemsotb child node 1b attribute %d: %v", attr, err)
 			}
 			item.Attributes[attr] = val
+		case ITEM_ATTR_NAME, ITEM_ATTR_DESCR:
+			item.Attributes[attr] = string(props.Next(int(datalen)))
 		case ITEM_ATTR_LIGHT2:
 			if datalen != 4 {
 				return nil, fmt.Errorf("invalid attribute %d size: got %d, want %d", attr, datalen, 4)
@@ -592,6 +736,7 @@ func (*Items) readChildNode(node *otb.OTBNode) (*Item, error) {
 			// however let's pretend it's useful to store them in the map
 			item.Attributes[attr] = props.Next(int(datalen))
 		}
+		item.attrOrder = append(item.attrOrder, attr)
 	}
 	return &item, nil
 }
@@ -625,6 +770,10 @@ type Item struct {
 
 	// TODO(ivucica): Consider making XML data public or merging it into OTB data.
 	xml *xmlItem
+
+	// attrOrder is the order of attributes in the file the item was read
+	// from, so that unchanged items are written back the same.
+	attrOrder []ItemsAttribute
 }
 
 // Name returns the name of the item. This may be sourced from XML, if loaded.
@@ -663,6 +812,17 @@ func (i *Item) Description() string {
 	return ""
 }
 
+// Weight returns the weight of the item in hundredths of an ounce, as stored in
+// items.xml. If XML was not loaded, or the item has no weight, zero is
+// returned.
+func (i *Item) Weight() int {
+	if i.xml == nil || len(i.xml.Attributes["weight"]) == 0 {
+		return 0
+	}
+	weight, _ := strconv.Atoi(i.xml.Attributes["weight"][0])
+	return weight
+}
+
 // ClientID returns the item client ID for the client version for which this OTB
 // is intended. If the item does not exist in this client version, zero is
 // returned.
@@ -685,6 +845,64 @@ func (i *Item) ServerID() uint16 {
 	return id.(uint16)
 }
 
+// NewItem returns an item of the passed group with the passed IDs, to be
+// added to items with AddItem.
+func NewItem(group ItemGroup, serverID, clientID uint16) Item {
+	return Item{
+		Group: group,
+		Attributes: map[ItemsAttribute]interface{}{
+			ITEM_ATTR_SERVERID: serverID,
+			ITEM_ATTR_CLIENTID: clientID,
+		},
+	}
+}
+
+// SetClientID changes the client ID of the item. If the item is among Items,
+// it needs to be passed to UpdateItem afterwards, or Reindex called.
+func (i *Item) SetClientID(clientID uint16) {
+	i.setAttribute(ITEM_ATTR_CLIENTID, clientID)
+}
+
+// SetSpeed changes the speed of walking over the item, if it is a ground.
+func (i *Item) SetSpeed(speed uint16) {
+	i.setAttribute(ITEM_ATTR_SPEED, speed)
+}
+
+// SetLight changes the light the item emits.
+func (i *Item) SetLight(light Light) {
+	i.setAttribute(ITEM_ATTR_LIGHT2, light)
+}
+
+// SetTopOrder changes the order in which the item is stacked on top of
+// other items; see ITEM_ATTR_TOPORDER.
+func (i *Item) SetTopOrder(topOrder uint8) {
+	i.setAttribute(ITEM_ATTR_TOPORDER, topOrder)
+}
+
+// SetName changes the name of the item stored in items.otb. Names from
+// items.xml take precedence over it.
+func (i *Item) SetName(name string) {
+	i.setAttribute(ITEM_ATTR_NAME, name)
+}
+
+// SetMinimapColor changes the color of the item on the minimap.
+func (i *Item) SetMinimapColor(color uint16) {
+	i.setAttribute(ITEM_ATTR_MINIMAPCOLOR, color)
+}
+
+// SetWareID changes the server ID of the item traded on the market in place
+// of this one.
+func (i *Item) SetWareID(wareID uint16) {
+	i.setAttribute(ITEM_ATTR_WAREID, wareID)
+}
+
+func (i *Item) setAttribute(attr ItemsAttribute, val interface{}) {
+	if i.Attributes == nil {
+		i.Attributes = make(map[ItemsAttribute]interface{})
+	}
+	i.Attributes[attr] = val
+}
+
 // Light represents the data structure describing a lit-up item's light attribute
 // ITEM_ATTR_LIGHT2, as stored in an items.otb file.
 type Light struct {
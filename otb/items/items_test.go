@@ -1,6 +1,7 @@
 package itemsotb
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -20,7 +21,7 @@ func TestNew(t *testing.T) {
 	}
 
 	ttesting.AssertEqualUint32(t, "correct major version", otb.Version.MajorVersion, 3)
-	ttesting.AssertInRangeUint32(t, "correct minor version", uint32(otb.Version.MinorVersion), uint32(CLIENT_VERSION_854), uint32(CLIENT_VERSION_870))
+	ttesting.AssertInRangeUint32(t, "correct minor version", uint32(otb.Version.MinorVersion), uint32(CLIENT_VERSION_FIRST), uint32(CLIENT_VERSION_LATEST))
 	type expectedCounts struct {
 		Items                int
 		ClientIDToArrayIndex int
@@ -56,3 +57,160 @@ func TestNew(t *testing.T) {
 		}
 	})
 }
+
+func TestVersions(t *testing.T) {
+	for _, tc := range []struct {
+		major   uint32
+		client  ClientVersion
+		wantErr bool
+	}{
+		{1, CLIENT_VERSION_750, false},
+		{2, CLIENT_VERSION_800, false},
+		{3, CLIENT_VERSION_854_BAD, false},
+		{3, CLIENT_VERSION_944_V2, false},
+		{3, CLIENT_VERSION_1098, false},
+		{0xFFFFFFFF, ClientVersion(1234), false},
+		{0, CLIENT_VERSION_854, true},
+		{4, CLIENT_VERSION_854, true},
+		{3, ClientVersion(0), true},
+		{3, CLIENT_VERSION_LATEST + 1, true},
+	} {
+		t.Run(fmt.Sprintf("%d.%d", tc.major, tc.client), func(t *testing.T) {
+			items := &Items{Version: ItemsVersion{MajorVersion: tc.major, MinorVersion: tc.client}}
+			items.AddItem(NewItem(ITEM_GROUP_GROUND, 100, 100))
+			buf := &bytes.Buffer{}
+			if err := items.Save(buf); err != nil {
+				t.Fatalf("saving otb: %v", err)
+			}
+			loaded, err := New(buf)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("loading otb for %s: got error %v, want error: %v", tc.client, err, tc.wantErr)
+			}
+			if err == nil && loaded.Version != items.Version {
+				t.Errorf("loaded version %+v, want %+v", loaded.Version, items.Version)
+			}
+		})
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	items := &Items{Version: ItemsVersion{MajorVersion: 3, MinorVersion: CLIENT_VERSION_1098}}
+	item := NewItem(ITEM_GROUP_NONE, 2160, 3043)
+	item.Flags = FLAG_PICKUPABLE | FLAG_MOVEABLE | FLAG_STACKABLE
+	item.SetSpeed(0)
+	item.SetLight(Light{LightLevel: 2, LightColor: 215})
+	item.SetTopOrder(0)
+	item.SetWareID(2160)
+	item.SetMinimapColor(129)
+	item.SetName("crystal coin")
+	item.Attributes[ITEM_ATTR_DESCR] = "shiny"
+	item.Attributes[ITEM_ATTR_SPRITEHASH] = []byte{1, 2, 3, 4}
+	items.AddItem(item)
+
+	// An item as described by files for old clients.
+	old := NewItem(ITEM_GROUP_NONE, 2400, 2400)
+	old.Attributes[ITEM_ATTR_SLOT] = []byte{6, 0}
+	old.Attributes[ITEM_ATTR_MAXITEMS] = []byte{20, 0}
+	old.Attributes[ITEM_ATTR_WEIGHT] = []byte{0, 0, 0, 0, 0, 0, 0x49, 0x40} // 50.0
+	old.Attributes[ITEM_ATTR_WEAPON2] = []byte{1, 2, 3, 4, 5}
+	old.Attributes[ITEM_ATTR_AMU] = []byte{6, 7, 8, 9}
+	old.Attributes[ITEM_ATTR_ARMOR2] = []byte{8, 0, 0, 0, 0, 0, 0, 0, 0x59, 0x40, 4, 0} // 8, 100.0, 4
+	old.Attributes[ITEM_ATTR_MAGLEVEL] = []byte{15, 0}
+	old.Attributes[ITEM_ATTR_MAGFIELDTYPE] = []byte{2}
+	old.Attributes[ITEM_ATTR_WRITEABLE] = []byte{0x70, 0x0B}
+	old.Attributes[ITEM_ATTR_ROTATETO] = []byte{0x61, 0x09}
+	old.Attributes[ITEM_ATTR_DECAY2] = []byte{0x62, 0x09, 60, 0}
+	items.AddItem(old)
+	items.AddItem(NewItem(ITEM_GROUP_GROUND, 100, 100))
+
+	buf := &bytes.Buffer{}
+	if err := items.Save(buf); err != nil {
+		t.Fatalf("saving otb: %v", err)
+	}
+	loaded, err := New(buf)
+	if err != nil {
+		t.Fatalf("loading saved otb: %v", err)
+	}
+
+	coin, err := loaded.ItemByServerID(2160)
+	if err != nil {
+		t.Fatalf("loaded coin not found: %v", err)
+	}
+	if got := coin.Light(); got != (Light{LightLevel: 2, LightColor: 215}) {
+		t.Errorf("Light() = %+v", got)
+	}
+	if got, ok := coin.TopOrder(); got != 0 || !ok {
+		t.Errorf("TopOrder() = %d, %v; want 0, true", got, ok)
+	}
+	if got := coin.WareID(); got != 2160 {
+		t.Errorf("WareID() = %d, want 2160", got)
+	}
+	if got := coin.MinimapColor(); got != 129 {
+		t.Errorf("MinimapColor() = %d, want 129", got)
+	}
+	if got := coin.OTBName(); got != "crystal coin" {
+		t.Errorf("OTBName() = %q", got)
+	}
+	if got := coin.OTBDescription(); got != "shiny" {
+		t.Errorf("OTBDescription() = %q", got)
+	}
+	if got := coin.SpriteHash(); !bytes.Equal(got, []byte{1, 2, 3, 4}) {
+		t.Errorf("SpriteHash() = %x", got)
+	}
+	if !coin.Flags.Pickupable() || !coin.Flags.Stackable() || coin.Flags.BlockSolid() || !coin.Flags.Has(FLAG_PICKUPABLE|FLAG_MOVEABLE) {
+		t.Errorf("flags %s", coin.Flags)
+	}
+
+	oldItem, err := loaded.ItemByServerID(2400)
+	if err != nil {
+		t.Fatalf("loaded old item not found: %v", err)
+	}
+	if oldItem.Slot() != 6 || oldItem.MaxItems() != 20 || oldItem.OTBWeight() != 50 || oldItem.MagicLevel() != 15 || oldItem.MagicFieldType() != 2 || oldItem.RotateTo() != 2401 {
+		t.Errorf("old item has slot %d, max items %d, weight %v, magic level %d, magic field type %d, rotates to %d; want 6, 20, 50, 15, 2, 2401",
+			oldItem.Slot(), oldItem.MaxItems(), oldItem.OTBWeight(), oldItem.MagicLevel(), oldItem.MagicFieldType(), oldItem.RotateTo())
+	}
+	if got, ok := oldItem.Weapon(); got != (Weapon{1, 2, 3, 4, 5}) || !ok {
+		t.Errorf("Weapon() = %+v, %v", got, ok)
+	}
+	if got, ok := oldItem.Ammunition(); got != (Ammunition{6, 7, 8, 9}) || !ok {
+		t.Errorf("Ammunition() = %+v, %v", got, ok)
+	}
+	if got, ok := oldItem.Armor(); got != (Armor{Armor: 8, Weight: 100, SlotPosition: 4}) || !ok {
+		t.Errorf("Armor() = %+v, %v", got, ok)
+	}
+	if got, ok := oldItem.Writeable(); got != (Writeable{ReadOnlyID: 2928}) || !ok {
+		t.Errorf("Writeable() = %+v, %v", got, ok)
+	}
+	if got, ok := oldItem.Decay(); got != (Decay{DecayTo: 2402, DecayTime: 60}) || !ok {
+		t.Errorf("Decay() = %+v, %v", got, ok)
+	}
+
+	ground, err := loaded.ItemByServerID(100)
+	if err != nil {
+		t.Fatalf("loaded ground not found: %v", err)
+	}
+	if _, ok := ground.TopOrder(); ok {
+		t.Errorf("ground has a top order")
+	}
+	if ground.Speed() != 0 || ground.WareID() != 0 || ground.Light() != (Light{}) || ground.OTBName() != "" || ground.SpriteHash() != nil {
+		t.Errorf("ground has unset attributes: %+v", ground)
+	}
+	if _, ok := ground.Decay(); ok || ground.OTBWeight() != 0 || ground.Slot() != 0 {
+		t.Errorf("ground has unset deprecated attributes: %+v", ground)
+	}
+
+	// Attributes of an unknown layout are kept as bytes.
+	ground.Attributes[ITEM_ATTR_MINIMAPCOLOR] = []byte{1, 2, 3}
+	if got := ground.MinimapColor(); got != 0 {
+		t.Errorf("MinimapColor() of 3 bytes = %d, want 0", got)
+	}
+	buf.Reset()
+	if err := loaded.Save(buf); err != nil {
+		t.Fatalf("saving loaded otb: %v", err)
+	}
+	if again, err := New(buf); err != nil {
+		t.Fatalf("loading otb again: %v", err)
+	} else if got, _ := again.ItemByServerID(100); !bytes.Equal(got.Attributes[ITEM_ATTR_MINIMAPCOLOR].([]byte), []byte{1, 2, 3}) {
+		t.Errorf("minimap color of 3 bytes read back as %v", got.Attributes[ITEM_ATTR_MINIMAPCOLOR])
+	}
+}
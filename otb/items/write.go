@@ -0,0 +1,141 @@
+package itemsotb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"badc0de.net/pkg/go-tibia/otb"
+)
+
+// AddItem adds the passed item, which needs a server ID not used by any
+// other item.
+func (otb *Items) AddItem(item Item) error {
+	id := item.ServerID()
+	if id == 0 {
+		return fmt.Errorf("item without server id cannot be added")
+	}
+	if _, ok := otb.ServerIDToArrayIndex[id]; ok {
+		return fmt.Errorf("item with server id %d already exists", id)
+	}
+	otb.Items = append(otb.Items, item)
+	otb.Reindex()
+	return nil
+}
+
+// UpdateItem replaces the item with the same server ID as the passed item.
+//
+// Items returned by ItemByServerID and ItemByClientID can also be changed in
+// place, as long as their IDs are not changed.
+func (otb *Items) UpdateItem(item Item) error {
+	idx, ok := otb.ServerIDToArrayIndex[item.ServerID()]
+	if !ok {
+		return fmt.Errorf("item not found with server id: %d", item.ServerID())
+	}
+	otb.Items[idx] = item
+	otb.Reindex()
+	return nil
+}
+
+// RemoveItem removes the item with the passed server ID. Items previously
+// returned by ItemByServerID and ItemByClientID must not be used afterwards.
+func (otb *Items) RemoveItem(serverID uint16) error {
+	idx, ok := otb.ServerIDToArrayIndex[serverID]
+	if !ok {
+		return fmt.Errorf("item not found with server id: %d", serverID)
+	}
+	otb.Items = append(otb.Items[:idx], otb.Items[idx+1:]...)
+	otb.Reindex()
+	return nil
+}
+
+// Save writes the items into the passed writer as an items.otb file, with
+// the version in Version.
+func (items *Items) Save(w io.Writer) error {
+	ow, err := otb.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := ow.StartNode(0); err != nil {
+		return err
+	}
+	root := struct {
+		Flags    uint32
+		Attr     uint8
+		DataSize ItemsDataSize
+		Version  ItemsVersion
+	}{items.rootFlags, ROOT_ATTR_VERSION, 4 + 4 + 4 + 128, items.Version}
+	if err := binary.Write(ow, binary.LittleEndian, root); err != nil {
+		return fmt.Errorf("error writing itemsotb root node: %v", err)
+	}
+	for i := range items.Items {
+		if err := items.Items[i].write(ow); err != nil {
+			return fmt.Errorf("error writing itemsotb item %d: %w", items.Items[i].ServerID(), err)
+		}
+	}
+	if err := ow.EndNode(); err != nil {
+		return err
+	}
+	return ow.Flush()
+}
+
+// write writes the item as a node.
+func (i *Item) write(w *otb.Writer) error {
+	if i.Group < 0 || i.Group >= otb.ESCAPE_CHAR {
+		return fmt.Errorf("invalid item group %d", i.Group)
+	}
+	if err := w.StartNode(uint8(i.Group)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, i.Flags); err != nil {
+		return err
+	}
+
+	// Attributes go in the order they were read in; new ones follow, in the
+	// order of their IDs.
+	var order []ItemsAttribute
+	seen := map[ItemsAttribute]bool{}
+	for _, attr := range i.attrOrder {
+		if _, ok := i.Attributes[attr]; ok && !seen[attr] {
+			order = append(order, attr)
+			seen[attr] = true
+		}
+	}
+	var added []ItemsAttribute
+	for attr := range i.Attributes {
+		if !seen[attr] {
+			added = append(added, attr)
+		}
+	}
+	sort.Slice(added, func(a, b int) bool { return added[a] < added[b] })
+	order = append(order, added...)
+
+	for _, attr := range order {
+		data := &bytes.Buffer{}
+		switch val := i.Attributes[attr].(type) {
+		case string:
+			data.WriteString(val)
+		case []byte:
+			data.Write(val)
+		case uint8, uint16, Light:
+			binary.Write(data, binary.LittleEndian, val)
+		default:
+			return fmt.Errorf("attribute %s has a value of unsupported type %T", attr, val)
+		}
+		if data.Len() > 0xFFFF {
+			return fmt.Errorf("attribute %s of %d bytes is too long", attr, data.Len())
+		}
+		if err := binary.Write(w, binary.LittleEndian, attr); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, ItemsDataSize(data.Len())); err != nil {
+			return err
+		}
+		if _, err := w.Write(data.Bytes()); err != nil {
+			return err
+		}
+	}
+	return w.EndNode()
+}
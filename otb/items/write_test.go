@@ -0,0 +1,108 @@
+package itemsotb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/paths"
+)
+
+func TestSaveRoundTrip(t *testing.T) {
+	f, err := paths.Open("items.otb")
+	if err != nil {
+		t.Skipf("skipping because no file: %v", err)
+	}
+	buf, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("reading otb: %v", err)
+	}
+	items, err := New(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("failed to parse otb: %s", err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := items.Save(out); err != nil {
+		t.Fatalf("saving otb: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), buf) {
+		t.Errorf("saved otb of %d bytes differs from the %d bytes read", out.Len(), len(buf))
+	}
+}
+
+func TestEdit(t *testing.T) {
+	items := &Items{Version: ItemsVersion{MajorVersion: 3, MinorVersion: CLIENT_VERSION_860, BuildNumber: 7}}
+	copy(items.Version.CSDVersion[:], "OTB 3.20.7-8.60")
+
+	ground := NewItem(ITEM_GROUP_GROUND, 100, 200)
+	ground.SetSpeed(150)
+	torch := NewItem(ITEM_GROUP_NONE, 101, 201)
+	torch.Flags = FLAG_PICKUPABLE | FLAG_MOVEABLE
+	torch.SetLight(Light{LightLevel: 7, LightColor: 206})
+	torch.SetName("torch")
+	for _, item := range []Item{ground, torch, NewItem(ITEM_GROUP_NONE, 102, 202)} {
+		if err := items.AddItem(item); err != nil {
+			t.Fatalf("adding item %d: %v", item.ServerID(), err)
+		}
+	}
+	if err := items.AddItem(NewItem(ITEM_GROUP_NONE, 101, 300)); err == nil {
+		t.Errorf("adding item with a duplicate server id succeeded")
+	}
+
+	border := NewItem(ITEM_GROUP_NONE, 102, 203)
+	border.SetTopOrder(1)
+	if err := items.UpdateItem(border); err != nil {
+		t.Fatalf("updating item: %v", err)
+	}
+	if err := items.RemoveItem(100); err != nil {
+		t.Fatalf("removing item: %v", err)
+	}
+	if err := items.RemoveItem(100); err == nil {
+		t.Errorf("removing a removed item succeeded")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := items.Save(buf); err != nil {
+		t.Fatalf("saving otb: %v", err)
+	}
+	loaded, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("loading saved otb: %v", err)
+	}
+
+	if loaded.Version != items.Version || loaded.Version.CSDVersionAsString() != "OTB 3.20.7-8.60" {
+		t.Errorf("loaded version %+v, want %+v", loaded.Version, items.Version)
+	}
+	if len(loaded.Items) != 2 || loaded.MinServerID != 101 || loaded.MaxServerID != 102 {
+		t.Fatalf("loaded %d items with server ids %d-%d, want 2 items 101-102", len(loaded.Items), loaded.MinServerID, loaded.MaxServerID)
+	}
+	item, err := loaded.ItemByClientID(201)
+	if err != nil {
+		t.Fatalf("loaded torch not found: %v", err)
+	}
+	if item.Flags != torch.Flags || item.Name() != "torch" || !reflect.DeepEqual(item.Attributes[ITEM_ATTR_LIGHT2], torch.Attributes[ITEM_ATTR_LIGHT2]) {
+		t.Errorf("loaded torch %+v, want %+v", item, torch)
+	}
+	item, err = loaded.ItemByServerID(102)
+	if err != nil {
+		t.Fatalf("loaded border not found: %v", err)
+	}
+	if item.ClientID() != 203 || item.Attributes[ITEM_ATTR_TOPORDER] != uint8(1) {
+		t.Errorf("loaded border %+v, want %+v", item, border)
+	}
+	if _, err := loaded.ItemByClientID(202); err == nil {
+		t.Errorf("client id changed by update still found")
+	}
+
+	// Unchanged items are written back the same.
+	again := &bytes.Buffer{}
+	if err := loaded.Save(again); err != nil {
+		t.Fatalf("saving loaded otb: %v", err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Errorf("saving loaded otb wrote % x, want % x", again.Bytes(), buf.Bytes())
+	}
+}
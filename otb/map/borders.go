@@ -0,0 +1,95 @@
+package otbm
+
+import (
+	"badc0de.net/pkg/go-tibia/autoborder"
+)
+
+// PlaceBorders replaces the borders on all tiles of the map with the borders
+// the passed brushes place around the grounds next to each tile, and returns
+// the number of tiles whose borders changed.
+//
+// Tiles are created where there is no tile but the brushes place a border
+// next to a ground, such as on the edges of an island floating in the void.
+// Tiles whose ground is not placed by any of the brushes are left alone, as
+// their borders were placed by hand.
+func (m *Map) PlaceBorders(b *autoborder.Brushes) (int, error) {
+	defer m.lock()()
+	if err := m.loadAll(); err != nil {
+		return 0, err
+	}
+
+	groundAt := func(p pos, dx, dy int) uint16 {
+		x, y := int(p.X())+dx, int(p.Y())+dy
+		if x < 0 || y < 0 || x > 0xFFFF || y > 0xFFFF {
+			return 0
+		}
+		t := m.tileAt(posFromCoord(uint16(x), uint16(y), p.Floor()))
+		if t == nil || t.ground() == nil {
+			return 0
+		}
+		return t.ground().GetServerType()
+	}
+
+	// Borders may be needed on all tiles, and on positions next to them.
+	tiles := m.sortedTiles()
+	candidates := make(map[pos]bool, len(tiles))
+	for _, t := range tiles {
+		candidates[t.ownPos] = true
+		if t.ground() == nil {
+			continue
+		}
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				x, y := int(t.ownPos.X())+dx, int(t.ownPos.Y())+dy
+				if x >= 0 && y >= 0 && x <= 0xFFFF && y <= 0xFFFF {
+					candidates[posFromCoord(uint16(x), uint16(y), t.ownPos.Floor())] = true
+				}
+			}
+		}
+	}
+
+	changed := 0
+	for p := range candidates {
+		want := b.Borders(func(dx, dy int) uint16 { return groundAt(p, dx, dy) })
+		t := m.tileAt(p)
+		if t == nil {
+			if len(want) == 0 {
+				continue
+			}
+			t = m.createTile(p)
+		} else if g := t.ground(); g != nil && !b.IsGround(g.GetServerType()) {
+			continue
+		}
+
+		var kept []*mapItem
+		var had []uint16
+		for _, item := range t.items {
+			if b.IsBorder(item.GetServerType()) {
+				had = append(had, item.GetServerType())
+			} else {
+				kept = append(kept, item)
+			}
+		}
+		if sameIDs(had, want) {
+			continue
+		}
+		t.items = kept
+		for _, id := range want {
+			t.addItem(m.newItem(id, 0, nil))
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+func sameIDs(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
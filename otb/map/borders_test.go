@@ -0,0 +1,104 @@
+package otbm
+
+import (
+	"reflect"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/autoborder"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+// Items used on maps with borders.
+const (
+	borderGrass = 500
+	borderSand  = 501
+	borderTree  = 502
+	borderStone = 503 // a ground not placed by any brush
+)
+
+// borderItems returns the items of a border, each edge's item being first+i
+// for the edge at index i of the border edge names.
+func borderItems(first int, edges ...string) []xmls.BorderItem {
+	var items []xmls.BorderItem
+	for i, e := range edges {
+		items = append(items, xmls.BorderItem{Edge: e, Item: first + i})
+	}
+	return items
+}
+
+func TestPlaceBorders(t *testing.T) {
+	borders := xmls.Borders{Border: []xmls.Border{
+		{ID: 1, Item: borderItems(510, "n", "e", "s", "w", "cnw", "cne", "csw", "cse", "dnw", "dne", "dsw", "dse")},
+		{ID: 2, Item: borderItems(530, "n", "e", "s", "w")},
+	}}
+	grounds := xmls.Grounds{Brush: []xmls.GroundBrush{
+		{
+			Name: "grass", Type: "ground", ZOrder: 3500,
+			Item:   []xmls.BrushItem{{ID: borderGrass, Chance: 1}},
+			Border: []xmls.BrushBorder{{Align: "outer", ID: 1}, {Align: "outer", To: "none", ID: 2}},
+		},
+		{Name: "sand", Type: "ground", ZOrder: 3400, Item: []xmls.BrushItem{{ID: borderSand, Chance: 1}}},
+	}}
+	b, err := autoborder.New(borders, grounds)
+	if err != nil {
+		t.Fatalf("creating brushes: %v", err)
+	}
+
+	groups := map[uint16]itemsotb.ItemGroup{
+		borderGrass: itemsotb.ITEM_GROUP_GROUND,
+		borderSand:  itemsotb.ITEM_GROUP_GROUND,
+		borderTree:  itemsotb.ITEM_GROUP_NONE,
+		borderStone: itemsotb.ITEM_GROUP_GROUND,
+	}
+	for id := uint16(510); id < 534; id++ {
+		groups[id] = itemsotb.ITEM_GROUP_NONE
+	}
+	m := newMap(syntheticThings(t, groups, nil))
+
+	// Grass in the middle of sand, with a stale border and a tree next to it.
+	for y := uint16(10); y <= 12; y++ {
+		for x := uint16(10); x <= 12; x++ {
+			m.createTile(posFromCoord(x, y, 7)).addItem(m.newItem(borderSand, 0, nil))
+		}
+	}
+	m.tileAt(posFromCoord(11, 11, 7)).addItem(m.newItem(borderGrass, 0, nil))
+	m.tileAt(posFromCoord(11, 10, 7)).addItem(m.newItem(borderTree, 0, nil))
+	m.tileAt(posFromCoord(11, 10, 7)).addItem(m.newItem(513, 0, nil))
+	// Grass alone in the void, next to stone bordered by hand.
+	m.createTile(posFromCoord(20, 20, 7)).addItem(m.newItem(borderGrass, 0, nil))
+	m.createTile(posFromCoord(20, 21, 7)).addItem(m.newItem(borderStone, 0, nil))
+	m.tileAt(posFromCoord(20, 21, 7)).addItem(m.newItem(531, 0, nil))
+
+	changed, err := m.PlaceBorders(b)
+	if err != nil {
+		t.Fatalf("placing borders: %v", err)
+	}
+	if want := 8 + 3; changed != want {
+		t.Errorf("placing borders changed %d tiles, want %d", changed, want)
+	}
+
+	for _, tc := range []struct {
+		x, y uint16
+		want []uint16
+	}{
+		{10, 10, []uint16{borderSand, 517}},
+		{11, 10, []uint16{borderSand, borderTree, 512}},
+		{12, 11, []uint16{borderSand, 513}},
+		{11, 11, []uint16{borderGrass}},
+		{13, 11, nil},
+		{21, 20, []uint16{533}},
+		{20, 19, []uint16{532}},
+		{21, 21, nil},
+		{20, 20, []uint16{borderGrass}},
+		{20, 21, []uint16{borderStone, 531}},
+	} {
+		if got := itemIDs(m, tc.x, tc.y, 7); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tile at %d,%d has items %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+
+	if changed, err := m.PlaceBorders(b); err != nil || changed != 0 {
+		t.Errorf("placing borders again changed %d tiles, error %v; want none", changed, err)
+	}
+}
@@ -0,0 +1,107 @@
+package otbm
+
+import (
+	"fmt"
+	"sort"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"github.com/golang/glog"
+)
+
+// Tiles are stored in chunks of chunkSize x chunkSize tiles on a single floor,
+// so that a lookup costs a single small map access plus an array index, and
+// so that large maps do not need a map entry per tile.
+const (
+	chunkBits = 5
+	chunkSize = 1 << chunkBits
+	chunkMask = chunkSize - 1
+)
+
+// mapChunk holds the tiles in a single chunk. Positions without a tile are
+// nil.
+type mapChunk struct {
+	tiles [chunkSize * chunkSize]*mapTile
+}
+
+// chunkKey returns the position identifying the chunk containing the passed
+// position, along with the index of the position within the chunk.
+func chunkKey(p pos) (pos, int) {
+	return posFromCoord(p.X()&^chunkMask, p.Y()&^chunkMask, p.Floor()), int(p.Y()&chunkMask)<<chunkBits | int(p.X()&chunkMask)
+}
+
+// tileAt returns the tile at the passed position, or nil if the map has no
+// tile there.
+func (m *Map) tileAt(p pos) *mapTile {
+	key, idx := chunkKey(p)
+	c, ok := m.chunks[key]
+	if !ok {
+		return nil
+	}
+	return c.tiles[idx]
+}
+
+// createTile creates an empty tile at the passed position, replacing any tile
+// previously there.
+func (m *Map) createTile(p pos) *mapTile {
+	key, idx := chunkKey(p)
+	c, ok := m.chunks[key]
+	if !ok {
+		c = &mapChunk{}
+		m.chunks[key] = c
+	}
+	if c.tiles[idx] == nil {
+		m.tileCount++
+	}
+	t := &mapTile{parent: m, ownPos: p}
+	c.tiles[idx] = t
+	return t
+}
+
+// sortedTiles returns all the tiles on the map, ordered by floor, then by
+// row, then by column.
+func (m *Map) sortedTiles() []*mapTile {
+	tiles := make([]*mapTile, 0, m.tileCount)
+	for _, c := range m.chunks {
+		for _, t := range c.tiles {
+			if t != nil {
+				tiles = append(tiles, t)
+			}
+		}
+	}
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].ownPos < tiles[j].ownPos })
+	return tiles
+}
+
+// voidTile is returned for positions where the map has no tile, so that
+// looking at empty parts of the map does not allocate. A single void tile is
+// shared by all such positions; adding a creature to it creates a real tile
+// at the creature's position.
+type voidTile struct {
+	parent *Map
+}
+
+func (t *voidTile) String() string {
+	return "<void tile>"
+}
+
+func (t *voidTile) GetItem(idx int) (gameworld.MapItem, error) {
+	return nil, gameworld.ItemNotFound
+}
+
+func (t *voidTile) GetCreature(idx int) (gameworld.Creature, error) {
+	return nil, gameworld.CreatureNotFound
+}
+
+func (t *voidTile) AddCreature(c gameworld.Creature) error {
+	p := posFromCoord(c.GetPos().X, c.GetPos().Y, c.GetPos().Floor)
+	defer t.parent.lock()()
+	if t.parent.tileAt(p) != nil {
+		return fmt.Errorf("adding creature %d to void tile at %s, where there is a tile", c.GetID(), p)
+	}
+	return t.parent.createTile(p).AddCreature(c)
+}
+
+func (t *voidTile) RemoveCreature(c gameworld.Creature) error {
+	glog.Warningf("removing creature %d from void tile at %d %d %d", c.GetID(), c.GetPos().X, c.GetPos().Y, c.GetPos().Floor)
+	return nil
+}
@@ -0,0 +1,234 @@
+package otbm
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+// Items used on generated maps.
+const (
+	testGround    = 100
+	testGround2   = 101
+	testItem      = 200
+	testContainer = 300
+)
+
+func testThings(t testing.TB) *things.Things {
+	return syntheticThings(t, map[uint16]itemsotb.ItemGroup{
+		testGround:    itemsotb.ITEM_GROUP_GROUND,
+		testGround2:   itemsotb.ITEM_GROUP_GROUND,
+		testItem:      itemsotb.ITEM_GROUP_NONE,
+		testContainer: itemsotb.ITEM_GROUP_CONTAINER,
+	}, nil)
+}
+
+func TestChunks(t *testing.T) {
+	m := newMap(testThings(t))
+	ps := []pos{
+		posFromCoord(0, 0, 0),
+		posFromCoord(chunkSize-1, chunkSize-1, 7),
+		posFromCoord(chunkSize, chunkSize-1, 7),
+		posFromCoord(chunkSize-1, chunkSize, 7),
+		posFromCoord(0xFFFF, 0xFFFF, 15),
+	}
+	for _, p := range ps {
+		m.createTile(p).addItem(m.newItem(testGround, 1, nil))
+	}
+	if m.tileCount != len(ps) {
+		t.Errorf("tile count %d, want %d", m.tileCount, len(ps))
+	}
+	for _, p := range ps {
+		tile, err := m.GetMapTile(p.X(), p.Y(), p.Floor())
+		if err != nil {
+			t.Fatalf("getting tile at %s: %v", p, err)
+		}
+		if mt, ok := tile.(*mapTile); !ok || mt.ownPos != p {
+			t.Errorf("tile at %s is %v", p, tile)
+		}
+	}
+	for _, p := range []pos{posFromCoord(1, 0, 0), posFromCoord(chunkSize, chunkSize, 7), posFromCoord(0, 0, 1)} {
+		tile, err := m.GetMapTile(p.X(), p.Y(), p.Floor())
+		if err != nil {
+			t.Fatalf("getting tile at %s: %v", p, err)
+		}
+		if tile != m.void {
+			t.Errorf("tile at %s is %v, want void tile", p, tile)
+		}
+		if _, err := tile.GetItem(0); err != gameworld.ItemNotFound {
+			t.Errorf("void tile at %s has an item", p)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		m.GetMapTile(0, 0, 0)
+		m.GetMapTile(1, 0, 0)
+	}); allocs != 0 {
+		t.Errorf("getting tiles allocates %v times", allocs)
+	}
+
+	sorted := m.sortedTiles()
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i-1].ownPos >= sorted[i].ownPos {
+			t.Errorf("tiles not sorted: %s before %s", sorted[i-1].ownPos, sorted[i].ownPos)
+		}
+	}
+}
+
+func TestTileItems(t *testing.T) {
+	m := newMap(testThings(t))
+	tile := m.createTile(posFromCoord(10, 10, 7))
+	tile.addItem(m.newItem(testItem, 0, nil))
+	tile.addItem(m.newItem(testGround, 1, nil))
+	tile.addItem(m.newItem(testContainer, 0, &mapItemAttrs{actionID: 1000}))
+	tile.addItem(m.newItem(testGround2, 1, nil))
+
+	var got []uint16
+	for idx := 0; ; idx++ {
+		item, err := tile.GetItem(idx)
+		if err != nil {
+			break
+		}
+		got = append(got, item.GetServerType())
+	}
+	if want := []uint16{testGround2, testItem, testContainer}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("items %v, want %v", got, want)
+	}
+
+	// Items without attributes are shared.
+	if m.newItem(testItem, 0, nil) != tile.items[1] || m.newItem(testItem, 0, &mapItemAttrs{}) != tile.items[1] {
+		t.Errorf("item without attributes is not shared")
+	}
+	if m.newItem(testContainer, 0, &mapItemAttrs{actionID: 1000}) == tile.items[2] {
+		t.Errorf("item with attributes is shared")
+	}
+}
+
+func TestVoidTileAddCreature(t *testing.T) {
+	m := newMap(testThings(t))
+	p := tnet.Position{X: 50, Y: 60, Floor: 7}
+	cr := gameworld.BakeTestOnlyCreature(0x10000001, p, 0, 0x88, [4]things.OutfitColor{})
+
+	tile, err := m.GetMapTile(p.X, p.Y, p.Floor)
+	if err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	if err := tile.AddCreature(cr); err != nil {
+		t.Fatalf("adding creature to void tile: %v", err)
+	}
+	tile, err = m.GetMapTile(p.X, p.Y, p.Floor)
+	if err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	if got, err := tile.GetCreature(0); err != nil || got.GetID() != cr.GetID() {
+		t.Errorf("creature on tile is %v (%v), want %v", got, err, cr)
+	}
+}
+
+// generateLargeMap returns a map with size x size tiles of ground on the
+// ground floor, some of them with items, saved in the OTBM format.
+func generateLargeMap(b testing.TB, th *things.Things, size int) []byte {
+	b.Helper()
+	m := newMap(th)
+	m.header = rootHeader{Ver: OTBM_VERSION_3, Width: uint16(size), Height: uint16(size), ItemsVerMajor: 3, ItemsVerMinor: uint32(itemsotb.CLIENT_VERSION_854)}
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			tile := m.createTile(posFromCoord(uint16(x), uint16(y), 7))
+			tile.addItem(m.newItem(testGround+uint16(rnd.Intn(2)), 1, nil))
+			switch rnd.Intn(20) {
+			case 0, 1, 2:
+				tile.addItem(m.newItem(testItem, 0, nil))
+			case 3:
+				tile.addItem(m.newItem(testContainer, 0, &mapItemAttrs{actionID: 1000, contents: []*mapItem{m.newItem(testItem, 0, nil)}}))
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := m.Save(buf); err != nil {
+		b.Fatalf("saving generated map: %v", err)
+	}
+	return buf.Bytes()
+}
+
+var benchmarkSizes = []int{256, 1024}
+
+func BenchmarkLargeMapNew(b *testing.B) {
+	th := testThings(b)
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			buf := generateLargeMap(b, th, size)
+			b.SetBytes(int64(len(buf)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := New(bytes.NewReader(buf), th); err != nil {
+					b.Fatalf("loading map: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLargeMapMemory reports the memory retained by a loaded map.
+func BenchmarkLargeMapMemory(b *testing.B) {
+	th := testThings(b)
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			buf := generateLargeMap(b, th, size)
+			b.ResetTimer()
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				m, err := New(bytes.NewReader(buf), th)
+				if err != nil {
+					b.Fatalf("loading map: %v", err)
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(m)
+				retained = after.HeapAlloc - before.HeapAlloc
+			}
+			b.ReportMetric(float64(retained)/(1<<20), "MB")
+			b.ReportMetric(float64(retained)/float64(size*size), "B/tile")
+		})
+	}
+}
+
+func BenchmarkLargeMapGetMapTile(b *testing.B) {
+	th := testThings(b)
+	for _, size := range benchmarkSizes {
+		buf := generateLargeMap(b, th, size)
+		m, err := New(bytes.NewReader(buf), th)
+		if err != nil {
+			b.Fatalf("loading map: %v", err)
+		}
+		rnd := rand.New(rand.NewSource(1))
+		hits := make([]pos, 4096)
+		misses := make([]pos, 4096)
+		for i := range hits {
+			hits[i] = posFromCoord(uint16(rnd.Intn(size)), uint16(rnd.Intn(size)), 7)
+			misses[i] = posFromCoord(uint16(rnd.Intn(size)), uint16(rnd.Intn(size)), 6)
+		}
+		for name, ps := range map[string][]pos{"hit": hits, "miss": misses} {
+			b.Run(fmt.Sprintf("%dx%d/%s", size, size, name), func(b *testing.B) {
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p := ps[i%len(ps)]
+					if _, err := m.GetMapTile(p.X(), p.Y(), p.Floor()); err != nil {
+						b.Fatalf("getting tile: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
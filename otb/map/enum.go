@@ -28,6 +28,60 @@ const (
 	OTBM_WAYPOINT    MapNodeType = 0x10
 )
 
+func (t MapNodeType) String() string {
+	switch t {
+	case OTBM_ROOT:
+		return "root"
+	case OTBM_ROOTV1:
+		return "rootv1"
+	case OTBM_MAP_DATA:
+		return "map_data"
+	case OTBM_ITEM_DEF:
+		return "item_def"
+	case OTBM_TILE_AREA:
+		return "tile_area"
+	case OTBM_TILE:
+		return "tile"
+	case OTBM_ITEM:
+		return "item"
+	case OTBM_TILE_SQUARE:
+		return "tile_square"
+	case OTBM_TILE_REF:
+		return "tile_ref"
+	case OTBM_SPAWNS:
+		return "spawns"
+	case OTBM_SPAWN_AREA:
+		return "spawn_area"
+	case OTBM_MONSTER:
+		return "monster"
+	case OTBM_TOWNS:
+		return "towns"
+	case OTBM_TOWN:
+		return "town"
+	case OTBM_HOUSETILE:
+		return "housetile"
+	case OTBM_WAYPOINTS:
+		return "waypoints"
+	case OTBM_WAYPOINT:
+		return "waypoint"
+	default:
+		return fmt.Sprintf("unknown otbm node type %02x", int(t))
+	}
+}
+
+// MapVersion is the version of the OTBM format, as stored in the header of
+// the root node.
+type MapVersion uint32
+
+const (
+	OTBM_VERSION_1 MapVersion = 0 // Countable items store their count right after their ID.
+	OTBM_VERSION_2 MapVersion = 1
+	OTBM_VERSION_3 MapVersion = 2
+	OTBM_VERSION_4 MapVersion = 3
+
+	OTBM_VERSION_LATEST = OTBM_VERSION_4
+)
+
 type ItemAttribute uint8
 
 const (
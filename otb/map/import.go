@@ -0,0 +1,122 @@
+package otbm
+
+import (
+	"errors"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+// Import copies the tiles of the passed map data source into a new map, such
+// as to save generated terrain with Save and edit it in map editors.
+//
+// Tiles within width x height tiles of the top left corner of the map are
+// copied, on floors from minFloor to maxFloor. If width or height is zero,
+// the size of the map data source is used, which it then needs to know.
+//
+// Items unknown to the passed things are left out. Action and unique IDs,
+// teleport destinations, depot IDs, house doors, houses and tile flags are
+// copied where the map data source has them, as are towns and waypoints.
+func Import(src gameworld.MapDataSource, t *things.Things, width, height uint16, minFloor, maxFloor uint8) (*Map, error) {
+	if width == 0 || height == 0 {
+		sized, ok := src.(gwmap.SizedMapDataSource)
+		if !ok {
+			return nil, errors.New("size of imported map data source is not known")
+		}
+		width, height = sized.Dimensions()
+	}
+
+	m := newMap(t)
+	m.header = rootHeader{Ver: OTBM_VERSION_3, Width: width, Height: height}
+	if itemsVer, ok := t.ItemsOTBVersion(); ok {
+		m.header.ItemsVerMajor, m.header.ItemsVerMinor = itemsVer.MajorVersion, uint32(itemsVer.MinorVersion)
+	}
+	if landmarks, ok := src.(gwmap.LandmarkMapDataSource); ok {
+		m.towns = landmarks.Towns()
+		m.waypoints = landmarks.Waypoints()
+	}
+
+	for floor := int(minFloor); floor <= int(maxFloor); floor++ {
+		for y := 0; y < int(height); y++ {
+			for x := 0; x < int(width); x++ {
+				srcTile, err := src.GetMapTile(uint16(x), uint16(y), uint8(floor))
+				if err != nil {
+					continue
+				}
+				m.importTile(posFromCoord(uint16(x), uint16(y), uint8(floor)), srcTile)
+			}
+		}
+	}
+	return m, nil
+}
+
+// importTile copies a tile, unless it is empty.
+func (m *Map) importTile(p pos, srcTile gameworld.MapTile) {
+	var houseID uint32
+	if house, ok := srcTile.(gwmap.HouseMapTile); ok {
+		houseID = house.GetHouseID()
+	}
+	var flags gwmap.TileFlags
+	if flagged, ok := srcTile.(gwmap.FlaggedMapTile); ok {
+		flags = flagged.GetFlags()
+	}
+
+	var tile *mapTile
+	for idx := 0; ; idx++ {
+		srcItem, err := srcTile.GetItem(idx)
+		if err != nil {
+			break
+		}
+		item := m.importItem(srcItem)
+		if item == nil {
+			continue
+		}
+		if tile == nil {
+			tile = m.createTile(p)
+		}
+		tile.addItem(item)
+	}
+	if tile == nil && (houseID != 0 || flags != 0) {
+		tile = m.createTile(p)
+	}
+	if tile == nil {
+		return
+	}
+	tile.flags = flags
+	if houseID != 0 {
+		tile.houseID = houseID
+		m.houseTiles[houseID] = append(m.houseTiles[houseID], p)
+	}
+}
+
+// importItem returns a copy of the passed item, or nil if the item is unknown
+// to the things of the map.
+func (m *Map) importItem(srcItem gameworld.MapItem) *mapItem {
+	id := srcItem.GetServerType()
+	typ := m.itemType(id)
+	if typ.otb == nil {
+		return nil
+	}
+	var count uint16
+	if typ.countable {
+		count = srcItem.GetCount()
+	}
+
+	attrs := &mapItemAttrs{}
+	if ids, ok := srcItem.(gwmap.MapItemWithIDs); ok {
+		attrs.actionID, attrs.uniqueID = ids.GetActionID(), ids.GetUniqueID()
+	}
+	if tele, ok := srcItem.(gwmap.TeleportMapItem); ok {
+		if dest, ok := tele.GetTeleportDestination(); ok {
+			attrs.teleDest = posFromCoord(dest.X, dest.Y, dest.Floor)
+		}
+	}
+	if depot, ok := srcItem.(gwmap.DepotMapItem); ok {
+		attrs.depotID = depot.GetDepotID()
+	}
+	if door, ok := srcItem.(gwmap.HouseDoorMapItem); ok {
+		attrs.houseDoorID = door.GetHouseDoorID()
+	}
+	return m.newItem(id, count, attrs)
+}
@@ -0,0 +1,114 @@
+package otbm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/otb/items"
+)
+
+// itemIDs returns the server IDs of the items on the tile at the passed
+// position, or nil if there is no tile.
+func itemIDs(src gameworld.MapDataSource, x, y uint16, floor uint8) []uint16 {
+	t, err := src.GetMapTile(x, y, floor)
+	if err != nil {
+		return nil
+	}
+	var ids []uint16
+	for item, err := t.GetItem(0); err == nil; item, err = t.GetItem(len(ids)) {
+		ids = append(ids, item.GetServerType())
+	}
+	return ids
+}
+
+func TestImport(t *testing.T) {
+	p := gameworld.DefaultTerrainPalette
+	groups := map[uint16]itemsotb.ItemGroup{
+		p.Grass: itemsotb.ITEM_GROUP_GROUND, p.Sand: itemsotb.ITEM_GROUP_GROUND, p.Water: itemsotb.ITEM_GROUP_GROUND,
+		p.Mountain: itemsotb.ITEM_GROUP_GROUND, p.Path: itemsotb.ITEM_GROUP_GROUND, p.Bridge: itemsotb.ITEM_GROUP_GROUND,
+		p.CaveFloor: itemsotb.ITEM_GROUP_GROUND, p.Hole: itemsotb.ITEM_GROUP_GROUND, p.Stairs: itemsotb.ITEM_GROUP_NONE,
+	}
+	for _, id := range p.Trees {
+		groups[id] = itemsotb.ITEM_GROUP_NONE
+	}
+	th := syntheticThings(t, groups, nil)
+
+	src, err := gameworld.NewTerrainMapDataSource(gameworld.TerrainOptions{Seed: 3, Width: 128, Height: 128, CaveFloors: 2})
+	if err != nil {
+		t.Fatalf("creating terrain: %v", err)
+	}
+	m, err := Import(src, th, 0, 0, 0, 15)
+	if err != nil {
+		t.Fatalf("importing terrain: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := m.Save(buf); err != nil {
+		t.Fatalf("saving imported terrain: %v", err)
+	}
+	loaded, err := New(bytes.NewReader(buf.Bytes()), th)
+	if err != nil {
+		t.Fatalf("loading imported terrain: %v", err)
+	}
+
+	if w, h := loaded.Dimensions(); w != 128 || h != 128 {
+		t.Errorf("loaded map is %dx%d, want 128x128", w, h)
+	}
+	if got, want := loaded.Towns(), src.(interface{ Towns() []gameworld.Town }).Towns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded towns %+v, want %+v", got, want)
+	}
+	tiles := 0
+	for floor := uint8(0); floor <= 15; floor++ {
+		for y := uint16(0); y < 128; y++ {
+			for x := uint16(0); x < 128; x++ {
+				want := itemIDs(src, x, y, floor)
+				if got := itemIDs(loaded, x, y, floor); !reflect.DeepEqual(got, want) {
+					t.Fatalf("tile at %d,%d,%d has items %v, want %v", x, y, floor, got, want)
+				}
+				if want != nil {
+					tiles++
+				}
+			}
+		}
+	}
+	if tiles == 0 {
+		t.Errorf("no tiles imported")
+	}
+
+	// Items unknown to the things are left out, without being reported as
+	// dropped.
+	for _, id := range p.Trees {
+		delete(groups, id)
+	}
+	m, err = Import(src, syntheticThings(t, groups, nil), 0, 0, 7, 7)
+	if err != nil {
+		t.Fatalf("importing terrain without trees: %v", err)
+	}
+	trees := 0
+	for y := uint16(0); y < 128; y++ {
+		for x := uint16(0); x < 128; x++ {
+			var want []uint16
+			for _, id := range itemIDs(src, x, y, 7) {
+				if _, ok := groups[id]; ok {
+					want = append(want, id)
+				} else {
+					trees++
+				}
+			}
+			if got := itemIDs(m, x, y, 7); !reflect.DeepEqual(got, want) {
+				t.Fatalf("tile at %d,%d,7 imported without trees has items %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if trees == 0 {
+		t.Errorf("no trees left out")
+	}
+	if len(m.droppedItems) != 0 {
+		t.Errorf("trees left out on purpose were recorded as %d dropped items, which Validate reports as unknown", len(m.droppedItems))
+	}
+
+	if _, err := Import(gameworld.NewMapDataSource(), th, 0, 0, 7, 7); err == nil {
+		t.Errorf("importing map data source of unknown size succeeded")
+	}
+}
@@ -0,0 +1,174 @@
+package otbm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"badc0de.net/pkg/go-tibia/otb"
+)
+
+// indexMagic starts every index file, followed by the version of the index
+// format.
+const indexMagic = "OTBMIDX1"
+
+// Index records where the nodes of an OTBM map are within the map file, so
+// that parts of the map can be read without reading the entire file. It is
+// stored next to the map; see IndexPath.
+//
+// An index is only valid for the exact file it was built from.
+type Index struct {
+	mapSize   int64 // size of the indexed map file, to detect stale indexes
+	headerLen int64 // bytes before the first child of the map data node
+	nodes     []indexNode
+}
+
+// indexNode is a single child of the map data node, such as a tile area.
+type indexNode struct {
+	Type           MapNodeType
+	X, Y           uint16 // Only for tile areas: the base of the area.
+	Floor          uint8
+	Offset, Length int64
+}
+
+// IndexPath returns where the index of the map at the passed path is stored.
+func IndexPath(mapPath string) string {
+	return mapPath + ".idx"
+}
+
+// BuildIndex reads the OTBM map from the passed reader and indexes it.
+func BuildIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	var version uint32
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("error reading otb version: %v", err)
+	}
+
+	// Nodes are found by following the structure of the file byte by byte;
+	// the root node is at depth 1, the map data node at depth 2 and the
+	// indexed nodes at depth 3.
+	idx := &Index{headerLen: -1}
+	offset := int64(4)
+	depth := 0
+	var cur indexNode
+	var props []byte // start of the props of the current indexed node
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading otbm at offset %d: %v", offset, err)
+		}
+		offset++
+
+		switch b {
+		case otb.NODE_START:
+			nodeType, err := br.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("error reading otbm node type at offset %d: %v", offset, err)
+			}
+			offset++
+			depth++
+			if depth == 3 {
+				if idx.headerLen < 0 {
+					idx.headerLen = offset - 2
+				}
+				cur = indexNode{Type: MapNodeType(nodeType), Offset: offset - 2}
+				props = props[:0]
+			}
+		case otb.NODE_END:
+			if depth == 2 && idx.headerLen < 0 {
+				// Map without any tiles, towns or waypoints.
+				idx.headerLen = offset - 1
+			}
+			if depth == 3 {
+				cur.Length = offset - cur.Offset
+				if cur.Type == OTBM_TILE_AREA {
+					if len(props) < 5 {
+						return nil, fmt.Errorf("tile area at offset %d is missing its position", cur.Offset)
+					}
+					cur.X = binary.LittleEndian.Uint16(props[0:])
+					cur.Y = binary.LittleEndian.Uint16(props[2:])
+					cur.Floor = props[4]
+				}
+				idx.nodes = append(idx.nodes, cur)
+			}
+			depth--
+		case otb.ESCAPE_CHAR:
+			if b, err = br.ReadByte(); err != nil {
+				return nil, fmt.Errorf("error reading escaped otbm byte at offset %d: %v", offset, err)
+			}
+			offset++
+			fallthrough
+		default:
+			if depth == 3 && len(props) < 5 {
+				props = append(props, b)
+			}
+		}
+	}
+	if depth != 0 || idx.headerLen < 0 {
+		return nil, fmt.Errorf("otbm ends abruptly at offset %d", offset)
+	}
+	idx.mapSize = offset
+	return idx, nil
+}
+
+// WriteTo writes the index into the passed writer, so it can be read by
+// ReadIndex.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	header := struct {
+		Magic              [8]byte
+		MapSize, HeaderLen int64
+		Count              uint32
+	}{MapSize: idx.mapSize, HeaderLen: idx.headerLen, Count: uint32(len(idx.nodes))}
+	copy(header.Magic[:], indexMagic)
+	if err := binary.Write(cw, binary.LittleEndian, header); err != nil {
+		return cw.n, err
+	}
+	if err := binary.Write(cw, binary.LittleEndian, idx.nodes); err != nil {
+		return cw.n, err
+	}
+	return cw.n, bw.Flush()
+}
+
+// ReadIndex reads an index written by Index.WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	var header struct {
+		Magic              [8]byte
+		MapSize, HeaderLen int64
+		Count              uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("error reading otbm index header: %v", err)
+	}
+	if string(header.Magic[:]) != indexMagic {
+		return nil, fmt.Errorf("not an otbm index: got magic %q, want %q", header.Magic[:], indexMagic)
+	}
+	idx := &Index{mapSize: header.MapSize, headerLen: header.HeaderLen}
+	// Avoid trusting the count for the allocation; a corrupt index would
+	// simply fail to read.
+	for i := 0; i < int(header.Count); i++ {
+		var n indexNode
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, fmt.Errorf("error reading otbm index node %d: %v", i, err)
+		}
+		idx.nodes = append(idx.nodes, n)
+	}
+	return idx, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,190 @@
+package otbm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/otb/items"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	buf := generateLargeMap(t, testThings(t), 300)
+	idx, err := BuildIndex(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("building index: %v", err)
+	}
+	if idx.mapSize != int64(len(buf)) {
+		t.Errorf("indexed map size %d, want %d", idx.mapSize, len(buf))
+	}
+	// 300x300 tiles span 2x2 tile areas.
+	if len(idx.nodes) != 4 {
+		t.Errorf("got %d indexed nodes, want 4: %+v", len(idx.nodes), idx.nodes)
+	}
+
+	out := &bytes.Buffer{}
+	if n, err := idx.WriteTo(out); err != nil {
+		t.Fatalf("writing index: %v", err)
+	} else if n != int64(out.Len()) {
+		t.Errorf("index write reported %d bytes, wrote %d", n, out.Len())
+	}
+	got, err := ReadIndex(out)
+	if err != nil {
+		t.Fatalf("reading index: %v", err)
+	}
+	if !reflect.DeepEqual(got, idx) {
+		t.Errorf("read index %+v, want %+v", got, idx)
+	}
+
+	if _, err := ReadIndex(bytes.NewReader(buf)); err == nil {
+		t.Errorf("reading map as an index succeeded")
+	}
+}
+
+func TestNewLazy(t *testing.T) {
+	buf, err := ioutil.ReadFile("../../datafiles/range-test-map.otbm")
+	if err != nil {
+		t.Skipf("skipping because no file: %v", err)
+	}
+	th := syntheticThingsForMap(t, buf)
+	eager, err := New(bytes.NewReader(buf), th)
+	if err != nil {
+		t.Fatalf("loading map: %v", err)
+	}
+	idx, err := BuildIndex(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("building index: %v", err)
+	}
+
+	m, err := NewLazy(bytes.NewReader(buf), idx, th)
+	if err != nil {
+		t.Fatalf("loading map lazily: %v", err)
+	}
+	if m.tileCount != 0 {
+		t.Errorf("lazy map read %d tiles before use", m.tileCount)
+	}
+	if !reflect.DeepEqual(m.towns, eager.towns) {
+		t.Errorf("lazy map towns %+v, want %+v", m.towns, eager.towns)
+	}
+
+	tile, err := m.GetMapTile(100, 100, 7)
+	if err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	if tile == m.void || m.tileCount == 0 {
+		t.Errorf("getting a tile did not read its tile area")
+	}
+
+	if err := m.LoadRegion(0, 0, 0x10000, 0x10000, 0, 15); err != nil {
+		t.Fatalf("loading all regions: %v", err)
+	}
+	if len(m.lazy.pending) != 0 {
+		t.Errorf("%d tile areas still pending", len(m.lazy.pending))
+	}
+	if got, want := describeTiles(m), describeTiles(eager); !reflect.DeepEqual(got, want) {
+		t.Errorf("lazy map has %d tiles, eager map has %d; contents differ", len(got), len(want))
+	}
+
+	// The index must match the file.
+	if _, err := NewLazy(bytes.NewReader(buf[:len(buf)-1]), idx, th); err == nil {
+		t.Errorf("loading with a stale index succeeded")
+	}
+}
+
+func TestLoadRegion(t *testing.T) {
+	th := testThings(t)
+	buf := generateLargeMap(t, th, 512)
+	idx, err := BuildIndex(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("building index: %v", err)
+	}
+	m, err := NewLazy(bytes.NewReader(buf), idx, th)
+	if err != nil {
+		t.Fatalf("loading map lazily: %v", err)
+	}
+
+	// Only the two top tile areas intersect this region.
+	if err := m.LoadRegion(200, 10, 100, 100, 7, 7); err != nil {
+		t.Fatalf("loading region: %v", err)
+	}
+	if want := 2 * 256 * 256; m.tileCount != want {
+		t.Errorf("read %d tiles, want %d", m.tileCount, want)
+	}
+	if _, err := m.GetMapTile(300, 300, 7); err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	if want := 3 * 256 * 256; m.tileCount != want {
+		t.Errorf("read %d tiles, want %d", m.tileCount, want)
+	}
+
+	// Saving reads the remaining tile areas.
+	out := &bytes.Buffer{}
+	if err := m.Save(out); err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), buf) {
+		t.Errorf("saved lazy map differs from the original")
+	}
+}
+
+func TestNewLazyUnalignedArea(t *testing.T) {
+	th := testThings(t)
+	m := newMap(th)
+	m.header = rootHeader{Ver: OTBM_VERSION_3, Width: 0x400, Height: 0x400, ItemsVerMajor: 3, ItemsVerMinor: uint32(itemsotb.CLIENT_VERSION_854)}
+	for _, x := range []uint16{0x120, 0x1F0} {
+		tile := m.createTile(posFromCoord(x, 0x110, 7))
+		tile.addItem(m.newItem(testGround, 1, nil))
+		tile.addItem(m.newItem(testItem, 0, nil))
+	}
+	out := &bytes.Buffer{}
+	if err := m.Save(out); err != nil {
+		t.Fatalf("saving map: %v", err)
+	}
+	buf := out.Bytes()
+	idx, err := BuildIndex(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("building index: %v", err)
+	}
+	if len(idx.nodes) != 1 {
+		t.Fatalf("got %d indexed nodes, want 1: %+v", len(idx.nodes), idx.nodes)
+	}
+
+	// Move the tile area from 0x100 to 0x1A0, as map editors may store it, so
+	// that its tiles are at 0x1C0 and 0x290, in two different blocks.
+	buf[idx.nodes[0].Offset+2] = 0xA0
+	if idx, err = BuildIndex(bytes.NewReader(buf)); err != nil {
+		t.Fatalf("building index: %v", err)
+	}
+	lazy, err := NewLazy(bytes.NewReader(buf), idx, th)
+	if err != nil {
+		t.Fatalf("loading map lazily: %v", err)
+	}
+	tile, err := lazy.GetMapTile(0x290, 0x110, 7)
+	if err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	if item, err := tile.GetItem(0); err != nil || item.GetServerType() != testGround {
+		t.Fatalf("tile at 0x290 has ground %v (error %v), want %d", item, err, testGround)
+	}
+	if err := tile.(*mapTile).RemoveItem(1); err != nil {
+		t.Fatalf("removing item: %v", err)
+	}
+
+	// Getting a tile in the other block the tile area overlaps does not read
+	// it again, which would replace the tile changed above.
+	tile, err = lazy.GetMapTile(0x1C0, 0x110, 7)
+	if err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	if item, err := tile.GetItem(0); err != nil || item.GetServerType() != testGround {
+		t.Errorf("tile at 0x1C0 has ground %v (error %v), want %d", item, err, testGround)
+	}
+	tile, _ = lazy.GetMapTile(0x290, 0x110, 7)
+	if n := len(tile.(*mapTile).items); n != 1 {
+		t.Errorf("tile at 0x290 has %d items after reading the other block, want 1", n)
+	}
+	if lazy.tileCount != 2 {
+		t.Errorf("lazy map read %d tiles, want 2", lazy.tileCount)
+	}
+}
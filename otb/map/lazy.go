@@ -0,0 +1,210 @@
+package otbm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"badc0de.net/pkg/go-tibia/otb"
+	"badc0de.net/pkg/go-tibia/things"
+
+	"github.com/golang/glog"
+)
+
+// lazySource is where a lazily opened map reads tile areas from once they are
+// needed.
+type lazySource struct {
+	mu sync.Mutex // guards the reader, pending areas and the tiles of the map
+
+	r       io.ReadSeeker
+	pending map[pos][]indexNode // tile areas not yet read, by the 256-aligned bases of the blocks they overlap
+	read    map[int64]bool      // offsets of tile areas already read
+}
+
+// NewLazy opens the OTBM map in the passed reader using its index, reading
+// only the map's attributes, towns and waypoints. Tile areas are read once
+// tiles within them are requested, or once LoadRegion is called for them.
+//
+// The reader must remain usable for as long as the map is in use.
+//
+// Until all tile areas are read, HouseTiles only knows the house tiles in
+// tile areas read so far.
+func NewLazy(r io.ReadSeeker, idx *Index, t *things.Things) (*Map, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("error finding size of otbm: %v", err)
+	}
+	if size != idx.mapSize {
+		return nil, fmt.Errorf("otbm index is stale: it was built for a file of %d bytes, but the file has %d bytes", idx.mapSize, size)
+	}
+
+	m := newMap(t)
+	m.lazy = &lazySource{
+		r:       r,
+		pending: map[pos][]indexNode{},
+		read:    map[int64]bool{},
+	}
+
+	// Read the root and map data nodes without their children, by closing
+	// both nodes right where their children would start.
+	header := make([]byte, idx.headerLen, idx.headerLen+2)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking to otbm header: %v", err)
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading otbm header: %v", err)
+	}
+	header = append(header, otb.NODE_END, otb.NODE_END)
+	f, err := otb.NewOTB(bytes.NewReader(header))
+	if err != nil {
+		return nil, fmt.Errorf("newotbm failed to use fileloader: %s", err)
+	}
+	if err := m.readRootNode(f.ChildNode(nil)); err != nil {
+		return nil, err
+	}
+
+	for _, n := range idx.nodes {
+		if n.Type == OTBM_TILE_AREA {
+			// Tiles are stored relative to the position of their area, up to
+			// 255 tiles away, so an area not aligned to 256 tiles overlaps
+			// several of the blocks tiles are looked up by.
+			for _, base := range overlappedAreaBases(n.X, n.Y, n.Floor) {
+				m.lazy.pending[base] = append(m.lazy.pending[base], n)
+			}
+			continue
+		}
+		node, err := m.lazy.readNode(n)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.readMapDataChildNode(node); err != nil {
+			return nil, fmt.Errorf("error reading map data child node: %w", err)
+		}
+	}
+
+	if len(m.towns) == 0 {
+		glog.Warningf("no towns; players will have nowhere to appear")
+	}
+	return m, nil
+}
+
+// readNode reads the indexed node from the map file.
+func (l *lazySource) readNode(n indexNode) (*otb.OTBNode, error) {
+	buf := make([]byte, n.Length)
+	if _, err := l.r.Seek(n.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking to otbm node at offset %d: %v", n.Offset, err)
+	}
+	if _, err := io.ReadFull(l.r, buf); err != nil {
+		return nil, fmt.Errorf("error reading otbm node at offset %d: %v", n.Offset, err)
+	}
+	node, err := otb.ReadNode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing otbm node at offset %d: %w", n.Offset, err)
+	}
+	return node, nil
+}
+
+// areaBase returns the base of the 256-aligned block containing the passed
+// position, by which pending tile areas are looked up.
+func areaBase(p pos) pos {
+	return posFromCoord(p.X()&0xFF00, p.Y()&0xFF00, p.Floor())
+}
+
+// overlappedAreaBases returns the bases of the 256-aligned blocks overlapped
+// by a tile area stored at the passed position.
+func overlappedAreaBases(x, y uint16, floor uint8) []pos {
+	xs, ys := []uint16{x & 0xFF00}, []uint16{y & 0xFF00}
+	if x&0xFF != 0 && x < 0xFF00 {
+		xs = append(xs, xs[0]+0x100)
+	}
+	if y&0xFF != 0 && y < 0xFF00 {
+		ys = append(ys, ys[0]+0x100)
+	}
+	var bases []pos
+	for _, by := range ys {
+		for _, bx := range xs {
+			bases = append(bases, posFromCoord(bx, by, floor))
+		}
+	}
+	return bases
+}
+
+// lock locks the map while its tiles are read, if the map is opened lazily.
+// The returned function unlocks it.
+func (m *Map) lock() func() {
+	if m.lazy == nil {
+		return func() {}
+	}
+	m.lazy.mu.Lock()
+	return m.lazy.mu.Unlock
+}
+
+// loadArea reads the tile area with the passed base, unless it was already
+// read. The map must be locked.
+func (m *Map) loadArea(base pos) error {
+	if m.lazy == nil {
+		return nil
+	}
+	nodes, ok := m.lazy.pending[base]
+	if !ok {
+		return nil
+	}
+	delete(m.lazy.pending, base)
+	glog.V(2).Infof("reading tile area %s", base)
+	for _, n := range nodes {
+		if m.lazy.read[n.Offset] {
+			// Already read along with another block it overlaps.
+			continue
+		}
+		m.lazy.read[n.Offset] = true
+		node, err := m.lazy.readNode(n)
+		if err != nil {
+			return err
+		}
+		if err := m.readTileAreaNode(node); err != nil {
+			return fmt.Errorf("error reading tile area %s: %w", base, err)
+		}
+	}
+	return nil
+}
+
+// LoadRegion reads all tile areas intersecting the passed rectangle on the
+// passed floors. It does nothing unless the map was opened by NewLazy, as
+// otherwise all tiles are already read.
+func (m *Map) LoadRegion(x, y uint16, w, h int, minFloor, maxFloor uint8) error {
+	defer m.lock()()
+	if m.lazy == nil || w <= 0 || h <= 0 {
+		return nil
+	}
+	x2, y2 := int(x)+w-1, int(y)+h-1
+	if x2 > 0xFFFF {
+		x2 = 0xFFFF
+	}
+	if y2 > 0xFFFF {
+		y2 = 0xFFFF
+	}
+	for floor := int(minFloor); floor <= int(maxFloor); floor++ {
+		for ay := int(y) &^ 0xFF; ay <= y2; ay += 0x100 {
+			for ax := int(x) &^ 0xFF; ax <= x2; ax += 0x100 {
+				if err := m.loadArea(posFromCoord(uint16(ax), uint16(ay), uint8(floor))); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// loadAll reads all tile areas not read yet. The map must be locked.
+func (m *Map) loadAll() error {
+	if m.lazy == nil {
+		return nil
+	}
+	for base := range m.lazy.pending {
+		if err := m.loadArea(base); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,13 +3,18 @@ package otbm
 
 import (
 	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
 	"badc0de.net/pkg/go-tibia/otb"
 	"github.com/golang/glog"
 
+	tnet "badc0de.net/pkg/go-tibia/net"
 	"badc0de.net/pkg/go-tibia/otb/items"
 
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
 	"strings"
 )
 
@@ -31,22 +36,28 @@ func (l pos) String() string {
 	return fmt.Sprintf("(%d,%d,%d)", l.X(), l.Y(), l.Floor())
 }
 
+func (l pos) Position() tnet.Position {
+	return tnet.Position{X: l.X(), Y: l.Y(), Floor: l.Floor()}
+}
+
 func posFromCoord(x, y uint16, floor uint8) pos {
 	return pos((uint64(floor) << 32) | (uint64(y) << 16) | uint64(x))
 }
 
+// mapTile is a tile on the map. Tiles are kept in chunks, and only exist where
+// the map has tiles; see voidTile for the rest.
 type mapTile struct {
-	gameworld.MapTile
-
 	parent *Map
 
-	ownPos pos
+	ownPos  pos
+	houseID uint32          // Zero unless the tile belongs to a house.
+	flags   gwmap.TileFlags // OTBM_ATTR_TILE_FLAGS, such as protection zone.
 
-	ground    mapItem
-	layers    [][]*mapItem
+	// items are the items on the tile: ground first if there is any, then
+	// the rest ordered by their top order, and then by the order in which
+	// they were added.
+	items     []*mapItem
 	creatures []gameworld.Creature
-
-	subscribers []gameworld.MapTileEventSubscriber
 }
 
 func (t *mapTile) String() string {
@@ -54,71 +65,75 @@ func (t *mapTile) String() string {
 }
 
 func (t *mapTile) GetItem(idx int) (gameworld.MapItem, error) {
-	if t.ground.otbItemTypeID != 0 {
-		if idx == 0 {
-			return &t.ground, nil
-		} else {
-			idx--
-		}
-	}
-	for _, layer := range t.layers {
-		if idx < len(layer) {
-			return layer[idx], nil
-		} else {
-			idx -= len(layer)
-		}
+	if idx < 0 || idx >= len(t.items) {
+		return nil, gameworld.ItemNotFound
 	}
+	return t.items[idx], nil
+}
 
-	return nil, gameworld.ItemNotFound
+// ground returns the ground item on the tile, or nil if there is none.
+func (t *mapTile) ground() *mapItem {
+	if len(t.items) > 0 && t.items[0].typ.ground {
+		return t.items[0]
+	}
+	return nil
 }
 
-func (t *mapTile) addItem(item mapItem) error {
+func (t *mapTile) addItem(item *mapItem) error {
 	// TODO notify of item updates (e.g. replacement)
 	// for now, private method because it's used only during map load
 	// maybe the public method will be a wrapper?
 
-	m := t.parent
-
 	if item.GetServerType() == 0 {
 		glog.Warningf("   attempting to add item with server ID 0 to map tile %s; skipping", t.String())
 		return nil
 	}
-	otbItem := m.things.Temp__GetItemFromOTB(item.GetServerType(), 0)
-	if otbItem == nil {
+	if item.typ.otb == nil {
 		glog.Warningf("   OTB item %d cannot be found in the OTB items file.", item.GetServerType())
+		t.parent.droppedItems = append(t.parent.droppedItems, droppedItem{pos: t.ownPos, serverID: item.GetServerType()})
 		return fmt.Errorf("otbm item %d not found in otb items", item.GetServerType())
 	}
-	if otbItem.Group == itemsotb.ITEM_GROUP_GROUND {
-		if t.ground.otbItemTypeID != 0 {
-			// maybe tell t.ground it is being replaced?
+	if item.typ.ground {
+		if t.ground() != nil {
+			// maybe tell the ground it is being replaced?
 			// definitely notification will be different
-			t.ground = item
+			t.items[0] = item
 		} else {
-			t.ground = item
+			t.items = append(t.items, nil)
+			copy(t.items[1:], t.items)
+			t.items[0] = item
 		}
 		return nil
 	}
-	if len(t.layers) < 4 {
-		// obviously an uninitialized tile.
-		// TODO move to a 'makeTile' function on the map.
-		t.layers = make([][]*mapItem, 4) // 0, 1, 2, 3
-	}
 
-	var ord uint8 // 0 by default
-	if ordI, ok := otbItem.Attributes[itemsotb.ITEM_ATTR_TOPORDER]; ok {
-		// 1: borders
-		// 2: ladders, signs, splashes
-		// 3: doors etc
-		// beyond that, nonitems such as creatures which we don't store in layers
-
-		ord = ordI.(uint8)
+	// 0: most items
+	// 1: borders
+	// 2: ladders, signs, splashes
+	// 3: doors etc
+	// beyond that, nonitems such as creatures which we don't store in items
+	idx := len(t.items)
+	for idx > 0 && !t.items[idx-1].typ.ground && t.items[idx-1].typ.topOrder > item.typ.topOrder {
+		idx--
 	}
-
-	t.layers[ord] = append(t.layers[ord], &item)
+	t.items = append(t.items, nil)
+	copy(t.items[idx+1:], t.items[idx:])
+	t.items[idx] = item
 
 	return nil
 }
 
+// GetHouseID returns the ID of the house the tile belongs to, or zero if it
+// does not belong to a house.
+func (t *mapTile) GetHouseID() uint32 {
+	return t.houseID
+}
+
+// GetFlags returns the flags set on the tile in the map editor, such as
+// whether it is a protection zone.
+func (t *mapTile) GetFlags() gwmap.TileFlags {
+	return t.flags
+}
+
 func (t *mapTile) AddCreature(c gameworld.Creature) error {
 	t.creatures = append(t.creatures, c)
 	return nil
@@ -150,60 +165,237 @@ func (t *mapTile) GetCreature(idx int) (gameworld.Creature, error) {
 	return t.creatures[idx], nil
 }
 
+// AddItem places a new item of the passed server type and count on the tile,
+// among the other items as its type requires, and returns its index.
+func (t *mapTile) AddItem(serverType, count uint16) (int, error) {
+	item := t.parent.newItem(serverType, count, nil)
+	if item.typ.otb == nil {
+		return 0, fmt.Errorf("item %d not found in otb items", serverType)
+	}
+	if err := t.addItem(item); err != nil {
+		return 0, err
+	}
+	return t.itemIndex(item), nil
+}
+
+// RemoveItem removes the item at the passed index.
+func (t *mapTile) RemoveItem(idx int) error {
+	if idx < 0 || idx >= len(t.items) {
+		return gameworld.ItemNotFound
+	}
+	t.items = append(t.items[:idx], t.items[idx+1:]...)
+	return nil
+}
+
+// TransformItem turns the item at the passed index into an item of the
+// passed server type, keeping its count and attributes. The item is moved if
+// the new type is stacked differently; its new index is returned.
+func (t *mapTile) TransformItem(idx int, serverType uint16) (int, error) {
+	if idx < 0 || idx >= len(t.items) {
+		return 0, gameworld.ItemNotFound
+	}
+	old := t.items[idx]
+	attrs := *old.a()
+	item := t.parent.newItem(serverType, old.count, &attrs)
+	if item.typ.otb == nil {
+		return 0, fmt.Errorf("item %d not found in otb items", serverType)
+	}
+	t.items = append(t.items[:idx], t.items[idx+1:]...)
+	if err := t.addItem(item); err != nil {
+		return 0, err
+	}
+	return t.itemIndex(item), nil
+}
+
+// SetItemActionID sets the action ID of the item at the passed index; zero
+// removes it.
+func (t *mapTile) SetItemActionID(idx int, actionID uint16) error {
+	if idx < 0 || idx >= len(t.items) {
+		return gameworld.ItemNotFound
+	}
+	old := t.items[idx]
+	attrs := *old.a()
+	attrs.actionID = actionID
+	// Items without attributes are shared, so the item is replaced rather
+	// than changed.
+	t.items[idx] = t.parent.newItem(old.typ.id, old.count, &attrs)
+	return nil
+}
+
+// itemIndex returns the index of the passed item on the tile, or -1.
+func (t *mapTile) itemIndex(item *mapItem) int {
+	for idx := len(t.items) - 1; idx >= 0; idx-- {
+		if t.items[idx] == item {
+			return idx
+		}
+	}
+	return -1
+}
+
 type mapTileArea struct {
 	base pos
 }
 
-type mapItem struct {
-	gameworld.MapItem
-
-	parentTile  *mapTile
-	parentItem  *mapItem
-	ancestorMap *Map
+// itemType is what the map needs to know about a type of item. It is shared
+// between all items of the type, and never changes once created.
+type itemType struct {
+	id        uint16
+	otb       *itemsotb.Item // Nil if the type is not in items.otb.
+	clientID  uint16
+	ground    bool
+	topOrder  uint8
+	countable bool // Stackable, splash or fluid container.
+}
 
-	otbItemTypeID uint16
+// mapItem is an item on the map. Items without any attributes are shared
+// between all the tiles they are on, so items must not be changed once they
+// are placed on the map.
+type mapItem struct {
+	typ   *itemType
+	count uint16
+	attrs *mapItemAttrs // Nil unless the item has attributes or contents.
+}
 
-	count                int
+// mapItemAttrs are the attributes of an item which most items do not have.
+type mapItemAttrs struct {
 	charges, runeCharges uint16
 	actionID             uint16
 	uniqueID             uint16
 	depotID              uint16
 	teleDest             pos
 	text                 string
+	houseDoorID          uint8
+	duration             uint32 // Milliseconds until the item decays.
+	decayingState        uint8
+	writtenDate          uint32 // Unix time at which the text was written.
+	writtenBy            string
+	sleeperGUID          uint32 // Player sleeping in the bed.
+	sleepStart           uint32 // Unix time at which the player fell asleep.
+	desc                 string // Description overriding the one of the item type.
+
+	// attributeMap holds custom attributes, as stored by newer map editors.
+	// Values are string, int32, float32, float64 or bool.
+	attributeMap map[string]interface{}
+
+	contents []*mapItem // Items inside a container, in the order they are stored.
+}
+
+// noAttrs are the attributes of items without any attributes.
+var noAttrs mapItemAttrs
+
+// empty returns whether none of the attributes are set.
+func (a *mapItemAttrs) empty() bool {
+	return a.charges == 0 && a.runeCharges == 0 && a.actionID == 0 && a.uniqueID == 0 && a.depotID == 0 && a.teleDest == 0 && a.text == "" && a.houseDoorID == 0 &&
+		a.duration == 0 && a.decayingState == 0 && a.writtenDate == 0 && a.writtenBy == "" && a.sleeperGUID == 0 && a.sleepStart == 0 && a.desc == "" &&
+		len(a.attributeMap) == 0 && len(a.contents) == 0
+}
+
+// a returns the attributes of the item, which must not be changed.
+func (i *mapItem) a() *mapItemAttrs {
+	if i.attrs == nil {
+		return &noAttrs
+	}
+	return i.attrs
 }
 
 // GetServerType returns the server-side ID of the item.
 func (i *mapItem) GetServerType() uint16 {
-	return uint16(i.otbItemTypeID)
+	return i.typ.id
 }
 
 // GetCount returns the number of instances of this item (e.g. for coins).
 //
 // If item is unstackable, this will most likely be zero.
 func (i *mapItem) GetCount() uint16 {
-	return uint16(i.count)
+	return i.count
+}
+
+// GetActionID returns the action ID assigned to the item in the map editor,
+// or zero if none was assigned.
+func (i *mapItem) GetActionID() uint16 {
+	return i.a().actionID
+}
+
+// GetUniqueID returns the unique ID assigned to the item in the map editor, or
+// zero if none was assigned.
+func (i *mapItem) GetUniqueID() uint16 {
+	return i.a().uniqueID
+}
+
+// GetTeleportDestination returns where creatures stepping onto the item are
+// moved to, and whether the item has a destination at all.
+func (i *mapItem) GetTeleportDestination() (tnet.Position, bool) {
+	teleDest := i.a().teleDest
+	if teleDest == 0 {
+		return tnet.Position{}, false
+	}
+	return tnet.Position{X: teleDest.X(), Y: teleDest.Y(), Floor: teleDest.Floor()}, true
+}
+
+// GetHouseDoorID returns the ID of the house door the item is, within its
+// house, or zero if it is not a house door.
+func (i *mapItem) GetHouseDoorID() uint8 {
+	return i.a().houseDoorID
+}
+
+// GetDepotID returns the ID of the depot the item gives access to, or zero if
+// it does not give access to a depot.
+func (i *mapItem) GetDepotID() uint16 {
+	return i.a().depotID
 }
 
 func (i *mapItem) String() string {
 	name := "unnamed"
-	clientID := uint16(0)
-	if i.GetServerType() != 0 {
-		otbItem := i.ancestorMap.things.Temp__GetItemFromOTB(i.GetServerType(), 0)
-		if otbItem != nil {
-			name = otbItem.Name()
-			clientID = i.ancestorMap.things.Temp__GetClientIDForServerID(i.GetServerType(), 0)
-		}
+	if i.typ.otb != nil {
+		name = i.typ.otb.Name()
+	}
+	return fmt.Sprintf("<mapItem %d : %02x %s>", i.typ.id, i.typ.clientID, name)
+}
+
+// itemType returns the shared data about items with the passed server ID.
+func (m *Map) itemType(serverID uint16) *itemType {
+	if typ, ok := m.itemTypes[serverID]; ok {
+		return typ
+	}
+	typ := &itemType{id: serverID}
+	if otbItem := m.things.Temp__GetItemFromOTB(serverID, 0); otbItem != nil {
+		typ.otb = otbItem
+		typ.clientID = m.things.Temp__GetClientIDForServerID(serverID, 0)
+		typ.ground = otbItem.Group == itemsotb.ITEM_GROUP_GROUND
+		typ.topOrder, _ = otbItem.TopOrder()
+		typ.countable = otbItem.Group == itemsotb.ITEM_GROUP_SPLASH || otbItem.Group == itemsotb.ITEM_GROUP_FLUID || otbItem.Flags.Stackable()
 	}
+	m.itemTypes[serverID] = typ
+	return typ
+}
 
-	return fmt.Sprintf("<mapItem %d : %02x %s>", i.otbItemTypeID, clientID, name)
+// newItem returns an item with the passed attributes. Items without
+// attributes are shared, so the returned item must not be changed.
+func (m *Map) newItem(serverID uint16, count uint16, attrs *mapItemAttrs) *mapItem {
+	if attrs != nil && !attrs.empty() {
+		return &mapItem{typ: m.itemType(serverID), count: count, attrs: attrs}
+	}
+	key := uint32(serverID)<<16 | uint32(count)
+	if item, ok := m.plainItems[key]; ok {
+		return item
+	}
+	item := &mapItem{typ: m.itemType(serverID), count: count}
+	m.plainItems[key] = item
+	return item
 }
 
 type rootHeader struct {
-	Ver                          uint32
+	Ver                          MapVersion
 	Width, Height                uint16
 	ItemsVerMajor, ItemsVerMinor uint32
 }
 
+// hasCountByte returns whether items of the passed type store their count
+// right after their ID, as they did in the first version of the format.
+func (m *Map) hasCountByte(serverID uint16) bool {
+	return m.header.Ver == OTBM_VERSION_1 && m.itemType(serverID).countable
+}
+
 // readRootChildNode reads a single "OTB node", as read from an OTB file.
 func (m *Map) readRootChildNode(node *otb.OTBNode) error {
 
@@ -337,8 +529,7 @@ func (m *Map) readTileOrHouseTileNode(node *otb.OTBNode, area *mapTileArea, nt M
 	}
 
 	p := posFromCoord(area.base.X()+uint16(props.X), area.base.Y()+uint16(props.Y), area.base.Floor())
-	tile := mapTile{ownPos: p, parent: m}
-	m.tiles[p] = &tile
+	tile := m.createTile(p)
 
 	var v glog.Level
 	v = 2
@@ -358,35 +549,33 @@ func (m *Map) readTileOrHouseTileNode(node *otb.OTBNode, area *mapTileArea, nt M
 			return fmt.Errorf("readTileNode: error reading flags attr of tile: %v", err)
 		}
 		glog.V(v).Infof("  house ID: %04x", houseID)
+		tile.houseID = houseID
+		m.houseTiles[houseID] = append(m.houseTiles[houseID], p)
 	}
 
 	for attr, err := propBuf.ReadByte(); err == nil; attr, err = propBuf.ReadByte() {
 		attr := ItemAttribute(attr)
 		switch attr {
 		case OTBM_ATTR_TILE_FLAGS:
-			var tileFlags uint32
+			var tileFlags gwmap.TileFlags
 			if err := binary.Read(propBuf, binary.LittleEndian, &tileFlags); err != nil {
 				return fmt.Errorf("readTileNode: error reading flags attr of tile: %v", err)
 			}
 			glog.V(v).Infof("  tileflags: %04x", tileFlags)
+			tile.flags = tileFlags
 		case OTBM_ATTR_ITEM:
-			item := mapItem{
-				ancestorMap: m,
-				parentTile:  &tile,
-				count:       1,
-			}
-			if err := binary.Read(propBuf, binary.LittleEndian, &item.otbItemTypeID); err != nil {
+			var serverID uint16
+			count := uint16(1)
+			if err := binary.Read(propBuf, binary.LittleEndian, &serverID); err != nil {
 				return fmt.Errorf("readTileNode: error reading item prop of tile: %v", err)
 			}
 			if glog.V(v) {
-				glog.Infof("  tileitem: %02d %04x", item.otbItemTypeID, item.otbItemTypeID)
+				glog.Infof("  tileitem: %02d %04x", serverID, serverID)
 			}
 
-			// if otbm version is MAP_OTBM_1 and item is stackable, or splash, or fluid container, read one more byte
-			// TODO: check for otbm_1
-			otbItem := m.things.Temp__GetItemFromOTB(item.GetServerType(), 0)
+			otbItem := m.itemType(serverID).otb
 			if otbItem == nil {
-				glog.Errorf("could not get otb for item %d!", item.GetServerType())
+				glog.Errorf("could not get otb for item %d!", serverID)
 				continue
 			}
 			// n.b. Item group is supposed to be ground here!
@@ -396,7 +585,7 @@ func (m *Map) readTileOrHouseTileNode(node *otb.OTBNode, area *mapTileArea, nt M
 			if glog.V(v) {
 				glog.Infof("  item flags: %s", otbItem.Flags)
 			}
-			if otbItem.Group == itemsotb.ITEM_GROUP_SPLASH || otbItem.Group == itemsotb.ITEM_GROUP_FLUID || otbItem.Flags&itemsotb.FLAG_STACKABLE != 0 {
+			if m.hasCountByte(serverID) {
 				cntB, err := propBuf.ReadByte()
 				if err != nil {
 					return fmt.Errorf("readTileNode: countable item error: %v", err)
@@ -404,18 +593,17 @@ func (m *Map) readTileOrHouseTileNode(node *otb.OTBNode, area *mapTileArea, nt M
 				if glog.V(v) {
 					glog.Infof("    -> count %d", cntB)
 				}
-				panic(cntB)
-				item.count = int(cntB)
+				count = uint16(cntB)
 			}
 
-			tile.addItem(item)
+			tile.addItem(m.newItem(serverID, count, nil))
 		default:
 			return fmt.Errorf("readTileNode: unsupported attr type 0x%02x (%s)", attr, attr)
 		}
 	}
 
 	for node := node.ChildNode(); node != nil; node = node.NextNode() {
-		if err := m.readTileChildNode(node, &tile); err != nil {
+		if err := m.readTileChildNode(node, tile); err != nil {
 			return fmt.Errorf("error reading tile child node: %v", err)
 		}
 	}
@@ -432,7 +620,7 @@ func (m *Map) readTileChildNode(node *otb.OTBNode, tile *mapTile) error {
 	return nil
 }
 
-func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *mapItem, depth int) error {
+func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *mapItemAttrs, depth int) error {
 	var indent string
 	if glog.V(2) {
 		indent = strings.Repeat(" ", depth+1)
@@ -443,30 +631,23 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 	}
 	propBuf := node.PropsBuffer()
 
-	item := mapItem{
-		ancestorMap: m,
-		parentTile:  parentTile,
-		parentItem:  parentItem,
-	}
+	var serverID, count uint16
+	var attrs mapItemAttrs
 
-	if err := binary.Read(propBuf, binary.LittleEndian, &item.otbItemTypeID); err != nil {
+	if err := binary.Read(propBuf, binary.LittleEndian, &serverID); err != nil {
 		return fmt.Errorf("error reading prop otbItemTypeID of item node: %v", err)
 	}
 
 	v := glog.Level(2)
-	glog.V(v).Infof("%sitem id: %d", indent, item.otbItemTypeID)
-	if item.otbItemTypeID != 0 {
-		otbItem := m.things.Temp__GetItemFromOTB(item.GetServerType(), 0)
+	glog.V(v).Infof("%sitem id: %d", indent, serverID)
+	if serverID != 0 {
+		otbItem := m.itemType(serverID).otb
 		if otbItem != nil {
 			if glog.V(v) {
 				glog.Infof("%sitem name: %s", indent, otbItem.Name())
 				glog.Infof("%sitem group: %s", indent, otbItem.Group)
 				glog.Infof("%sitem flags: %s", indent, otbItem.Flags)
 			}
-			// if otbm version is MAP_OTBM_1 and item is stackable, or splash, or fluid container, read one more byte
-			// TODO: check for otbm_1
-			if otbItem.Group == itemsotb.ITEM_GROUP_SPLASH || otbItem.Group == itemsotb.ITEM_GROUP_FLUID || otbItem.Flags&itemsotb.FLAG_STACKABLE != 0 {
-			}
 		} else {
 			if glog.V(v) {
 				glog.Infof("%s[n.b. item nil in items.otb]", indent)
@@ -478,6 +659,17 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 		return nil // just ignore the item for the time being, figure out what's up later...
 	}
 
+	if m.hasCountByte(serverID) {
+		cntB, err := propBuf.ReadByte()
+		if err != nil {
+			return fmt.Errorf("readItemNode: countable item error: %v", err)
+		}
+		if glog.V(v) {
+			glog.Infof("%sitem count: %d", indent, cntB)
+		}
+		count = uint16(cntB)
+	}
+
 	for attr, err := propBuf.ReadByte(); err == nil; attr, err = propBuf.ReadByte() {
 		attr := ItemAttribute(attr)
 		switch attr {
@@ -489,41 +681,43 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 			if glog.V(v) {
 				glog.Infof("%sitem count: %d", indent, cntB)
 			}
-			item.count = int(cntB)
+			count = uint16(cntB)
 		case OTBM_ATTR_RUNE_CHARGES:
-			if err := binary.Read(propBuf, binary.LittleEndian, &item.runeCharges); err != nil {
+			runeCharges, err := propBuf.ReadByte()
+			if err != nil {
 				return fmt.Errorf("readItemNode: rune item error: %v", err)
 			}
+			attrs.runeCharges = uint16(runeCharges)
 			if glog.V(v) {
-				glog.Infof("%sitem rune charges: %d", indent, item.runeCharges)
+				glog.Infof("%sitem rune charges: %d", indent, attrs.runeCharges)
 			}
 		case OTBM_ATTR_CHARGES:
-			if err := binary.Read(propBuf, binary.LittleEndian, &item.charges); err != nil {
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.charges); err != nil {
 				return fmt.Errorf("readItemNode: chargable item error: %v", err)
 			}
 			if glog.V(v) {
-				glog.Infof("%sitem charges: %d", indent, item.charges)
+				glog.Infof("%sitem charges: %d", indent, attrs.charges)
 			}
 		case OTBM_ATTR_ACTION_ID:
-			if err := binary.Read(propBuf, binary.LittleEndian, &item.actionID); err != nil {
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.actionID); err != nil {
 				return fmt.Errorf("readItemNode: actionable item error: %v", err)
 			}
 			if glog.V(v) {
-				glog.Infof("%sitem action ID: %d", indent, item.actionID)
+				glog.Infof("%sitem action ID: %d", indent, attrs.actionID)
 			}
 		case OTBM_ATTR_UNIQUE_ID:
-			if err := binary.Read(propBuf, binary.LittleEndian, &item.uniqueID); err != nil {
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.uniqueID); err != nil {
 				return fmt.Errorf("readItemNode: unique item error: %v", err)
 			}
 			if glog.V(v) {
-				glog.Infof("%sitem unique ID: %d", indent, item.uniqueID)
+				glog.Infof("%sitem unique ID: %d", indent, attrs.uniqueID)
 			}
 		case OTBM_ATTR_DEPOT_ID:
-			if err := binary.Read(propBuf, binary.LittleEndian, &item.depotID); err != nil {
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.depotID); err != nil {
 				return fmt.Errorf("readItemNode: depotid item error: %v", err)
 			}
 			if glog.V(v) {
-				glog.Infof("%sitem depot ID: %d", indent, item.depotID)
+				glog.Infof("%sitem depot ID: %d", indent, attrs.depotID)
 			}
 		case OTBM_ATTR_TELE_DEST:
 			var teleDest struct {
@@ -533,9 +727,9 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 			if err := binary.Read(propBuf, binary.LittleEndian, &teleDest); err != nil {
 				return fmt.Errorf("readItemNode: teledest item error: %v", err)
 			}
-			item.teleDest = posFromCoord(teleDest.X, teleDest.Y, teleDest.Floor)
+			attrs.teleDest = posFromCoord(teleDest.X, teleDest.Y, teleDest.Floor)
 			if glog.V(v) {
-				glog.Infof("%sitem teledest: %s", indent, item.teleDest)
+				glog.Infof("%sitem teledest: %s", indent, attrs.teleDest)
 			}
 		case OTBM_ATTR_TEXT:
 			var textSize uint16
@@ -550,10 +744,10 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 			if n != int(textSize) {
 				return fmt.Errorf("did not read entire text in item node: got %d, want %d", n, textSize)
 			}
-			item.text = string(textB) // assume utf8, I suppose
+			attrs.text = string(textB) // assume utf8, I suppose
 
 			if glog.V(v) {
-				glog.Infof("%sitem text[%d]: %s", indent, textSize, item.text)
+				glog.Infof("%sitem text[%d]: %s", indent, textSize, attrs.text)
 			}
 		case OTBM_ATTR_HOUSEDOORID:
 			var houseDoorID [1]byte
@@ -569,6 +763,71 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 			if glog.V(v) {
 				glog.Infof("%shouse door id: %d", indent, houseDoorID[0])
 			}
+			attrs.houseDoorID = houseDoorID[0]
+		case OTBM_ATTR_DURATION:
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.duration); err != nil {
+				return fmt.Errorf("readItemNode: decaying item error: %v", err)
+			}
+			if glog.V(v) {
+				glog.Infof("%sitem duration: %d", indent, attrs.duration)
+			}
+		case OTBM_ATTR_DECAYING_STATE:
+			decayingState, err := propBuf.ReadByte()
+			if err != nil {
+				return fmt.Errorf("readItemNode: decaying state error: %v", err)
+			}
+			attrs.decayingState = decayingState
+			if glog.V(v) {
+				glog.Infof("%sitem decaying state: %d", indent, attrs.decayingState)
+			}
+		case OTBM_ATTR_WRITTENDATE:
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.writtenDate); err != nil {
+				return fmt.Errorf("readItemNode: written date error: %v", err)
+			}
+			if glog.V(v) {
+				glog.Infof("%sitem written date: %d", indent, attrs.writtenDate)
+			}
+		case OTBM_ATTR_WRITTENBY:
+			writtenBy, err := readString(propBuf)
+			if err != nil {
+				return fmt.Errorf("readItemNode: written by error: %v", err)
+			}
+			attrs.writtenBy = writtenBy
+			if glog.V(v) {
+				glog.Infof("%sitem written by: %s", indent, attrs.writtenBy)
+			}
+		case OTBM_ATTR_SLEEPERGUID:
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.sleeperGUID); err != nil {
+				return fmt.Errorf("readItemNode: sleeper guid error: %v", err)
+			}
+			if glog.V(v) {
+				glog.Infof("%sitem sleeper guid: %d", indent, attrs.sleeperGUID)
+			}
+		case OTBM_ATTR_SLEEPSTART:
+			if err := binary.Read(propBuf, binary.LittleEndian, &attrs.sleepStart); err != nil {
+				return fmt.Errorf("readItemNode: sleep start error: %v", err)
+			}
+			if glog.V(v) {
+				glog.Infof("%sitem sleep start: %d", indent, attrs.sleepStart)
+			}
+		case OTBM_ATTR_DESC:
+			desc, err := readString(propBuf)
+			if err != nil {
+				return fmt.Errorf("readItemNode: description error: %v", err)
+			}
+			attrs.desc = desc
+			if glog.V(v) {
+				glog.Infof("%sitem description: %s", indent, attrs.desc)
+			}
+		case OTBM_ATTR_ATTRIBUTE_MAP:
+			attributes, err := readAttributeMap(propBuf)
+			if err != nil {
+				return fmt.Errorf("readItemNode: attribute map error: %v", err)
+			}
+			attrs.attributeMap = attributes
+			if glog.V(v) {
+				glog.Infof("%sitem attributes: %v", indent, attrs.attributeMap)
+			}
 
 		default:
 			return fmt.Errorf("readItemNode: unsupported attr type: %s", attr)
@@ -576,21 +835,16 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 	}
 
 	for node := node.ChildNode(); node != nil; node = node.NextNode() {
-		if err := m.readItemChildNode(node, parentTile, &item, depth+1); err != nil {
+		if err := m.readItemChildNode(node, parentTile, &attrs, depth+1); err != nil {
 			return fmt.Errorf("error reading tile child node: %v", err)
 		}
 	}
 
-	if item.otbItemTypeID == 0 {
-		glog.Errorf("bad item 0 at tile parent item %v / parent tile %v; skipping", parentItem, parentTile)
-		return nil
-	}
-
+	item := m.newItem(serverID, count, &attrs)
 	if parentItem != nil {
-		// TODO: parentItem.AddChild(...)
+		parentItem.contents = append(parentItem.contents, item)
 	} else if parentTile != nil {
-		//otbItem := m.things.Temp__GetItemFromOTB(item.GetServerType(), 0)
-		//if otbItem.Group == itemsotb.ITEM_GROUP_GROUND {
+		//if item.typ.ground {
 		//}
 		parentTile.addItem(item)
 	}
@@ -598,7 +852,84 @@ func (m *Map) readItemNode(node *otb.OTBNode, parentTile *mapTile, parentItem *m
 	return nil
 }
 
-func (m *Map) readItemChildNode(node *otb.OTBNode, parentTile *mapTile, parentItem *mapItem, depth int) error {
+// Types of values in an attribute map.
+const (
+	attributeMapString  = 1
+	attributeMapInteger = 2
+	attributeMapFloat   = 3
+	attributeMapBoolean = 4
+	attributeMapDouble  = 5
+)
+
+// readString reads a string prefixed by its 16-bit length.
+func readString(buf *bytes.Buffer) (string, error) {
+	var sz uint16
+	if err := binary.Read(buf, binary.LittleEndian, &sz); err != nil {
+		return "", err
+	}
+	b := make([]byte, sz)
+	if _, err := io.ReadFull(buf, b); err != nil {
+		return "", fmt.Errorf("reading string of %d bytes: %w", sz, err)
+	}
+	return string(b), nil
+}
+
+// readAttributeMap reads custom item attributes, stored as a 16-bit number of
+// attributes, followed by the name, value type and value of each attribute.
+//
+// Strings in values are prefixed by their 32-bit length, unlike elsewhere.
+func readAttributeMap(buf *bytes.Buffer) (map[string]interface{}, error) {
+	var cnt uint16
+	if err := binary.Read(buf, binary.LittleEndian, &cnt); err != nil {
+		return nil, err
+	}
+	attributes := make(map[string]interface{}, cnt)
+	for i := 0; i < int(cnt); i++ {
+		key, err := readString(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading attribute name: %w", err)
+		}
+		typ, err := buf.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading type of attribute %q: %w", key, err)
+		}
+		switch typ {
+		case attributeMapString:
+			var sz uint32
+			if err := binary.Read(buf, binary.LittleEndian, &sz); err != nil {
+				return nil, fmt.Errorf("reading attribute %q: %w", key, err)
+			}
+			if int(sz) > buf.Len() {
+				return nil, fmt.Errorf("attribute %q of %d bytes is longer than the remaining %d bytes", key, sz, buf.Len())
+			}
+			attributes[key] = string(buf.Next(int(sz)))
+		case attributeMapInteger:
+			var val int32
+			err = binary.Read(buf, binary.LittleEndian, &val)
+			attributes[key] = val
+		case attributeMapFloat:
+			var val uint32
+			err = binary.Read(buf, binary.LittleEndian, &val)
+			attributes[key] = math.Float32frombits(val)
+		case attributeMapDouble:
+			var val uint64
+			err = binary.Read(buf, binary.LittleEndian, &val)
+			attributes[key] = math.Float64frombits(val)
+		case attributeMapBoolean:
+			var val uint8
+			err = binary.Read(buf, binary.LittleEndian, &val)
+			attributes[key] = val != 0
+		default:
+			return nil, fmt.Errorf("attribute %q has unsupported type %d", key, typ)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading attribute %q: %w", key, err)
+		}
+	}
+	return attributes, nil
+}
+
+func (m *Map) readItemChildNode(node *otb.OTBNode, parentTile *mapTile, parentItem *mapItemAttrs, depth int) error {
 	switch MapNodeType(node.NodeType()) {
 	case OTBM_ITEM:
 		return m.readItemNode(node, parentTile, parentItem, depth)
@@ -665,10 +996,11 @@ func (m *Map) readTownNode(node *otb.OTBNode) error {
 
 	glog.V(2).Infof(" town %s (%d) with temple at %d,%d,%d", props.name, props.id, props.templePos.TempleX, props.templePos.TempleY, props.templePos.TempleFloor)
 
-	if m.defaultPlayerSpawnPoint == 0 {
-		m.defaultPlayerSpawnPoint = posFromCoord(props.templePos.TempleX, props.templePos.TempleY, props.templePos.TempleFloor)
-		glog.V(2).Infof("  this town is now the default spawn point %v", m.defaultPlayerSpawnPoint)
-	}
+	m.towns = append(m.towns, gameworld.Town{
+		ID:        props.id,
+		Name:      props.name,
+		TemplePos: tnet.Position{X: props.templePos.TempleX, Y: props.templePos.TempleY, Floor: props.templePos.TempleFloor},
+	})
 
 	// skipping child nodes
 
@@ -727,10 +1059,10 @@ func (m *Map) readWaypointNode(node *otb.OTBNode) error {
 
 	glog.V(2).Infof(" waypoint %s with pos at %d,%d,%d", props.name, props.pos.X, props.pos.Y, props.pos.Floor)
 
-	if m.defaultPlayerSpawnPoint == 0 {
-		m.defaultPlayerSpawnPoint = posFromCoord(props.pos.X, props.pos.Y, props.pos.Floor)
-		glog.V(2).Infof("  this waypoint is now the default spawn point %v", m.defaultPlayerSpawnPoint)
-	}
+	m.waypoints = append(m.waypoints, gameworld.Waypoint{
+		Name: props.name,
+		Pos:  tnet.Position{X: props.pos.X, Y: props.pos.Y, Floor: props.pos.Floor},
+	})
 
 	// skipping child nodes
 
@@ -7,28 +7,36 @@ import (
 
 	"badc0de.net/pkg/go-tibia/gameworld"
 	"badc0de.net/pkg/go-tibia/otb"
+	"badc0de.net/pkg/go-tibia/otb/items"
 	"badc0de.net/pkg/go-tibia/things"
 
 	"github.com/golang/glog"
 )
 
 // New reads an OTB file from a given reader.
-func New(r io.ReadSeeker, t *things.Things) (*Map, error) {
+func New(r io.Reader, t *things.Things) (*Map, error) {
 	f, err := otb.NewOTB(r)
 	if err != nil {
 		return nil, fmt.Errorf("newotbm failed to use fileloader: %s", err)
 	}
 
-	otb := Map{
-		tiles:     map[pos]*mapTile{},
-		creatures: map[gameworld.CreatureID]gameworld.Creature{},
+	otb := newMap(t)
 
-		things: t,
+	if err := otb.readRootNode(f.ChildNode(nil)); err != nil {
+		return nil, err
+	}
+
+	if len(otb.towns) == 0 {
+		glog.Warningf("no towns; players will have nowhere to appear")
 	}
 
-	root := f.ChildNode(nil)
+	return otb, nil
+}
+
+// readRootNode reads the root node of the map, along with all its children.
+func (m *Map) readRootNode(root *otb.OTBNode) error {
 	if root == nil {
-		return nil, fmt.Errorf("nil root node")
+		return fmt.Errorf("nil root node")
 	}
 
 	props := root.PropsBuffer()
@@ -37,37 +45,71 @@ func New(r io.ReadSeeker, t *things.Things) (*Map, error) {
 	//	return fmt.Errorf("error reading otbm root node attr: %v", err)
 	//}
 	switch MapNodeType(root.NodeType()) {
-	case OTBM_ROOT:
-		var head rootHeader
-		if err := binary.Read(props, binary.LittleEndian, &head); err != nil {
-			return nil, fmt.Errorf("error reading otbm root node header attrs: %v", err)
+	case OTBM_ROOT, OTBM_ROOTV1:
+		// Both carry the same header; the format version is recorded in it.
+		if err := binary.Read(props, binary.LittleEndian, &m.header); err != nil {
+			return fmt.Errorf("error reading otbm root node header attrs: %v", err)
 		}
 
-		glog.V(2).Infof("otbm header: %+v", head)
-		// TODO: store version and ensure items.otb is applicable enough
-	case OTBM_ROOTV1:
-		return nil, fmt.Errorf("otbm with rootv1 header is not supported at this time")
+		glog.V(2).Infof("otbm header: %+v", m.header)
+		if err := m.checkVersion(); err != nil {
+			return err
+		}
 	default:
 		glog.Errorf("unknown root node 0x%02x", root.NodeType())
-		return nil, fmt.Errorf("unknown root node 0x%02x", root.NodeType())
+		return fmt.Errorf("unknown root node 0x%02x", root.NodeType())
 	}
 
 	if root.ChildNode() == nil {
-		return nil, fmt.Errorf("no children in root node")
+		return fmt.Errorf("no children in root node")
 	}
 
 	for node := root.ChildNode(); node != nil; node = node.NextNode() {
-		if err := otb.readRootChildNode(node); err != nil {
-			return nil, fmt.Errorf("error reading root child node: %v", err)
+		if err := m.readRootChildNode(node); err != nil {
+			return fmt.Errorf("error reading root child node: %v", err)
 		}
 	}
+	return nil
+}
+
+// newMap returns an empty map, using the passed things registry to look up
+// items placed on it.
+func newMap(t *things.Things) *Map {
+	m := &Map{
+		chunks:     map[pos]*mapChunk{},
+		creatures:  map[gameworld.CreatureID]gameworld.Creature{},
+		houseTiles: map[uint32][]pos{},
+		itemTypes:  map[uint16]*itemType{},
+		plainItems: map[uint32]*mapItem{},
 
-	if otb.defaultPlayerSpawnPoint == 0 {
-		//otb.defaultPlayerSpawnPoint = posFromCoord(44, 173, 5) // generated file
-		//otb.defaultPlayerSpawnPoint = posFromCoord(1001, 1010, 7) // test file
-		//return fmt.Errorf("no default player spawn point; does the map have any temples?")
-		glog.Warningf("no default player spawn point; does the map have any temples?")
+		things: t,
+	}
+	m.void = &voidTile{parent: m}
+	return m
+}
+
+// checkVersion ensures the map can be read, and that the loaded items.otb
+// knows about all the items the map may contain.
+//
+// Maps made for older items.otb files are fine, as items are only ever added.
+// Maps made for newer items.otb files of the same major version are loaded
+// with a warning, as only some of their items may be unknown.
+func (m *Map) checkVersion() error {
+	if m.header.Ver > OTBM_VERSION_LATEST {
+		return fmt.Errorf("unsupported otbm version %d; latest supported is %d", m.header.Ver, OTBM_VERSION_LATEST)
 	}
 
-	return &otb, nil
+	itemsVer, ok := m.things.ItemsOTBVersion()
+	if !ok || itemsVer.MajorVersion == 0 || itemsVer.MajorVersion == 0xFFFFFFFF {
+		// Generic or unknown items.otb; nothing to compare against.
+		glog.V(2).Infof("not checking otbm items version %d.%d against items.otb", m.header.ItemsVerMajor, m.header.ItemsVerMinor)
+		return nil
+	}
+	if m.header.ItemsVerMajor != itemsVer.MajorVersion {
+		return fmt.Errorf("otbm was made for items.otb major version %d, but loaded items.otb has major version %d", m.header.ItemsVerMajor, itemsVer.MajorVersion)
+	}
+	if m.header.ItemsVerMinor > uint32(itemsVer.MinorVersion) {
+		glog.Warningf("otbm was made for items.otb for %s, but loaded items.otb is for %s; some items may be missing", itemsotb.ClientVersion(m.header.ItemsVerMinor), itemsVer.MinorVersion)
+	}
+	return nil
 }
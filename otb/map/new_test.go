@@ -0,0 +1,96 @@
+package otbm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+func TestVersions(t *testing.T) {
+	buf, err := ioutil.ReadFile("../../datafiles/range-test-map.otbm")
+	if err != nil {
+		t.Skipf("skipping because no file: %v", err)
+	}
+	th := syntheticThingsForMap(t, buf)
+	m, err := New(bytes.NewReader(buf), th)
+	if err != nil {
+		t.Fatalf("loading map: %v", err)
+	}
+	if got, want := m.Version(), OTBM_VERSION_3; got != want {
+		t.Errorf("version %d, want %d", got, want)
+	}
+	if major, minor := m.ItemsVersion(); major != 3 || minor != itemsotb.CLIENT_VERSION_854 {
+		t.Errorf("items version %d.%s, want 3.%s", major, minor, itemsotb.CLIENT_VERSION_854)
+	}
+	if w, h := m.Dimensions(); w != 500 || h != 500 {
+		t.Errorf("dimensions %dx%d, want 500x500", w, h)
+	}
+	if desc := m.Description(); len(desc) == 0 {
+		t.Errorf("no description")
+	}
+
+	t.Run("v1 count byte", func(t *testing.T) {
+		m, err := New(bytes.NewReader(buf), th)
+		if err != nil {
+			t.Fatalf("loading map: %v", err)
+		}
+		m.header.Ver = OTBM_VERSION_1
+		tile, err := m.GetMapTile(100, 101, 7)
+		if err != nil {
+			t.Fatalf("getting tile: %v", err)
+		}
+		if err := tile.(*mapTile).addItem(m.newItem(2148, 7, nil)); err != nil {
+			t.Fatalf("adding coins: %v", err)
+		}
+		saved := &bytes.Buffer{}
+		if err := m.Save(saved); err != nil {
+			t.Fatalf("saving map: %v", err)
+		}
+		// The count directly follows the item ID instead of being an attribute.
+		if !bytes.Contains(saved.Bytes(), []byte{0xFE, byte(OTBM_ITEM), 0x64, 0x08, 7, 0xFF}) {
+			t.Errorf("saved map does not contain coins with a count byte")
+		}
+
+		reloaded, err := New(bytes.NewReader(saved.Bytes()), th)
+		if err != nil {
+			t.Fatalf("reloading map: %v", err)
+		}
+		if got := reloaded.Version(); got != OTBM_VERSION_1 {
+			t.Errorf("reloaded version %d, want %d", got, OTBM_VERSION_1)
+		}
+		if got, want := describeTiles(reloaded), describeTiles(m); got[posFromCoord(100, 101, 7)] != want[posFromCoord(100, 101, 7)] {
+			t.Errorf("reloaded tile %q, want %q", got[posFromCoord(100, 101, 7)], want[posFromCoord(100, 101, 7)])
+		}
+	})
+
+	for _, tc := range []struct {
+		name    string
+		ver     MapVersion
+		items   itemsotb.ItemsVersion
+		wantErr bool
+	}{
+		{name: "same items", ver: OTBM_VERSION_3, items: itemsotb.ItemsVersion{MajorVersion: 3, MinorVersion: itemsotb.CLIENT_VERSION_854}},
+		{name: "newer items", ver: OTBM_VERSION_3, items: itemsotb.ItemsVersion{MajorVersion: 3, MinorVersion: itemsotb.CLIENT_VERSION_870}},
+		{name: "older items", ver: OTBM_VERSION_3, items: itemsotb.ItemsVersion{MajorVersion: 3, MinorVersion: itemsotb.CLIENT_VERSION_850}},
+		{name: "generic items", ver: OTBM_VERSION_3, items: itemsotb.ItemsVersion{MajorVersion: 0xFFFFFFFF}},
+		{name: "other major items", ver: OTBM_VERSION_3, items: itemsotb.ItemsVersion{MajorVersion: 2, MinorVersion: itemsotb.CLIENT_VERSION_854}, wantErr: true},
+		{name: "future map", ver: OTBM_VERSION_LATEST + 1, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			items := &itemsotb.Items{Version: tc.items}
+			th, err := things.New()
+			if err != nil {
+				t.Fatalf("creating things registry: %v", err)
+			}
+			th.AddItemsOTB(items)
+			m := &Map{header: m.header, things: th}
+			m.header.Ver = tc.ver
+			if err := m.checkVersion(); (err != nil) != tc.wantErr {
+				t.Errorf("checkVersion() = %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -5,76 +5,167 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 
 	"badc0de.net/pkg/go-tibia/dat"
 	"badc0de.net/pkg/go-tibia/gameworld"
 	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
 	"badc0de.net/pkg/go-tibia/things"
 )
 
 type Map struct {
 	gameworld.MapDataSource
-	tiles     map[pos]*mapTile
+	chunks    map[pos]*mapChunk // by position of their top left tile
+	tileCount int
+	void      *voidTile
+	lazy      *lazySource // nil unless opened by NewLazy
 	creatures map[gameworld.CreatureID]gameworld.Creature
 	things    *things.Things
 
-	defaultPlayerSpawnPoint pos // temporary variable; ideally this is specified by having player's town ID in config
+	itemTypes  map[uint16]*itemType
+	plainItems map[uint32]*mapItem // items without attributes, by server ID and count
+
+	header rootHeader
+
+	towns     []gameworld.Town
+	waypoints []gameworld.Waypoint
 
 	desc                       []string
 	extSpawnFile, extHouseFile string
+
+	houseTiles map[uint32][]pos // positions of tiles belonging to houses, by house ID
+
+	// droppedItems are items which could not be placed on tiles while the
+	// map was read, as items.otb does not know them.
+	droppedItems []droppedItem
+}
+
+// droppedItem is an item left out of the map, along with where it was.
+type droppedItem struct {
+	pos      pos
+	serverID uint16
 }
 
 func (m *Map) String() string {
 	return fmt.Sprintf("<map with description: [%s]>", strings.Join(m.desc, "; "))
 }
 
-func (m *Map) Private_And_Temp__DefaultPlayerSpawnPoint(c gameworld.CreatureID) tnet.Position {
-	pos := m.defaultPlayerSpawnPoint
-	return tnet.Position{
-		X:     pos.X(),
-		Y:     pos.Y(),
-		Floor: pos.Floor(),
+// Version returns the version of the OTBM format the map was stored in.
+func (m *Map) Version() MapVersion {
+	return m.header.Ver
+}
+
+// ItemsVersion returns the version of the items.otb file the map was made
+// for, with the minor version being the client version.
+func (m *Map) ItemsVersion() (major uint32, minor itemsotb.ClientVersion) {
+	return m.header.ItemsVerMajor, itemsotb.ClientVersion(m.header.ItemsVerMinor)
+}
+
+// Dimensions returns the width and height of the map, as recorded in the map
+// itself. Tiles are not guaranteed to lie within these dimensions.
+func (m *Map) Dimensions() (width, height uint16) {
+	return m.header.Width, m.header.Height
+}
+
+// Description returns the descriptions recorded in the map, such as the
+// name of the editor it was saved with, in the order they are recorded.
+func (m *Map) Description() []string {
+	return append([]string(nil), m.desc...)
+}
+
+// ExtSpawnFile returns the name of the spawn file accompanying this map, as
+// recorded in the map itself. It is usually relative to the directory
+// containing the map file.
+func (m *Map) ExtSpawnFile() string {
+	return m.extSpawnFile
+}
+
+// ExtHouseFile returns the name of the house file accompanying this map, as
+// recorded in the map itself. It is usually relative to the directory
+// containing the map file.
+func (m *Map) ExtHouseFile() string {
+	return m.extHouseFile
+}
+
+// HouseTiles returns the positions of the tiles belonging to each house on
+// the map, by house ID.
+func (m *Map) HouseTiles() map[uint32][]tnet.Position {
+	defer m.lock()()
+	houseTiles := make(map[uint32][]tnet.Position, len(m.houseTiles))
+	for id, ps := range m.houseTiles {
+		for _, p := range ps {
+			houseTiles[id] = append(houseTiles[id], tnet.Position{X: p.X(), Y: p.Y(), Floor: p.Floor()})
+		}
 	}
+	return houseTiles
 }
 
+// Towns returns the towns on the map, in the order they are recorded in it.
+func (m *Map) Towns() []gameworld.Town {
+	return append([]gameworld.Town(nil), m.towns...)
+}
+
+// Waypoints returns the waypoints on the map, in the order they are recorded
+// in it.
+func (m *Map) Waypoints() []gameworld.Waypoint {
+	return append([]gameworld.Waypoint(nil), m.waypoints...)
+}
+
+// GetTownTemple returns the position of the temple of the town with the
+// passed ID.
+func (m *Map) GetTownTemple(townID uint32) (tnet.Position, error) {
+	for _, t := range m.towns {
+		if t.ID == townID {
+			return t.TemplePos, nil
+		}
+	}
+	return tnet.Position{}, fmt.Errorf("town %d: %w", townID, gameworld.TownNotFound)
+}
+
+// GetAmbientLight returns the light in the game world at the current time of
+// day.
 func (m *Map) GetAmbientLight() (dat.DatasetColor, uint8) {
-	return gameworld.NightAmbient, gameworld.NightAmbientLevel
+	return gameworld.AmbientLight(time.Now())
 }
 
 func (m *Map) AddCreature(c gameworld.Creature) error {
 	glog.V(2).Infof("adding creature %d", c.GetID())
 	m.creatures[c.GetID()] = c
-	if t, err := m.GetMapTile(c.GetPos().X, c.GetPos().Y, c.GetPos().Floor); err != nil {
+	p := posFromCoord(c.GetPos().X, c.GetPos().Y, c.GetPos().Floor)
+	defer m.lock()()
+	if err := m.loadArea(areaBase(p)); err != nil {
 		return err
-	} else {
-		glog.V(2).Infof("adding creature to %d %d %d", c.GetPos().X, c.GetPos().Y, c.GetPos().Floor)
-
-		// HACK: tile has no ground? add it.
-		// REMOVE THIS once maps are correctly loaded.
-		if i, err := t.GetItem(0); err != nil || i == nil {
-			glog.V(2).Info("  but first adding some ground for the creature")
-			item := mapItem{
-				ancestorMap:   m,
-				parentTile:    t.(*mapTile),
-				parentItem:    nil,
-				otbItemTypeID: 100,
-			}
-			t.(*mapTile).ground = item
-		}
+	}
+	t := m.tileAt(p)
+	if t == nil {
+		t = m.createTile(p)
+	}
+	glog.V(2).Infof("adding creature to %d %d %d", c.GetPos().X, c.GetPos().Y, c.GetPos().Floor)
 
-		return t.AddCreature(c)
+	// HACK: tile has no ground? add it.
+	// REMOVE THIS once maps are correctly loaded.
+	if t.ground() == nil {
+		glog.V(2).Info("  but first adding some ground for the creature")
+		t.items = append([]*mapItem{m.newItem(100, 0, nil)}, t.items...)
 	}
+
+	return t.AddCreature(c)
 }
 
 func (m *Map) GetMapTile(x, y uint16, z uint8) (gameworld.MapTile, error) {
-	pos := posFromCoord(x, y, z)
-	if t, ok := m.tiles[pos]; ok { //tnet.Position{x, y, z}]; ok {
+	p := posFromCoord(x, y, z)
+	defer m.lock()()
+	if err := m.loadArea(areaBase(p)); err != nil {
+		return nil, err
+	}
+	if t := m.tileAt(p); t != nil {
 		return t, nil
 	}
 	//return fmt.Errorf("tile not found") // TODO(ivucica): we should not return a tile
-	return &mapTile{parent: m, ownPos: pos}, nil
+	return m.void, nil
 }
 
 func (m *Map) GetCreatureByIDBytes(idBytes [4]byte) (gameworld.Creature, error) {
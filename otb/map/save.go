@@ -0,0 +1,445 @@
+package otbm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"badc0de.net/pkg/go-tibia/otb"
+)
+
+// Save writes the map in the OTBM format into the passed writer, such that it
+// can be loaded again with New.
+//
+// Creatures on the map are not saved; neither are spawns and houses, which
+// are kept in files referred to by the map.
+func (m *Map) Save(w io.Writer) error {
+	defer m.lock()()
+	if err := m.loadAll(); err != nil {
+		return err
+	}
+
+	ow, err := otb.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	if err := ow.StartNode(uint8(OTBM_ROOT)); err != nil {
+		return err
+	}
+	if err := binary.Write(ow, binary.LittleEndian, m.header); err != nil {
+		return fmt.Errorf("error writing otbm root node header attrs: %v", err)
+	}
+	if err := m.writeMapDataNode(ow); err != nil {
+		return err
+	}
+	if err := ow.EndNode(); err != nil {
+		return err
+	}
+	return ow.Flush()
+}
+
+// writeMapDataNode writes the node holding the map's attributes, its tiles,
+// towns and waypoints.
+func (m *Map) writeMapDataNode(ow *otb.Writer) error {
+	if err := ow.StartNode(uint8(OTBM_MAP_DATA)); err != nil {
+		return err
+	}
+	for _, desc := range m.desc {
+		if err := writeStringAttr(ow, OTBM_ATTR_DESCRIPTION, desc); err != nil {
+			return err
+		}
+	}
+	if m.extSpawnFile != "" {
+		if err := writeStringAttr(ow, OTBM_ATTR_EXT_SPAWN_FILE, m.extSpawnFile); err != nil {
+			return err
+		}
+	}
+	if m.extHouseFile != "" {
+		if err := writeStringAttr(ow, OTBM_ATTR_EXT_HOUSE_FILE, m.extHouseFile); err != nil {
+			return err
+		}
+	}
+
+	if err := m.writeTileAreaNodes(ow); err != nil {
+		return err
+	}
+	if err := m.writeTownsNode(ow); err != nil {
+		return err
+	}
+	if err := m.writeWaypointsNode(ow); err != nil {
+		return err
+	}
+	return ow.EndNode()
+}
+
+// writeTileAreaNodes writes the tiles of the map, grouped into areas of
+// 256x256 tiles on a single floor, as positions of tiles are stored relative to
+// their area.
+func (m *Map) writeTileAreaNodes(ow *otb.Writer) error {
+	sorted := m.sortedTiles()
+	sort.SliceStable(sorted, func(i, j int) bool { return areaBase(sorted[i].ownPos) < areaBase(sorted[j].ownPos) })
+
+	var areas [][]*mapTile
+	var bases []pos
+	for _, t := range sorted {
+		base := areaBase(t.ownPos)
+		if len(bases) == 0 || bases[len(bases)-1] != base {
+			bases = append(bases, base)
+			areas = append(areas, nil)
+		}
+		areas[len(areas)-1] = append(areas[len(areas)-1], t)
+	}
+
+	for i, base := range bases {
+		tiles := areas[i]
+
+		if err := ow.StartNode(uint8(OTBM_TILE_AREA)); err != nil {
+			return err
+		}
+		if err := binary.Write(ow, binary.LittleEndian, struct {
+			X, Y  uint16
+			Floor uint8
+		}{base.X(), base.Y(), base.Floor()}); err != nil {
+			return fmt.Errorf("error writing props of tile area node: %v", err)
+		}
+		for _, t := range tiles {
+			if err := m.writeTileNode(ow, t, base); err != nil {
+				return fmt.Errorf("error writing tile %s: %v", t.ownPos, err)
+			}
+		}
+		if err := ow.EndNode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTileNode writes a single tile, as a house tile if it belongs to a
+// house.
+//
+// A ground without any attributes is written as a tile attribute; all other
+// items are written as child nodes.
+func (m *Map) writeTileNode(ow *otb.Writer, t *mapTile, base pos) error {
+	nt := OTBM_TILE
+	if t.houseID != 0 {
+		nt = OTBM_HOUSETILE
+	}
+	if err := ow.StartNode(uint8(nt)); err != nil {
+		return err
+	}
+	if _, err := ow.Write([]byte{uint8(t.ownPos.X() - base.X()), uint8(t.ownPos.Y() - base.Y())}); err != nil {
+		return err
+	}
+	if t.houseID != 0 {
+		if err := binary.Write(ow, binary.LittleEndian, t.houseID); err != nil {
+			return err
+		}
+	}
+	if t.flags != 0 {
+		if _, err := ow.Write([]byte{uint8(OTBM_ATTR_TILE_FLAGS)}); err != nil {
+			return err
+		}
+		if err := binary.Write(ow, binary.LittleEndian, t.flags); err != nil {
+			return err
+		}
+	}
+
+	items := t.items
+	if g := t.ground(); g != nil && m.isPlain(g) {
+		if _, err := ow.Write([]byte{uint8(OTBM_ATTR_ITEM)}); err != nil {
+			return err
+		}
+		if err := binary.Write(ow, binary.LittleEndian, g.GetServerType()); err != nil {
+			return err
+		}
+		items = items[1:]
+	}
+	for _, item := range items {
+		if err := m.writeItemNode(ow, item); err != nil {
+			return err
+		}
+	}
+	return ow.EndNode()
+}
+
+// isPlain returns whether the item can be written as a tile attribute rather
+// than as a node, i.e. it has no attributes and no contents, and reading it
+// back as a tile attribute results in the same item.
+func (m *Map) isPlain(i *mapItem) bool {
+	// Countable items carry a count as a tile attribute in the first version
+	// of the format, which cannot be written for the item to remain plain.
+	return i.count == 1 && i.a().empty() && i.typ.otb != nil && !m.hasCountByte(i.GetServerType())
+}
+
+// writeItemNode writes a single item with all its attributes, followed by the
+// items it contains.
+func (m *Map) writeItemNode(ow *otb.Writer, item *mapItem) error {
+	if err := ow.StartNode(uint8(OTBM_ITEM)); err != nil {
+		return err
+	}
+	if err := binary.Write(ow, binary.LittleEndian, item.GetServerType()); err != nil {
+		return err
+	}
+	countByte := m.hasCountByte(item.GetServerType())
+	if countByte {
+		if _, err := ow.Write([]byte{uint8(item.count)}); err != nil {
+			return err
+		}
+	}
+
+	a := item.a()
+	// Attributes are written in the same order as the map editor does.
+	if a.actionID != 0 {
+		if err := writeUint16Attr(ow, OTBM_ATTR_ACTION_ID, a.actionID); err != nil {
+			return err
+		}
+	}
+	if a.uniqueID != 0 {
+		if err := writeUint16Attr(ow, OTBM_ATTR_UNIQUE_ID, a.uniqueID); err != nil {
+			return err
+		}
+	}
+	if a.text != "" {
+		if err := writeStringAttr(ow, OTBM_ATTR_TEXT, a.text); err != nil {
+			return err
+		}
+	}
+	if a.teleDest != 0 {
+		if _, err := ow.Write([]byte{uint8(OTBM_ATTR_TELE_DEST)}); err != nil {
+			return err
+		}
+		if err := binary.Write(ow, binary.LittleEndian, struct {
+			X, Y  uint16
+			Floor uint8
+		}{a.teleDest.X(), a.teleDest.Y(), a.teleDest.Floor()}); err != nil {
+			return err
+		}
+	}
+	if a.depotID != 0 {
+		if err := writeUint16Attr(ow, OTBM_ATTR_DEPOT_ID, a.depotID); err != nil {
+			return err
+		}
+	}
+	if a.houseDoorID != 0 {
+		if _, err := ow.Write([]byte{uint8(OTBM_ATTR_HOUSEDOORID), a.houseDoorID}); err != nil {
+			return err
+		}
+	}
+	if item.count != 0 && !countByte {
+		if _, err := ow.Write([]byte{uint8(OTBM_ATTR_COUNT), uint8(item.count)}); err != nil {
+			return err
+		}
+	}
+	if a.charges != 0 {
+		if err := writeUint16Attr(ow, OTBM_ATTR_CHARGES, a.charges); err != nil {
+			return err
+		}
+	}
+	if a.runeCharges != 0 {
+		if _, err := ow.Write([]byte{uint8(OTBM_ATTR_RUNE_CHARGES), uint8(a.runeCharges)}); err != nil {
+			return err
+		}
+	}
+	if a.duration != 0 {
+		if err := writeUint32Attr(ow, OTBM_ATTR_DURATION, a.duration); err != nil {
+			return err
+		}
+	}
+	if a.decayingState != 0 {
+		if _, err := ow.Write([]byte{uint8(OTBM_ATTR_DECAYING_STATE), a.decayingState}); err != nil {
+			return err
+		}
+	}
+	if a.writtenDate != 0 {
+		if err := writeUint32Attr(ow, OTBM_ATTR_WRITTENDATE, a.writtenDate); err != nil {
+			return err
+		}
+	}
+	if a.writtenBy != "" {
+		if err := writeStringAttr(ow, OTBM_ATTR_WRITTENBY, a.writtenBy); err != nil {
+			return err
+		}
+	}
+	if a.sleeperGUID != 0 {
+		if err := writeUint32Attr(ow, OTBM_ATTR_SLEEPERGUID, a.sleeperGUID); err != nil {
+			return err
+		}
+	}
+	if a.sleepStart != 0 {
+		if err := writeUint32Attr(ow, OTBM_ATTR_SLEEPSTART, a.sleepStart); err != nil {
+			return err
+		}
+	}
+	if a.desc != "" {
+		if err := writeStringAttr(ow, OTBM_ATTR_DESC, a.desc); err != nil {
+			return err
+		}
+	}
+	if len(a.attributeMap) != 0 {
+		if err := writeAttributeMap(ow, a.attributeMap); err != nil {
+			return err
+		}
+	}
+
+	for _, content := range a.contents {
+		if err := m.writeItemNode(ow, content); err != nil {
+			return err
+		}
+	}
+	return ow.EndNode()
+}
+
+// writeTownsNode writes the towns on the map, if any.
+func (m *Map) writeTownsNode(ow *otb.Writer) error {
+	if len(m.towns) == 0 {
+		return nil
+	}
+	if err := ow.StartNode(uint8(OTBM_TOWNS)); err != nil {
+		return err
+	}
+	for _, t := range m.towns {
+		if err := ow.StartNode(uint8(OTBM_TOWN)); err != nil {
+			return err
+		}
+		if err := binary.Write(ow, binary.LittleEndian, t.ID); err != nil {
+			return err
+		}
+		if err := writeString(ow, t.Name); err != nil {
+			return err
+		}
+		if err := binary.Write(ow, binary.LittleEndian, t.TemplePos); err != nil {
+			return err
+		}
+		if err := ow.EndNode(); err != nil {
+			return err
+		}
+	}
+	return ow.EndNode()
+}
+
+// writeWaypointsNode writes the waypoints on the map, if any.
+func (m *Map) writeWaypointsNode(ow *otb.Writer) error {
+	if len(m.waypoints) == 0 {
+		return nil
+	}
+	if err := ow.StartNode(uint8(OTBM_WAYPOINTS)); err != nil {
+		return err
+	}
+	for _, w := range m.waypoints {
+		if err := ow.StartNode(uint8(OTBM_WAYPOINT)); err != nil {
+			return err
+		}
+		if err := writeString(ow, w.Name); err != nil {
+			return err
+		}
+		if err := binary.Write(ow, binary.LittleEndian, w.Pos); err != nil {
+			return err
+		}
+		if err := ow.EndNode(); err != nil {
+			return err
+		}
+	}
+	return ow.EndNode()
+}
+
+// writeString writes a string prefixed by its length.
+func writeString(ow *otb.Writer, s string) error {
+	if len(s) > 0xFFFF {
+		return fmt.Errorf("string of %d bytes is too long", len(s))
+	}
+	if err := binary.Write(ow, binary.LittleEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := ow.Write([]byte(s))
+	return err
+}
+
+// writeStringAttr writes an attribute with a string value.
+func writeStringAttr(ow *otb.Writer, attr ItemAttribute, s string) error {
+	if _, err := ow.Write([]byte{uint8(attr)}); err != nil {
+		return err
+	}
+	return writeString(ow, s)
+}
+
+// writeUint16Attr writes an attribute with a 16-bit value.
+func writeUint16Attr(ow *otb.Writer, attr ItemAttribute, v uint16) error {
+	if _, err := ow.Write([]byte{uint8(attr)}); err != nil {
+		return err
+	}
+	return binary.Write(ow, binary.LittleEndian, v)
+}
+
+// writeUint32Attr writes an attribute with a 32-bit value.
+func writeUint32Attr(ow *otb.Writer, attr ItemAttribute, v uint32) error {
+	if _, err := ow.Write([]byte{uint8(attr)}); err != nil {
+		return err
+	}
+	return binary.Write(ow, binary.LittleEndian, v)
+}
+
+// writeAttributeMap writes custom item attributes, sorted by their name so
+// that saving a map is deterministic.
+func writeAttributeMap(ow *otb.Writer, attributes map[string]interface{}) error {
+	if len(attributes) > 0xFFFF {
+		return fmt.Errorf("%d attributes are too many", len(attributes))
+	}
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if _, err := ow.Write([]byte{uint8(OTBM_ATTR_ATTRIBUTE_MAP)}); err != nil {
+		return err
+	}
+	if err := binary.Write(ow, binary.LittleEndian, uint16(len(keys))); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := writeString(ow, key); err != nil {
+			return err
+		}
+		var err error
+		switch val := attributes[key].(type) {
+		case string:
+			if _, err := ow.Write([]byte{attributeMapString}); err != nil {
+				return err
+			}
+			if err := binary.Write(ow, binary.LittleEndian, uint32(len(val))); err != nil {
+				return err
+			}
+			_, err = ow.Write([]byte(val))
+		case int32:
+			if _, err := ow.Write([]byte{attributeMapInteger}); err != nil {
+				return err
+			}
+			err = binary.Write(ow, binary.LittleEndian, val)
+		case float32:
+			if _, err := ow.Write([]byte{attributeMapFloat}); err != nil {
+				return err
+			}
+			err = binary.Write(ow, binary.LittleEndian, math.Float32bits(val))
+		case float64:
+			if _, err := ow.Write([]byte{attributeMapDouble}); err != nil {
+				return err
+			}
+			err = binary.Write(ow, binary.LittleEndian, math.Float64bits(val))
+		case bool:
+			var b uint8
+			if val {
+				b = 1
+			}
+			_, err = ow.Write([]byte{attributeMapBoolean, b})
+		default:
+			return fmt.Errorf("attribute %q has unsupported type %T", key, val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package otbm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+// syntheticThingsForMap returns a things registry knowing all the items on
+// the passed map, as items.otb cannot be shipped with the repository. Items
+// stored as tile attributes are taken to be ground, as map editors store
+// ground that way; all other items are plain items.
+func syntheticThingsForMap(t testing.TB, buf []byte) *things.Things {
+	t.Helper()
+	f, err := otb.NewOTB(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("reading map as otb: %v", err)
+	}
+	groups := map[uint16]itemsotb.ItemGroup{}
+	var walk func(n *otb.OTBNode)
+	walk = func(n *otb.OTBNode) {
+		for ; n != nil; n = n.NextNode() {
+			props := n.PropsBuffer().Bytes()
+			switch MapNodeType(n.NodeType()) {
+			case OTBM_TILE, OTBM_HOUSETILE:
+				props = props[2:]
+				if MapNodeType(n.NodeType()) == OTBM_HOUSETILE {
+					props = props[4:]
+				}
+				for len(props) > 0 {
+					switch ItemAttribute(props[0]) {
+					case OTBM_ATTR_TILE_FLAGS:
+						props = props[5:]
+					case OTBM_ATTR_ITEM:
+						groups[binary.LittleEndian.Uint16(props[1:])] = itemsotb.ITEM_GROUP_GROUND
+						props = props[3:]
+					default:
+						t.Fatalf("unexpected tile attribute %s", ItemAttribute(props[0]))
+					}
+				}
+			case OTBM_ITEM:
+				if id := binary.LittleEndian.Uint16(props); groups[id] == 0 {
+					groups[id] = itemsotb.ITEM_GROUP_NONE
+				}
+			}
+			walk(n.ChildNode())
+		}
+	}
+	walk(f.ChildNode(nil))
+
+	// Items added to the map by tests; coins are stackable.
+	groups[1987] = itemsotb.ITEM_GROUP_CONTAINER
+	groups[2148] = itemsotb.ITEM_GROUP_NONE
+	return syntheticThings(t, groups, map[uint16]itemsotb.ItemsFlags{2148: itemsotb.FLAG_STACKABLE})
+}
+
+// syntheticThings returns a things registry knowing items of the passed
+// groups, with the passed flags.
+func syntheticThings(t testing.TB, groups map[uint16]itemsotb.ItemGroup, flags map[uint16]itemsotb.ItemsFlags) *things.Things {
+	t.Helper()
+	items := &itemsotb.Items{
+		ServerIDToArrayIndex: map[uint16]int{},
+		ClientIDToArrayIndex: map[uint16]int{},
+	}
+	for id, group := range groups {
+		items.ServerIDToArrayIndex[id] = len(items.Items)
+		items.ClientIDToArrayIndex[id] = len(items.Items)
+		items.Items = append(items.Items, itemsotb.Item{
+			Group: group,
+			Flags: flags[id],
+			Attributes: map[itemsotb.ItemsAttribute]interface{}{
+				itemsotb.ITEM_ATTR_SERVERID: id,
+				itemsotb.ITEM_ATTR_CLIENTID: id,
+			},
+		})
+	}
+	th, err := things.New()
+	if err != nil {
+		t.Fatalf("creating things registry: %v", err)
+	}
+	th.AddItemsOTB(items)
+	return th
+}
+
+// describeItem returns everything stored about the item, including its
+// contents.
+func describeItem(i *mapItem) string {
+	var contents []string
+	a := i.a()
+	for _, c := range a.contents {
+		contents = append(contents, describeItem(c))
+	}
+	return fmt.Sprintf("%d count=%d charges=%d runecharges=%d aid=%d uid=%d depot=%d tele=%s text=%q door=%d duration=%d decaying=%d written=%d,%q sleeper=%d,%d desc=%q attrs=%v [%s]",
+		i.GetServerType(), i.count, a.charges, a.runeCharges, a.actionID, a.uniqueID, a.depotID, a.teleDest, a.text, a.houseDoorID,
+		a.duration, a.decayingState, a.writtenDate, a.writtenBy, a.sleeperGUID, a.sleepStart, a.desc, a.attributeMap, strings.Join(contents, ", "))
+}
+
+// describeTiles returns everything stored about the tiles on the map.
+func describeTiles(m *Map) map[pos]string {
+	tiles := map[pos]string{}
+	for _, t := range m.sortedTiles() {
+		desc := []string{fmt.Sprintf("house=%d flags=%x", t.houseID, t.flags)}
+		for idx := 0; ; idx++ {
+			item, err := t.GetItem(idx)
+			if err != nil {
+				break
+			}
+			desc = append(desc, describeItem(item.(*mapItem)))
+		}
+		tiles[t.ownPos] = strings.Join(desc, "; ")
+	}
+	return tiles
+}
+
+func TestSave(t *testing.T) {
+	buf, err := ioutil.ReadFile("../../datafiles/range-test-map.otbm")
+	if err != nil {
+		t.Skipf("skipping because no file: %v", err)
+	}
+	th := syntheticThingsForMap(t, buf)
+	m, err := New(bytes.NewReader(buf), th)
+	if err != nil {
+		t.Fatalf("loading map: %v", err)
+	}
+	if m.tileCount == 0 {
+		t.Fatalf("no tiles loaded")
+	}
+
+	// Exercise everything the test map does not use.
+	m.waypoints = append(m.waypoints, gameworld.Waypoint{Name: "Quest \xfe\xff", Pos: tnet.Position{X: 100, Y: 102, Floor: 7}})
+	m.towns = append(m.towns, gameworld.Town{ID: 0xFD, Name: "Second", TemplePos: tnet.Position{X: 0xFE, Y: 0xFF, Floor: 7}})
+	m.desc = append(m.desc, "saved by a test")
+	m.extHouseFile = "range-test-map-house.xml"
+	tile, err := m.GetMapTile(100, 101, 7)
+	if err != nil {
+		t.Fatalf("getting tile: %v", err)
+	}
+	tile.(*mapTile).houseID = 3
+	m.houseTiles[3] = append(m.houseTiles[3], posFromCoord(100, 101, 7))
+	tile.(*mapTile).flags = gwmap.TILE_FLAG_PROTECTIONZONE | gwmap.TILE_FLAG_NOLOGOUT
+	coins := m.newItem(2148, 0xFF, &mapItemAttrs{duration: 0xFEFF, decayingState: 1, desc: "shiny",
+		attributeMap: map[string]interface{}{"s": "\xff", "i": int32(-2), "f": float32(0.5), "d": 0.25, "b": true}})
+	bag := m.newItem(1987, 0, &mapItemAttrs{actionID: 0xFEFD, uniqueID: 2000, text: "a\xffb", depotID: 1, houseDoorID: 2, teleDest: posFromCoord(100, 100, 7), charges: 3, runeCharges: 4, writtenDate: 1600000000, writtenBy: "Someone", sleeperGUID: 5, sleepStart: 6, contents: []*mapItem{coins}})
+	if err := tile.(*mapTile).addItem(bag); err != nil {
+		t.Fatalf("adding bag: %v", err)
+	}
+
+	saved := &bytes.Buffer{}
+	if err := m.Save(saved); err != nil {
+		t.Fatalf("saving map: %v", err)
+	}
+	reloaded, err := New(bytes.NewReader(saved.Bytes()), th)
+	if err != nil {
+		t.Fatalf("reloading map: %v", err)
+	}
+
+	if got, want := describeTiles(reloaded), describeTiles(m); !reflect.DeepEqual(got, want) {
+		for p := range want {
+			if got[p] != want[p] {
+				t.Errorf("tile at %s is %q, want %q", p, got[p], want[p])
+			}
+		}
+		t.Errorf("reloaded %d tiles, want %d", len(got), len(want))
+	}
+	if tile, err := reloaded.GetMapTile(100, 101, 7); err != nil {
+		t.Errorf("getting reloaded tile: %v", err)
+	} else if got, want := tile.(gwmap.FlaggedMapTile).GetFlags(), gwmap.TILE_FLAG_PROTECTIONZONE|gwmap.TILE_FLAG_NOLOGOUT; got != want {
+		t.Errorf("reloaded tile flags %x, want %x", got, want)
+	}
+	if !reflect.DeepEqual(reloaded.Towns(), m.Towns()) || !reflect.DeepEqual(reloaded.Waypoints(), m.Waypoints()) {
+		t.Errorf("reloaded towns %v and waypoints %v, want %v and %v", reloaded.Towns(), reloaded.Waypoints(), m.Towns(), m.Waypoints())
+	}
+	if reloaded.header != m.header || !reflect.DeepEqual(reloaded.desc, m.desc) || reloaded.extSpawnFile != m.extSpawnFile || reloaded.extHouseFile != m.extHouseFile {
+		t.Errorf("reloaded map %+v %q %q %q, want %+v %q %q %q", reloaded.header, reloaded.desc, reloaded.extSpawnFile, reloaded.extHouseFile, m.header, m.desc, m.extSpawnFile, m.extHouseFile)
+	}
+	if !reflect.DeepEqual(reloaded.HouseTiles(), m.HouseTiles()) {
+		t.Errorf("reloaded house tiles %v, want %v", reloaded.HouseTiles(), m.HouseTiles())
+	}
+
+	// Saving the reloaded map results in the same file.
+	resaved := &bytes.Buffer{}
+	if err := reloaded.Save(resaved); err != nil {
+		t.Fatalf("saving reloaded map: %v", err)
+	}
+	if !bytes.Equal(resaved.Bytes(), saved.Bytes()) {
+		t.Errorf("saving reloaded map resulted in a different file")
+	}
+}
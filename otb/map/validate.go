@@ -0,0 +1,245 @@
+package otbm
+
+import (
+	"fmt"
+	"sort"
+
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+// ProblemKind identifies what is wrong with a map; see Validate.
+type ProblemKind string
+
+const (
+	PROBLEM_UNKNOWN_ITEM        ProblemKind = "unknown-item"        // Item not in items.otb.
+	PROBLEM_NO_CLIENT_ID        ProblemKind = "no-client-id"        // Item without a client ID, or with a client ID not in Tibia.dat.
+	PROBLEM_NO_GROUND           ProblemKind = "no-ground"           // Tile without ground.
+	PROBLEM_BLOCKED_CREATURE    ProblemKind = "blocked-creature"    // Spawned creature where it cannot stand.
+	PROBLEM_BLOCKED_TEMPLE      ProblemKind = "blocked-temple"      // Town temple where players cannot stand.
+	PROBLEM_UNREACHABLE_TEMPLE  ProblemKind = "unreachable-temple"  // Town temple from which players cannot walk far.
+	PROBLEM_BAD_TELEPORT        ProblemKind = "bad-teleport"        // Teleport to where players cannot stand.
+	PROBLEM_DUPLICATE_UNIQUE_ID ProblemKind = "duplicate-unique-id" // Unique ID used by more than one item.
+	PROBLEM_ORPHAN_HOUSE_DOOR   ProblemKind = "orphan-house-door"   // House door on a tile not belonging to a house.
+)
+
+// Problem is something wrong with the map, found at a position on the map.
+type Problem struct {
+	Kind    ProblemKind   `json:"kind"`
+	Pos     tnet.Position `json:"pos"`
+	Message string        `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("(%d,%d,%d) %s: %s", p.Pos.X, p.Pos.Y, p.Pos.Floor, p.Kind, p.Message)
+}
+
+// DefaultMinTempleArea is the number of tiles which must be reachable from a
+// temple, unless ValidateOptions says otherwise.
+const DefaultMinTempleArea = 100
+
+// ValidateOptions configures the checks done by Validate.
+type ValidateOptions struct {
+	// Spawns placing creatures on the map; if nil, spawned creatures are
+	// not checked.
+	Spawns *xmls.Spawns
+
+	// MinTempleArea is the number of tiles which must be reachable by
+	// walking from each temple, for the temple not to be considered walled
+	// in. If zero, DefaultMinTempleArea is used.
+	MinTempleArea int
+}
+
+// Validate checks the map for problems which would show up once players are
+// on it, and returns them sorted by position.
+//
+// Whether an item is known and whether it blocks creatures is looked up in
+// the things registry the map was read with. Reachability follows stairs,
+// ramps and holes as marked in items.otb, but not ladders or other items
+// which need to be used.
+//
+// A lazily opened map is read in full.
+func (m *Map) Validate(opts ValidateOptions) ([]Problem, error) {
+	defer m.lock()()
+	if err := m.loadAll(); err != nil {
+		return nil, err
+	}
+	if opts.MinTempleArea == 0 {
+		opts.MinTempleArea = DefaultMinTempleArea
+	}
+
+	var problems []Problem
+	report := func(kind ProblemKind, p pos, format string, args ...interface{}) {
+		problems = append(problems, Problem{Kind: kind, Pos: p.Position(), Message: fmt.Sprintf(format, args...)})
+	}
+
+	datItems := m.things.Temp__DATItemCount(0)
+	uniqueIDs := map[uint16][]pos{}
+	var checkItem func(t *mapTile, item *mapItem)
+	checkItem = func(t *mapTile, item *mapItem) {
+		typ := item.typ
+		switch {
+		case typ.otb == nil:
+			report(PROBLEM_UNKNOWN_ITEM, t.ownPos, "item %d is not in items.otb", typ.id)
+		case typ.clientID == 0:
+			report(PROBLEM_NO_CLIENT_ID, t.ownPos, "item %d has no client id", typ.id)
+		case datItems > 0 && (typ.clientID < 100 || int(typ.clientID) >= 100+datItems):
+			report(PROBLEM_NO_CLIENT_ID, t.ownPos, "item %d has client id %d, which is not in Tibia.dat", typ.id, typ.clientID)
+		}
+
+		a := item.a()
+		if a.uniqueID != 0 {
+			uniqueIDs[a.uniqueID] = append(uniqueIDs[a.uniqueID], t.ownPos)
+		}
+		if a.teleDest != 0 {
+			if why := m.blocked(a.teleDest); why != "" {
+				report(PROBLEM_BAD_TELEPORT, t.ownPos, "teleport leads to %s, which %s", a.teleDest, why)
+			}
+		}
+		if a.houseDoorID != 0 && t.houseID == 0 {
+			report(PROBLEM_ORPHAN_HOUSE_DOOR, t.ownPos, "house door %d is not on a house tile", a.houseDoorID)
+		}
+		for _, c := range a.contents {
+			checkItem(t, c)
+		}
+	}
+	for _, d := range m.droppedItems {
+		report(PROBLEM_UNKNOWN_ITEM, d.pos, "item %d is not in items.otb, and was left out of the map", d.serverID)
+	}
+	for _, t := range m.sortedTiles() {
+		if t.ground() == nil {
+			report(PROBLEM_NO_GROUND, t.ownPos, "tile has no ground")
+		}
+		for _, item := range t.items {
+			checkItem(t, item)
+		}
+	}
+
+	ids := make([]int, 0, len(uniqueIDs))
+	for id := range uniqueIDs {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		ps := uniqueIDs[uint16(id)]
+		if len(ps) < 2 {
+			continue
+		}
+		for _, p := range ps {
+			report(PROBLEM_DUPLICATE_UNIQUE_ID, p, "unique id %d is used by %d items", id, len(ps))
+		}
+	}
+
+	for _, town := range m.towns {
+		temple := posFromCoord(town.TemplePos.X, town.TemplePos.Y, town.TemplePos.Floor)
+		if why := m.blocked(temple); why != "" {
+			report(PROBLEM_BLOCKED_TEMPLE, temple, "temple of town %q (%d) %s", town.Name, town.ID, why)
+		} else if area := m.reachableArea(temple, opts.MinTempleArea); area < opts.MinTempleArea {
+			report(PROBLEM_UNREACHABLE_TEMPLE, temple, "only %d tiles can be reached from the temple of town %q (%d)", area, town.Name, town.ID)
+		}
+	}
+
+	if opts.Spawns != nil {
+		checkCreature := func(spawn xmls.Spawn, c xmls.SpawnCreature, what string) {
+			x, y := spawn.CenterX+c.X, spawn.CenterY+c.Y
+			if x < 0 || x > 0xFFFF || y < 0 || y > 0xFFFF || c.Z < 0 || c.Z > 15 {
+				report(PROBLEM_BLOCKED_CREATURE, posFromCoord(uint16(spawn.CenterX), uint16(spawn.CenterY), uint8(spawn.CenterZ)), "%s %q is placed outside the map, at (%d,%d,%d)", what, c.Name, x, y, c.Z)
+				return
+			}
+			p := posFromCoord(uint16(x), uint16(y), uint8(c.Z))
+			if why := m.blocked(p); why != "" {
+				report(PROBLEM_BLOCKED_CREATURE, p, "%s %q is placed on a tile which %s", what, c.Name, why)
+			}
+		}
+		for _, spawn := range opts.Spawns.Spawn {
+			for _, c := range spawn.Monster {
+				checkCreature(spawn, c, "monster")
+			}
+			for _, c := range spawn.NPC {
+				checkCreature(spawn, c, "npc")
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		pi, pj := problems[i].Pos, problems[j].Pos
+		return posFromCoord(pi.X, pi.Y, pi.Floor) < posFromCoord(pj.X, pj.Y, pj.Floor)
+	})
+	return problems, nil
+}
+
+// blocked returns why a creature cannot stand at the passed position, or an
+// empty string if it can. Other creatures are not taken into account.
+func (m *Map) blocked(p pos) string {
+	t := m.tileAt(p)
+	if t == nil {
+		return "is void"
+	}
+	if t.ground() == nil {
+		return "has no ground"
+	}
+	for _, item := range t.items {
+		if item.typ.otb != nil && item.typ.otb.Flags.BlockSolid() {
+			return fmt.Sprintf("is blocked by item %d", item.typ.id)
+		}
+	}
+	return ""
+}
+
+// reachableArea returns the number of tiles which can be walked to from the
+// passed position, stopping once the passed limit is reached.
+func (m *Map) reachableArea(start pos, limit int) int {
+	seen := map[pos]bool{start: true}
+	queue := []pos{start}
+	visit := func(x, y, floor int) {
+		if x < 0 || x > 0xFFFF || y < 0 || y > 0xFFFF || floor < 0 || floor > 15 {
+			return
+		}
+		p := posFromCoord(uint16(x), uint16(y), uint8(floor))
+		if seen[p] || m.blocked(p) != "" {
+			return
+		}
+		seen[p] = true
+		queue = append(queue, p)
+	}
+	for len(queue) > 0 && len(seen) < limit {
+		p := queue[0]
+		queue = queue[1:]
+		x, y, floor := int(p.X()), int(p.Y()), int(p.Floor())
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				visit(x+dx, y+dy, floor)
+			}
+		}
+
+		// Follow floor changes: holes lead down next to where they are,
+		// stairs and ramps lead up in the direction they face.
+		var flags itemsotb.ItemsFlags
+		for _, item := range m.tileAt(p).items {
+			if item.typ.otb != nil {
+				flags |= item.typ.otb.Flags
+			}
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGEDOWN != 0 {
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					visit(x+dx, y+dy, floor+1)
+				}
+			}
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGENORTH != 0 {
+			visit(x, y-1, floor-1)
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGEEAST != 0 {
+			visit(x+1, y, floor-1)
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGESOUTH != 0 {
+			visit(x, y+1, floor-1)
+		}
+		if flags&itemsotb.FLAG_FLOORCHANGEWEST != 0 {
+			visit(x-1, y, floor-1)
+		}
+	}
+	return len(seen)
+}
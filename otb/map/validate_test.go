@@ -0,0 +1,106 @@
+package otbm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/gameworld"
+	tnet "badc0de.net/pkg/go-tibia/net"
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/xmls"
+)
+
+func TestValidate(t *testing.T) {
+	const (
+		ground  = 100
+		wall    = 150
+		hole    = 160
+		item    = 200
+		unknown = 999
+	)
+	m := newMap(syntheticThings(t, map[uint16]itemsotb.ItemGroup{
+		ground: itemsotb.ITEM_GROUP_GROUND,
+		hole:   itemsotb.ITEM_GROUP_GROUND,
+		wall:   itemsotb.ITEM_GROUP_NONE,
+		item:   itemsotb.ITEM_GROUP_NONE,
+	}, map[uint16]itemsotb.ItemsFlags{
+		wall: itemsotb.FLAG_BLOCK_SOLID,
+		hole: itemsotb.FLAG_FLOORCHANGEDOWN,
+	}))
+	fill := func(x1, y1, x2, y2 uint16, floor uint8) {
+		for y := y1; y <= y2; y++ {
+			for x := x1; x <= x2; x++ {
+				m.createTile(posFromCoord(x, y, floor)).addItem(m.newItem(ground, 1, nil))
+			}
+		}
+	}
+	add := func(x, y uint16, floor uint8, id uint16, attrs *mapItemAttrs) *mapTile {
+		tile := m.tileAt(posFromCoord(x, y, floor))
+		if tile == nil {
+			tile = m.createTile(posFromCoord(x, y, floor))
+		}
+		tile.addItem(m.newItem(id, 0, attrs))
+		return tile
+	}
+
+	// Mainland, with a town in the middle.
+	fill(100, 100, 119, 119, 7)
+	m.towns = append(m.towns, gameworld.Town{ID: 1, Name: "Mainland", TemplePos: tnet.Position{X: 110, Y: 110, Floor: 7}})
+	// A temple walled in on a tiny island.
+	fill(198, 198, 202, 202, 7)
+	for i := uint16(198); i <= 202; i++ {
+		add(i, 198, 7, wall, nil)
+		add(i, 202, 7, wall, nil)
+		add(198, i, 7, wall, nil)
+		add(202, i, 7, wall, nil)
+	}
+	m.towns = append(m.towns, gameworld.Town{ID: 2, Name: "Island", TemplePos: tnet.Position{X: 200, Y: 200, Floor: 7}})
+	// A temple in a wall.
+	m.towns = append(m.towns, gameworld.Town{ID: 3, Name: "Walled", TemplePos: tnet.Position{X: 198, Y: 198, Floor: 7}})
+	// A temple on a tiny island above the mainland, with a hole down.
+	fill(110, 110, 111, 111, 6)
+	add(111, 111, 6, hole, nil)
+	m.towns = append(m.towns, gameworld.Town{ID: 4, Name: "Tower", TemplePos: tnet.Position{X: 110, Y: 110, Floor: 6}})
+
+	add(101, 101, 7, unknown, nil)
+	add(102, 101, 8, item, nil)
+	add(103, 101, 7, item, &mapItemAttrs{teleDest: posFromCoord(300, 300, 7)})
+	add(104, 101, 7, item, &mapItemAttrs{teleDest: posFromCoord(105, 105, 7)})
+	add(105, 101, 7, item, &mapItemAttrs{uniqueID: 5})
+	add(106, 101, 7, wall, &mapItemAttrs{uniqueID: 5, houseDoorID: 1})
+	add(107, 101, 7, item, &mapItemAttrs{uniqueID: 6, contents: []*mapItem{m.newItem(unknown, 0, nil)}})
+	houseTile := add(108, 101, 7, item, &mapItemAttrs{houseDoorID: 2})
+	houseTile.houseID = 1
+
+	spawns := &xmls.Spawns{Spawn: []xmls.Spawn{{
+		CenterX: 105, CenterY: 105, CenterZ: 7, Radius: 3,
+		Monster: []xmls.SpawnCreature{{Name: "Rat", X: 1, Y: -4, Z: 7}, {Name: "Rat", X: 0, Y: 0, Z: 7}},
+		NPC:     []xmls.SpawnCreature{{Name: "Sam", X: -200, Y: 0, Z: 7}},
+	}}}
+
+	problems, err := m.Validate(ValidateOptions{Spawns: spawns, MinTempleArea: 50})
+	if err != nil {
+		t.Fatalf("validating: %v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, fmt.Sprintf("%d,%d,%d %s", p.Pos.X, p.Pos.Y, p.Pos.Floor, p.Kind))
+	}
+	want := []string{
+		"101,101,7 unknown-item",
+		"103,101,7 bad-teleport",
+		"105,101,7 duplicate-unique-id",
+		"106,101,7 orphan-house-door",
+		"106,101,7 duplicate-unique-id",
+		"106,101,7 blocked-creature",
+		"107,101,7 unknown-item",
+		"105,105,7 blocked-creature",
+		"198,198,7 blocked-temple",
+		"200,200,7 unreachable-temple",
+		"102,101,8 no-ground",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems:\n%v\nwant:\n%v\nfull:\n%v", got, want, problems)
+	}
+}
@@ -0,0 +1,197 @@
+package otb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/golang/glog"
+)
+
+// Reader reads the nodes of an OTB file one by one, in the order they are
+// stored, without building a tree of them. Unlike NewOTB, which builds the
+// tree using a Reader, it can be used to read files too large to keep in
+// memory.
+//
+// Props of each node are unescaped as they are read, and allocated once at
+// their exact size.
+type Reader struct {
+	// Strict makes Next return an error wrapping io.ErrUnexpectedEOF when the
+	// file ends before all nodes are ended. Otherwise, a warning is logged
+	// and whatever was read of the truncated node is returned as its props.
+	Strict bool
+
+	r       *bufio.Reader
+	offset  int64 // of the next byte read from r
+	open    int   // number of nodes started but not yet ended
+	pending bool  // whether NODE_START of the next node was already read
+	err     error // returned by all further calls to Next
+	scratch []byte
+}
+
+// Node is a single node read by Reader.
+type Node struct {
+	Type uint8
+
+	// Depth of the node in the tree; 0 for the root node. A node is a child
+	// of the closest node before it with a depth lower by one.
+	Depth int
+
+	// Offset of the node's NODE_START byte, from the start of the file.
+	Offset int64
+
+	// Props of the node, unescaped; nil if there are none.
+	Props []byte
+}
+
+// NewReader starts reading an OTB file from the passed reader, checking the
+// version at the start of the file. Nodes are then read using Next.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := NewNodeReader(r)
+	var version uint32
+	if err := binary.Read(rd.r, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("error reading otb version: %v", err)
+	}
+	if version > 0 {
+		return nil, fmt.Errorf("invalid otb version; got %d, want %d", version, 0)
+	}
+	rd.offset = 4
+	return rd, nil
+}
+
+// NewNodeReader returns a reader of nodes from the passed reader, positioned
+// at the start of a node rather than at the start of an OTB file. Offsets of
+// the nodes are from the start of the passed reader.
+func NewNodeReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next node, or io.EOF once all nodes are read.
+func (r *Reader) Next() (*Node, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	n, err := r.next()
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *Reader) next() (*Node, error) {
+	// Skip the ends of any nodes ended right before the next node.
+	for !r.pending {
+		b, err := r.r.ReadByte()
+		if err == io.EOF && r.open == 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, r.truncated(err)
+		}
+		r.offset++
+		switch {
+		case b == NODE_START:
+			r.pending = true
+		case b == NODE_END && r.open == 0:
+			// The end of the node enclosing the nodes read by a node
+			// reader.
+			return nil, io.EOF
+		case b == NODE_END:
+			r.open--
+		default:
+			return nil, fmt.Errorf("expected NODE_START or NODE_END at offset %d, got %x", r.offset-1, b)
+		}
+	}
+
+	n := &Node{Depth: r.open, Offset: r.offset - 1}
+	r.pending = false
+	r.open++
+	nodeType, err := r.r.ReadByte()
+	if err != nil {
+		return nil, r.truncated(err)
+	}
+	r.offset++
+	n.Type = nodeType
+
+	r.scratch = r.scratch[:0]
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			if err = r.truncated(err); err == io.EOF {
+				// Keep whatever was read of the node, as the nodes read
+				// before it are kept.
+				r.err = err
+				break
+			}
+			return nil, err
+		}
+		r.offset++
+		if b == ESCAPE_CHAR {
+			if b, err = r.r.ReadByte(); err != nil {
+				if err = r.truncated(err); err == io.EOF {
+					r.err = err
+					break
+				}
+				return nil, err
+			}
+			r.offset++
+		} else if b == NODE_START {
+			r.pending = true // A child follows.
+			break
+		} else if b == NODE_END {
+			r.open--
+			break
+		}
+		r.scratch = append(r.scratch, b)
+	}
+	if len(r.scratch) > 0 {
+		n.Props = make([]byte, len(r.scratch))
+		copy(n.Props, r.scratch)
+	}
+	return n, nil
+}
+
+// truncated returns the error to return when reading the file fails with the
+// passed error while nodes are not ended: in strict mode or for errors other
+// than the end of the file, an error; otherwise io.EOF, ending the nodes.
+func (r *Reader) truncated(err error) error {
+	if err != io.EOF {
+		return fmt.Errorf("error reading otb at offset %d: %v", r.offset, err)
+	}
+	if r.Strict {
+		return fmt.Errorf("otb ends abruptly at offset %d: %w", r.offset, io.ErrUnexpectedEOF)
+	}
+	glog.Warning("warning: abrupt end to an OTB.")
+	return io.EOF
+}
+
+// ReadTree reads all remaining nodes from the passed reader, and returns the
+// first of them, with the rest attached as its children and siblings.
+func ReadTree(r *Reader) (*OTBNode, error) {
+	var root *OTBNode
+	var last []*OTBNode // The latest node read at each depth.
+	for {
+		n, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		node := &OTBNode{nodeType: n.Type, props: n.Props}
+		switch {
+		case root == nil:
+			root = node
+		case n.Depth < len(last):
+			last[n.Depth].next = node
+		default:
+			last[n.Depth-1].child = node
+		}
+		last = append(last[:n.Depth], node)
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no otb nodes")
+	}
+	return root, nil
+}
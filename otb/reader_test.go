@@ -0,0 +1,140 @@
+package otb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// testFile returns an OTB file with a root with two children, the first of
+// which has a child of its own, and the nodes the file holds.
+func testFile(t *testing.T) ([]byte, []Node) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatalf("creating writer: %v", err)
+	}
+	w.StartNode(0x00)
+	w.WriteProps([]byte{0x01, ESCAPE_CHAR, 0x02})
+	w.StartNode(0x02)
+	w.WriteProps([]byte{NODE_START, NODE_END})
+	w.StartNode(0x04)
+	w.WriteProps([]byte{0x10})
+	w.EndNode()
+	w.EndNode()
+	w.StartNode(0x0C)
+	w.EndNode()
+	w.EndNode()
+	if err := w.Flush(); err != nil {
+		t.Fatalf("flushing writer: %v", err)
+	}
+	return buf.Bytes(), []Node{
+		{Type: 0x00, Depth: 0, Offset: 4, Props: []byte{0x01, ESCAPE_CHAR, 0x02}},
+		{Type: 0x02, Depth: 1, Offset: 10, Props: []byte{NODE_START, NODE_END}},
+		{Type: 0x04, Depth: 2, Offset: 16, Props: []byte{0x10}},
+		{Type: 0x0C, Depth: 1, Offset: 21},
+	}
+}
+
+// readAll returns all nodes read from the passed file, and the error which
+// ended reading.
+func readAll(file []byte, strict bool) ([]Node, error) {
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	r.Strict = strict
+	var nodes []Node
+	for {
+		n, err := r.Next()
+		if err != nil {
+			return nodes, err
+		}
+		nodes = append(nodes, *n)
+	}
+}
+
+func TestReader(t *testing.T) {
+	file, want := testFile(t)
+	for _, strict := range []bool{false, true} {
+		got, err := readAll(file, strict)
+		if err != io.EOF {
+			t.Errorf("reading with strict=%v ended with %v, want io.EOF", strict, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("read with strict=%v %+v, want %+v", strict, got, want)
+		}
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	file, want := testFile(t)
+	// Cut the file anywhere between the start of the root and its end.
+	for l := 5; l < len(file); l++ {
+		if _, err := readAll(file[:l], true); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("strictly reading %d bytes ended with %v, want io.ErrUnexpectedEOF", l, err)
+		}
+
+		got, err := readAll(file[:l], false)
+		if err != io.EOF {
+			t.Errorf("reading %d bytes ended with %v, want io.EOF", l, err)
+		}
+		// All nodes started before the cut are read, the last one possibly
+		// without all of its props.
+		started := 0
+		for _, n := range want {
+			if n.Offset+1 < int64(l) {
+				started++
+			}
+		}
+		if len(got) != started {
+			t.Errorf("read %d nodes of %d bytes, want %d", len(got), l, started)
+		}
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		file []byte
+	}{
+		{"no node", []byte{0, 0, 0, 0, 0x01}},
+		{"data after the root", []byte{0, 0, 0, 0, NODE_START, 0x00, NODE_END, 0x01}},
+	} {
+		if _, err := readAll(tc.file, false); err == nil || err == io.EOF {
+			t.Errorf("%s: reading ended with %v, want an error", tc.name, err)
+		}
+	}
+	if _, err := NewOTB(bytes.NewReader([]byte{0, 0, 0, 0, NODE_END})); err == nil {
+		t.Errorf("reading otb without nodes succeeded")
+	}
+	if _, err := NewReader(bytes.NewReader([]byte{1, 0, 0, 0})); err == nil {
+		t.Errorf("reading otb of version 1 succeeded")
+	}
+}
+
+func TestReadTree(t *testing.T) {
+	file, _ := testFile(t)
+	otb, err := NewOTB(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("reading tree: %v", err)
+	}
+	root := otb.ChildNode(nil)
+	if root.NodeType() != 0x00 || root.ChildNode().NodeType() != 0x02 ||
+		root.ChildNode().ChildNode().NodeType() != 0x04 || root.ChildNode().NextNode().NodeType() != 0x0C ||
+		root.NextNode() != nil || root.ChildNode().ChildNode().NextNode() != nil {
+		t.Errorf("tree read wrongly: %+v", root)
+	}
+
+	// A node read on its own, from its offset, with the node following it.
+	node, err := ReadNode(bytes.NewReader(file[10:]))
+	if err != nil {
+		t.Fatalf("reading node: %v", err)
+	}
+	if node.NodeType() != 0x02 || node.NextNode().NodeType() != 0x0C {
+		t.Errorf("node read wrongly: %+v", node)
+	}
+}
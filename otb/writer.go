@@ -0,0 +1,152 @@
+package otb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Writer writes a tree of nodes in the file format read by NewOTB and Reader.
+//
+// Nodes are written depth-first: a node is started, its props are written,
+// then its children are written, and finally the node is ended. Bytes in
+// props which have a special meaning in the file format are escaped.
+//
+// Meaning of nodes and their props is up to writers of individual formats.
+type Writer struct {
+	w     *bufio.Writer
+	depth int  // number of nodes started but not yet ended
+	child bool // whether the latest started node already has children
+}
+
+// NewWriter starts writing an OTB file into the passed writer. Once all nodes
+// are written, Flush must be called.
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	var version uint32 // Only version 0 can be read back.
+	if err := binary.Write(bw, binary.LittleEndian, version); err != nil {
+		return nil, fmt.Errorf("error writing otb version: %v", err)
+	}
+	return &Writer{w: bw}, nil
+}
+
+// StartNode starts a new node of the passed type. If another node was started
+// and not yet ended, the new node is its child.
+func (w *Writer) StartNode(nodeType uint8) error {
+	if nodeType >= ESCAPE_CHAR {
+		// The node type is read verbatim, so it cannot be escaped.
+		return fmt.Errorf("otb node type 0x%02x cannot be written", nodeType)
+	}
+	w.child = false
+	w.depth++
+	if err := w.w.WriteByte(NODE_START); err != nil {
+		return err
+	}
+	return w.w.WriteByte(nodeType)
+}
+
+// WriteProps appends the passed bytes to the props of the latest started
+// node. Props cannot be written once the node has children.
+func (w *Writer) WriteProps(props []byte) error {
+	if w.depth == 0 {
+		return fmt.Errorf("writing otb props outside of a node")
+	}
+	if w.child {
+		return fmt.Errorf("writing otb props after child nodes")
+	}
+	for _, b := range props {
+		switch b {
+		case ESCAPE_CHAR, NODE_START, NODE_END:
+			if err := w.w.WriteByte(ESCAPE_CHAR); err != nil {
+				return err
+			}
+		}
+		if err := w.w.WriteByte(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements io.Writer by appending to the props of the latest started
+// node, so that props can be written using e.g. binary.Write.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.WriteProps(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// EndNode ends the latest started node.
+func (w *Writer) EndNode() error {
+	if w.depth == 0 {
+		return fmt.Errorf("ending otb node which was not started")
+	}
+	w.depth--
+	// Whatever follows in the parent node is another child.
+	w.child = true
+	return w.w.WriteByte(NODE_END)
+}
+
+// WriteNode writes the passed node, along with its children and the siblings
+// following it.
+func (w *Writer) WriteNode(n *OTBNode) error {
+	for ; n != nil; n = n.next {
+		if err := w.StartNode(n.nodeType); err != nil {
+			return err
+		}
+		if err := w.WriteProps(n.props); err != nil {
+			return err
+		}
+		if err := w.WriteNode(n.child); err != nil {
+			return err
+		}
+		if err := w.EndNode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy writes all nodes remaining in the passed reader, as children of the
+// latest started node if there is one, so that files can be rewritten node by
+// node without keeping them in memory.
+func (w *Writer) Copy(r *Reader) error {
+	base := w.depth
+	for {
+		n, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for w.depth > base+n.Depth {
+			if err := w.EndNode(); err != nil {
+				return err
+			}
+		}
+		if err := w.StartNode(n.Type); err != nil {
+			return err
+		}
+		if err := w.WriteProps(n.Props); err != nil {
+			return err
+		}
+	}
+	for w.depth > base {
+		if err := w.EndNode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data into the underlying writer. All nodes must
+// have been ended.
+func (w *Writer) Flush() error {
+	if w.depth != 0 {
+		return fmt.Errorf("%d otb nodes were not ended", w.depth)
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,98 @@
+package otb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	// A root with two children, the first of which has a child of its own.
+	// Props contain all the bytes with a special meaning.
+	root := &OTBNode{
+		nodeType: 0x00,
+		props:    []byte{0x01, ESCAPE_CHAR, 0x02},
+		child: &OTBNode{
+			nodeType: 0x02,
+			props:    []byte{NODE_START, NODE_END, ESCAPE_CHAR},
+			child: &OTBNode{
+				nodeType: 0x04,
+				props:    []byte{0x10, 0x20},
+			},
+			next: &OTBNode{
+				nodeType: 0x0C,
+			},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatalf("creating writer: %v", err)
+	}
+	if err := w.WriteNode(root); err != nil {
+		t.Fatalf("writing nodes: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("flushing writer: %v", err)
+	}
+
+	want := []byte{
+		0, 0, 0, 0,
+		NODE_START, 0x00, 0x01, ESCAPE_CHAR, ESCAPE_CHAR, 0x02,
+		NODE_START, 0x02, ESCAPE_CHAR, NODE_START, ESCAPE_CHAR, NODE_END, ESCAPE_CHAR, ESCAPE_CHAR,
+		NODE_START, 0x04, 0x10, 0x20, NODE_END,
+		NODE_END,
+		NODE_START, 0x0C, NODE_END,
+		NODE_END,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("written % x, want % x", buf.Bytes(), want)
+	}
+
+	otb, err := NewOTB(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reading written otb: %v", err)
+	}
+	if !reflect.DeepEqual(otb.root, root) {
+		t.Errorf("read back %+v, want %+v", otb.root, root)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w, _ := NewWriter(&bytes.Buffer{})
+	if err := w.WriteProps([]byte{1}); err == nil {
+		t.Errorf("writing props outside of a node succeeded")
+	}
+	if err := w.StartNode(NODE_START); err == nil {
+		t.Errorf("starting node of type NODE_START succeeded")
+	}
+	w.StartNode(0)
+	w.StartNode(1)
+	w.EndNode()
+	if err := w.WriteProps([]byte{1}); err == nil {
+		t.Errorf("writing props after a child node succeeded")
+	}
+	if err := w.Flush(); err == nil {
+		t.Errorf("flushing with a node not ended succeeded")
+	}
+}
+
+func TestWriterCopy(t *testing.T) {
+	file, _ := testFile(t)
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("creating reader: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf)
+	if err := w.Copy(r); err != nil {
+		t.Fatalf("copying nodes: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("flushing writer: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), file) {
+		t.Errorf("copied % x, want % x", buf.Bytes(), file)
+	}
+}
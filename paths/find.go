@@ -40,6 +40,17 @@ func Open(fileName string) (interface {
 	return openImp(fileName)
 }
 
+// OpenRanged opens the passed file like Open, but if the file is fetched over
+// HTTP, only the parts of it which are read are downloaded, using range
+// requests. This suits large files of which only parts are needed, such as
+// indexed maps. If ranges are not served, the file is fetched whole.
+func OpenRanged(fileName string) (interface {
+	io.ReadCloser
+	io.Seeker
+}, error) {
+	return openRangedImp(fileName)
+}
+
 func NoFindOpen(fileName string) (interface {
 	io.ReadCloser
 	io.Seeker
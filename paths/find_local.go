@@ -37,6 +37,15 @@ func openImp(fileName string) (interface {
 	return openFSImp(fileName)
 }
 
+// openRangedImp opens the file like openImp; local files are read only as
+// needed anyway.
+func openRangedImp(fileName string) (interface {
+	io.ReadCloser
+	io.Seeker
+}, error) {
+	return openImp(fileName)
+}
+
 func noFindOpenImp(fileName string) (interface {
 	io.ReadCloser
 	io.Seeker
@@ -65,6 +65,31 @@ func openImp(fileName string) (interface {
 	return openHTTPImp(fileName)
 }
 
+// openRangedImp locates the passed file in the same locations as openImp, but
+// reads files served over HTTP using range requests. Files which are not
+// served over HTTP, or whose ranges are not served, are opened by openImp.
+func openRangedImp(fileName string) (interface {
+	io.ReadCloser
+	io.Seeker
+}, error) {
+	for _, f := range getPossiblePathsImp(fileName) {
+		url := ""
+		if strings.HasPrefix(f, "http://") {
+			url = f
+		} else if strings.HasPrefix(f, "http:/") {
+			url = f[len("http:/"):]
+		} else {
+			continue
+		}
+		o, err := noFindOpenRangedHTTPImp(url)
+		if err == nil {
+			return o, nil
+		}
+		log.Printf("paths: ranged open of %q failed, trying other locations: %v", url, err)
+	}
+	return openImp(fileName)
+}
+
 func noFindOpenImp(fileName string) (interface {
 	io.ReadCloser
 	io.Seeker
@@ -2,10 +2,13 @@ package paths
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -107,3 +110,100 @@ type bytesReaderWithDummyClose struct {
 func (bytesReaderWithDummyClose) Close() error {
 	return nil
 }
+
+// rangedHTTPReader reads a file over HTTP using range requests, downloading
+// only the parts of the file which are read. Each Read is a request of its
+// own, so it suits few large reads, such as of indexed map nodes.
+type rangedHTTPReader struct {
+	url       string
+	size, off int64
+}
+
+// noFindOpenRangedHTTPImp opens the file at the passed URL for ranged reads.
+// If the server does not serve ranges of it, an error is returned.
+func noFindOpenRangedHTTPImp(url string) (*rangedHTTPReader, error) {
+	r := &rangedHTTPReader{url: url}
+	// Reading the first byte tells whether ranges are served, and the size of
+	// the file.
+	resp, err := r.get(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	contentRange := resp.Header.Get("Content-Range")
+	slash := strings.LastIndexByte(contentRange, '/')
+	if slash < 0 {
+		return nil, fmt.Errorf("go-tibia/paths: ranged open of %q: bad Content-Range %q", url, contentRange)
+	}
+	if r.size, err = strconv.ParseInt(contentRange[slash+1:], 10, 64); err != nil {
+		return nil, fmt.Errorf("go-tibia/paths: ranged open of %q: bad Content-Range %q: %v", url, contentRange, err)
+	}
+	return r, nil
+}
+
+// get requests n bytes of the file starting at the passed offset.
+func (r *rangedHTTPReader) get(off, n int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		e := os.ErrInvalid
+		if resp.StatusCode == http.StatusNotFound {
+			e = os.ErrNotExist
+		}
+		return nil, errors.Wrapf(e, "go-tibia/paths: ranged read of %q: http response.StatusCode=%v, want 206", r.url, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (r *rangedHTTPReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if n == 0 {
+		return 0, nil
+	}
+	if r.off+n > r.size {
+		n = r.size - r.off
+	}
+	resp, err := r.get(r.off, n)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	read, err := io.ReadFull(resp.Body, p[:n])
+	r.off += int64(read)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return read, err
+}
+
+func (r *rangedHTTPReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.off, fmt.Errorf("go-tibia/paths: bad whence %d", whence)
+	}
+	if offset < 0 {
+		return r.off, fmt.Errorf("go-tibia/paths: seek to negative offset %d", offset)
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *rangedHTTPReader) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package paths
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangedHTTPReader(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	var served int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingResponseWriter{ResponseWriter: w}
+		http.ServeContent(cw, r, "map.otbm", time.Time{}, bytes.NewReader(content))
+		served += cw.n
+	}))
+	defer srv.Close()
+
+	r, err := noFindOpenRangedHTTPImp(srv.URL + "/map.otbm")
+	if err != nil {
+		t.Fatalf("opening: %v", err)
+	}
+	if size, err := r.Seek(0, io.SeekEnd); err != nil || size != int64(len(content)) {
+		t.Errorf("seeking to end: %d, %v; want %d", size, err, len(content))
+	}
+	buf := make([]byte, 10)
+	if _, err := r.Seek(5005, io.SeekStart); err != nil {
+		t.Fatalf("seeking: %v", err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "5678901234" {
+		t.Errorf("read %q, %v; want %q", buf, err, "5678901234")
+	}
+	if _, err := r.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatalf("seeking: %v", err)
+	}
+	if n, err := io.ReadFull(r, buf); err != io.ErrUnexpectedEOF || string(buf[:n]) != "6789" {
+		t.Errorf("read %q, %v at end; want %q and unexpected EOF", buf[:n], err, "6789")
+	}
+	if served > 100 {
+		t.Errorf("served %d bytes for reading 14, want only the ranges read", served)
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer plain.Close()
+	if _, err := noFindOpenRangedHTTPImp(plain.URL + "/map.otbm"); err == nil {
+		t.Errorf("opening file from server not serving ranges succeeded")
+	}
+}
+
+// countingResponseWriter counts the bytes of the response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,494 @@
+package scripting
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// World is the part of the gameworld that scripts can access and modify,
+// exposed to them as the game module.
+//
+// All methods are called with whatever locks the caller of the engine holds.
+type World interface {
+	// CreatureName returns the name of the creature.
+	CreatureName(id gwmap.CreatureID) (string, error)
+	// CreaturePosition returns the position of the creature.
+	CreaturePosition(id gwmap.CreatureID) (tnet.Position, error)
+	// CreatureHealth returns the current and the maximum health of the creature.
+	CreatureHealth(id gwmap.CreatureID) (health, maxHealth int, err error)
+	// AddHealth heals the creature by the passed amount, or damages it if the
+	// amount is negative.
+	AddHealth(id gwmap.CreatureID, amount int) error
+	// Teleport moves the creature to the passed position, regardless of the
+	// distance.
+	Teleport(id gwmap.CreatureID, pos tnet.Position) error
+	// Say makes the creature say the text.
+	Say(id gwmap.CreatureID, text string) error
+	// SendTextMessage sends the text to the player as a message from the
+	// server, presented as the passed kind ("info", "warning", "event",
+	// "status" or "console").
+	SendTextMessage(player gwmap.CreatureID, kind, text string) error
+	// MagicEffect shows the named magic effect at the passed position.
+	MagicEffect(pos tnet.Position, effect string) error
+	// TileItems returns the items on the tile at the passed position, starting
+	// with the ground.
+	TileItems(pos tnet.Position) ([]Item, error)
+	// TransformItem turns the item into an item with the passed server ID,
+	// keeping its count and IDs, and returns it as changed.
+	TransformItem(item Item, serverID uint16) (Item, error)
+	// CreateItem places a new item with the passed server ID and count on the
+	// tile at the passed position, and returns it.
+	CreateItem(pos tnet.Position, serverID, count uint16) (Item, error)
+	// RemoveItem removes the item from its tile.
+	RemoveItem(item Item) error
+	// SetItemActionID sets the action ID of the item, or removes it if zero,
+	// and returns the item as changed.
+	SetItemActionID(item Item, actionID uint16) (Item, error)
+	// TileCreatures returns the creatures on the tile at the passed position.
+	TileCreatures(pos tnet.Position) ([]gwmap.CreatureID, error)
+	// TileWalkable returns whether a creature could step onto the tile at the
+	// passed position.
+	TileWalkable(pos tnet.Position) (bool, error)
+	// Towns returns the towns on the map.
+	Towns() []gwmap.Town
+	// Waypoints returns the named positions on the map.
+	Waypoints() []gwmap.Waypoint
+}
+
+// gameModule returns the game module, through which scripts access the
+// world.
+func (e *Engine) gameModule() *starlarkstruct.Module {
+	fns := map[string]func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error){
+		"creature_name":     e.creatureName,
+		"creature_position": e.creaturePosition,
+		"creature_health":   e.creatureHealth,
+		// Creature types are determined by bits in their IDs, the same way as
+		// gameworld.CreatureType.
+		"is_player":      isCreatureKind(0x10000000),
+		"is_npc":         isCreatureKind(0x20000000),
+		"is_monster":     isCreatureKind(0x40000000),
+		"add_health":     e.addHealth,
+		"teleport":       e.teleport,
+		"say":            e.say,
+		"send_message":   e.sendMessage,
+		"magic_effect":   e.magicEffect,
+		"tile_items":     e.tileItems,
+		"transform_item": e.transformItem,
+		"create_item":    e.createItem,
+		"remove_item":    e.removeItem,
+		"set_action_id":  e.setActionID,
+		"tile_creatures": e.tileCreatures,
+		"tile_walkable":  e.tileWalkable,
+		"town":           e.town,
+		"waypoint":       e.waypoint,
+	}
+	members := starlark.StringDict{}
+	for name, fn := range fns {
+		members[name] = starlark.NewBuiltin("game."+name, fn)
+	}
+	return &starlarkstruct.Module{Name: "game", Members: members}
+}
+
+func (e *Engine) creatureName(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cr creatureArg
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &cr); err != nil {
+		return nil, err
+	}
+	name, err := e.world.CreatureName(gwmap.CreatureID(cr))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.String(name), nil
+}
+
+func (e *Engine) creaturePosition(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cr creatureArg
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &cr); err != nil {
+		return nil, err
+	}
+	pos, err := e.world.CreaturePosition(gwmap.CreatureID(cr))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return positionValue(pos), nil
+}
+
+func (e *Engine) creatureHealth(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cr creatureArg
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &cr); err != nil {
+		return nil, err
+	}
+	health, maxHealth, err := e.world.CreatureHealth(gwmap.CreatureID(cr))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.Tuple{starlark.MakeInt(health), starlark.MakeInt(maxHealth)}, nil
+}
+
+// isCreatureKind returns a builtin checking whether the creature ID belongs to
+// a creature of the kind identified by the passed bit.
+func isCreatureKind(bit uint32) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var cr creatureArg
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &cr); err != nil {
+			return nil, err
+		}
+		return starlark.Bool(uint32(cr)&bit != 0), nil
+	}
+}
+
+func (e *Engine) addHealth(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cr creatureArg
+	var amount int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "creature", &cr, "amount", &amount); err != nil {
+		return nil, err
+	}
+	if err := e.world.AddHealth(gwmap.CreatureID(cr), amount); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (e *Engine) teleport(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cr creatureArg
+	var pos positionArg
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "creature", &cr, "pos", &pos); err != nil {
+		return nil, err
+	}
+	if err := e.world.Teleport(gwmap.CreatureID(cr), tnet.Position(pos)); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (e *Engine) say(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cr creatureArg
+	var text string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "creature", &cr, "text", &text); err != nil {
+		return nil, err
+	}
+	if err := e.world.Say(gwmap.CreatureID(cr), text); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (e *Engine) sendMessage(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cr creatureArg
+	var text string
+	kind := "info"
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "player", &cr, "text", &text, "kind?", &kind); err != nil {
+		return nil, err
+	}
+	if err := e.world.SendTextMessage(gwmap.CreatureID(cr), kind, text); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (e *Engine) magicEffect(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pos positionArg
+	var effect string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pos", &pos, "effect", &effect); err != nil {
+		return nil, err
+	}
+	if err := e.world.MagicEffect(tnet.Position(pos), effect); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (e *Engine) tileItems(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pos positionArg
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &pos); err != nil {
+		return nil, err
+	}
+	items, err := e.world.TileItems(tnet.Position(pos))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	var values []starlark.Value
+	for _, item := range items {
+		values = append(values, itemValue(item))
+	}
+	return starlark.NewList(values), nil
+}
+
+func (e *Engine) transformItem(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var item itemArg
+	var id uint16Arg
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "item", &item, "id", &id); err != nil {
+		return nil, err
+	}
+	changed, err := e.world.TransformItem(Item(item), uint16(id))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return itemValue(changed), nil
+}
+
+func (e *Engine) createItem(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pos positionArg
+	var id uint16Arg
+	count := uint16Arg(1)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pos", &pos, "id", &id, "count?", &count); err != nil {
+		return nil, err
+	}
+	item, err := e.world.CreateItem(tnet.Position(pos), uint16(id), uint16(count))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return itemValue(item), nil
+}
+
+func (e *Engine) removeItem(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var item itemArg
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &item); err != nil {
+		return nil, err
+	}
+	if err := e.world.RemoveItem(Item(item)); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (e *Engine) setActionID(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var item itemArg
+	var actionID uint16Arg
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "item", &item, "action_id", &actionID); err != nil {
+		return nil, err
+	}
+	changed, err := e.world.SetItemActionID(Item(item), uint16(actionID))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return itemValue(changed), nil
+}
+
+func (e *Engine) tileCreatures(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pos positionArg
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &pos); err != nil {
+		return nil, err
+	}
+	ids, err := e.world.TileCreatures(tnet.Position(pos))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	var values []starlark.Value
+	for _, id := range ids {
+		values = append(values, creatureValue(id))
+	}
+	return starlark.NewList(values), nil
+}
+
+func (e *Engine) tileWalkable(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pos positionArg
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &pos); err != nil {
+		return nil, err
+	}
+	walkable, err := e.world.TileWalkable(tnet.Position(pos))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.Bool(walkable), nil
+}
+
+func (e *Engine) town(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var town starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &town); err != nil {
+		return nil, err
+	}
+	for _, t := range e.world.Towns() {
+		switch town := town.(type) {
+		case starlark.String:
+			if !strings.EqualFold(t.Name, string(town)) {
+				continue
+			}
+		case starlark.Int:
+			if id, ok := town.Uint64(); !ok || id != uint64(t.ID) {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("%s: got %s, want town name or ID", b.Name(), town.Type())
+		}
+		return starlarkstruct.FromStringDict(starlark.String("town"), starlark.StringDict{
+			"id":     starlark.MakeUint(uint(t.ID)),
+			"name":   starlark.String(t.Name),
+			"temple": positionValue(t.TemplePos),
+		}), nil
+	}
+	return starlark.None, nil
+}
+
+func (e *Engine) waypoint(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name); err != nil {
+		return nil, err
+	}
+	for _, w := range e.world.Waypoints() {
+		if strings.EqualFold(w.Name, name) {
+			return positionValue(w.Pos), nil
+		}
+	}
+	return starlark.None, nil
+}
+
+// builtinPosition is the position(x, y, z) builtin, creating a position
+// struct.
+func builtinPosition(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y, z int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "y", &y, "z", &z); err != nil {
+		return nil, err
+	}
+	if x < 0 || x > 0xFFFF || y < 0 || y > 0xFFFF || z < 0 || z > 15 {
+		return nil, fmt.Errorf("%s: position %d, %d, %d out of range", b.Name(), x, y, z)
+	}
+	return positionValue(tnet.Position{X: uint16(x), Y: uint16(y), Floor: uint8(z)}), nil
+}
+
+// positionValue returns the position as a struct with x, y and z fields.
+func positionValue(pos tnet.Position) starlark.Value {
+	return starlarkstruct.FromStringDict(starlark.String("position"), starlark.StringDict{
+		"x": starlark.MakeInt(int(pos.X)),
+		"y": starlark.MakeInt(int(pos.Y)),
+		"z": starlark.MakeInt(int(pos.Floor)),
+	})
+}
+
+// itemValue returns the item as a struct.
+func itemValue(item Item) starlark.Value {
+	return starlarkstruct.FromStringDict(starlark.String("item"), starlark.StringDict{
+		"id":        starlark.MakeInt(int(item.ServerID)),
+		"count":     starlark.MakeInt(int(item.Count)),
+		"action_id": starlark.MakeInt(int(item.ActionID)),
+		"unique_id": starlark.MakeInt(int(item.UniqueID)),
+		"pos":       positionValue(item.Pos),
+		"stack_pos": starlark.MakeInt(item.StackPos),
+	})
+}
+
+// creatureValue returns the creature ID as passed to scripts.
+func creatureValue(id gwmap.CreatureID) starlark.Value {
+	return starlark.MakeUint(uint(id))
+}
+
+// creatureArg unpacks a creature ID passed by a script.
+type creatureArg gwmap.CreatureID
+
+func (c *creatureArg) Unpack(v starlark.Value) error {
+	i, ok := v.(starlark.Int)
+	if !ok {
+		return fmt.Errorf("got %s, want creature ID", v.Type())
+	}
+	id, ok := i.Uint64()
+	if !ok || id > 0xFFFFFFFF {
+		return fmt.Errorf("creature ID %v out of range", i)
+	}
+	*c = creatureArg(id)
+	return nil
+}
+
+// uint16Arg unpacks an item ID, count or action ID passed by a script.
+type uint16Arg uint16
+
+func (u *uint16Arg) Unpack(v starlark.Value) error {
+	var i int
+	if err := starlark.AsInt(v, &i); err != nil {
+		return err
+	}
+	if i < 0 || i > 0xFFFF {
+		return fmt.Errorf("%d out of range", i)
+	}
+	*u = uint16Arg(i)
+	return nil
+}
+
+// itemArg unpacks an item passed by a script, as returned by itemValue. The
+// item is identified by its position, stack position and ID.
+type itemArg Item
+
+func (it *itemArg) Unpack(v starlark.Value) error {
+	attrs, ok := v.(starlark.HasAttrs)
+	if !ok {
+		return fmt.Errorf("got %s, want item", v.Type())
+	}
+	attr := func(name string) (starlark.Value, error) {
+		val, err := attrs.Attr(name)
+		if err != nil || val == nil {
+			return nil, fmt.Errorf("got %s without %s, want item", v.Type(), name)
+		}
+		return val, nil
+	}
+	var item Item
+	for name, field := range map[string]*uint16{"id": &item.ServerID, "count": &item.Count, "action_id": &item.ActionID, "unique_id": &item.UniqueID} {
+		val, err := attr(name)
+		if err != nil {
+			return err
+		}
+		var u uint16Arg
+		if err := u.Unpack(val); err != nil {
+			return fmt.Errorf("item %s: %v", name, err)
+		}
+		*field = uint16(u)
+	}
+	val, err := attr("stack_pos")
+	if err != nil {
+		return err
+	}
+	if err := starlark.AsInt(val, &item.StackPos); err != nil {
+		return fmt.Errorf("item stack_pos: %v", err)
+	}
+	val, err = attr("pos")
+	if err != nil {
+		return err
+	}
+	var pos positionArg
+	if err := pos.Unpack(val); err != nil {
+		return fmt.Errorf("item pos: %v", err)
+	}
+	item.Pos = tnet.Position(pos)
+	*it = itemArg(item)
+	return nil
+}
+
+// positionArg unpacks a position passed by a script, either as a struct with
+// x, y and z fields (such as one created by position()), or as a tuple.
+type positionArg tnet.Position
+
+func (p *positionArg) Unpack(v starlark.Value) error {
+	var coords [3]int
+	switch v := v.(type) {
+	case starlark.Tuple:
+		if len(v) != 3 {
+			return fmt.Errorf("got tuple of %d, want position", len(v))
+		}
+		for i := range coords {
+			if err := starlark.AsInt(v[i], &coords[i]); err != nil {
+				return err
+			}
+		}
+	case starlark.HasAttrs:
+		for i, name := range []string{"x", "y", "z"} {
+			attr, err := v.Attr(name)
+			if err != nil || attr == nil {
+				return fmt.Errorf("got %s without %s, want position", v.Type(), name)
+			}
+			if err := starlark.AsInt(attr, &coords[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("got %s, want position", v.Type())
+	}
+	if coords[0] < 0 || coords[0] > 0xFFFF || coords[1] < 0 || coords[1] > 0xFFFF || coords[2] < 0 || coords[2] > 15 {
+		return fmt.Errorf("position %v out of range", coords)
+	}
+	*p = positionArg{X: uint16(coords[0]), Y: uint16(coords[1]), Floor: uint8(coords[2])}
+	return nil
+}
@@ -0,0 +1,355 @@
+// Package scripting provides an embedded Starlark scripting engine, allowing
+// the behavior of the gameworld to be changed without recompiling the server.
+//
+// Scripts are files with the .star extension in a single directory. When
+// loaded, they register handlers for events such as using an item or saying
+// some words:
+//
+//	def pull_lever(player, item):
+//	    game.send_message(player, "You pulled the lever.")
+//	    game.teleport(player, position(100, 100, 7))
+//
+//	on_use(pull_lever, action_id = 2000)
+//
+// Scripts are sandboxed: they cannot access the filesystem or the network,
+// cannot load other files, and are stopped if they run for too long. They
+// interact with the world only through the game module (see World).
+package scripting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// maxExecutionSteps limits how much work a single script execution may do
+// before it is cancelled, so that a buggy script cannot stall the world.
+const maxExecutionSteps = 1000000
+
+// Item describes an item involved in an event, such as the item being used or
+// stepped on.
+type Item struct {
+	ServerID uint16
+	Count    uint16
+	ActionID uint16
+	UniqueID uint16
+	Pos      tnet.Position
+	StackPos int // Index of the item on its tile, starting with the ground.
+}
+
+// handlers is the set of event handlers registered by the loaded scripts.
+type handlers struct {
+	useByUniqueID    map[uint16]starlark.Callable
+	useByActionID    map[uint16]starlark.Callable
+	useByItemID      map[uint16]starlark.Callable
+	stepByUniqueID   map[uint16]starlark.Callable
+	stepByActionID   map[uint16]starlark.Callable
+	stepByItemID     map[uint16]starlark.Callable
+	words            map[string]starlark.Callable
+	login, logout    []starlark.Callable
+	registeredInFile string // Name of the file currently being loaded, for diagnostics.
+}
+
+func newHandlers() *handlers {
+	return &handlers{
+		useByUniqueID:  map[uint16]starlark.Callable{},
+		useByActionID:  map[uint16]starlark.Callable{},
+		useByItemID:    map[uint16]starlark.Callable{},
+		stepByUniqueID: map[uint16]starlark.Callable{},
+		stepByActionID: map[uint16]starlark.Callable{},
+		stepByItemID:   map[uint16]starlark.Callable{},
+		words:          map[string]starlark.Callable{},
+	}
+}
+
+// Engine runs the scripts from a single directory, dispatching events to the
+// handlers they register.
+//
+// Engine is not safe for concurrent use: the caller is expected to serialize
+// dispatching events and reloading, e.g. by holding its world lock.
+type Engine struct {
+	world World
+	dir   string
+
+	handlers *handlers
+	game     *starlarkstruct.Module
+}
+
+// New creates a new engine whose scripts interact with the passed world. No
+// scripts are loaded until LoadDir is called.
+func New(world World) *Engine {
+	e := &Engine{
+		world:    world,
+		handlers: newHandlers(),
+	}
+	e.game = e.gameModule()
+	return e
+}
+
+// LoadDir loads all scripts from the passed directory. Subsequent calls to
+// Reload will load the scripts from the same directory.
+//
+// If any of the scripts fails to load, the error is returned, and both the
+// previously registered handlers and the directory they came from are kept.
+func (e *Engine) LoadDir(dir string) error {
+	if err := e.loadDir(dir); err != nil {
+		return err
+	}
+	e.dir = dir
+	return nil
+}
+
+// Reload loads all scripts from the directory again, replacing the handlers
+// registered so far.
+//
+// If any of the scripts fails to load, the error is returned and the
+// previously registered handlers are kept.
+func (e *Engine) Reload() error {
+	if e.dir == "" {
+		return fmt.Errorf("no script directory set")
+	}
+	return e.loadDir(e.dir)
+}
+
+// loadDir loads all scripts from the passed directory, replacing the handlers
+// registered so far only if all of them load.
+func (e *Engine) loadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	h := newHandlers()
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("reading script %q: %w", file, err)
+		}
+		if err := e.load(h, filepath.Base(file), src); err != nil {
+			return err
+		}
+	}
+	e.handlers = h
+	glog.Infof("loaded %d scripts from %q", len(files), dir)
+	return nil
+}
+
+// LoadSource loads a single script from the passed source, adding to the
+// handlers registered so far. It is mainly useful for tests and for scripts
+// that do not come from files.
+func (e *Engine) LoadSource(name string, src string) error {
+	return e.load(e.handlers, name, src)
+}
+
+// load executes the script, registering its handlers into h.
+func (e *Engine) load(h *handlers, name string, src interface{}) error {
+	h.registeredInFile = name
+	defer func() { h.registeredInFile = "" }()
+
+	predeclared := starlark.StringDict{
+		"game":       e.game,
+		"position":   starlark.NewBuiltin("position", builtinPosition),
+		"struct":     starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"on_use":     starlark.NewBuiltin("on_use", h.registerItemHandler(h.useByUniqueID, h.useByActionID, h.useByItemID)),
+		"on_step_in": starlark.NewBuiltin("on_step_in", h.registerItemHandler(h.stepByUniqueID, h.stepByActionID, h.stepByItemID)),
+		"on_say":     starlark.NewBuiltin("on_say", h.registerWords),
+		"on_login":   starlark.NewBuiltin("on_login", h.registerList(&h.login)),
+		"on_logout":  starlark.NewBuiltin("on_logout", h.registerList(&h.logout)),
+	}
+	if _, err := starlark.ExecFile(newThread(name), name, src, predeclared); err != nil {
+		return fmt.Errorf("loading script %q: %w", name, scriptError(err))
+	}
+	return nil
+}
+
+// registerItemHandler returns a builtin registering a handler for an event
+// involving an item, bound to a unique ID, action ID or item ID.
+func (h *handlers) registerItemHandler(byUniqueID, byActionID, byItemID map[uint16]starlark.Callable) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var fn starlark.Callable
+		var uniqueID, actionID, itemID int
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "fn", &fn, "unique_id?", &uniqueID, "action_id?", &actionID, "item_id?", &itemID); err != nil {
+			return nil, err
+		}
+		bound := false
+		for _, bind := range []struct {
+			m  map[uint16]starlark.Callable
+			id int
+			by string
+		}{{byUniqueID, uniqueID, "unique ID"}, {byActionID, actionID, "action ID"}, {byItemID, itemID, "item ID"}} {
+			if bind.id == 0 {
+				continue
+			}
+			if bind.id < 0 || bind.id > 0xFFFF {
+				return nil, fmt.Errorf("%s: %s %d out of range", b.Name(), bind.by, bind.id)
+			}
+			if _, ok := bind.m[uint16(bind.id)]; ok {
+				glog.Warningf("%s: %s: replacing handler for %s %d", h.registeredInFile, b.Name(), bind.by, bind.id)
+			}
+			bind.m[uint16(bind.id)] = fn
+			bound = true
+		}
+		if !bound {
+			return nil, fmt.Errorf("%s: one of unique_id, action_id or item_id is required", b.Name())
+		}
+		return starlark.None, nil
+	}
+}
+
+// registerWords is a builtin registering a handler for spoken words.
+func (h *handlers) registerWords(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var words string
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "words", &words, "fn", &fn); err != nil {
+		return nil, err
+	}
+	words = strings.ToLower(strings.TrimSpace(words))
+	if words == "" {
+		return nil, fmt.Errorf("%s: words must not be empty", b.Name())
+	}
+	if _, ok := h.words[words]; ok {
+		glog.Warningf("%s: %s: replacing handler for %q", h.registeredInFile, b.Name(), words)
+	}
+	h.words[words] = fn
+	return starlark.None, nil
+}
+
+// registerList returns a builtin adding a handler to the passed list.
+func (h *handlers) registerList(list *[]starlark.Callable) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var fn starlark.Callable
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "fn", &fn); err != nil {
+			return nil, err
+		}
+		*list = append(*list, fn)
+		return starlark.None, nil
+	}
+}
+
+// Use dispatches the player using the item to the script handling it, if any.
+// Handlers bound to the unique ID take precedence over those bound to the
+// action ID, which take precedence over those bound to the item ID.
+//
+// It returns whether a script handled the use.
+func (e *Engine) Use(player gwmap.CreatureID, item Item) (bool, error) {
+	fn := lookupItemHandler(item, e.handlers.useByUniqueID, e.handlers.useByActionID, e.handlers.useByItemID)
+	if fn == nil {
+		return false, nil
+	}
+	return e.call(fn, creatureValue(player), itemValue(item))
+}
+
+// StepIn dispatches the creature stepping onto the tile containing the item to
+// the script handling it, if any. Handlers are looked up the same way as for
+// Use.
+//
+// It returns whether a script handled the step.
+func (e *Engine) StepIn(cr gwmap.CreatureID, item Item) (bool, error) {
+	fn := lookupItemHandler(item, e.handlers.stepByUniqueID, e.handlers.stepByActionID, e.handlers.stepByItemID)
+	if fn == nil {
+		return false, nil
+	}
+	return e.call(fn, creatureValue(cr), itemValue(item))
+}
+
+// Say dispatches the text spoken by the player to the script handling its
+// first word (or the whole text), if any. The handler is called with the
+// player, the words and the rest of the text.
+//
+// It returns whether a script handled the text, in which case it should not
+// be spoken.
+func (e *Engine) Say(player gwmap.CreatureID, text string) (bool, error) {
+	text = strings.TrimSpace(text)
+	words, param := text, ""
+	fn, ok := e.handlers.words[strings.ToLower(words)]
+	if !ok {
+		if idx := strings.IndexByte(text, ' '); idx > 0 {
+			words, param = text[:idx], strings.TrimSpace(text[idx+1:])
+			fn, ok = e.handlers.words[strings.ToLower(words)]
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+	return e.call(fn, creatureValue(player), starlark.String(words), starlark.String(param))
+}
+
+// Login dispatches the player logging in to all scripts handling it.
+func (e *Engine) Login(player gwmap.CreatureID) error {
+	for _, fn := range e.handlers.login {
+		if _, err := e.call(fn, creatureValue(player)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Logout dispatches the player logging out to all scripts handling it.
+func (e *Engine) Logout(player gwmap.CreatureID) error {
+	for _, fn := range e.handlers.logout {
+		if _, err := e.call(fn, creatureValue(player)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call calls the handler with the passed arguments. A handler returning False
+// is considered to not have handled the event; any other result, including
+// None, means it was handled.
+func (e *Engine) call(fn starlark.Callable, args ...starlark.Value) (bool, error) {
+	result, err := starlark.Call(newThread(fn.Name()), fn, args, nil)
+	if err != nil {
+		return false, fmt.Errorf("script %s: %w", fn.Name(), scriptError(err))
+	}
+	return result != starlark.False, nil
+}
+
+// lookupItemHandler returns the handler for the item from the passed maps,
+// in order of precedence, or nil.
+func lookupItemHandler(item Item, byUniqueID, byActionID, byItemID map[uint16]starlark.Callable) starlark.Callable {
+	if fn, ok := byUniqueID[item.UniqueID]; ok && item.UniqueID != 0 {
+		return fn
+	}
+	if fn, ok := byActionID[item.ActionID]; ok && item.ActionID != 0 {
+		return fn
+	}
+	if fn, ok := byItemID[item.ServerID]; ok {
+		return fn
+	}
+	return nil
+}
+
+// newThread creates a sandboxed thread for running a script: it cannot load
+// other files, and is cancelled if it runs for too long.
+func newThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(thread *starlark.Thread, msg string) {
+			glog.Infof("script %s: %s", thread.Name, msg)
+		},
+		Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("loading %q: scripts cannot load other files", module)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxExecutionSteps)
+	return thread
+}
+
+// scriptError adds the Starlark backtrace to errors coming from scripts.
+func scriptError(err error) error {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	return err
+}
@@ -0,0 +1,307 @@
+package scripting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	tnet "badc0de.net/pkg/go-tibia/net"
+)
+
+// fakeWorld records what scripts do to it.
+type fakeWorld struct {
+	positions map[gwmap.CreatureID]tnet.Position
+	messages  []string
+	effects   []string
+	items     []string
+}
+
+func newFakeWorld() *fakeWorld {
+	return &fakeWorld{positions: map[gwmap.CreatureID]tnet.Position{}}
+}
+
+func (w *fakeWorld) CreatureName(id gwmap.CreatureID) (string, error) {
+	return fmt.Sprintf("creature %d", id), nil
+}
+func (w *fakeWorld) CreaturePosition(id gwmap.CreatureID) (tnet.Position, error) {
+	return w.positions[id], nil
+}
+func (w *fakeWorld) CreatureHealth(id gwmap.CreatureID) (int, int, error) {
+	return 50, 100, nil
+}
+func (w *fakeWorld) AddHealth(id gwmap.CreatureID, amount int) error {
+	return nil
+}
+func (w *fakeWorld) Teleport(id gwmap.CreatureID, pos tnet.Position) error {
+	w.positions[id] = pos
+	return nil
+}
+func (w *fakeWorld) Say(id gwmap.CreatureID, text string) error {
+	w.messages = append(w.messages, "say: "+text)
+	return nil
+}
+func (w *fakeWorld) SendTextMessage(player gwmap.CreatureID, kind, text string) error {
+	w.messages = append(w.messages, kind+": "+text)
+	return nil
+}
+func (w *fakeWorld) MagicEffect(pos tnet.Position, effect string) error {
+	w.effects = append(w.effects, fmt.Sprintf("%s at %d,%d,%d", effect, pos.X, pos.Y, pos.Floor))
+	return nil
+}
+func (w *fakeWorld) TileItems(pos tnet.Position) ([]Item, error) {
+	return []Item{{ServerID: 100, Pos: pos}, {ServerID: 1945, ActionID: 2000, Pos: pos}}, nil
+}
+func (w *fakeWorld) TransformItem(item Item, serverID uint16) (Item, error) {
+	w.items = append(w.items, fmt.Sprintf("transform %d at %d to %d", item.ServerID, item.StackPos, serverID))
+	item.ServerID = serverID
+	return item, nil
+}
+func (w *fakeWorld) CreateItem(pos tnet.Position, serverID, count uint16) (Item, error) {
+	w.items = append(w.items, fmt.Sprintf("create %d x%d", serverID, count))
+	return Item{ServerID: serverID, Count: count, Pos: pos, StackPos: 2}, nil
+}
+func (w *fakeWorld) RemoveItem(item Item) error {
+	w.items = append(w.items, fmt.Sprintf("remove %d at %d", item.ServerID, item.StackPos))
+	return nil
+}
+func (w *fakeWorld) SetItemActionID(item Item, actionID uint16) (Item, error) {
+	w.items = append(w.items, fmt.Sprintf("set action ID of %d at %d to %d", item.ServerID, item.StackPos, actionID))
+	item.ActionID = actionID
+	return item, nil
+}
+func (w *fakeWorld) TileCreatures(pos tnet.Position) ([]gwmap.CreatureID, error) {
+	return nil, nil
+}
+func (w *fakeWorld) TileWalkable(pos tnet.Position) (bool, error) {
+	return true, nil
+}
+func (w *fakeWorld) Towns() []gwmap.Town {
+	return []gwmap.Town{{ID: 1, Name: "Thais", TemplePos: tnet.Position{X: 200, Y: 200, Floor: 7}}}
+}
+func (w *fakeWorld) Waypoints() []gwmap.Waypoint {
+	return []gwmap.Waypoint{{Name: "Depot", Pos: tnet.Position{X: 210, Y: 190, Floor: 7}}}
+}
+
+const testScript = `
+def pull_lever(player, item):
+    items = game.tile_items(item.pos)
+    game.send_message(player, "%s pulled lever %d of %d items" % (game.creature_name(player), item.action_id, len(items)))
+    game.magic_effect(item.pos, "teleport")
+    game.teleport(player, position(100, 100, 7))
+
+def use_any_lever(player, item):
+    return False
+
+def step_on_trap(creature, item):
+    if game.is_player(creature):
+        game.teleport(creature, (item.pos.x, item.pos.y + 1, item.pos.z))
+
+def pos(player, words, param):
+    p = game.creature_position(player)
+    game.send_message(player, "%d %d %d %s" % (p.x, p.y, p.z, param), kind = "console")
+
+def goto(player, words, param):
+    town = game.town(int(param) if param.isdigit() else param)
+    if town:
+        game.teleport(player, town.temple)
+        return
+    pos = game.waypoint(param)
+    if pos:
+        game.teleport(player, pos)
+        return
+    game.send_message(player, "No such place.", kind = "status")
+
+def welcome(player):
+    game.send_message(player, "Welcome!")
+
+on_use(pull_lever, action_id = 2000)
+on_use(use_any_lever, item_id = 1945)
+on_step_in(step_on_trap, unique_id = 3000)
+on_say("!pos", pos)
+on_say("/goto", goto)
+on_login(welcome)
+`
+
+func TestEvents(t *testing.T) {
+	w := newFakeWorld()
+	e := New(w)
+	if err := e.LoadSource("test.star", testScript); err != nil {
+		t.Fatalf("loading script: %v", err)
+	}
+	player := gwmap.CreatureID(0x10000001)
+
+	lever := Item{ServerID: 1945, ActionID: 2000, Pos: tnet.Position{X: 50, Y: 60, Floor: 7}}
+	if handled, err := e.Use(player, lever); err != nil || !handled {
+		t.Errorf("using lever: handled %v, error %v; want handled", handled, err)
+	}
+	if want := (tnet.Position{X: 100, Y: 100, Floor: 7}); w.positions[player] != want {
+		t.Errorf("player at %v after pulling lever, want %v", w.positions[player], want)
+	}
+	if len(w.effects) != 1 || w.effects[0] != "teleport at 50,60,7" {
+		t.Errorf("effects %q, want a teleport effect at the lever", w.effects)
+	}
+
+	// A handler returning False does not handle the use; items without a
+	// handler are not handled either.
+	if handled, err := e.Use(player, Item{ServerID: 1945}); err != nil || handled {
+		t.Errorf("using lever without action ID: handled %v, error %v; want not handled", handled, err)
+	}
+	if handled, err := e.Use(player, Item{ServerID: 100}); err != nil || handled {
+		t.Errorf("using ground: handled %v, error %v; want not handled", handled, err)
+	}
+
+	trap := Item{ServerID: 100, UniqueID: 3000, Pos: tnet.Position{X: 10, Y: 10, Floor: 8}}
+	if handled, err := e.StepIn(player, trap); err != nil || !handled {
+		t.Errorf("stepping on trap: handled %v, error %v; want handled", handled, err)
+	}
+	if want := (tnet.Position{X: 10, Y: 11, Floor: 8}); w.positions[player] != want {
+		t.Errorf("player at %v after stepping on trap, want %v", w.positions[player], want)
+	}
+
+	if handled, err := e.Say(player, "!POS  please"); err != nil || !handled {
+		t.Errorf("saying !pos: handled %v, error %v; want handled", handled, err)
+	}
+	if handled, err := e.Say(player, "hello"); err != nil || handled {
+		t.Errorf("saying hello: handled %v, error %v; want not handled", handled, err)
+	}
+
+	for param, want := range map[string]tnet.Position{
+		"thais": {X: 200, Y: 200, Floor: 7},
+		"depot": {X: 210, Y: 190, Floor: 7},
+		"1":     {X: 200, Y: 200, Floor: 7},
+	} {
+		if handled, err := e.Say(player, "/goto "+param); err != nil || !handled {
+			t.Errorf("going to %s: handled %v, error %v; want handled", param, handled, err)
+		}
+		if w.positions[player] != want {
+			t.Errorf("player at %v after going to %s, want %v", w.positions[player], param, want)
+		}
+	}
+	if handled, err := e.Say(player, "/goto venore"); err != nil || !handled {
+		t.Errorf("going to venore: handled %v, error %v; want handled", handled, err)
+	}
+
+	if err := e.Login(player); err != nil {
+		t.Errorf("login: %v", err)
+	}
+
+	want := []string{
+		"info: creature 268435457 pulled lever 2000 of 2 items",
+		"console: 10 11 8 please",
+		"status: No such place.",
+		"info: Welcome!",
+	}
+	if strings.Join(w.messages, "\n") != strings.Join(want, "\n") {
+		t.Errorf("messages:\n%s\nwant:\n%s", strings.Join(w.messages, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestItemChanges(t *testing.T) {
+	w := newFakeWorld()
+	e := New(w)
+	src := `
+def switch(player, item):
+    item = game.transform_item(item, item.id + 1)
+    item = game.set_action_id(item, 0)
+    coin = game.create_item(item.pos, 2148, count = 5)
+    game.remove_item(coin)
+
+on_use(switch, item_id = 1945)
+`
+	if err := e.LoadSource("switch.star", src); err != nil {
+		t.Fatalf("loading script: %v", err)
+	}
+	lever := Item{ServerID: 1945, ActionID: 2000, Pos: tnet.Position{X: 50, Y: 60, Floor: 7}, StackPos: 1}
+	if handled, err := e.Use(1, lever); err != nil || !handled {
+		t.Fatalf("using lever: handled %v, error %v; want handled", handled, err)
+	}
+	want := []string{
+		"transform 1945 at 1 to 1946",
+		"set action ID of 1946 at 1 to 0",
+		"create 2148 x5",
+		"remove 2148 at 2",
+	}
+	if strings.Join(w.items, "\n") != strings.Join(want, "\n") {
+		t.Errorf("item changes:\n%s\nwant:\n%s", strings.Join(w.items, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	e := New(newFakeWorld())
+	for name, src := range map[string]string{
+		"load":       `load("other.star", "x")`,
+		"infinite":   "def f():\n    for i in range(1000000000):\n        pass\nf()",
+		"no binding": "def f(player, item):\n    pass\non_use(f)",
+	} {
+		if err := e.LoadSource(name+".star", src); err == nil {
+			t.Errorf("loading %s script succeeded, want error", name)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(src string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "words.star"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newFakeWorld()
+	e := New(w)
+	write("on_say('!a', lambda player, words, param: game.say(player, 'a'))")
+	if err := e.LoadDir(dir); err != nil {
+		t.Fatalf("loading scripts: %v", err)
+	}
+	if handled, _ := e.Say(1, "!a"); !handled {
+		t.Errorf("!a not handled after loading")
+	}
+
+	write("on_say('!b', lambda player, words, param: game.say(player, 'b'))")
+	if err := e.Reload(); err != nil {
+		t.Fatalf("reloading scripts: %v", err)
+	}
+	if handled, _ := e.Say(1, "!a"); handled {
+		t.Errorf("!a still handled after reloading")
+	}
+	if handled, _ := e.Say(1, "!b"); !handled {
+		t.Errorf("!b not handled after reloading")
+	}
+
+	// Broken scripts do not replace working ones.
+	write("on_say('!c',")
+	if err := e.Reload(); err == nil {
+		t.Errorf("reloading broken script succeeded, want error")
+	}
+	if handled, _ := e.Say(1, "!b"); !handled {
+		t.Errorf("!b not handled after failing to reload")
+	}
+
+	// Nor does a broken directory replace the working one for later reloads.
+	badDir, err := ioutil.TempDir("", "badscripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(badDir)
+	if err := ioutil.WriteFile(filepath.Join(badDir, "broken.star"), []byte("on_say("), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.LoadDir(badDir); err == nil {
+		t.Errorf("loading broken directory succeeded, want error")
+	}
+	write("on_say('!d', lambda player, words, param: game.say(player, 'd'))")
+	if err := e.Reload(); err != nil {
+		t.Fatalf("reloading scripts after failing to load another directory: %v", err)
+	}
+	if handled, _ := e.Say(1, "!d"); !handled {
+		t.Errorf("!d not handled after reloading the previous directory")
+	}
+}
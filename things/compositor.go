@@ -6,7 +6,6 @@ import (
 	"image/draw"
 
 	"badc0de.net/pkg/go-tibia/dat"
-	"badc0de.net/pkg/go-tibia/otb/items"
 	"badc0de.net/pkg/go-tibia/spr"
 	"github.com/golang/glog"
 )
@@ -27,14 +26,14 @@ func (i *Item) ItemFrame(idx int, x, y, z int) image.Image {
 			glog.Errorf("cannot composite image for item with unknown serverid (no i.otb set): no dat")
 			return nil
 		}
-		glog.Errorf("cannot composite image for item %d: no dat", i.otb.Attributes[itemsotb.ITEM_ATTR_SERVERID].(uint16))
+		glog.Errorf("cannot composite image for item %d: no dat", i.otb.ServerID())
 		return nil
 	}
 
 	// n.b. rendersize is used for scaling.
 	gfx := i.dataset.GetGraphics()
 
-	glog.V(2).Infof("compositing image for %d (client: %d): gfx: %+v", i.otb.Attributes[itemsotb.ITEM_ATTR_SERVERID].(uint16), i.dataset.Id, gfx)
+	glog.V(2).Infof("compositing image for %d (client: %d): gfx: %+v", i.otb.ServerID(), i.dataset.Id, gfx)
 	img := compositeGfx(idx, x, y, z, gfx, i.parent.spriteSet, nil)
 	i.img[itf] = img
 	return img
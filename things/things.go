@@ -123,6 +123,20 @@ func (c *Creature) AnimCount() int {
 	return int(gfx.AnimCount)
 }
 
+// AddonCount returns the number of addons which can be worn with the outfit.
+// The dat file describes these as additional vertical pattern variations of
+// the outfit.
+func (c *Creature) AddonCount() int {
+	if c.outfit == nil {
+		return 0
+	}
+	gfx := c.outfit.GetGraphics()
+	if gfx.YDiv <= 1 {
+		return 0
+	}
+	return int(gfx.YDiv) - 1
+}
+
 func (c *Creature) ClientID(clientVersion uint16) uint16 {
 	return c.clientID
 }
@@ -156,11 +170,30 @@ func (t *Things) AddSpriteSet(s *spr.SpriteSet) error {
 	return nil
 }
 
+// ItemsOTBVersion returns the version of the items.otb file added to the
+// registry, and whether one was added at all.
+func (t *Things) ItemsOTBVersion() (itemsotb.ItemsVersion, bool) {
+	if t == nil || t.items == nil {
+		return itemsotb.ItemsVersion{}, false
+	}
+	return t.items.Version, true
+}
+
+// TibiaDatasetSignature returns the signature of the Tibia.dat file added to
+// the registry, or zero if none was added.
 func (t *Things) TibiaDatasetSignature() uint32 {
+	if t == nil || t.dataset == nil {
+		return 0
+	}
 	return t.dataset.Header.Signature
 }
 
+// SpriteSetSignature returns the signature of the Tibia.spr file added to the
+// registry, or zero if none was added.
 func (t *Things) SpriteSetSignature() uint32 {
+	if t == nil || t.spriteSet == nil {
+		return 0
+	}
 	return t.spriteSet.Header.Signature
 }
 
@@ -349,12 +382,11 @@ func (t *Things) Temp__GetClientIDForServerID(serverID uint16, clientVersion uin
 		glog.Errorf("item %d fetch gave error: %v", serverID, err)
 		return 0
 	}
-	if attr, ok := itm.Attributes[itemsotb.ITEM_ATTR_CLIENTID]; ok {
-		return attr.(uint16)
-	} else {
+	id := itm.ClientID()
+	if id == 0 {
 		glog.Errorf("item %d has no ITEM_ATTR_CLIENTID", serverID)
-		return 0
 	}
+	return id
 }
 
 func (t *Things) Temp__GetItemFromOTB(serverID uint16, clientVersion uint16) *itemsotb.Item {
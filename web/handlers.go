@@ -2,6 +2,7 @@ package web
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
@@ -13,6 +14,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,6 +24,9 @@ import (
 
 	"badc0de.net/pkg/go-tibia/compositor"
 	"badc0de.net/pkg/go-tibia/gameworld"
+	"badc0de.net/pkg/go-tibia/gameworld/gwmap"
+	"badc0de.net/pkg/go-tibia/maptiles"
+	tnet "badc0de.net/pkg/go-tibia/net"
 	"badc0de.net/pkg/go-tibia/spr"
 	"badc0de.net/pkg/go-tibia/things"
 )
@@ -31,6 +36,7 @@ type Handler struct {
 	creatureLock  sync.Mutex
 	th            *things.Things
 	mapDataSource gameworld.MapDataSource
+	mapTiles      *maptiles.Pyramid
 
 	tibiaSprPath string
 	tibiaPicPath string
@@ -526,6 +532,19 @@ func (h *Handler) mapHandler(w http.ResponseWriter, r *http.Request) {
 		th = 70
 	}
 
+	// Jumping to a town or a waypoint centers the map on it.
+	if pos, ok := h.landmarkPosition(r.URL.Query().Get("town"), r.URL.Query().Get("waypoint")); ok {
+		tx = centeredAt(pos.X, tw)
+		ty = centeredAt(pos.Y, th)
+		tbot = pos.Floor
+		if tbot > 7 {
+			ttop = tbot
+		}
+		if ttop > tbot {
+			ttop = tbot
+		}
+	}
+
 	// TODO: more input validation! never allow for number inside CompositeMap to go negative, e.g.
 	img := compositor.CompositeMap(h.mapDataSource, t, tx, ty, ttop, tbot, tw, th, 32, 32)
 	if true {
@@ -540,6 +559,118 @@ func (h *Handler) mapHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// minimapHandler paints a floor of the map with one square per tile, in the
+// map colors of the items on it. Without an area, the whole floor is painted
+// if the map knows its size and it fits into the largest image; otherwise, an
+// area around the temple of the first town is painted.
+func (h *Handler) minimapHandler(w http.ResponseWriter, r *http.Request) {
+	var tx, ty uint16
+	var tz uint8 = 7
+	tw, th := 256, 256
+	scale := 1
+	if mw, mh, ok := compositor.MinimapBounds(h.mapDataSource); ok && mw <= maxMinimapSize && mh <= maxMinimapSize {
+		tw, th = mw, mh
+	} else if landmarks, ok := h.mapDataSource.(gwmap.LandmarkMapDataSource); ok && len(landmarks.Towns()) > 0 {
+		temple := landmarks.Towns()[0].TemplePos
+		tx, ty, tz = centeredAt(temple.X, tw), centeredAt(temple.Y, th), temple.Floor
+	}
+
+	if x := r.URL.Query().Get("x"); x != "" {
+		txI, _ := strconv.Atoi(x)
+		tx = uint16(txI)
+	}
+	if y := r.URL.Query().Get("y"); y != "" {
+		tyI, _ := strconv.Atoi(y)
+		ty = uint16(tyI)
+	}
+	if z := r.URL.Query().Get("z"); z != "" {
+		tzI, _ := strconv.Atoi(z)
+		tz = uint8(tzI)
+	}
+	if w := r.URL.Query().Get("w"); w != "" {
+		tw, _ = strconv.Atoi(w)
+	}
+	if h := r.URL.Query().Get("h"); h != "" {
+		th, _ = strconv.Atoi(h)
+	}
+	if s := r.URL.Query().Get("scale"); s != "" {
+		scale, _ = strconv.Atoi(s)
+	}
+
+	if tz > 15 || tw <= 0 || th <= 0 || scale <= 0 || tw*scale > maxMinimapSize || th*scale > maxMinimapSize {
+		http.Error(w, fmt.Sprintf("bad minimap area: floor must be up to 15, and the image up to %dx%d", maxMinimapSize, maxMinimapSize), http.StatusBadRequest)
+		return
+	}
+
+	img := compositor.CompositeMinimap(h.mapDataSource, h.th, tx, ty, tz, tw, th, scale)
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	png.Encode(w, img)
+}
+
+// maxMinimapSize is the width and height of the largest minimap image which
+// is painted.
+const maxMinimapSize = 4096
+
+// centeredAt returns where an area of the passed size starts for it to be
+// centered on c, without going past the top or left edge of the map.
+func centeredAt(c uint16, size int) uint16 {
+	start := int(c) - size/2
+	if start < 0 {
+		start = 0
+	}
+	return uint16(start)
+}
+
+// landmarkPosition returns the temple position of the town with the passed
+// name or ID, or else the position of the waypoint with the passed name, if
+// the map knows about them.
+func (h *Handler) landmarkPosition(town, waypoint string) (tnet.Position, bool) {
+	landmarks, ok := h.mapDataSource.(gwmap.LandmarkMapDataSource)
+	if !ok || (town == "" && waypoint == "") {
+		return tnet.Position{}, false
+	}
+	for _, t := range landmarks.Towns() {
+		if town != "" && (strings.EqualFold(t.Name, town) || town == strconv.Itoa(int(t.ID))) {
+			return t.TemplePos, true
+		}
+	}
+	for _, w := range landmarks.Waypoints() {
+		if waypoint != "" && strings.EqualFold(w.Name, waypoint) {
+			return w.Pos, true
+		}
+	}
+	return tnet.Position{}, false
+}
+
+// landmarksHandler lists the towns and waypoints on the map as JSON, so that
+// the map can be jumped to them.
+func (h *Handler) landmarksHandler(w http.ResponseWriter, r *http.Request) {
+	type position struct {
+		X, Y, Z uint16
+	}
+	type landmark struct {
+		ID   uint32 `json:",omitempty"`
+		Name string
+		Pos  position
+	}
+	var resp struct {
+		Towns     []landmark
+		Waypoints []landmark
+	}
+	if landmarks, ok := h.mapDataSource.(gwmap.LandmarkMapDataSource); ok {
+		for _, t := range landmarks.Towns() {
+			resp.Towns = append(resp.Towns, landmark{ID: t.ID, Name: t.Name, Pos: position{t.TemplePos.X, t.TemplePos.Y, uint16(t.TemplePos.Floor)}})
+		}
+		for _, wp := range landmarks.Waypoints() {
+			resp.Waypoints = append(resp.Waypoints, landmark{Name: wp.Name, Pos: position{wp.Pos.X, wp.Pos.Y, uint16(wp.Pos.Floor)}})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
 // RegisterRoutes registers routes allowing fetching of various images derived
 // from raw data files.
 //
@@ -564,6 +695,8 @@ func (h *Handler) RegisterMapRoute(r *mux.Router, mapDataSource gameworld.MapDat
 	}
 	h.mapDataSource = mapDataSource
 	r.HandleFunc("/map", h.mapHandler)
+	r.HandleFunc("/map/landmarks", h.landmarksHandler)
+	r.HandleFunc("/map/minimap", h.minimapHandler)
 }
 
 func (h *Handler) RegisterSubscriptionCreateRoute(r *mux.Router, subscriptionManager *SubscriptionManager) {
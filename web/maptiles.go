@@ -0,0 +1,83 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+
+	"badc0de.net/pkg/go-tibia/maptiles"
+)
+
+// RegisterMapTilesRoutes registers routes serving the tiles of the passed
+// pyramid at /maptiles/{floor}/{z}/{x}/{y}.png, and a description of the
+// pyramid at /maptiles/info.json. If a path to the viewer's HTML is passed,
+// the viewer is served at /maptiles/.
+func (h *Handler) RegisterMapTilesRoutes(r *mux.Router, p *maptiles.Pyramid, viewerHTMLPath string) {
+	if r == nil {
+		panic("nil mux router passed into RegisterMapTilesRoutes")
+	}
+	h.mapTiles = p
+	r.HandleFunc("/maptiles/{floor:[0-9]+}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.png", h.mapTileHandler)
+	r.HandleFunc("/maptiles/info.json", h.mapTilesInfoHandler)
+	if viewerHTMLPath != "" {
+		r.HandleFunc("/maptiles/", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, viewerHTMLPath)
+		})
+	}
+}
+
+func (h *Handler) mapTileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	floor, _ := strconv.Atoi(vars["floor"])
+	z, _ := strconv.Atoi(vars["z"])
+	x, _ := strconv.Atoi(vars["x"])
+	y, _ := strconv.Atoi(vars["y"])
+	if floor > 15 {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := fmt.Sprintf(`W/"maptile:%s:%d/%d/%d/%d"`, h.mapTiles.Key(), floor, z, x, y)
+	w.Header().Set("Cache-Control", "public; max-age=36000") // 36000 = 10h
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	b, err := h.mapTiles.Tile(uint8(floor), z, x, y)
+	if err == maptiles.ErrNoTile {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		glog.Errorf("rendering map tile %d/%d/%d/%d: %v", floor, z, x, y, err)
+		http.Error(w, "500", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// mapTilesInfoHandler describes the pyramid to the viewer.
+func (h *Handler) mapTilesInfoHandler(w http.ResponseWriter, r *http.Request) {
+	width, height := h.mapTiles.MapSize()
+	resp := struct {
+		TileSize, MaxZoom int
+		Width, Height     int // of the map, in map tiles
+		MapTileSize       int // in pixels, at the deepest zoom level
+	}{
+		TileSize:    maptiles.TileSize,
+		MaxZoom:     h.mapTiles.MaxZoom(),
+		Width:       width,
+		Height:      height,
+		MapTileSize: 32,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,98 @@
+package xmls
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// Borders describes the contents of a borders.xml file, as used by Remere's
+// Map Editor: sets of items placed around a ground to smoothly blend it into
+// the grounds next to it.
+type Borders struct {
+	xml.Name `xml:"materials"`
+	Border   []Border `xml:"border"`
+}
+
+// Border is a set of border items, one for each edge and corner.
+type Border struct {
+	ID   int          `xml:"id,attr"`
+	Type string       `xml:"type,attr"` // Empty for regular borders; "optional" for borders placed only on request.
+	Item []BorderItem `xml:"borderitem"`
+}
+
+// BorderItem is the item placed on a single edge or corner.
+//
+// Edges are "n", "e", "s" and "w", placed on tiles with the bordered ground
+// on that side. Corners are "cnw", "cne", "csw" and "cse", placed on tiles
+// with the bordered ground only diagonally in that direction, and diagonals
+// are "dnw", "dne", "dsw" and "dse", placed on tiles with the bordered ground
+// on both sides of that corner.
+type BorderItem struct {
+	Edge string `xml:"edge,attr"`
+	Item int    `xml:"item,attr"`
+}
+
+// Grounds describes the contents of a grounds.xml file, as used by Remere's
+// Map Editor: brushes painting grounds, and the borders they get.
+type Grounds struct {
+	xml.Name `xml:"materials"`
+	Brush    []GroundBrush `xml:"brush"`
+}
+
+// GroundBrush paints one kind of ground, choosing among its items by their
+// chance.
+//
+// Grounds with a higher z-order are bordered over grounds with a lower
+// z-order.
+type GroundBrush struct {
+	Name         string        `xml:"name,attr"`
+	Type         string        `xml:"type,attr"` // "ground"; brushes of other types are not grounds.
+	ServerLookID int           `xml:"server_lookid,attr"`
+	ZOrder       int           `xml:"z-order,attr"`
+	Item         []BrushItem   `xml:"item"`
+	Border       []BrushBorder `xml:"border"`
+	Friend       []BrushFriend `xml:"friend"`
+}
+
+// BrushItem is one of the items a brush paints.
+type BrushItem struct {
+	ID     int `xml:"id,attr"`
+	Chance int `xml:"chance,attr"`
+}
+
+// BrushBorder refers to the border placed around a ground.
+//
+// Outer borders are placed on neighbouring tiles, inner borders on the
+// ground's own tiles. The border applies to neighbouring grounds painted by
+// the brush named in To, to tiles without ground if To is "none", and to all
+// grounds if To is empty or "all".
+type BrushBorder struct {
+	Align string `xml:"align,attr"`
+	To    string `xml:"to,attr"`
+	ID    int    `xml:"id,attr"`
+}
+
+// BrushFriend names a brush which is not bordered against.
+type BrushFriend struct {
+	Name string `xml:"name,attr"`
+}
+
+// ReadBorders reads a borders.xml file.
+func ReadBorders(r io.Reader) (Borders, error) {
+	dec := xml.NewDecoder(r)
+	borders := Borders{}
+	if err := dec.Decode(&borders); err != nil {
+		return borders, err
+	}
+	return borders, nil
+}
+
+// ReadGrounds reads a grounds.xml file.
+func ReadGrounds(r io.Reader) (Grounds, error) {
+	dec := xml.NewDecoder(r)
+	grounds := Grounds{}
+	if err := dec.Decode(&grounds); err != nil {
+		return grounds, err
+	}
+	return grounds, nil
+}
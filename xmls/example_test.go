@@ -3,6 +3,10 @@ package xmls
 import (
 	"bytes"
 	"fmt"
+	"io"
+
+	"badc0de.net/pkg/go-tibia/otb/items"
+	"badc0de.net/pkg/go-tibia/things"
 )
 
 // Example demonstrates how to load outfits from the outfits.xml file.
@@ -145,3 +149,192 @@ See also other [[Objects]] and [[Grass (Tile)]].
 	// ==> "Ice (Tile)", [800 6683 6684 6685 6686]
 
 }
+
+// ExampleReadSpawns demonstrates how to load spawns from a spawn file, such as
+// one referred to by an OTBM map.
+func ExampleReadSpawns() {
+	o := bytes.NewReader([]byte(`<?xml version="1.0"?>
+<spawns>
+	<spawn centerx="105" centery="100" centerz="7" radius="3">
+		<monster name="Rat" x="1" y="-1" z="7" spawntime="60"/>
+		<npc name="Sam" x="0" y="0" z="7" spawntime="60" direction="2"/>
+	</spawn>
+</spawns>`))
+	spawns, err := ReadSpawns(o)
+	if err != nil {
+		panic(err)
+	}
+
+	s := spawns.Spawn[0]
+	fmt.Println(s.CenterX+s.Monster[0].X, s.CenterY+s.Monster[0].Y, s.Monster[0].Name, s.Monster[0].RespawnInterval())
+	fmt.Println(s.NPC[0].Name)
+	// Output:
+	// 106 99 Rat 1m0s
+	// Sam
+}
+
+// ExampleReadHouses demonstrates how to load houses from a house file, such as
+// one referred to by an OTBM map.
+func ExampleReadHouses() {
+	o := bytes.NewReader([]byte(`<?xml version="1.0"?>
+<houses>
+	<house name="Market Street 1" houseid="1" entryx="1010" entryy="1002" entryz="7" rent="500" townid="1" size="24"/>
+	<house name="Guildhall" houseid="2" entryx="1050" entryy="1000" entryz="7" rent="5000" townid="1" size="120" guildhall="true"/>
+</houses>`))
+	houses, err := ReadHouses(o)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, h := range houses.House {
+		fmt.Println(h.HouseID, h.Name, h.EntryX, h.EntryY, h.EntryZ, h.Rent, h.Guildhall)
+	}
+	// Output:
+	// 1 Market Street 1 1010 1002 7 500 false
+	// 2 Guildhall 1050 1000 7 5000 true
+}
+
+// ExampleReadMonsters demonstrates how to load monsters.xml along with the
+// per-monster files it refers to.
+func ExampleReadMonsters() {
+	files := map[string]string{
+		"monsters.xml": `<?xml version="1.0"?>
+<monsters>
+	<monster name="Rat" file="rat.xml"/>
+</monsters>`,
+		"rat.xml": `<?xml version="1.0"?>
+<monster name="Rat" nameDescription="a rat" race="blood" experience="5" speed="134" manacost="200">
+	<health now="20" max="20"/>
+	<look type="21" head="0" body="0" legs="0" feet="0" corpse="2813"/>
+	<targetchange interval="2000" chance="0"/>
+	<strategy attack="100" defense="0"/>
+	<flags>
+		<flag summonable="1"/>
+		<flag hostile="1"/>
+		<flag runonhealth="5"/>
+	</flags>
+	<attacks>
+		<attack name="melee" interval="2000" min="0" max="-8"/>
+	</attacks>
+	<defenses armor="1" defense="2"/>
+	<immunities>
+		<immunity poison="1"/>
+		<immunity name="fire"/>
+	</immunities>
+	<voices interval="5000" chance="10">
+		<voice sentence="Meep!"/>
+	</voices>
+	<loot>
+		<item id="2148" countmax="4" chance="37500"/>
+		<item name="cheese" chance="50000"/>
+	</loot>
+</monster>`,
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		return readerReadCloser{bytes.NewReader([]byte(files[name]))}, nil
+	}
+
+	monsters, err := ReadMonsters(bytes.NewReader([]byte(files["monsters.xml"])), open)
+	if err != nil {
+		panic(err)
+	}
+
+	rat := monsters.ByName("rat")
+	fmt.Println(rat.NameDescription, rat.Look.Type, rat.Health.Max, rat.Flags.Hostile, rat.Flags.RunOnHealth)
+	fmt.Println(rat.Attacks[0].SpellName, rat.Attacks[0].Max, rat.Immune("poison"), rat.Immune("fire"), rat.Immune("energy"), rat.Voices.Voice[0].Sentence)
+	fmt.Println(rat.Loot[0].ID, rat.Loot[0].CountMax, rat.Loot[1].Name)
+	// Output:
+	// a rat 21 20 true 5
+	// melee -8 true true false Meep!
+	// 2148 4 cheese
+}
+
+// ExampleMonster_Validate demonstrates that monsters referring to things not
+// known to the registry are reported.
+func ExampleMonster_Validate() {
+	th, err := things.New()
+	if err != nil {
+		panic(err)
+	}
+
+	th.AddItemsOTB(&itemsotb.Items{
+		Items: []itemsotb.Item{{
+			Attributes: map[itemsotb.ItemsAttribute]interface{}{
+				itemsotb.ITEM_ATTR_SERVERID: uint16(2696),
+				itemsotb.ITEM_ATTR_NAME:     "cheese",
+			},
+		}},
+		ServerIDToArrayIndex: map[uint16]int{2696: 0},
+		MinServerID:          2696,
+		MaxServerID:          2696,
+	})
+
+	m := &Monster{
+		MonsterName: "Rat",
+		Look:        MonsterLook{Type: 21},
+		Loot:        []MonsterLootItem{{Name: "cheese"}, {Name: "gold coin"}},
+	}
+	fmt.Println(m.Loot[0].ServerID(th))
+	fmt.Println(m.Validate(th))
+	// Output:
+	// 2696 <nil>
+	// monster "Rat": look type 21 does not exist in the dat; monster "Rat": loot item: no item named "gold coin" in items.xml
+}
+
+// ExampleReadNPCs demonstrates how to load NPC definitions for the NPCs placed
+// by spawns, and how to access their dialogue and shop.
+func ExampleReadNPCs() {
+	files := map[string]string{
+		"Sam.xml": `<?xml version="1.0"?>
+<npc name="Sam" script="default.lua" walkinterval="2000" floorchange="0">
+	<health now="100" max="100"/>
+	<look type="131" head="38" body="38" legs="38" feet="38"/>
+	<parameters>
+		<parameter key="message_greet" value="Hello |PLAYERNAME|! Do you want to see my offers?"/>
+		<parameter key="shop_buyable" value="dagger,2379,4;sword,2376,85"/>
+		<parameter key="shop_sellable" value="sword,2376,25;leather helmet,2461,3"/>
+		<parameter key="keywords" value="job;name"/>
+		<parameter key="keyword_reply1" value="I am the blacksmith."/>
+		<parameter key="keyword_reply2" value="My name is Sam."/>
+	</parameters>
+	<interaction range="3" idletime="60">
+		<interact keywords="weapons">
+			<response text="Do you want to see my weapons?">
+				<interact keywords="yes">
+					<response text="Here they are."/>
+				</interact>
+			</response>
+		</interact>
+	</interaction>
+</npc>`,
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		return readerReadCloser{bytes.NewReader([]byte(files[name]))}, nil
+	}
+
+	npcs, err := ReadNPCs([]string{"Sam"}, open)
+	if err != nil {
+		panic(err)
+	}
+
+	sam := npcs.ByName("sam")
+	fmt.Println(sam.Look.Type, sam.WalkInterval, sam.Message("farewell", "Good bye."))
+	for _, interact := range sam.Interacts() {
+		fmt.Println(interact.KeywordList(), interact.Matches("What is your job?"), interact.Response[0].Text)
+	}
+	shop, err := sam.Shop()
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range shop {
+		fmt.Println(item.Name, item.ID, item.BuyPrice, item.SellPrice)
+	}
+	// Output:
+	// 131 2000 Good bye.
+	// [weapons] false Do you want to see my weapons?
+	// [job] true I am the blacksmith.
+	// [name] false My name is Sam.
+	// dagger 2379 4 0
+	// sword 2376 85 25
+	// leather helmet 2461 0 3
+}
@@ -0,0 +1,38 @@
+package xmls
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// Houses describes the contents of a house file, such as the one referred to
+// by an OTBM map through its external house file attribute.
+//
+// The tiles belonging to each house are recorded in the map itself.
+type Houses struct {
+	xml.Name `xml:"houses"`
+	House    []House `xml:"house"`
+}
+
+// House is a single house, which players can own and invite others into.
+type House struct {
+	Name      string `xml:"name,attr"`
+	HouseID   uint32 `xml:"houseid,attr"`
+	EntryX    int    `xml:"entryx,attr"`
+	EntryY    int    `xml:"entryy,attr"`
+	EntryZ    int    `xml:"entryz,attr"`
+	Rent      int    `xml:"rent,attr"`      // Gold to be paid each rent period.
+	TownID    uint32 `xml:"townid,attr"`    // Town the rent is paid in.
+	Size      int    `xml:"size,attr"`      // Number of tiles, as computed by the map editor.
+	Guildhall bool   `xml:"guildhall,attr"` // Whether the house is meant to be owned by a guild.
+}
+
+// ReadHouses reads a house file from the passed reader.
+func ReadHouses(r io.Reader) (Houses, error) {
+	dec := xml.NewDecoder(r)
+	houses := Houses{}
+	if err := dec.Decode(&houses); err != nil {
+		return houses, err
+	}
+	return houses, nil
+}
@@ -0,0 +1,508 @@
+package xmls
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"badc0de.net/pkg/go-tibia/things"
+)
+
+// MonsterList describes the contents of monsters.xml, which lists all the
+// monsters and the files in which they are defined.
+type MonsterList struct {
+	xml.Name `xml:"monsters"`
+	Monster  []MonsterListEntry `xml:"monster"`
+}
+
+// MonsterListEntry is a single monster listed in monsters.xml.
+type MonsterListEntry struct {
+	Name string `xml:"name,attr"`
+	File string `xml:"file,attr"` // Relative to the directory containing monsters.xml.
+}
+
+// Monster describes a single monster, as defined in a per-monster XML file.
+type Monster struct {
+	xml.Name        `xml:"monster"`
+	MonsterName     string `xml:"name,attr"`
+	NameDescription string `xml:"nameDescription,attr"`
+	Race            string `xml:"race,attr"`
+	Experience      int    `xml:"experience,attr"`
+	Speed           int    `xml:"speed,attr"`
+	ManaCost        int    `xml:"manacost,attr"`
+
+	Health       MonsterHealth       `xml:"health"`
+	Look         MonsterLook         `xml:"look"`
+	TargetChange MonsterTargetChange `xml:"targetchange"`
+	Strategy     MonsterStrategy     `xml:"strategy"`
+	Flags        MonsterFlags        `xml:"flags"`
+	Attacks      []MonsterSpell      `xml:"attacks>attack"`
+	Defenses     MonsterDefenses     `xml:"defenses"`
+	Elements     attributeList       `xml:"elements"`
+	Immunities   attributeList       `xml:"immunities"`
+	Summons      MonsterSummons      `xml:"summons"`
+	Voices       MonsterVoices       `xml:"voices"`
+	Loot         []MonsterLootItem   `xml:"loot>item"`
+}
+
+// MonsterHealth describes the health the monster has when placed.
+type MonsterHealth struct {
+	Now int `xml:"now,attr"`
+	Max int `xml:"max,attr"`
+}
+
+// MonsterLook describes the outfit of the monster.
+//
+// Monsters looking like items have TypeEx set to the server ID of the item,
+// instead of having Type set.
+type MonsterLook struct {
+	Type   int `xml:"type,attr"`
+	TypeEx int `xml:"typeex,attr"`
+	Head   int `xml:"head,attr"`
+	Body   int `xml:"body,attr"`
+	Legs   int `xml:"legs,attr"`
+	Feet   int `xml:"feet,attr"`
+	Addons int `xml:"addons,attr"`
+	Corpse int `xml:"corpse,attr"` // Server ID of the item left behind on death.
+}
+
+// OutfitColors returns the head, body, legs and feet colors of the monster.
+func (l *MonsterLook) OutfitColors() [4]things.OutfitColor {
+	return [4]things.OutfitColor{
+		things.OutfitColor(l.Head),
+		things.OutfitColor(l.Body),
+		things.OutfitColor(l.Legs),
+		things.OutfitColor(l.Feet),
+	}
+}
+
+// MonsterTargetChange describes how often the monster considers changing its
+// target, in milliseconds, and the percentage chance it will do so.
+type MonsterTargetChange struct {
+	Interval int `xml:"interval,attr"`
+	Chance   int `xml:"chance,attr"`
+}
+
+// MonsterStrategy describes how likely the monster is to attack or defend, in
+// percent.
+type MonsterStrategy struct {
+	Attack  int `xml:"attack,attr"`
+	Defense int `xml:"defense,attr"`
+}
+
+// MonsterFlags describes the behavior of the monster.
+//
+// In the XML file, each flag is an attribute on a separate element. Flags that
+// are not known are stored in Other.
+type MonsterFlags struct {
+	Summonable       bool
+	Attackable       bool
+	Hostile          bool
+	Illusionable     bool
+	Convinceable     bool
+	Pushable         bool
+	CanPushItems     bool
+	CanPushCreatures bool
+	TargetDistance   int // Distance the monster tries to keep from its target.
+	StaticAttack     int // Chance, in percent, that the monster will not move while attacking.
+	RunOnHealth      int // Health at or below which the monster flees.
+
+	Other map[string]string
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (f *MonsterFlags) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var attrs attributeList
+	if err := d.DecodeElement(&attrs, &start); err != nil {
+		return err
+	}
+	f.TargetDistance = 1
+	for key, value := range attrs {
+		var err error
+		switch strings.ToLower(key) {
+		case "summonable":
+			f.Summonable, err = parseXMLBool(value)
+		case "attackable":
+			f.Attackable, err = parseXMLBool(value)
+		case "hostile":
+			f.Hostile, err = parseXMLBool(value)
+		case "illusionable":
+			f.Illusionable, err = parseXMLBool(value)
+		case "convinceable":
+			f.Convinceable, err = parseXMLBool(value)
+		case "pushable":
+			f.Pushable, err = parseXMLBool(value)
+		case "canpushitems":
+			f.CanPushItems, err = parseXMLBool(value)
+		case "canpushcreatures":
+			f.CanPushCreatures, err = parseXMLBool(value)
+		case "targetdistance":
+			f.TargetDistance, err = strconv.Atoi(value)
+		case "staticattack":
+			f.StaticAttack, err = strconv.Atoi(value)
+		case "runonhealth":
+			f.RunOnHealth, err = strconv.Atoi(value)
+		default:
+			if f.Other == nil {
+				f.Other = map[string]string{}
+			}
+			f.Other[key] = value
+		}
+		if err != nil {
+			return fmt.Errorf("flag %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// MonsterSpell describes a single attack or defense of the monster.
+//
+// Melee attacks are named "melee"; other names refer to spells or to damage
+// types. Interval is in milliseconds, and chance in percent. Damage of an
+// attack is usually negative, as it takes away health.
+type MonsterSpell struct {
+	SpellName  string             `xml:"name,attr"`
+	Interval   int                `xml:"interval,attr"`
+	Chance     int                `xml:"chance,attr"`
+	Min        int                `xml:"min,attr"`
+	Max        int                `xml:"max,attr"`
+	Range      int                `xml:"range,attr"`
+	Radius     int                `xml:"radius,attr"`
+	Length     int                `xml:"length,attr"`
+	Spread     int                `xml:"spread,attr"`
+	Target     int                `xml:"target,attr"`
+	Skill      int                `xml:"skill,attr"`
+	Attack     int                `xml:"attack,attr"`
+	Speed      int                `xml:"speedchange,attr"`
+	Duration   int                `xml:"duration,attr"`
+	Attributes []MonsterAttribute `xml:"attribute"`
+}
+
+// Attribute returns the value of the attribute with the passed key, such as
+// "shootEffect" or "areaEffect", and whether it is set.
+func (s *MonsterSpell) Attribute(key string) (string, bool) {
+	for _, attr := range s.Attributes {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// MonsterAttribute is a key-value pair further describing a spell.
+type MonsterAttribute struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// MonsterDefenses describes the armor and defense values of the monster,
+// as well as the spells it can use to defend itself.
+type MonsterDefenses struct {
+	Armor   int            `xml:"armor,attr"`
+	Defense int            `xml:"defense,attr"`
+	Spells  []MonsterSpell `xml:"defense"`
+}
+
+// MonsterSummons describes the creatures the monster can summon.
+type MonsterSummons struct {
+	MaxSummons int             `xml:"maxSummons,attr"`
+	Summon     []MonsterSummon `xml:"summon"`
+}
+
+// MonsterSummon is a single creature the monster can summon. Interval is in
+// milliseconds, and chance in percent.
+type MonsterSummon struct {
+	Name     string `xml:"name,attr"`
+	Interval int    `xml:"interval,attr"`
+	Chance   int    `xml:"chance,attr"`
+}
+
+// MonsterVoices describes the sentences the monster says. Interval is in
+// milliseconds, and chance in percent.
+type MonsterVoices struct {
+	Interval int            `xml:"interval,attr"`
+	Chance   int            `xml:"chance,attr"`
+	Voice    []MonsterVoice `xml:"voice"`
+}
+
+// MonsterVoice is a single sentence the monster says.
+type MonsterVoice struct {
+	Sentence string `xml:"sentence,attr"`
+	Yell     int    `xml:"yell,attr"`
+}
+
+// MonsterLootItem is a single item that might be left behind in the monster's
+// corpse. Chance is out of 100000. Containers can contain further loot.
+//
+// Items are given either by their server ID, or by their name in items.xml;
+// see ServerID.
+type MonsterLootItem struct {
+	ID       int               `xml:"id,attr"`   // Server ID of the item, or zero if given by name.
+	Name     string            `xml:"name,attr"` // Name of the item, if not given by ID.
+	CountMax int               `xml:"countmax,attr"`
+	Chance   int               `xml:"chance,attr"`
+	Inside   []MonsterLootItem `xml:"inside>item"`
+}
+
+// ServerID returns the server ID of the loot item, looking it up in the passed
+// registry by its name if it is not given by ID.
+func (l *MonsterLootItem) ServerID(th *things.Things) (int, error) {
+	if l.ID != 0 || l.Name == "" {
+		return l.ID, nil
+	}
+	for id := 1; id <= int(th.MaxItemServerID(0)); id++ {
+		if item := th.Temp__GetItemFromOTB(uint16(id), 0); item != nil && strings.EqualFold(item.Name(), l.Name) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no item named %q in items.xml", l.Name)
+}
+
+// attributeList collects all attributes of all child elements into a single
+// map. It is used for lists such as flags or immunities, where each child
+// element carries a single attribute.
+//
+// A child element may also name its attribute instead, as in
+// <immunity name="fire"/>, which is the same as <immunity fire="1"/>.
+type attributeList map[string]string
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (l *attributeList) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*l = attributeList{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			for _, attr := range tok.Attr {
+				if attr.Name.Local == "name" {
+					(*l)[attr.Value] = "1"
+					continue
+				}
+				(*l)[attr.Name.Local] = attr.Value
+			}
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Immune returns whether the monster is immune to the passed damage or
+// condition type, such as "fire" or "paralyze".
+func (m *Monster) Immune(kind string) bool {
+	for key, value := range m.Immunities {
+		if strings.EqualFold(key, kind) {
+			b, _ := parseXMLBool(value)
+			return b
+		}
+	}
+	return false
+}
+
+// ElementPercent returns the percentage by which the damage of the passed
+// type, such as "fire", is modified for the monster.
+func (m *Monster) ElementPercent(kind string) int {
+	for key, value := range m.Elements {
+		if strings.EqualFold(key, kind+"Percent") {
+			pct, _ := strconv.Atoi(value)
+			return pct
+		}
+	}
+	return 0
+}
+
+// Monsters is a collection of monster definitions, indexed by name.
+type Monsters struct {
+	List   []*Monster
+	byName map[string]*Monster
+}
+
+// ByName returns the definition of the monster with the passed name, or nil.
+// The name is not case-sensitive.
+func (ms *Monsters) ByName(name string) *Monster {
+	if ms == nil {
+		return nil
+	}
+	return ms.byName[strings.ToLower(name)]
+}
+
+// Add adds the monster definition to the collection, replacing any other
+// definition with the same name.
+func (ms *Monsters) Add(m *Monster) {
+	if ms.byName == nil {
+		ms.byName = map[string]*Monster{}
+	}
+	key := strings.ToLower(m.MonsterName)
+	if old, ok := ms.byName[key]; ok {
+		for i := range ms.List {
+			if ms.List[i] == old {
+				ms.List = append(ms.List[:i], ms.List[i+1:]...)
+				break
+			}
+		}
+	}
+	ms.byName[key] = m
+	ms.List = append(ms.List, m)
+}
+
+// ReadMonsterList reads monsters.xml from the passed reader.
+func ReadMonsterList(r io.Reader) (MonsterList, error) {
+	dec := xml.NewDecoder(r)
+	list := MonsterList{}
+	if err := dec.Decode(&list); err != nil {
+		return list, err
+	}
+	return list, nil
+}
+
+// ReadMonster reads a single per-monster XML file from the passed reader.
+func ReadMonster(r io.Reader) (*Monster, error) {
+	dec := xml.NewDecoder(r)
+	m := &Monster{}
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	if m.Health.Max == 0 {
+		m.Health.Max = m.Health.Now
+	}
+	if m.Flags.TargetDistance == 0 {
+		m.Flags.TargetDistance = 1
+	}
+	return m, nil
+}
+
+// ReadMonsters reads monsters.xml from the passed reader, and then reads each
+// of the listed per-monster files, opening them with the passed function.
+//
+// The name listed in monsters.xml takes precedence over the name in the
+// per-monster file.
+func ReadMonsters(r io.Reader, open func(file string) (io.ReadCloser, error)) (*Monsters, error) {
+	list, err := ReadMonsterList(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading monster list: %w", err)
+	}
+
+	ms := &Monsters{}
+	for _, entry := range list.Monster {
+		f, err := open(entry.File)
+		if err != nil {
+			return nil, fmt.Errorf("opening monster %q: %w", entry.Name, err)
+		}
+		m, err := ReadMonster(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading monster %q from %q: %w", entry.Name, entry.File, err)
+		}
+		if entry.Name != "" {
+			m.MonsterName = entry.Name
+		}
+		ms.Add(m)
+	}
+	return ms, nil
+}
+
+// ValidationErrors is a list of problems found while validating data against
+// a things registry.
+type ValidationErrors []error
+
+// Error implements the error interface.
+func (e ValidationErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks that the monster only refers to things known to the passed
+// registry: its look type must exist in the dat, and its corpse and loot must
+// exist in items.otb.
+//
+// If any problems are found, ValidationErrors is returned.
+func (m *Monster) Validate(th *things.Things) error {
+	var errs ValidationErrors
+
+	if m.Look.Type != 0 {
+		if m.Look.Type < 0 || m.Look.Type > th.CreatureCount(0) {
+			errs = append(errs, fmt.Errorf("monster %q: look type %d does not exist in the dat", m.MonsterName, m.Look.Type))
+		}
+	}
+	if m.Look.TypeEx != 0 && !validItem(th, m.Look.TypeEx) {
+		errs = append(errs, fmt.Errorf("monster %q: look item %d does not exist in items.otb", m.MonsterName, m.Look.TypeEx))
+	}
+	if m.Look.Type == 0 && m.Look.TypeEx == 0 {
+		errs = append(errs, fmt.Errorf("monster %q: no look type", m.MonsterName))
+	}
+	if m.Look.Corpse != 0 && !validItem(th, m.Look.Corpse) {
+		errs = append(errs, fmt.Errorf("monster %q: corpse %d does not exist in items.otb", m.MonsterName, m.Look.Corpse))
+	}
+
+	var checkLoot func([]MonsterLootItem)
+	checkLoot = func(loot []MonsterLootItem) {
+		for _, item := range loot {
+			if id, err := item.ServerID(th); err != nil {
+				errs = append(errs, fmt.Errorf("monster %q: loot item: %w", m.MonsterName, err))
+			} else if !validItem(th, id) {
+				errs = append(errs, fmt.Errorf("monster %q: loot item %d does not exist in items.otb", m.MonsterName, id))
+			}
+			checkLoot(item.Inside)
+		}
+	}
+	checkLoot(m.Loot)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Validate validates all monsters in the collection against the passed
+// registry, and also checks that summoned creatures are known monsters.
+//
+// If any problems are found, ValidationErrors is returned.
+func (ms *Monsters) Validate(th *things.Things) error {
+	var errs ValidationErrors
+	for _, m := range ms.List {
+		if err := m.Validate(th); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+		for _, summon := range m.Summons.Summon {
+			if ms.ByName(summon.Name) == nil {
+				errs = append(errs, fmt.Errorf("monster %q: summoned monster %q is not known", m.MonsterName, summon.Name))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validItem returns whether the item with the passed server ID exists in the
+// items.otb known to the registry.
+func validItem(th *things.Things, serverID int) bool {
+	if serverID <= 0 || serverID > 0xFFFF {
+		return false
+	}
+	return th.Temp__GetItemFromOTB(uint16(serverID), 0) != nil
+}
+
+// parseXMLBool parses boolean values as used in OT XML files: "1" and "0" as
+// well as "yes", "no", "true" and "false".
+func parseXMLBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "yes", "true":
+		return true, nil
+	case "0", "no", "false", "":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean %q", value)
+	}
+}
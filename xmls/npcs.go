@@ -0,0 +1,283 @@
+package xmls
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NPC describes a single NPC, as defined in a per-NPC XML file.
+//
+// Both OTServ-style definitions, with the dialogue described by an interaction
+// element, and Jiddo-style definitions, with the dialogue and the shop
+// described by parameters, are supported.
+type NPC struct {
+	xml.Name     `xml:"npc"`
+	NPCName      string `xml:"name,attr"`
+	Script       string `xml:"script,attr"`
+	WalkInterval int    `xml:"walkinterval,attr"` // In milliseconds; zero means the NPC does not walk.
+	Floorchange  int    `xml:"floorchange,attr"`
+
+	Health      MonsterHealth   `xml:"health"`
+	Look        MonsterLook     `xml:"look"`
+	Parameters  []NPCParameter  `xml:"parameters>parameter"`
+	Interaction *NPCInteraction `xml:"interaction"`
+}
+
+// NPCParameter is a single key-value parameter of an NPC, such as the greeting
+// message or the list of items sold.
+type NPCParameter struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// NPCInteraction describes the dialogue of an NPC.
+type NPCInteraction struct {
+	Range    int           `xml:"range,attr"`    // Distance from which players can talk to the NPC.
+	IdleTime int           `xml:"idletime,attr"` // Seconds after which the NPC stops talking to a silent player.
+	Interact []NPCInteract `xml:"interact"`
+}
+
+// NPCInteract is a node in the dialogue tree: when a player says something
+// containing all of its keywords, the NPC answers with the response.
+//
+// Interacts nested in a response are only considered for the next thing the
+// player says after the NPC answers with that response.
+type NPCInteract struct {
+	Keywords string        `xml:"keywords,attr"` // Separated by semicolons.
+	Focus    string        `xml:"focus,attr"`    // "1" if the NPC starts talking to the player, "0" if it stops.
+	Response []NPCResponse `xml:"response"`
+}
+
+// StartsFocus returns whether the NPC starts talking to the player when the
+// interact matches, i.e. whether it is a greeting.
+func (i *NPCInteract) StartsFocus() bool {
+	return strings.TrimSpace(i.Focus) == "1"
+}
+
+// EndsFocus returns whether the NPC stops talking to the player when the
+// interact matches, i.e. whether it is a farewell.
+func (i *NPCInteract) EndsFocus() bool {
+	return strings.TrimSpace(i.Focus) == "0"
+}
+
+// NPCResponse is what an NPC answers in a dialogue.
+//
+// The text can contain |NAME| (or |PLAYERNAME|, as used by Jiddo-style
+// definitions), replaced with the name of the player.
+type NPCResponse struct {
+	Text     string        `xml:"text,attr"`
+	Interact []NPCInteract `xml:"interact"`
+}
+
+// KeywordList returns the keywords of the interact, in lowercase.
+func (i *NPCInteract) KeywordList() []string {
+	var keywords []string
+	for _, kw := range strings.Split(i.Keywords, ";") {
+		if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+			keywords = append(keywords, kw)
+		}
+	}
+	return keywords
+}
+
+// Matches returns whether the passed text contains all of the interact's
+// keywords.
+func (i *NPCInteract) Matches(text string) bool {
+	keywords := i.KeywordList()
+	if len(keywords) == 0 {
+		return false
+	}
+	text = strings.ToLower(text)
+	for _, kw := range keywords {
+		if !strings.Contains(text, kw) {
+			return false
+		}
+	}
+	return true
+}
+
+// NPCShopItem is a single item that can be bought from or sold to an NPC.
+// Prices of zero mean the item cannot be bought, or sold, respectively.
+type NPCShopItem struct {
+	Name      string
+	ID        int // Server ID of the item.
+	SubType   int // Count of a fluid container, or charges.
+	BuyPrice  int // Price the player pays to the NPC.
+	SellPrice int // Price the NPC pays to the player.
+}
+
+// Parameter returns the value of the parameter with the passed key, and
+// whether it is set.
+func (n *NPC) Parameter(key string) (string, bool) {
+	for _, p := range n.Parameters {
+		if strings.EqualFold(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Message returns the value of the message parameter with the passed name,
+// such as "greet", or the passed default if it is not set.
+func (n *NPC) Message(name, def string) string {
+	if msg, ok := n.Parameter("message_" + name); ok {
+		return msg
+	}
+	return def
+}
+
+// Interacts returns the top level of the NPC's dialogue tree.
+//
+// Jiddo-style "keywords" and "keyword_replyN" parameters are converted into
+// interacts following the ones defined by the interaction element.
+func (n *NPC) Interacts() []NPCInteract {
+	var interacts []NPCInteract
+	if n.Interaction != nil {
+		interacts = append(interacts, n.Interaction.Interact...)
+	}
+	if keywords, ok := n.Parameter("keywords"); ok {
+		for i, kw := range strings.Split(keywords, ";") {
+			reply, ok := n.Parameter(fmt.Sprintf("keyword_reply%d", i+1))
+			if !ok {
+				break
+			}
+			interacts = append(interacts, NPCInteract{
+				Keywords: kw,
+				Response: []NPCResponse{{Text: reply}},
+			})
+		}
+	}
+	return interacts
+}
+
+// Shop returns the items the NPC trades, parsed from the Jiddo-style
+// "shop_buyable" and "shop_sellable" parameters.
+//
+// Both parameters contain entries separated by semicolons, each consisting of
+// the item name, the server ID, the price and optionally the subtype,
+// separated by commas.
+func (n *NPC) Shop() ([]NPCShopItem, error) {
+	var items []NPCShopItem
+	find := func(id, subType int) *NPCShopItem {
+		for i := range items {
+			if items[i].ID == id && items[i].SubType == subType {
+				return &items[i]
+			}
+		}
+		items = append(items, NPCShopItem{ID: id, SubType: subType})
+		return &items[len(items)-1]
+	}
+
+	for _, key := range []string{"shop_buyable", "shop_sellable"} {
+		value, ok := n.Parameter(key)
+		if !ok {
+			continue
+		}
+		for _, entry := range strings.Split(value, ";") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			fields := strings.Split(entry, ",")
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("npc %q: %s entry %q: want at least name, id and price", n.NPCName, key, entry)
+			}
+			var nums [3]int
+			for i, f := range fields[1:] {
+				if i >= len(nums) {
+					break
+				}
+				num, err := strconv.Atoi(strings.TrimSpace(f))
+				if err != nil {
+					return nil, fmt.Errorf("npc %q: %s entry %q: %w", n.NPCName, key, entry, err)
+				}
+				nums[i] = num
+			}
+
+			item := find(nums[0], nums[2])
+			item.Name = strings.TrimSpace(fields[0])
+			if key == "shop_buyable" {
+				item.BuyPrice = nums[1]
+			} else {
+				item.SellPrice = nums[1]
+			}
+		}
+	}
+	return items, nil
+}
+
+// NPCs is a collection of NPC definitions, indexed by name.
+type NPCs struct {
+	List   []*NPC
+	byName map[string]*NPC
+}
+
+// ByName returns the definition of the NPC with the passed name, or nil. The
+// name is not case-sensitive.
+func (ns *NPCs) ByName(name string) *NPC {
+	if ns == nil {
+		return nil
+	}
+	return ns.byName[strings.ToLower(name)]
+}
+
+// Add adds the NPC definition to the collection, replacing any other
+// definition with the same name.
+func (ns *NPCs) Add(n *NPC) {
+	if ns.byName == nil {
+		ns.byName = map[string]*NPC{}
+	}
+	key := strings.ToLower(n.NPCName)
+	if old, ok := ns.byName[key]; ok {
+		for i := range ns.List {
+			if ns.List[i] == old {
+				ns.List = append(ns.List[:i], ns.List[i+1:]...)
+				break
+			}
+		}
+	}
+	ns.byName[key] = n
+	ns.List = append(ns.List, n)
+}
+
+// ReadNPC reads a single per-NPC XML file from the passed reader.
+func ReadNPC(r io.Reader) (*NPC, error) {
+	dec := xml.NewDecoder(r)
+	n := &NPC{}
+	if err := dec.Decode(n); err != nil {
+		return nil, err
+	}
+	if n.Health.Max == 0 {
+		n.Health.Max = n.Health.Now
+	}
+	return n, nil
+}
+
+// ReadNPCs reads the definitions of the NPCs with the passed names, opening
+// the per-NPC files with the passed function. As in OT servers, the file of
+// each NPC is named after it, e.g. "Sam.xml".
+//
+// The name passed in takes precedence over the name in the per-NPC file.
+func ReadNPCs(names []string, open func(file string) (io.ReadCloser, error)) (*NPCs, error) {
+	ns := &NPCs{}
+	for _, name := range names {
+		if ns.ByName(name) != nil {
+			continue
+		}
+		file := name + ".xml"
+		f, err := open(file)
+		if err != nil {
+			return nil, fmt.Errorf("opening npc %q: %w", name, err)
+		}
+		n, err := ReadNPC(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading npc %q from %q: %w", name, file, err)
+		}
+		n.NPCName = name
+		ns.Add(n)
+	}
+	return ns, nil
+}
@@ -19,6 +19,12 @@ type Outfit struct {
 	List    []OutfitListEntry `xml:"list"`
 }
 
+// IsDefault returns whether the outfit may be worn by all players, rather than
+// only by players who unlocked it.
+func (o *Outfit) IsDefault() bool {
+	return o.Default != "0"
+}
+
 type OutfitType string
 
 const (
@@ -0,0 +1,77 @@
+package xmls
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Spawns describes the contents of a spawn file, such as the one referred to
+// by an OTBM map through its external spawn file attribute.
+type Spawns struct {
+	xml.Name `xml:"spawns"`
+	Spawn    []Spawn `xml:"spawn"`
+}
+
+// Spawn is a single area on the map in which creatures are placed, and in which
+// they will be placed again once they disappear.
+//
+// Creatures are placed relative to the center, and are expected to remain
+// within the radius.
+type Spawn struct {
+	CenterX int `xml:"centerx,attr"`
+	CenterY int `xml:"centery,attr"`
+	CenterZ int `xml:"centerz,attr"`
+	Radius  int `xml:"radius,attr"`
+
+	Monster []SpawnCreature `xml:"monster"`
+	NPC     []SpawnCreature `xml:"npc"`
+}
+
+// SpawnCreature is a single creature placed by a spawn.
+//
+// Its position is relative to the center of the spawn. Its floor is usually
+// the same as the floor of the center, but is stored separately.
+type SpawnCreature struct {
+	Name      string `xml:"name,attr"`
+	X         int    `xml:"x,attr"`
+	Y         int    `xml:"y,attr"`
+	Z         int    `xml:"z,attr"`
+	SpawnTime int    `xml:"spawntime,attr"` // Seconds until the creature is placed again after it disappears.
+	Direction int    `xml:"direction,attr"` // Only meaningful for NPCs.
+}
+
+// RespawnInterval returns the time to wait until the creature is placed again
+// after it disappears. If spawn time was not specified, one minute is used.
+func (c *SpawnCreature) RespawnInterval() time.Duration {
+	if c.SpawnTime <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.SpawnTime) * time.Second
+}
+
+// NPCNames returns the names of all NPCs placed by the spawns, without
+// duplicates.
+func (s *Spawns) NPCNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, spawn := range s.Spawn {
+		for _, npc := range spawn.NPC {
+			if !seen[npc.Name] {
+				seen[npc.Name] = true
+				names = append(names, npc.Name)
+			}
+		}
+	}
+	return names
+}
+
+// ReadSpawns reads a spawn file from the passed reader.
+func ReadSpawns(r io.Reader) (Spawns, error) {
+	dec := xml.NewDecoder(r)
+	spawns := Spawns{}
+	if err := dec.Decode(&spawns); err != nil {
+		return spawns, err
+	}
+	return spawns, nil
+}